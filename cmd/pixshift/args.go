@@ -6,6 +6,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+	"github.com/DanielTso/pixshift/internal/pipeline"
 	"github.com/DanielTso/pixshift/internal/preset"
 	"github.com/DanielTso/pixshift/internal/version"
 )
@@ -14,6 +17,8 @@ type options struct {
 	format        string
 	quality       int
 	jobs          int
+	maxMemory     int64 // bytes, 0 = no limit
+	jobTimeout    int   // seconds, 0 = no limit
 	outputDir     string
 	recursive     bool
 	metadata      bool
@@ -41,6 +46,13 @@ type options struct {
 	watermarkOpacity float64
 	presetName       string
 	backup           bool
+	preserveAttrs    bool
+	incremental      bool
+	manifestPath     string
+	prune            bool
+	journalPath      string
+	resume           bool
+	retryFailed      bool
 	jsonOutput       bool
 	treeMode         bool
 	dedupMode        bool
@@ -63,6 +75,7 @@ type options struct {
 	pngCompression int
 	webpMethod     int
 	lossless       bool
+	pngOptimize    bool
 	watermarkSize  float64
 	watermarkColor string
 	watermarkBg    string
@@ -85,9 +98,28 @@ type options struct {
 
 	// v0.7.0 fields
 	scanMode        bool
+	infoMode        bool
 	paletteCount    int // --palette N (0 = disabled)
 	smartCropWidth  int
 	smartCropHeight int
+	ops             []pipeline.Op
+	variants        []pipeline.Variant
+
+	// v0.9.0 fields
+	autoFormats      []codec.Format
+	autoMinSSIM      float64
+	reencodeJPEG     bool
+	chroma           string
+	jxlEffort        int
+	jxlDistance      float64
+	avifSpeed        int
+	avifAlphaQuality int
+	avifBitDepth     int
+	metadataPolicy   metadata.Policy
+	xmp              metadata.XMPFields
+	iptc             metadata.IPTCFields
+	sidecar          metadata.SidecarFormat
+	fromSidecar      bool
 }
 
 func parseArgs(args []string) *options {
@@ -134,6 +166,26 @@ func parseArgs(args []string) *options {
 			}
 			opts.jobs = j
 			i += 2
+		case "--max-memory":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			m, err := parseMemory(args[i+1])
+			if err != nil {
+				fatal("max-memory: %v", err)
+			}
+			opts.maxMemory = m
+			i += 2
+		case "--job-timeout":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			jt, err := strconv.Atoi(args[i+1])
+			if err != nil || jt < 1 {
+				fatal("job-timeout must be a positive integer (seconds)")
+			}
+			opts.jobTimeout = jt
+			i += 2
 		case "-o", "--output":
 			if i+1 >= len(args) {
 				fatal("missing value for %s", args[i])
@@ -155,6 +207,60 @@ func parseArgs(args []string) *options {
 		case "-s", "--strip-metadata":
 			opts.stripMetadata = true
 			i++
+		case "--keep-exif", "--strip-exif":
+			if i+1 >= len(args) {
+				fatal("missing value for %s (e.g. copyright,artist,datetime)", args[i])
+			}
+			entries := metadata.ParsePolicyList(args[i+1])
+			if args[i] == "--keep-exif" {
+				opts.metadataPolicy.Keep = entries
+			} else {
+				opts.metadataPolicy.Strip = entries
+			}
+			i += 2
+		case "--xmp-title", "--xmp-creator", "--xmp-rights", "--xmp-keywords":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			switch args[i] {
+			case "--xmp-title":
+				opts.xmp.Title = args[i+1]
+			case "--xmp-creator":
+				opts.xmp.Creator = args[i+1]
+			case "--xmp-rights":
+				opts.xmp.Rights = args[i+1]
+			default:
+				opts.xmp.Keywords = metadata.ParsePolicyList(args[i+1])
+			}
+			i += 2
+		case "--iptc-caption", "--iptc-byline", "--iptc-copyright", "--iptc-keywords":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			switch args[i] {
+			case "--iptc-caption":
+				opts.iptc.Caption = args[i+1]
+			case "--iptc-byline":
+				opts.iptc.Byline = args[i+1]
+			case "--iptc-copyright":
+				opts.iptc.Copyright = args[i+1]
+			default:
+				opts.iptc.Keywords = metadata.ParsePolicyList(args[i+1])
+			}
+			i += 2
+		case "--sidecar":
+			if i+1 >= len(args) {
+				fatal("missing value for %s (json or xmp)", args[i])
+			}
+			f, err := metadata.ParseSidecarFormat(args[i+1])
+			if err != nil {
+				fatal("%v", err)
+			}
+			opts.sidecar = f
+			i += 2
+		case "--from-sidecar":
+			opts.fromSidecar = true
+			i++
 		case "-w", "--watch":
 			opts.watchMode = true
 			i++
@@ -164,6 +270,32 @@ func parseArgs(args []string) *options {
 		case "--dry-run":
 			opts.dryRun = true
 			i++
+		case "--incremental":
+			opts.incremental = true
+			i++
+		case "--manifest":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			opts.manifestPath = args[i+1]
+			opts.incremental = true
+			i += 2
+		case "--prune":
+			opts.prune = true
+			i++
+		case "--journal":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			opts.journalPath = args[i+1]
+			i += 2
+		case "--resume":
+			opts.resume = true
+			i++
+		case "--retry-failed":
+			opts.retryFailed = true
+			opts.resume = true
+			i++
 		case "-v", "--verbose":
 			opts.verbose = true
 			i++
@@ -271,6 +403,9 @@ func parseArgs(args []string) *options {
 		case "--backup":
 			opts.backup = true
 			i++
+		case "--preserve-attrs":
+			opts.preserveAttrs = true
+			i++
 		case "--json":
 			opts.jsonOutput = true
 			i++
@@ -396,6 +531,95 @@ func parseArgs(args []string) *options {
 		case "--lossless":
 			opts.lossless = true
 			i++
+		case "--optimize":
+			opts.pngOptimize = true
+			i++
+		case "--auto-formats":
+			if i+1 >= len(args) {
+				fatal("missing value for %s (e.g. avif,webp,jxl,jpeg)", args[i])
+			}
+			opts.autoFormats = nil
+			for _, name := range strings.Split(args[i+1], ",") {
+				f, err := codec.ParseFormat(strings.TrimSpace(name))
+				if err != nil || f == codec.Auto {
+					fatal("invalid auto format %q", name)
+				}
+				opts.autoFormats = append(opts.autoFormats, f)
+			}
+			i += 2
+		case "--chroma":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			// The bundled AVIF and HEIC encoders only write 4:2:0
+			if args[i+1] != "420" {
+				fatal("chroma must be 420 (444 and 422 are not supported by the bundled encoders)")
+			}
+			opts.chroma = args[i+1]
+			i += 2
+		case "--jxl-effort":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			e, err := strconv.Atoi(args[i+1])
+			if err != nil || e < 1 || e > 9 {
+				fatal("jxl-effort must be 1-9")
+			}
+			opts.jxlEffort = e
+			i += 2
+		case "--jxl-distance":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			d, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || d < 0.01 || d > 25 {
+				fatal("jxl-distance must be a number between 0.01 and 25")
+			}
+			opts.jxlDistance = d
+			i += 2
+		case "--avif-speed":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			sp, err := strconv.Atoi(args[i+1])
+			if err != nil || sp < 0 || sp > 10 {
+				fatal("avif-speed must be 0-10")
+			}
+			opts.avifSpeed = sp
+			i += 2
+		case "--avif-alpha-quality":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			aq, err := strconv.Atoi(args[i+1])
+			if err != nil || aq < 1 || aq > 100 {
+				fatal("avif-alpha-quality must be 1-100")
+			}
+			opts.avifAlphaQuality = aq
+			i += 2
+		case "--avif-depth":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			bd, err := strconv.Atoi(args[i+1])
+			if err != nil || bd != 8 {
+				fatal("avif-depth must be 8 (10 and 12 are not supported by the bundled encoder)")
+			}
+			opts.avifBitDepth = bd
+			i += 2
+		case "--reencode-jpeg":
+			opts.reencodeJPEG = true
+			i++
+		case "--auto-min-ssim":
+			if i+1 >= len(args) {
+				fatal("missing value for %s", args[i])
+			}
+			ms, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || ms <= 0 || ms > 1 {
+				fatal("auto-min-ssim must be a number between 0 and 1")
+			}
+			opts.autoMinSSIM = ms
+			i += 2
 		case "--watermark-size":
 			if i+1 >= len(args) {
 				fatal("missing value for %s", args[i])
@@ -529,9 +753,32 @@ func parseArgs(args []string) *options {
 			opts.smartCropWidth = sw
 			opts.smartCropHeight = sh
 			i += 2
+		case "--op":
+			if i+1 >= len(args) {
+				fatal("missing value for %s (e.g. resize:max=1200, crop:ratio=1:1, blur:2)", args[i])
+			}
+			op, err := pipeline.ParseOp(args[i+1])
+			if err != nil {
+				fatal("%v", err)
+			}
+			opts.ops = append(opts.ops, op)
+			i += 2
+		case "--variant":
+			if i+1 >= len(args) {
+				fatal("missing value for %s (e.g. webp:640, avif:max=1280,q=60)", args[i])
+			}
+			v, err := pipeline.ParseVariant(args[i+1])
+			if err != nil {
+				fatal("%v", err)
+			}
+			opts.variants = append(opts.variants, v)
+			i += 2
 		case "mcp":
 			opts.mcpMode = true
 			i++
+		case "info":
+			opts.infoMode = true
+			i++
 		case "serve":
 			addr := ":8080"
 			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
@@ -559,6 +806,20 @@ func parseArgs(args []string) *options {
 	if opts.metadata && opts.stripMetadata {
 		fatal("--preserve-metadata and --strip-metadata are mutually exclusive")
 	}
+	if opts.fromSidecar && opts.stripMetadata {
+		fatal("--from-sidecar and --strip-metadata are mutually exclusive")
+	}
+	if opts.prune && !opts.incremental {
+		fatal("--prune requires --incremental")
+	}
+	if !opts.metadataPolicy.IsZero() {
+		if opts.stripMetadata {
+			fatal("--keep-exif/--strip-exif cannot be combined with --strip-metadata")
+		}
+		if err := opts.metadataPolicy.Validate(); err != nil {
+			fatal("%v", err)
+		}
+	}
 
 	needsInput := !opts.watchMode && opts.configFile == "" && opts.completionSh == "" &&
 		opts.serveAddr == "" && len(opts.ssimFiles) == 0 && !opts.mcpMode && !opts.scanMode
@@ -576,6 +837,7 @@ Usage:
   pixshift [options] <files or directories...>
   pixshift serve [addr]
   pixshift mcp
+  pixshift info <files...>
   pixshift --scan [dir]
   pixshift --tree [dir]
   pixshift --dedup [dir]
@@ -584,18 +846,60 @@ Usage:
   pixshift --palette [N] <files...>
 
 Conversion options:
-  -f, --format <fmt>        Output format: jpg, png, gif, webp, tiff, bmp, heic, avif
+  -f, --format <fmt>        Output format: jpg, png, gif, webp, tiff, bmp, heic, avif, jxl, auto
   -q, --quality <1-100>     Encoding quality (default: 92)
   -j, --jobs <N>            Parallel workers (default: number of CPUs)
+      --max-memory <size>   Memory budget for running jobs, e.g. 4G or 512M (plain
+                            numbers are MB); larger images start first and wait for room
+      --job-timeout <sec>   Fail any single conversion that runs longer (default: none)
   -o, --output <dir>        Output directory (default: same as input)
   -r, --recursive           Process directories recursively
   -m, --preserve-metadata   Preserve EXIF metadata
   -s, --strip-metadata      Strip all EXIF/GPS metadata from output
+      --keep-exif <list>    Preserve only these EXIF groups/tags (plus orientation and other
+                            image structure tags), e.g. copyright,artist,datetime
+      --strip-exif <list>   Preserve EXIF except these groups/tags, e.g. gps,serial or privacy
+                            Groups: gps, serial, owner, camera, exposure, datetime, copyright,
+                            artist, description, software, makernote, thumbnail, image, other;
+                            privacy = gps,serial,owner,makernote. Tag names (GPSAltitude) also work
+      --xmp-title <text>    Set the XMP title (dc:title)
+      --xmp-creator <name>  Set the XMP creator (dc:creator)
+      --xmp-rights <text>   Set the XMP rights statement (dc:rights)
+      --xmp-keywords <list> Set the XMP keywords (dc:subject), comma-separated
+      --iptc-caption <text> Set the IPTC caption/abstract (2:120)
+      --iptc-byline <name>  Set the IPTC by-line (2:80)
+      --iptc-copyright <text>
+                            Set the IPTC copyright notice (2:116)
+      --iptc-keywords <list>
+                            Set the IPTC keywords (2:25), comma-separated
+                            XMP and IPTC fields are written even with --strip-metadata;
+                            outputs other than JPEG and TIFF get the IPTC fields as XMP
+      --sidecar <json|xmp>  Write the source metadata (EXIF, XMP, IPTC, ICC summary) to
+                            <output>.json or <output>.xmp, even with --strip-metadata
+      --from-sidecar        Take the metadata from the input's sidecar (<input>.json,
+                            <input>.xmp or <name>.xmp) instead of the file; implies -m
   -w, --watch               Watch mode: auto-convert new files
   -c, --config <file>       Rules mode: use YAML config file
       --overwrite           Overwrite existing output files
       --dry-run             Preview what would happen
-      --template <pattern>  Output naming template (placeholders: {name}, {ext}, {format})
+      --incremental         Rebuild only outputs whose input or settings changed, as
+                            recorded in .pixshift-cache.json (in -o or the current dir)
+      --manifest <file>     Manifest file for --incremental (implies --incremental)
+      --prune               With --incremental, delete outputs whose input was removed
+                            and outputs a job no longer writes
+      --journal <file>      Journal of finished jobs, kept when a run fails or is
+                            interrupted (default: .pixshift-journal.jsonl in -o or the
+                            current dir)
+      --resume              Skip the jobs the journal records as done or failed
+      --retry-failed        Like --resume, but convert the failed jobs again
+      --template <pattern>  Output naming template; may contain / for folders. Placeholders:
+                            {name} {ext} {format} {parent} {date[:2006/01/02]} {camera}
+                            {lens} {width} {height} {hash8} {counter[:digits]}
+      --variant <fmt[:params]>
+                            Write an output variant, decoding each input once (repeatable),
+                            e.g. --variant webp:640 --variant avif:max=1280,q=60
+                            Params: N (max dimension), WxH, name, q, ratio, gravity,
+                            template (default name: {name}-<N|WxH|name>)
       --preset <name>       Named preset: web, thumbnail, print, archive
 
 Image transforms:
@@ -610,6 +914,12 @@ Image transforms:
       --watermark-size <N>   Watermark font scale (default: 1.0, e.g. 3.0 = 3x)
       --watermark-color <hex> Watermark text color (default: #FFFFFF)
       --watermark-bg <hex>   Watermark background color (default: #000000)
+      --op <op[:params]>     Append an operation to the chain, applied in order after the
+                             transform, filter and resize flags (repeatable), e.g.
+                             --op resize:max=1200 --op crop:ratio=1:1,gravity=north
+                             Ops: auto-rotate, smart-crop:WxH, crop:WxH|ratio=W:H,
+                             resize:WxH|max=N, watermark:text=T,pos=P, brightness:N,
+                             contrast:N, sharpen, blur:R, grayscale, sepia:I, invert
 
 Filters:
       --grayscale            Convert to grayscale
@@ -630,7 +940,17 @@ Encoding options:
       --progressive          JPEG progressive encoding (reserved for future encoder)
       --png-compression <N>  PNG compression: 0=default, 1=none, 2=fast, 3=best
       --webp-method <N>     WebP encoding method: 0-6 (0=fast, 6=best)
-      --lossless             WebP lossless mode
+      --lossless             Lossless mode (WebP, JXL, HEIC)
+      --optimize             Search PNG color types, bit depths and filters for the smallest file
+      --chroma <mode>        Chroma subsampling for AVIF/HEIC: 420
+      --jxl-effort <N>       JXL encoder effort 1-9 (default: 7)
+      --jxl-distance <N>     JXL Butteraugli distance 0.01-25 (overrides -q)
+      --avif-speed <N>       AVIF encoder speed 0-10 (0=slowest/best, default: 0)
+      --avif-alpha-quality <N> AVIF alpha channel quality 1-100 (default: same as -q)
+      --avif-depth <N>       AVIF bit depth: 8
+      --reencode-jpeg        Re-encode JPEG from pixels instead of lossless transcode/rotate/crop
+      --auto-formats <list>  Candidates for -f auto (default: avif,webp,jxl,jpeg)
+      --auto-min-ssim <N>    SSIM target auto candidates are tuned to, up to -q (default: 0.95)
 
 Analysis tools:
       --scan                Scan directory: count images by format with sizes
@@ -645,6 +965,7 @@ Analysis tools:
 
 Other:
       --backup              Create .bak backup of originals before converting
+      --preserve-attrs      Give outputs the input file's mode and modification time
       --json                Output results as JSON
       --completion <shell>  Generate shell completion (bash, zsh, fish)
   -v, --verbose             Verbose output
@@ -660,6 +981,10 @@ MCP mode:
   pixshift mcp              Start MCP (Model Context Protocol) server on stdio
                             Exposes tools: convert_image, get_formats, analyze_image, compare_images
 
+Info mode:
+  pixshift info <files...>  Show format, dimensions and EXIF (camera, lens, exposure,
+                            capture date, GPS); -v lists every tag, --json for JSON
+
 Serve mode:
   pixshift serve [addr]     Start HTTP conversion server (default: :8080)
     POST /convert           Upload image with multipart form (file, format, quality)
@@ -681,6 +1006,11 @@ Presets:
   archive     PNG, q100, preserve metadata
   (custom presets can be defined in config YAML under "presets:" section)
 
+Exit codes:
+  0 success, 1 error or failures of mixed kinds, 2 unsupported format,
+  3 decode failed, 4 encode failed, 5 metadata failed, 6 limit exceeded,
+  7 I/O error, 130 interrupted
+
 Examples:
   pixshift photo.heic                            Convert HEIC to JPEG (default)
   pixshift -f webp -q 90 photo.heic             Convert to WebP at quality 90
@@ -693,6 +1023,11 @@ Examples:
   pixshift --watermark "Test" --watermark-size 3 --watermark-color "#FF0000" -f jpg photo.jpg
   pixshift --png-compression 3 -f png photo.jpg  Best PNG compression
   pixshift --lossless -f webp photo.jpg           Lossless WebP
+  pixshift --optimize -f png -o out/ shot.png    Smallest lossless PNG
+  pixshift --jxl-effort 9 --jxl-distance 1 -f jxl photo.png Slow, visually lossless JXL
+  pixshift --avif-speed 6 --avif-alpha-quality 90 -f avif logo.png
+  pixshift -f auto -o web/ photos/               Pick the smallest format per image
+  pixshift -f jxl -o archive/ photos/            Lossless JPEG->JXL recompression
   pixshift --tree ~/Pictures                     Show image directory tree
   pixshift --dedup ~/Pictures                    Find duplicate images
   pixshift --ssim original.jpg compressed.jpg    Compare image quality
@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/naming"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 	"github.com/schollz/progressbar/v3"
 )
@@ -25,6 +28,11 @@ func runBatchMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 
 	// Determine base directories for relative path preservation
 	baseDirs := resolveBaseDirs(opts.inputs)
+	tmpl := parseTemplate(opts)
+	variantTmpls := parseVariantTemplates(opts, outputFormat)
+	counters := newCounterMarks(append([]*naming.Template{tmpl}, variantTmpls...)...)
+	inc := openIncremental(opts)
+	jr := openJournal(opts)
 
 	var jobs []pipeline.Job
 	for _, f := range files {
@@ -36,42 +44,83 @@ func runBatchMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 			continue
 		}
 
-		// Skip if input and output format are the same
-		if inputFormat == outputFormat {
+		counters.mark()
+		var outPath string
+		var variants []pipeline.Variant
+		if len(opts.variants) > 0 {
+			variants = make([]pipeline.Variant, len(opts.variants))
+			for i, v := range opts.variants {
+				v.OutputPath = buildOutputPath(f, opts.outputDir, v.OutputFormat(outputFormat), variantTmpls[i], baseDirs, opts.recursive)
+				variants[i] = v
+			}
+		} else {
+			outPath = buildOutputPath(f, opts.outputDir, outputFormat, tmpl, baseDirs, opts.recursive)
+		}
+
+		job := buildJob(opts, f, outPath, outputFormat, inputFormat)
+		job.Variants = variants
+
+		// Skip if input and output format are the same, unless the job
+		// transforms or optimizes the image (variants are usually resized, so a JPEG
+		// may well get JPEG variants)
+		if inputFormat == outputFormat && len(variants) == 0 && !job.ChangesImage() {
+			counters.reset()
 			if opts.verbose {
 				fmt.Fprintf(os.Stderr, "skip %s: already %s\n", f, outputFormat)
 			}
 			continue
 		}
+		// Auto output only knows its path once it has chosen the format
+		job.NoOverwrite = !opts.overwrite && inc == nil
 
-		outPath := buildOutputPath(f, opts.outputDir, outputFormat, opts.template, baseDirs, opts.recursive)
-
-		if !opts.overwrite {
-			if _, err := os.Stat(outPath); err == nil {
+		if why, ok := jr.skip(job); ok {
+			counters.reset()
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "skip %s: %s\n", f, why)
+			}
+			continue
+		}
+		if inc != nil {
+			// The manifest decides, so stale outputs are rebuilt
+			if inc.upToDate(job) && !opts.overwrite {
+				counters.reset()
 				if opts.verbose {
-					fmt.Fprintf(os.Stderr, "skip %s: output exists (use --overwrite)\n", f)
+					fmt.Fprintf(os.Stderr, "skip %s: up to date\n", f)
 				}
 				continue
 			}
+		} else if !opts.overwrite && anyExists(job) {
+			counters.reset()
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "skip %s: output exists (use --overwrite)\n", f)
+			}
+			continue
 		}
 
-		job := buildJob(opts, f, outPath, outputFormat, inputFormat)
 		jobs = append(jobs, job)
 	}
 
 	if len(jobs) == 0 {
+		if inc != nil && !opts.dryRun {
+			inc.finish()
+		}
+		if jr != nil {
+			jr.finish(ctx)
+		}
 		fmt.Println("Nothing to convert.")
 		return
 	}
 
 	if opts.dryRun {
 		if opts.jsonOutput {
-			items := make([]map[string]string, len(jobs))
-			for i, j := range jobs {
-				items[i] = map[string]string{
-					"input":  j.InputPath,
-					"output": j.OutputPath,
-					"format": string(j.OutputFormat),
+			var items []map[string]string
+			for _, j := range jobs {
+				for _, out := range jobOutputs(j) {
+					items = append(items, map[string]string{
+						"input":  j.InputPath,
+						"output": out.path,
+						"format": string(out.format),
+					})
 				}
 			}
 			enc := json.NewEncoder(os.Stdout)
@@ -79,7 +128,9 @@ func runBatchMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 			_ = enc.Encode(items)
 		} else {
 			for _, j := range jobs {
-				fmt.Printf("[dry-run] %s -> %s\n", j.InputPath, j.OutputPath)
+				for _, out := range jobOutputs(j) {
+					fmt.Printf("[dry-run] %s -> %s\n", j.InputPath, out.path)
+				}
 			}
 			fmt.Printf("\n%d file(s) would be converted.\n", len(jobs))
 		}
@@ -87,8 +138,10 @@ func runBatchMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 	}
 
 	pool := pipeline.NewPool(pipe, opts.jobs)
+	pool.MaxMemory = opts.maxMemory
 
 	var succeeded, failed int
+	var status exitStatus
 	var totalInputSize, totalOutputSize int64
 	var jsonResults []map[string]interface{}
 
@@ -107,8 +160,15 @@ func runBatchMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 		)
 
 		pool.RunWithCallback(ctx, jobs, func(r pipeline.Result, completed, total int) {
+			if inc != nil {
+				inc.record(r)
+			}
+			if jr != nil {
+				jr.record(r)
+			}
 			if r.Error != nil {
 				failed++
+				status.add(r.Error)
 			} else {
 				succeeded++
 				totalInputSize += r.InputSize
@@ -121,40 +181,81 @@ func runBatchMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 	} else {
 		// Verbose, JSON, or single-file mode
 		pool.RunWithCallback(ctx, jobs, func(r pipeline.Result, completed, total int) {
+			if inc != nil {
+				inc.record(r)
+			}
+			if jr != nil {
+				jr.record(r)
+			}
 			if r.Error != nil {
 				failed++
+				status.add(r.Error)
 				if opts.jsonOutput {
-					jsonResults = append(jsonResults, map[string]interface{}{
+					item := map[string]interface{}{
 						"input":  r.Job.InputPath,
 						"error":  r.Error.Error(),
 						"status": "failed",
-					})
+					}
+					if kind := errorKind(r.Error); kind != "" {
+						item["error_kind"] = kind
+					}
+					if r.Variants != nil {
+						item["variants"] = variantsJSON(r.Variants)
+					}
+					jsonResults = append(jsonResults, item)
 				} else {
 					fmt.Fprintf(os.Stderr, "[%d/%d] FAIL %s: %v\n", completed, total, r.Job.InputPath, r.Error)
+					printPanicStack(r.Error, opts.verbose)
 				}
 			} else {
 				succeeded++
 				totalInputSize += r.InputSize
 				totalOutputSize += r.OutputSize
 				if opts.jsonOutput {
-					jsonResults = append(jsonResults, map[string]interface{}{
+					item := map[string]interface{}{
 						"input":       r.Job.InputPath,
 						"output":      r.Job.OutputPath,
 						"input_size":  r.InputSize,
 						"output_size": r.OutputSize,
 						"status":      "ok",
-					})
+					}
+					if r.Auto != nil {
+						item["auto"] = autoDecisionJSON(r.Auto)
+					}
+					if r.Transcoded {
+						item["transcoded"] = true
+					}
+					if r.Variants != nil {
+						delete(item, "output")
+						item["variants"] = variantsJSON(r.Variants)
+					}
+					jsonResults = append(jsonResults, item)
+				} else if r.Variants != nil {
+					printVariants(r, completed, total)
 				} else {
 					fmt.Printf("[%d/%d] %s (%s) -> %s (%s) [%s]\n",
 						completed, total,
 						r.Job.InputPath, humanSize(r.InputSize),
 						r.Job.OutputPath, humanSize(r.OutputSize),
 						sizeRatio(r.InputSize, r.OutputSize))
+					if r.Auto != nil && opts.verbose {
+						fmt.Fprintf(os.Stderr, "  auto: %s\n", describeAutoDecision(r.Auto))
+					}
+					if r.Transcoded && opts.verbose {
+						fmt.Fprintf(os.Stderr, "  lossless JPEG transcode\n")
+					}
 				}
 			}
 		})
 	}
 
+	if inc != nil {
+		inc.finish()
+	}
+	if jr != nil {
+		jr.finish(ctx)
+	}
+
 	if opts.jsonOutput {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
@@ -175,6 +276,162 @@ func runBatchMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 		fmt.Println()
 	}
 	if failed > 0 {
-		os.Exit(1)
+		status.exit(ctx)
+	}
+}
+
+// errorKind classifies a job error for JSON output: "panic" for a
+// recovered panic, "timeout" for a job that exceeded --job-timeout, the
+// pipeline error kind ("unsupported_format", "decode", "encode",
+// "metadata", "limit" or "io"), and "" for other failures.
+func errorKind(err error) string {
+	var pe *pipeline.PanicError
+	var te *pipeline.TimeoutError
+	switch {
+	case errors.As(err, &pe):
+		return "panic"
+	case errors.As(err, &te):
+		return "timeout"
+	}
+	switch pipeline.ErrorKind(err) {
+	case pipeline.ErrUnsupportedFormat:
+		return "unsupported_format"
+	case pipeline.ErrDecode:
+		return "decode"
+	case pipeline.ErrEncode:
+		return "encode"
+	case pipeline.ErrMetadata:
+		return "metadata"
+	case pipeline.ErrLimit:
+		return "limit"
+	case pipeline.ErrIO:
+		return "io"
+	}
+	return ""
+}
+
+// printPanicStack prints the stack of a recovered panic in verbose mode.
+func printPanicStack(err error, verbose bool) {
+	var pe *pipeline.PanicError
+	if verbose && errors.As(err, &pe) {
+		fmt.Fprintf(os.Stderr, "%s\n", pe.Stack)
+	}
+}
+
+// output is one file a job writes.
+type output struct {
+	path   string
+	format codec.Format
+}
+
+// jobOutputs returns the files a job writes: its output, or its variants.
+func jobOutputs(j pipeline.Job) []output {
+	if len(j.Variants) == 0 {
+		return []output{{j.OutputPath, j.OutputFormat}}
+	}
+	outs := make([]output, len(j.Variants))
+	for i, v := range j.Variants {
+		outs[i] = output{v.OutputPath, v.OutputFormat(j.OutputFormat)}
+	}
+	return outs
+}
+
+// printVariants prints the outcome of a job with variants, one line per
+// variant below the input.
+func printVariants(r pipeline.Result, completed, total int) {
+	fmt.Printf("[%d/%d] %s (%s) -> %d variants (%s) [%s]\n",
+		completed, total,
+		r.Job.InputPath, humanSize(r.InputSize),
+		len(r.Variants), humanSize(r.OutputSize),
+		sizeRatio(r.InputSize, r.OutputSize))
+	for _, v := range r.Variants {
+		fmt.Printf("  %s (%s)\n", v.OutputPath, humanSize(v.OutputSize))
+	}
+}
+
+// anyExists reports whether one of the job's outputs exists. Auto output
+// may be written under the extension of any candidate format.
+func anyExists(j pipeline.Job) bool {
+	for _, out := range jobOutputs(j) {
+		paths := []string{out.path}
+		if out.format == codec.Auto {
+			paths = pipeline.AutoOutputPaths(j, out.path)
+		}
+		for _, p := range paths {
+			if _, err := os.Stat(p); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// variantsJSON converts variant results into JSON-friendly maps.
+func variantsJSON(vrs []pipeline.VariantResult) []map[string]interface{} {
+	items := make([]map[string]interface{}, len(vrs))
+	for i, v := range vrs {
+		item := map[string]interface{}{
+			"output": v.OutputPath,
+			"format": string(v.Format),
+		}
+		if v.Error != nil {
+			item["error"] = v.Error.Error()
+			item["status"] = "failed"
+		} else {
+			item["output_size"] = v.OutputSize
+			item["status"] = "ok"
+		}
+		if v.Auto != nil {
+			item["auto"] = autoDecisionJSON(v.Auto)
+		}
+		items[i] = item
+	}
+	return items
+}
+
+// autoDecisionJSON converts an auto-format decision into a JSON-friendly map.
+func autoDecisionJSON(d *pipeline.AutoDecision) map[string]interface{} {
+	trials := make([]map[string]interface{}, len(d.Trials))
+	for i, t := range d.Trials {
+		trial := map[string]interface{}{
+			"format": string(t.Format),
+		}
+		if t.Err != nil {
+			trial["error"] = t.Err.Error()
+		} else {
+			trial["size"] = t.Size
+			trial["ssim"] = t.SSIM
+			if t.Quality > 0 {
+				trial["quality"] = t.Quality
+			}
+		}
+		trials[i] = trial
+	}
+	decision := map[string]interface{}{
+		"format": string(d.Format),
+		"ssim":   d.SSIM,
+		"trials": trials,
+	}
+	if d.Quality > 0 {
+		decision["quality"] = d.Quality
+	}
+	return decision
+}
+
+// describeAutoDecision summarizes the candidates tried for an auto-format job.
+func describeAutoDecision(d *pipeline.AutoDecision) string {
+	parts := make([]string, len(d.Trials))
+	for i, t := range d.Trials {
+		switch {
+		case t.Err != nil:
+			parts[i] = fmt.Sprintf("%s skipped (%v)", t.Format, t.Err)
+		case t.Quality > 0:
+			parts[i] = fmt.Sprintf("%s %s q=%d ssim=%.4f", t.Format, humanSize(t.Size), t.Quality, t.SSIM)
+		case t.SSIM > 0:
+			parts[i] = fmt.Sprintf("%s %s ssim=%.4f", t.Format, humanSize(t.Size), t.SSIM)
+		default:
+			parts[i] = fmt.Sprintf("%s %s", t.Format, humanSize(t.Size))
+		}
 	}
+	return fmt.Sprintf("chose %s from %s", d.Format, strings.Join(parts, ", "))
 }
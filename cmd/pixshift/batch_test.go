@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+func writeTestImage(t *testing.T, path string, format codec.Format) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if format == codec.JPEG {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunBatchMode_SameFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		format codec.Format
+		input  string
+		want   bool // output written
+	}{
+		{"plain png", []string{"-f", "png"}, codec.PNG, "shot.png", false},
+		{"optimize png", []string{"--optimize", "-f", "png"}, codec.PNG, "shot.png", true},
+		{"plain jpeg", []string{"-f", "jpg"}, codec.JPEG, "photo.jpg", false},
+		{"crop jpeg", []string{"--crop", "32x32", "-f", "jpg"}, codec.JPEG, "photo.jpg", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, tt.input)
+			writeTestImage(t, in, tt.format)
+			outDir := filepath.Join(dir, "out")
+
+			opts := parseArgs(append(tt.args, "-o", outDir, in))
+			reg := codec.DefaultRegistry()
+			runBatchMode(context.Background(), pipeline.NewPipeline(reg), reg, tt.format, opts)
+
+			_, err := os.Stat(filepath.Join(outDir, tt.input))
+			if got := err == nil; got != tt.want {
+				t.Errorf("output written = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBatchMode_CounterOnlyNumbersConverted(t *testing.T) {
+	dir := t.TempDir()
+	var inputs []string
+	for _, name := range []string{"a.jpg", "b.png", "c.jpg"} {
+		in := filepath.Join(dir, name)
+		format := codec.JPEG
+		if filepath.Ext(name) == ".png" {
+			format = codec.PNG
+		}
+		writeTestImage(t, in, format)
+		inputs = append(inputs, in)
+	}
+	outDir := filepath.Join(dir, "out")
+
+	// b.png is already PNG and skipped, so it takes no number
+	opts := parseArgs(append([]string{"-f", "png", "--template", "{counter}", "-o", outDir}, inputs...))
+	reg := codec.DefaultRegistry()
+	runBatchMode(context.Background(), pipeline.NewPipeline(reg), reg, codec.PNG, opts)
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "1.png" || names[1] != "2.png" {
+		t.Errorf("outputs = %v, want [1.png 2.png]", names)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+// Exit codes. A run whose failed jobs all failed the same way exits with
+// that kind's code, so scripts can tell bad inputs from a full disk.
+const (
+	exitFailure     = 1 // usage errors, panics, timeouts and mixed failures
+	exitUnsupported = 2 // pipeline.ErrUnsupportedFormat
+	exitDecode      = 3 // pipeline.ErrDecode
+	exitEncode      = 4 // pipeline.ErrEncode
+	exitMetadata    = 5 // pipeline.ErrMetadata
+	exitLimit       = 6 // pipeline.ErrLimit
+	exitIO          = 7 // pipeline.ErrIO
+	exitInterrupted = 130
+)
+
+// exitCode returns the exit code for a job's error.
+func exitCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+	switch pipeline.ErrorKind(err) {
+	case pipeline.ErrUnsupportedFormat:
+		return exitUnsupported
+	case pipeline.ErrDecode:
+		return exitDecode
+	case pipeline.ErrEncode:
+		return exitEncode
+	case pipeline.ErrMetadata:
+		return exitMetadata
+	case pipeline.ErrLimit:
+		return exitLimit
+	case pipeline.ErrIO:
+		return exitIO
+	}
+	return exitFailure
+}
+
+// exitStatus collects the exit code of a batch's failed jobs.
+type exitStatus struct {
+	mu   sync.Mutex
+	code int
+}
+
+// add records a failed job.
+func (s *exitStatus) add(err error) {
+	c := exitCode(err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.code == 0 {
+		s.code = c
+	} else if s.code != c {
+		s.code = exitFailure
+	}
+}
+
+// exit exits with the code of the failures: an interrupted run exits with
+// exitInterrupted, and failures of different kinds with exitFailure.
+func (s *exitStatus) exit(ctx context.Context) {
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+	s.mu.Lock()
+	code := s.code
+	s.mu.Unlock()
+	os.Exit(code)
+}
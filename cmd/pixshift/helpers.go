@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/naming"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 	"github.com/DanielTso/pixshift/internal/preset"
 	"github.com/DanielTso/pixshift/internal/rules"
@@ -39,6 +41,7 @@ func buildJob(opts *options, inputPath, outputPath string, outputFormat codec.Fo
 		WatermarkColor:   opts.watermarkColor,
 		WatermarkBg:      opts.watermarkBg,
 		BackupOriginal:   opts.backup,
+		PreserveAttrs:    opts.preserveAttrs,
 		Grayscale:        opts.grayscale,
 		Sepia:            opts.sepia,
 		Brightness:       opts.brightness,
@@ -47,13 +50,16 @@ func buildJob(opts *options, inputPath, outputPath string, outputFormat codec.Fo
 		Blur:             opts.blur,
 		Invert:           opts.invert,
 		Interpolation:    opts.interpolation,
-		EncodeOpts: codec.EncodeOptions{
-			Quality:     opts.quality,
-			Progressive: opts.progressive,
-			Compression: opts.pngCompression,
-			WebPMethod:  opts.webpMethod,
-			Lossless:    opts.lossless,
-		},
+		EncodeOpts:       buildEncodeOptions(opts),
+		AutoFormats:      opts.autoFormats,
+		AutoMinSSIM:      opts.autoMinSSIM,
+		ReencodeJPEG:     opts.reencodeJPEG,
+		MetadataPolicy:   opts.metadataPolicy,
+		XMP:              opts.xmp,
+		IPTC:             opts.iptc,
+		Sidecar:          opts.sidecar,
+		FromSidecar:      opts.fromSidecar,
+		Ops:              opts.ops,
 	}
 }
 
@@ -77,6 +83,7 @@ func applyOptsToJob(opts *options, job *pipeline.Job) {
 	job.WatermarkColor = opts.watermarkColor
 	job.WatermarkBg = opts.watermarkBg
 	job.BackupOriginal = opts.backup
+	job.PreserveAttrs = opts.preserveAttrs
 	job.Grayscale = opts.grayscale
 	job.Sepia = opts.sepia
 	job.Brightness = opts.brightness
@@ -85,12 +92,43 @@ func applyOptsToJob(opts *options, job *pipeline.Job) {
 	job.Blur = opts.blur
 	job.Invert = opts.invert
 	job.Interpolation = opts.interpolation
-	job.EncodeOpts = codec.EncodeOptions{
-		Quality:     opts.quality,
-		Progressive: opts.progressive,
-		Compression: opts.pngCompression,
-		WebPMethod:  opts.webpMethod,
-		Lossless:    opts.lossless,
+	job.ReencodeJPEG = opts.reencodeJPEG
+	if !opts.metadataPolicy.IsZero() {
+		job.MetadataPolicy = opts.metadataPolicy
+	}
+	if !opts.xmp.IsZero() {
+		job.XMP = opts.xmp
+	}
+	if !opts.iptc.IsZero() {
+		job.IPTC = opts.iptc
+	}
+	if opts.sidecar != "" {
+		job.Sidecar = opts.sidecar
+	}
+	if opts.fromSidecar {
+		job.FromSidecar = true
+	}
+	if len(opts.ops) > 0 {
+		job.Ops = opts.ops
+	}
+	job.EncodeOpts = buildEncodeOptions(opts)
+}
+
+// buildEncodeOptions creates format-specific encoding options from CLI options.
+func buildEncodeOptions(opts *options) codec.EncodeOptions {
+	return codec.EncodeOptions{
+		Quality:          opts.quality,
+		Progressive:      opts.progressive,
+		Subsample:        opts.chroma,
+		Compression:      opts.pngCompression,
+		WebPMethod:       opts.webpMethod,
+		Lossless:         opts.lossless,
+		JXLEffort:        opts.jxlEffort,
+		JXLDistance:      opts.jxlDistance,
+		AVIFSpeed:        opts.avifSpeed,
+		AVIFAlphaQuality: opts.avifAlphaQuality,
+		AVIFBitDepth:     opts.avifBitDepth,
+		PNGOptimize:      opts.pngOptimize,
 	}
 }
 
@@ -169,24 +207,75 @@ func resolveBaseDirs(inputs []string) map[string]string {
 	return bases
 }
 
-func buildOutputPath(inputPath, outputDir string, format codec.Format, tmpl string, baseDirs map[string]string, recursive bool) string {
-	base := filepath.Base(inputPath)
-	ext := filepath.Ext(base)
-	name := base[:len(base)-len(ext)]
+// parseTemplate parses the --template option, or returns nil without one.
+func parseTemplate(opts *options) *naming.Template {
+	if opts.template == "" {
+		return nil
+	}
+	tmpl, err := naming.Parse(opts.template)
+	if err != nil {
+		fatal("invalid --template: %v", err)
+	}
+	return tmpl
+}
 
-	// Apply template if set
-	var outName string
-	if tmpl != "" {
-		outName = tmpl
-		outName = strings.ReplaceAll(outName, "{name}", name)
-		outName = strings.ReplaceAll(outName, "{ext}", strings.TrimPrefix(ext, "."))
-		outName = strings.ReplaceAll(outName, "{format}", string(format))
-		// Ensure it has the right extension
-		if filepath.Ext(outName) == "" {
-			outName += codec.DefaultExtension(format)
+// parseVariantTemplates validates the --variant options, whose default
+// format is outputFormat, and parses their name templates.
+func parseVariantTemplates(opts *options, outputFormat codec.Format) []*naming.Template {
+	if err := pipeline.ValidateVariants(opts.variants, outputFormat); err != nil {
+		fatal("invalid --variant: %v", err)
+	}
+	tmpls := make([]*naming.Template, len(opts.variants))
+	for i, v := range opts.variants {
+		var err error
+		if tmpls[i], err = v.NameTemplate(i); err != nil {
+			fatal("invalid --variant: %v", err)
 		}
+	}
+	return tmpls
+}
+
+// counterMarks gives back the {counter} numbers a file took from the
+// output templates when the file is skipped after its paths were built,
+// so only converted files are numbered.
+type counterMarks struct {
+	tmpls []*naming.Template
+	marks []int64
+}
+
+// newCounterMarks returns the counter marks of the non-nil templates.
+func newCounterMarks(tmpls ...*naming.Template) *counterMarks {
+	c := &counterMarks{}
+	for _, t := range tmpls {
+		if t != nil {
+			c.tmpls = append(c.tmpls, t)
+		}
+	}
+	c.marks = make([]int64, len(c.tmpls))
+	return c
+}
+
+// mark records the templates' positions before a file's paths are built.
+func (c *counterMarks) mark() {
+	for i, t := range c.tmpls {
+		c.marks[i] = t.Mark()
+	}
+}
+
+// reset gives back the numbers taken since mark.
+func (c *counterMarks) reset() {
+	for i, t := range c.tmpls {
+		t.Reset(c.marks[i])
+	}
+}
+
+func buildOutputPath(inputPath, outputDir string, format codec.Format, tmpl *naming.Template, baseDirs map[string]string, recursive bool) string {
+	var outName string
+	if tmpl != nil {
+		outName = tmpl.OutputName(inputPath, format)
 	} else {
-		outName = name + codec.DefaultExtension(format)
+		base := filepath.Base(inputPath)
+		outName = base[:len(base)-len(filepath.Ext(base))] + codec.DefaultExtension(format)
 	}
 
 	if outputDir != "" {
@@ -254,6 +343,8 @@ func loadPresetsFromConfig(path string) {
 				Height:           pc.Height,
 				StripMetadata:    pc.StripMetadata,
 				PreserveMetadata: pc.PreserveMetadata,
+				KeepEXIF:         pc.KeepEXIF,
+				StripEXIF:        pc.StripEXIF,
 				Grayscale:        pc.Grayscale,
 				Sharpen:          pc.Sharpen,
 				AutoRotate:       pc.AutoRotate,
@@ -263,6 +354,30 @@ func loadPresetsFromConfig(path string) {
 	}
 }
 
+// parseMemory parses a memory size such as "4G", "512M", "1.5GB" or
+// "2048" (MB) into bytes. Units are binary: 1K = 1024 bytes.
+func parseMemory(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	unit := int64(1 << 20)
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'K':
+			unit, num = 1<<10, num[:n-1]
+		case 'M':
+			unit, num = 1<<20, num[:n-1]
+		case 'G':
+			unit, num = 1<<30, num[:n-1]
+		case 'T':
+			unit, num = 1<<40, num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 4G, 512M)", s)
+	}
+	return int64(v * float64(unit)), nil
+}
+
 // humanSize formats bytes into human-readable size.
 func humanSize(b int64) string {
 	const unit = 1024
@@ -292,6 +407,11 @@ func sizeRatio(input, output int64) string {
 }
 
 func fatal(format string, args ...interface{}) {
+	exitf(exitFailure, format, args...)
+}
+
+// exitf prints an error like fatal and exits with code.
+func exitf(code int, format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
-	os.Exit(1)
+	os.Exit(code)
 }
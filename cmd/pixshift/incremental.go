@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/DanielTso/pixshift/internal/manifest"
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+// incremental tracks a batch run in the --incremental manifest: jobs whose
+// outputs are up to date are skipped, and successful jobs are recorded.
+type incremental struct {
+	m       *manifest.Manifest
+	prune   bool
+	verbose bool
+
+	mu     sync.Mutex
+	hashes map[string]string // input path -> hash, from UpToDate for Record
+}
+
+// openIncremental loads the manifest for --incremental: --manifest, or
+// .pixshift-cache.json in the output directory or the current directory.
+// It returns nil without --incremental.
+func openIncremental(opts *options) *incremental {
+	if !opts.incremental {
+		return nil
+	}
+	path := opts.manifestPath
+	if path == "" {
+		path = filepath.Join(opts.outputDir, manifest.FileName)
+	}
+	m, err := manifest.Load(path)
+	if err != nil {
+		fatal("%v", err)
+	}
+	return &incremental{m: m, prune: opts.prune, verbose: opts.verbose, hashes: make(map[string]string)}
+}
+
+// upToDate reports whether the job's outputs are up to date. An input that
+// cannot be checked is rebuilt.
+func (inc *incremental) upToDate(job pipeline.Job) bool {
+	ok, hash, err := inc.m.UpToDate(job)
+	if err != nil {
+		if inc.verbose {
+			fmt.Fprintf(os.Stderr, "manifest: %s: %v\n", job.InputPath, err)
+		}
+		return false
+	}
+	inc.mu.Lock()
+	inc.hashes[job.InputPath] = hash
+	inc.mu.Unlock()
+	return ok
+}
+
+// record stores a successful job in the manifest and, with --prune,
+// deletes the outputs it no longer writes.
+func (inc *incremental) record(r pipeline.Result) {
+	if r.Error != nil {
+		return
+	}
+	inc.mu.Lock()
+	hash := inc.hashes[r.Job.InputPath]
+	inc.mu.Unlock()
+
+	var outputs []string
+	if r.Variants != nil {
+		for _, v := range r.Variants {
+			outputs = append(outputs, v.OutputPath)
+		}
+	} else {
+		outputs = []string{r.Job.OutputPath}
+	}
+	stale, err := inc.m.Record(r.SubmittedJob(), hash, outputs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "manifest: %s: %v\n", r.Job.InputPath, err)
+		return
+	}
+	if inc.prune {
+		inc.remove(stale)
+	}
+}
+
+// finish deletes, with --prune, the outputs of inputs that no longer
+// exist, and saves the manifest.
+func (inc *incremental) finish() {
+	if inc.prune {
+		inc.remove(inc.m.Orphans())
+	}
+	if err := inc.m.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+}
+
+func (inc *incremental) remove(paths []string) {
+	for _, p := range paths {
+		err := os.Remove(p)
+		switch {
+		case err == nil:
+			if inc.verbose {
+				fmt.Fprintf(os.Stderr, "pruned %s\n", p)
+			}
+		case !os.IsNotExist(err):
+			fmt.Fprintf(os.Stderr, "warning: prune %s: %v\n", p, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+)
+
+// fileInfo is the per-file result of info mode.
+type fileInfo struct {
+	File     string               `json:"file"`
+	Format   codec.Format         `json:"format"`
+	Width    int                  `json:"width,omitempty"`
+	Height   int                  `json:"height,omitempty"`
+	Size     int64                `json:"size"`
+	EXIF     *metadata.Summary    `json:"exif,omitempty"`
+	EXIFTags []metadata.Field     `json:"exif_tags,omitempty"`
+	XMP      *metadata.XMPFields  `json:"xmp,omitempty"`
+	IPTC     *metadata.IPTCFields `json:"iptc,omitempty"`
+}
+
+func runInfoMode(reg *codec.Registry, opts *options) {
+	files := collectFiles(opts.inputs, opts.recursive)
+	if len(files) == 0 {
+		fatal("no supported image files found")
+	}
+
+	var results []fileInfo
+	for _, f := range files {
+		info, err := inspectFile(reg, f, opts.jsonOutput || opts.verbose)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", f, err)
+			continue
+		}
+		results = append(results, *info)
+	}
+
+	if opts.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+		return
+	}
+
+	for _, r := range results {
+		printFileInfo(r)
+	}
+}
+
+// inspectFile reads the format, dimensions and EXIF, XMP and IPTC
+// metadata of a file.
+func inspectFile(reg *codec.Registry, path string, withTags bool) (*fileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	format, err := codec.DetectFormat(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("detect format: %w", err)
+	}
+	info := &fileInfo{File: path, Format: format, Size: st.Size()}
+
+	// Read the size from the header; RAW files report their preview's, and
+	// formats without a probe (JXL) are decoded
+	if !codec.IsRAW(format) {
+		if _, err := f.Seek(0, 0); err != nil {
+			return nil, fmt.Errorf("seek: %w", err)
+		}
+		info.Width, info.Height, _ = codec.ProbeDimensions(f)
+	}
+	if dec, err := reg.Decoder(format); err == nil && info.Width == 0 {
+		if _, err := f.Seek(0, 0); err != nil {
+			return nil, fmt.Errorf("seek: %w", err)
+		}
+		if img, err := dec.Decode(f); err == nil {
+			info.Width = img.Bounds().Dx()
+			info.Height = img.Bounds().Dy()
+		}
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	meta, err := metadata.Extract(f, format)
+	if err != nil {
+		return info, nil
+	}
+	if exif, err := meta.EXIF(); err == nil {
+		info.EXIF = exif.Summary()
+		if withTags {
+			info.EXIFTags = exif.Fields()
+		}
+	}
+	if xmp, err := meta.XMPFields(); err == nil && !xmp.IsZero() {
+		info.XMP = &xmp
+	}
+	if iptc, err := meta.IPTCFields(); err == nil && !iptc.IsZero() {
+		info.IPTC = &iptc
+	}
+	return info, nil
+}
+
+func printFileInfo(r fileInfo) {
+	fmt.Printf("%s:\n", r.File)
+	fmt.Printf("  Format:      %s\n", r.Format)
+	if r.Width > 0 {
+		fmt.Printf("  Dimensions:  %dx%d\n", r.Width, r.Height)
+	}
+	fmt.Printf("  Size:        %s\n", humanSize(r.Size))
+
+	if s := r.EXIF; s != nil {
+		if camera := strings.TrimSpace(s.Make + " " + s.Model); camera != "" {
+			fmt.Printf("  Camera:      %s\n", camera)
+		}
+		if lens := strings.TrimSpace(s.LensMake + " " + s.LensModel); lens != "" {
+			fmt.Printf("  Lens:        %s\n", lens)
+		}
+		if exposure := describeExposure(s); exposure != "" {
+			fmt.Printf("  Exposure:    %s\n", exposure)
+		}
+		if s.DateTimeOriginal != nil {
+			fmt.Printf("  Captured:    %s\n", s.DateTimeOriginal.Format("2006-01-02 15:04:05 -07:00"))
+		}
+		if s.GPS != nil {
+			fmt.Printf("  GPS:         %.6f, %.6f", s.GPS.Latitude, s.GPS.Longitude)
+			if s.GPS.Altitude != nil {
+				fmt.Printf(" (%.1f m)", *s.GPS.Altitude)
+			}
+			fmt.Println()
+		}
+		if s.Orientation > 1 {
+			fmt.Printf("  Orientation: %d\n", s.Orientation)
+		}
+		if s.Artist != "" {
+			fmt.Printf("  Artist:      %s\n", s.Artist)
+		}
+		if s.Copyright != "" {
+			fmt.Printf("  Copyright:   %s\n", s.Copyright)
+		}
+		if s.Software != "" {
+			fmt.Printf("  Software:    %s\n", s.Software)
+		}
+	}
+
+	if x := r.XMP; x != nil {
+		if x.Title != "" {
+			fmt.Printf("  Title:       %s\n", x.Title)
+		}
+		if x.Creator != "" {
+			fmt.Printf("  Creator:     %s\n", x.Creator)
+		}
+		if x.Rights != "" {
+			fmt.Printf("  Rights:      %s\n", x.Rights)
+		}
+		if len(x.Keywords) > 0 {
+			fmt.Printf("  Keywords:    %s\n", strings.Join(x.Keywords, ", "))
+		}
+	}
+
+	if p := r.IPTC; p != nil {
+		fmt.Println("  IPTC:")
+		for _, f := range []struct{ label, value string }{
+			{"Object name", p.ObjectName},
+			{"Headline", p.Headline},
+			{"Caption", p.Caption},
+			{"By-line", p.Byline},
+			{"Credit", p.Credit},
+			{"Source", p.Source},
+			{"Copyright", p.Copyright},
+			{"City", p.City},
+			{"Country", p.Country},
+			{"Date", p.DateCreated},
+			{"Keywords", strings.Join(p.Keywords, ", ")},
+		} {
+			if f.value != "" {
+				fmt.Printf("    %-12s %s\n", f.label+":", f.value)
+			}
+		}
+	}
+
+	if len(r.EXIFTags) > 0 {
+		fmt.Println("  EXIF tags:")
+		for _, t := range r.EXIFTags {
+			fmt.Printf("    %-7s %-28s %v\n", t.IFD, t.Name, t.Value)
+		}
+	}
+	fmt.Println()
+}
+
+// describeExposure formats exposure settings like "1/250s f/2.8 ISO 400 50mm".
+func describeExposure(s *metadata.Summary) string {
+	var parts []string
+	if s.ExposureTime != "" {
+		parts = append(parts, s.ExposureTime+"s")
+	}
+	if s.FNumber > 0 {
+		parts = append(parts, fmt.Sprintf("f/%g", s.FNumber))
+	}
+	if s.ISO > 0 {
+		parts = append(parts, fmt.Sprintf("ISO %d", s.ISO))
+	}
+	if s.FocalLength > 0 {
+		parts = append(parts, fmt.Sprintf("%gmm", s.FocalLength))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+func TestInspectFile_ProbesDimensions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shot.png")
+	writeTestImage(t, path, codec.PNG)
+
+	// Without decoders the size can only come from the header
+	info, err := inspectFile(codec.NewRegistry(), path, false)
+	if err != nil {
+		t.Fatalf("inspectFile: %v", err)
+	}
+	if info.Format != codec.PNG || info.Width != 64 || info.Height != 64 {
+		t.Errorf("info = %s %dx%d, want png 64x64", info.Format, info.Width, info.Height)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/DanielTso/pixshift/internal/journal"
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+// batchJournal records a batch run in the journal and, with --resume,
+// skips the jobs an earlier run finished.
+type batchJournal struct {
+	j           *journal.Journal
+	write       bool // false for --dry-run
+	resume      bool
+	retryFailed bool
+}
+
+// openJournal opens the journal: --journal, or .pixshift-journal.jsonl in
+// the output directory or the current directory. A dry run writes no
+// journal, so it returns nil unless the dry run resumes one.
+func openJournal(opts *options) *batchJournal {
+	if opts.dryRun && !opts.resume {
+		return nil
+	}
+	path := opts.journalPath
+	if path == "" {
+		path = filepath.Join(opts.outputDir, journal.FileName)
+	}
+	j, err := journal.Open(path, opts.resume)
+	if err != nil {
+		fatal("%v", err)
+	}
+	return &batchJournal{j: j, write: !opts.dryRun, resume: opts.resume, retryFailed: opts.retryFailed}
+}
+
+// skip reports whether --resume skips the job, and why. A nil journal
+// skips nothing.
+func (bj *batchJournal) skip(job pipeline.Job) (string, bool) {
+	if bj == nil || !bj.resume {
+		return "", false
+	}
+	status, ok := bj.j.Status(job)
+	switch {
+	case !ok:
+		return "", false
+	case status == journal.Done:
+		return "done in an earlier run", true
+	case !bj.retryFailed:
+		return "failed in an earlier run (use --retry-failed)", true
+	}
+	return "", false
+}
+
+// record appends a finished job to the journal.
+func (bj *batchJournal) record(r pipeline.Result) {
+	if !bj.write {
+		return
+	}
+	if err := bj.j.Record(r); err != nil {
+		fmt.Fprintf(os.Stderr, "journal: %s: %v\n", r.Job.InputPath, err)
+	}
+}
+
+// finish deletes the journal once the run has converted everything, and
+// otherwise keeps it for --resume.
+func (bj *batchJournal) finish(ctx context.Context) {
+	if bj.write && ctx.Err() == nil && !bj.j.Failed() {
+		if err := bj.j.Remove(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+		return
+	}
+	if err := bj.j.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	if bj.write {
+		fmt.Fprintf(os.Stderr, "Journal kept in %s; rerun with --resume to continue.\n", bj.j.Path())
+	}
+}
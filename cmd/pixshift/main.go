@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/DanielTso/pixshift/internal/codec"
 	"github.com/DanielTso/pixshift/internal/completion"
+	"github.com/DanielTso/pixshift/internal/metadata"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 	"github.com/DanielTso/pixshift/internal/preset"
 )
@@ -62,9 +64,13 @@ func main() {
 		if opts.height == 0 && p.Height > 0 {
 			opts.height = p.Height
 		}
-		if !opts.metadata && !opts.stripMetadata {
+		if !opts.metadata && !opts.stripMetadata && opts.metadataPolicy.IsZero() {
 			opts.metadata = p.PreserveMetadata
 			opts.stripMetadata = p.StripMetadata
+			opts.metadataPolicy = metadata.Policy{Keep: p.KeepEXIF, Strip: p.StripEXIF}
+			if err := opts.metadataPolicy.Validate(); err != nil {
+				fatal("preset %q: %v", p.Name, err)
+			}
 		}
 		if p.Grayscale {
 			opts.grayscale = true
@@ -84,6 +90,7 @@ func main() {
 	}
 
 	pipe := pipeline.NewPipeline(registry)
+	pipe.JobTimeout = time.Duration(opts.jobTimeout) * time.Second
 
 	// Serve mode
 	if opts.serveAddr != "" {
@@ -97,6 +104,12 @@ func main() {
 		return
 	}
 
+	// Info mode
+	if opts.infoMode {
+		runInfoMode(registry, opts)
+		return
+	}
+
 	// Palette mode
 	if opts.paletteCount > 0 {
 		runPaletteMode(registry, opts)
@@ -137,6 +150,17 @@ func main() {
 		loadPresetsFromConfig(opts.configFile)
 	}
 
+	batch := !opts.watchMode && !(len(opts.inputs) == 1 && opts.inputs[0] == "-")
+	if len(opts.variants) > 0 && !batch {
+		fatal("--variant is only supported in batch mode")
+	}
+	if opts.incremental && !batch {
+		fatal("--incremental is only supported in batch mode")
+	}
+	if opts.resume && !batch {
+		fatal("--resume is only supported in batch mode")
+	}
+
 	// Rules mode
 	if opts.configFile != "" {
 		runRulesMode(ctx, pipe, registry, opts)
@@ -155,7 +179,7 @@ func main() {
 
 	// Stdin/stdout mode
 	if len(opts.inputs) == 1 && opts.inputs[0] == "-" {
-		runStdinMode(pipe, registry, outputFormat, opts)
+		runStdinMode(ctx, pipe, registry, outputFormat, opts)
 		return
 	}
 
@@ -26,6 +26,7 @@ func runRulesMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 	engine.OutputDir = opts.outputDir
 	engine.Quality = opts.quality
 	engine.Metadata = opts.metadata
+	engine.Template = parseTemplate(opts)
 
 	if opts.outputDir != "" {
 		if err := os.MkdirAll(opts.outputDir, 0755); err != nil {
@@ -53,6 +54,9 @@ func runRulesMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 		fatal("no supported image files found")
 	}
 
+	inc := openIncremental(opts)
+	jr := openJournal(opts)
+	counters := newCounterMarks(engine.Templates()...)
 	var jobs []pipeline.Job
 	for _, f := range files {
 		inputFormat, err := detectFileFormat(f)
@@ -63,6 +67,7 @@ func runRulesMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 			continue
 		}
 
+		counters.mark()
 		job := engine.Match(f, inputFormat)
 		if job == nil {
 			if opts.verbose {
@@ -73,44 +78,81 @@ func runRulesMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 
 		// Apply resize, transform, and strip settings from CLI
 		applyOptsToJob(opts, job)
+		job.NoOverwrite = !opts.overwrite && inc == nil
 
-		if !opts.overwrite {
-			if _, err := os.Stat(job.OutputPath); err == nil {
+		if why, ok := jr.skip(*job); ok {
+			counters.reset()
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "skip %s: %s\n", f, why)
+			}
+			continue
+		}
+		if inc != nil {
+			if inc.upToDate(*job) && !opts.overwrite {
+				counters.reset()
 				if opts.verbose {
-					fmt.Fprintf(os.Stderr, "skip %s: output exists\n", f)
+					fmt.Fprintf(os.Stderr, "skip %s: up to date\n", f)
 				}
 				continue
 			}
+		} else if !opts.overwrite && anyExists(*job) {
+			counters.reset()
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "skip %s: output exists\n", f)
+			}
+			continue
 		}
 
 		jobs = append(jobs, *job)
 	}
 
 	if len(jobs) == 0 {
+		if inc != nil && !opts.dryRun {
+			inc.finish()
+		}
+		if jr != nil {
+			jr.finish(ctx)
+		}
 		fmt.Println("Nothing to convert.")
 		return
 	}
 
 	if opts.dryRun {
 		for _, j := range jobs {
-			fmt.Printf("[dry-run] %s -> %s (%s)\n", j.InputPath, j.OutputPath, j.OutputFormat)
+			for _, out := range jobOutputs(j) {
+				fmt.Printf("[dry-run] %s -> %s (%s)\n", j.InputPath, out.path, out.format)
+			}
 		}
 		fmt.Printf("\n%d file(s) would be converted.\n", len(jobs))
 		return
 	}
 
 	pool := pipeline.NewPool(pipe, opts.jobs)
+	pool.MaxMemory = opts.maxMemory
 
 	var succeeded, failed int
+	var status exitStatus
 	var totalInputSize, totalOutputSize int64
 	pool.RunWithCallback(ctx, jobs, func(r pipeline.Result, completed, total int) {
+		if inc != nil {
+			inc.record(r)
+		}
+		if jr != nil {
+			jr.record(r)
+		}
 		if r.Error != nil {
 			failed++
+			status.add(r.Error)
 			fmt.Fprintf(os.Stderr, "[%d/%d] FAIL %s: %v\n", completed, total, r.Job.InputPath, r.Error)
+			printPanicStack(r.Error, opts.verbose)
 		} else {
 			succeeded++
 			totalInputSize += r.InputSize
 			totalOutputSize += r.OutputSize
+			if r.Variants != nil {
+				printVariants(r, completed, total)
+				return
+			}
 			fmt.Printf("[%d/%d] %s (%s) -> %s (%s) [%s]\n",
 				completed, total,
 				r.Job.InputPath, humanSize(r.InputSize),
@@ -119,6 +161,13 @@ func runRulesMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 		}
 	})
 
+	if inc != nil {
+		inc.finish()
+	}
+	if jr != nil {
+		jr.finish(ctx)
+	}
+
 	fmt.Printf("\nDone. %d converted, %d failed.", succeeded, failed)
 	if totalInputSize > 0 && succeeded > 0 {
 		fmt.Printf(" Total: %s -> %s (%s)",
@@ -127,7 +176,7 @@ func runRulesMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Regis
 	}
 	fmt.Println()
 	if failed > 0 {
-		os.Exit(1)
+		status.exit(ctx)
 	}
 }
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"io"
 	"os"
 
@@ -8,7 +9,7 @@ import (
 	"github.com/DanielTso/pixshift/internal/pipeline"
 )
 
-func runStdinMode(pipe *pipeline.Pipeline, reg *codec.Registry, outputFormat codec.Format, opts *options) {
+func runStdinMode(ctx context.Context, pipe *pipeline.Pipeline, reg *codec.Registry, outputFormat codec.Format, opts *options) {
 	// Buffer stdin to a temp file for seeking
 	tmpIn, err := os.CreateTemp("", "pixshift-stdin-*")
 	if err != nil {
@@ -33,12 +34,17 @@ func runStdinMode(pipe *pipeline.Pipeline, reg *codec.Registry, outputFormat cod
 	job := buildJob(opts, tmpIn.Name(), "", outputFormat, "")
 	job.OutputPath = tmpOut.Name()
 
-	if _, _, err := pipe.Execute(job); err != nil {
-		fatal("convert: %v", err)
+	res := pipe.ExecuteJobContext(ctx, job)
+	if res.Error != nil {
+		exitf(exitCode(res.Error), "convert: %v", res.Error)
+	}
+	if res.Job.OutputPath != tmpOut.Name() {
+		// Auto output resolved to a different extension
+		defer os.Remove(res.Job.OutputPath)
 	}
 
 	// Write output to stdout
-	outFile, err := os.Open(tmpOut.Name())
+	outFile, err := os.Open(res.Job.OutputPath)
 	if err != nil {
 		fatal("read output: %v", err)
 	}
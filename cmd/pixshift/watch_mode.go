@@ -38,6 +38,7 @@ func runWatchMode(ctx context.Context, pipe *pipeline.Pipeline, outputFormat cod
 		IgnorePatterns: opts.watchIgnore,
 		MaxRetries:     opts.watchRetry,
 		JobTemplate:    buildJob(opts, "", "", outputFormat, ""),
+		NameTemplate:   parseTemplate(opts),
 		OnConvert: func(r pipeline.Result) {
 			if r.Error != nil {
 				fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", r.Job.InputPath, r.Error)
@@ -1,6 +1,7 @@
 package codec
 
 import (
+	"fmt"
 	"image"
 	"io"
 
@@ -20,6 +21,26 @@ func (e *avifEncoder) Encode(w io.Writer, img image.Image, quality int) error {
 	return avif.Encode(w, img, &avif.Options{ColorQuality: quality, AlphaQuality: quality})
 }
 
+func (e *avifEncoder) EncodeWithOptions(w io.Writer, img image.Image, opts EncodeOptions) error {
+	// The bundled libavif build always encodes 8-bit YUV 4:2:0
+	if opts.Subsample != "" && opts.Subsample != "420" {
+		return fmt.Errorf("avif: chroma subsampling %s is not supported (only 420)", opts.Subsample)
+	}
+	if opts.AVIFBitDepth != 0 && opts.AVIFBitDepth != 8 {
+		return fmt.Errorf("avif: bit depth %d is not supported (only 8)", opts.AVIFBitDepth)
+	}
+
+	alphaQuality := opts.AVIFAlphaQuality
+	if alphaQuality == 0 {
+		alphaQuality = opts.Quality
+	}
+	return avif.Encode(w, img, &avif.Options{
+		Speed:        opts.AVIFSpeed,
+		ColorQuality: opts.Quality,
+		AlphaQuality: alphaQuality,
+	})
+}
+
 func (e *avifEncoder) Format() Format { return AVIF }
 
 func registerAVIF(r *Registry) {
@@ -1,6 +1,8 @@
 package codec
 
 import (
+	"errors"
+	"fmt"
 	"image"
 	"io"
 	"strings"
@@ -26,8 +28,16 @@ const (
 	RAF  Format = "raf"  // Fujifilm
 	ORF  Format = "orf"  // Olympus
 	RW2  Format = "rw2"  // Panasonic
+
+	// Auto is a pseudo output format: the pipeline trial-encodes a set of
+	// candidate formats and keeps the smallest acceptable result.
+	Auto Format = "auto"
 )
 
+// DefaultAutoCandidates is the candidate set tried for Auto output when none
+// is configured, in order of preference for equally sized results.
+var DefaultAutoCandidates = []Format{AVIF, WebP, JXL, JPEG}
+
 // Decoder can decode an image from a reader.
 type Decoder interface {
 	Decode(r io.ReadSeeker) (image.Image, error)
@@ -44,10 +54,32 @@ type Encoder interface {
 type EncodeOptions struct {
 	Quality     int
 	Progressive bool   // JPEG: progressive encoding (reserved for future encoder)
-	Subsample   string // JPEG: chroma subsampling "444", "422", "420" (reserved)
+	Subsample   string // AVIF, HEIC: chroma subsampling, only "420" ("" = 420; JPEG reserved)
 	Compression int    // PNG: 0=default, 1=none, 2=fast, 3=best
 	WebPMethod  int    // WebP: encoding method 0-6 (speed vs quality)
-	Lossless    bool   // WebP: lossless mode
+	Lossless    bool   // WebP, JXL, HEIC: lossless mode
+
+	// v0.9.0 fields
+	JXLEffort        int     // JXL: encoder effort 1-9 (0 = default 7)
+	JXLDistance      float64 // JXL: Butteraugli distance 0.01-25 (0 = derived from Quality)
+	AVIFSpeed        int     // AVIF: encoder speed 0-10, higher is faster (0 = slowest, best)
+	AVIFAlphaQuality int     // AVIF: alpha channel quality 1-100 (0 = same as Quality)
+	AVIFBitDepth     int     // AVIF: bits per channel, only 8 (0 = 8)
+	PNGOptimize      bool    // PNG: search color types, bit depths and filters for the smallest file
+	EXIF             []byte  // JXL: TIFF-structured EXIF block (no "Exif\0\0" prefix) stored in an Exif box
+	XMP              []byte  // JXL: XMP packet stored in an "xml " box
+}
+
+// Validate rejects options the bundled encoders cannot honor: AVIF and
+// HEIC are always encoded as 8-bit 4:2:0.
+func (o EncodeOptions) Validate() error {
+	if o.Subsample != "" && o.Subsample != "420" {
+		return fmt.Errorf("chroma subsampling %s is not supported (only 420)", o.Subsample)
+	}
+	if o.AVIFBitDepth != 0 && o.AVIFBitDepth != 8 {
+		return fmt.Errorf("AVIF bit depth %d is not supported (only 8)", o.AVIFBitDepth)
+	}
+	return nil
 }
 
 // AdvancedEncoder extends Encoder with format-specific encoding options.
@@ -56,6 +88,24 @@ type AdvancedEncoder interface {
 	EncodeWithOptions(w io.Writer, img image.Image, opts EncodeOptions) error
 }
 
+// JPEGRecompressor can losslessly recompress a JPEG bitstream without decoding
+// it to pixels, keeping enough data to reconstruct the original file.
+type JPEGRecompressor interface {
+	Encoder
+	RecompressJPEG(w io.Writer, jpegData []byte, opts EncodeOptions) error
+}
+
+// JPEGReconstructor can restore the original JPEG bitstream from a file that
+// was produced by a JPEGRecompressor.
+type JPEGReconstructor interface {
+	Decoder
+	ReconstructJPEG(w io.Writer, r io.Reader) error
+}
+
+// ErrNoJPEGReconstruction is returned by ReconstructJPEG when the input does
+// not carry JPEG reconstruction data.
+var ErrNoJPEGReconstruction = errors.New("no JPEG reconstruction data")
+
 // AnimatedImage holds a multi-frame animation.
 type AnimatedImage struct {
 	Frames    []image.Image
@@ -85,6 +135,15 @@ func IsRAW(f Format) bool {
 	return false
 }
 
+// SupportsAlpha returns true if the format's encoder preserves an alpha channel.
+func SupportsAlpha(f Format) bool {
+	switch f {
+	case PNG, GIF, WebP, TIFF, HEIC, AVIF, JXL:
+		return true
+	}
+	return false
+}
+
 // DefaultExtension returns the primary file extension for a format.
 func DefaultExtension(f Format) string {
 	switch f {
@@ -86,3 +86,16 @@ func TestIsRAW(t *testing.T) {
 		}
 	}
 }
+
+func TestSupportsAlpha(t *testing.T) {
+	for _, f := range []Format{PNG, GIF, WebP, TIFF, AVIF, JXL} {
+		if !SupportsAlpha(f) {
+			t.Errorf("SupportsAlpha(%q) = false, want true", f)
+		}
+	}
+	for _, f := range []Format{JPEG, BMP, CR2} {
+		if SupportsAlpha(f) {
+			t.Errorf("SupportsAlpha(%q) = true, want false", f)
+		}
+	}
+}
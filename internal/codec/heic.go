@@ -1,6 +1,7 @@
 package codec
 
 import (
+	"fmt"
 	"image"
 	"io"
 
@@ -20,6 +21,20 @@ func (e *heicEncoder) Encode(w io.Writer, img image.Image, quality int) error {
 	return heif.Encode(w, img, &heif.Options{Quality: quality})
 }
 
+func (e *heicEncoder) EncodeWithOptions(w io.Writer, img image.Image, opts EncodeOptions) error {
+	// The bundled libheif build always encodes lossy HEVC as 4:2:0
+	if opts.Subsample != "" && opts.Subsample != "420" {
+		return fmt.Errorf("heic: chroma subsampling %s is not supported (only 420)", opts.Subsample)
+	}
+
+	// libheif switches the HEVC encoder to lossless mode at quality 100
+	quality := opts.Quality
+	if opts.Lossless {
+		quality = 100
+	}
+	return heif.Encode(w, img, &heif.Options{Quality: quality})
+}
+
 func (e *heicEncoder) Format() Format { return HEIC }
 
 func registerHEIC(r *Registry) {
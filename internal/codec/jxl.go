@@ -105,12 +105,17 @@ static int jxl_decode(const uint8_t* data, size_t data_len,
     return 0;
 }
 
-// jxl_encode encodes RGBA pixels into JXL format.
+// jxl_encode encodes RGBA pixels into JXL format. When exif_size is
+// non-zero, exif (a 4-byte TIFF header offset followed by the EXIF data)
+// is stored in an "Exif" box, and when xmp_size is non-zero the XMP
+// packet in an "xml " box; both require the container format.
 // Returns 0 on success, negative on error.
 // On success, *out_data is allocated with malloc and must be freed by caller.
 // *out_size is set to the output data length.
 static int jxl_encode(const uint8_t* pixels, uint32_t width, uint32_t height,
                       float distance, int lossless, int effort,
+                      const uint8_t* exif, size_t exif_size,
+                      const uint8_t* xmp, size_t xmp_size,
                       uint8_t** out_data, size_t* out_size) {
     JxlEncoder* enc = JxlEncoderCreate(NULL);
     if (!enc) return -1;
@@ -128,6 +133,15 @@ static int jxl_encode(const uint8_t* pixels, uint32_t width, uint32_t height,
         return -3;
     }
 
+    if (exif_size > 0 || xmp_size > 0) {
+        if (JxlEncoderUseContainer(enc, JXL_TRUE) != JXL_ENC_SUCCESS ||
+            JxlEncoderUseBoxes(enc) != JXL_ENC_SUCCESS) {
+            JxlThreadParallelRunnerDestroy(runner);
+            JxlEncoderDestroy(enc);
+            return -10;
+        }
+    }
+
     JxlBasicInfo info;
     JxlEncoderInitBasicInfo(&info);
     info.xsize = width;
@@ -173,6 +187,24 @@ static int jxl_encode(const uint8_t* pixels, uint32_t width, uint32_t height,
         return -6;
     }
 
+    if (exif_size > 0) {
+        if (JxlEncoderAddBox(enc, "Exif", exif, exif_size, JXL_FALSE) != JXL_ENC_SUCCESS) {
+            JxlThreadParallelRunnerDestroy(runner);
+            JxlEncoderDestroy(enc);
+            return -11;
+        }
+    }
+    if (xmp_size > 0) {
+        if (JxlEncoderAddBox(enc, "xml ", xmp, xmp_size, JXL_FALSE) != JXL_ENC_SUCCESS) {
+            JxlThreadParallelRunnerDestroy(runner);
+            JxlEncoderDestroy(enc);
+            return -12;
+        }
+    }
+    if (exif_size > 0 || xmp_size > 0) {
+        JxlEncoderCloseBoxes(enc);
+    }
+
     JxlEncoderCloseInput(enc);
 
     // Collect output
@@ -221,6 +253,192 @@ static int jxl_encode(const uint8_t* pixels, uint32_t width, uint32_t height,
     JxlEncoderDestroy(enc);
     return 0;
 }
+// jxl_transcode_jpeg losslessly recompresses a JPEG bitstream into JXL using a
+// JPEG reconstruction frame, so the original file can be restored bit-exactly.
+// Returns 0 on success, negative on error.
+// On success, *out_data is allocated with malloc and must be freed by caller.
+static int jxl_transcode_jpeg(const uint8_t* jpeg_data, size_t jpeg_len, int effort,
+                              uint8_t** out_data, size_t* out_size) {
+    JxlEncoder* enc = JxlEncoderCreate(NULL);
+    if (!enc) return -1;
+
+    void* runner = JxlThreadParallelRunnerCreate(NULL,
+        JxlThreadParallelRunnerDefaultNumWorkerThreads());
+    if (!runner) {
+        JxlEncoderDestroy(enc);
+        return -2;
+    }
+
+    if (JxlEncoderSetParallelRunner(enc, JxlThreadParallelRunner, runner) != JXL_ENC_SUCCESS) {
+        JxlThreadParallelRunnerDestroy(runner);
+        JxlEncoderDestroy(enc);
+        return -3;
+    }
+
+    // Keep the JPEG headers and markers needed for bit-exact reconstruction
+    if (JxlEncoderStoreJPEGMetadata(enc, JXL_TRUE) != JXL_ENC_SUCCESS) {
+        JxlThreadParallelRunnerDestroy(runner);
+        JxlEncoderDestroy(enc);
+        return -4;
+    }
+
+    JxlEncoderFrameSettings* frame_settings = JxlEncoderFrameSettingsCreate(enc, NULL);
+    if (!frame_settings) {
+        JxlThreadParallelRunnerDestroy(runner);
+        JxlEncoderDestroy(enc);
+        return -5;
+    }
+
+    if (effort >= 1 && effort <= 9) {
+        JxlEncoderFrameSettingsSetOption(frame_settings, JXL_ENC_FRAME_SETTING_EFFORT, effort);
+    }
+
+    if (JxlEncoderAddJPEGFrame(frame_settings, jpeg_data, jpeg_len) != JXL_ENC_SUCCESS) {
+        JxlThreadParallelRunnerDestroy(runner);
+        JxlEncoderDestroy(enc);
+        return -6;
+    }
+
+    JxlEncoderCloseInput(enc);
+
+    // Collect output
+    size_t buf_cap = jpeg_len > 64 * 1024 ? jpeg_len : 64 * 1024;
+    uint8_t* buf = (uint8_t*)malloc(buf_cap);
+    if (!buf) {
+        JxlThreadParallelRunnerDestroy(runner);
+        JxlEncoderDestroy(enc);
+        return -7;
+    }
+    size_t total = 0;
+
+    for (;;) {
+        size_t avail = buf_cap - total;
+        uint8_t* next_out = buf + total;
+        JxlEncoderStatus status = JxlEncoderProcessOutput(enc, &next_out, &avail);
+
+        total = (size_t)(next_out - buf);
+
+        if (status == JXL_ENC_SUCCESS) {
+            break;
+        }
+        if (status == JXL_ENC_NEED_MORE_OUTPUT) {
+            size_t new_cap = buf_cap * 2;
+            uint8_t* new_buf = (uint8_t*)realloc(buf, new_cap);
+            if (!new_buf) {
+                free(buf);
+                JxlThreadParallelRunnerDestroy(runner);
+                JxlEncoderDestroy(enc);
+                return -8;
+            }
+            buf = new_buf;
+            buf_cap = new_cap;
+            continue;
+        }
+        // Error
+        free(buf);
+        JxlThreadParallelRunnerDestroy(runner);
+        JxlEncoderDestroy(enc);
+        return -9;
+    }
+
+    *out_data = buf;
+    *out_size = total;
+    JxlThreadParallelRunnerDestroy(runner);
+    JxlEncoderDestroy(enc);
+    return 0;
+}
+
+// jxl_reconstruct_jpeg restores the original JPEG bitstream from a JXL file
+// that carries JPEG reconstruction data.
+// Returns 0 on success, JXL_NO_JPEG_RECONSTRUCTION if the file was not
+// transcoded from a JPEG, other negative values on error.
+// On success, *out_data is allocated with malloc and must be freed by caller.
+#define JXL_NO_JPEG_RECONSTRUCTION -100
+
+static int jxl_reconstruct_jpeg(const uint8_t* data, size_t data_len,
+                                uint8_t** out_data, size_t* out_size) {
+    JxlDecoder* dec = JxlDecoderCreate(NULL);
+    if (!dec) return -1;
+
+    if (JxlDecoderSubscribeEvents(dec, JXL_DEC_JPEG_RECONSTRUCTION | JXL_DEC_FULL_IMAGE) != JXL_DEC_SUCCESS) {
+        JxlDecoderDestroy(dec);
+        return -2;
+    }
+
+    if (JxlDecoderSetInput(dec, data, data_len) != JXL_DEC_SUCCESS) {
+        JxlDecoderDestroy(dec);
+        return -3;
+    }
+    JxlDecoderCloseInput(dec);
+
+    size_t buf_cap = data_len * 2 > 64 * 1024 ? data_len * 2 : 64 * 1024;
+    uint8_t* buf = NULL;
+    int have_jpeg = 0;
+
+    for (;;) {
+        JxlDecoderStatus status = JxlDecoderProcessInput(dec);
+
+        if (status == JXL_DEC_JPEG_RECONSTRUCTION) {
+            buf = (uint8_t*)malloc(buf_cap);
+            if (!buf) {
+                JxlDecoderDestroy(dec);
+                return -4;
+            }
+            if (JxlDecoderSetJPEGBuffer(dec, buf, buf_cap) != JXL_DEC_SUCCESS) {
+                free(buf);
+                JxlDecoderDestroy(dec);
+                return -5;
+            }
+            have_jpeg = 1;
+            continue;
+        }
+
+        if (status == JXL_DEC_JPEG_NEED_MORE_OUTPUT) {
+            size_t used = buf_cap - JxlDecoderReleaseJPEGBuffer(dec);
+            size_t new_cap = buf_cap * 2;
+            uint8_t* new_buf = (uint8_t*)realloc(buf, new_cap);
+            if (!new_buf) {
+                free(buf);
+                JxlDecoderDestroy(dec);
+                return -6;
+            }
+            buf = new_buf;
+            buf_cap = new_cap;
+            if (JxlDecoderSetJPEGBuffer(dec, buf + used, buf_cap - used) != JXL_DEC_SUCCESS) {
+                free(buf);
+                JxlDecoderDestroy(dec);
+                return -7;
+            }
+            continue;
+        }
+
+        if (status == JXL_DEC_NEED_IMAGE_OUT_BUFFER) {
+            // The decoder wants pixels: there is no JPEG to reconstruct
+            if (buf) free(buf);
+            JxlDecoderDestroy(dec);
+            return JXL_NO_JPEG_RECONSTRUCTION;
+        }
+
+        if (status == JXL_DEC_FULL_IMAGE || status == JXL_DEC_SUCCESS) {
+            break;
+        }
+
+        // Error or unexpected status
+        if (buf) free(buf);
+        JxlDecoderDestroy(dec);
+        return -8;
+    }
+
+    if (!have_jpeg) {
+        JxlDecoderDestroy(dec);
+        return JXL_NO_JPEG_RECONSTRUCTION;
+    }
+
+    *out_data = buf;
+    *out_size = buf_cap - JxlDecoderReleaseJPEGBuffer(dec);
+    JxlDecoderDestroy(dec);
+    return 0;
+}
 */
 import "C"
 
@@ -293,12 +511,27 @@ func (e *jxlEncoder) EncodeWithOptions(w io.Writer, img image.Image, opts Encode
 	// quality 90  -> distance 1.0 (visually lossless)
 	// quality 1   -> distance 15.0
 	distance := qualityToJXLDistance(opts.Quality)
+	if opts.JXLDistance > 0 {
+		distance = float32(opts.JXLDistance)
+	}
 	lossless := 0
 	if opts.Lossless {
 		lossless = 1
 		distance = 0.0
 	}
 
+	// The Exif box payload starts with the offset of the TIFF header
+	var exifBox []byte
+	var exifPtr *C.uint8_t
+	if len(opts.EXIF) > 0 {
+		exifBox = append(make([]byte, 4), opts.EXIF...)
+		exifPtr = (*C.uint8_t)(unsafe.Pointer(&exifBox[0]))
+	}
+	var xmpPtr *C.uint8_t
+	if len(opts.XMP) > 0 {
+		xmpPtr = (*C.uint8_t)(unsafe.Pointer(&opts.XMP[0]))
+	}
+
 	var outData *C.uint8_t
 	var outSize C.size_t
 
@@ -308,7 +541,11 @@ func (e *jxlEncoder) EncodeWithOptions(w io.Writer, img image.Image, opts Encode
 		C.uint32_t(height),
 		C.float(distance),
 		C.int(lossless),
-		C.int(7), // default effort: squirrel
+		C.int(jxlEffort(opts)),
+		exifPtr,
+		C.size_t(len(exifBox)),
+		xmpPtr,
+		C.size_t(len(opts.XMP)),
 		&outData,
 		&outSize,
 	)
@@ -324,6 +561,76 @@ func (e *jxlEncoder) EncodeWithOptions(w io.Writer, img image.Image, opts Encode
 
 func (e *jxlEncoder) Format() Format { return JXL }
 
+// RecompressJPEG losslessly transcodes a JPEG bitstream into JXL. The DCT
+// coefficients are kept as-is, so only opts.JXLEffort applies; the original
+// JPEG can be restored with jxlDecoder.ReconstructJPEG.
+func (e *jxlEncoder) RecompressJPEG(w io.Writer, jpegData []byte, opts EncodeOptions) error {
+	if len(jpegData) == 0 {
+		return fmt.Errorf("jxl recompress: empty JPEG data")
+	}
+
+	var outData *C.uint8_t
+	var outSize C.size_t
+
+	ret := C.jxl_transcode_jpeg(
+		(*C.uint8_t)(unsafe.Pointer(&jpegData[0])),
+		C.size_t(len(jpegData)),
+		C.int(jxlEffort(opts)),
+		&outData,
+		&outSize,
+	)
+	if ret != 0 {
+		return fmt.Errorf("jxl jpeg recompression failed (code %d)", int(ret))
+	}
+	defer C.free(unsafe.Pointer(outData))
+
+	encoded := C.GoBytes(unsafe.Pointer(outData), C.int(outSize))
+	_, err := w.Write(encoded)
+	return err
+}
+
+// ReconstructJPEG writes the original JPEG bitstream stored in a JXL file
+// produced by RecompressJPEG. It returns ErrNoJPEGReconstruction if the file
+// was encoded from pixels.
+func (d *jxlDecoder) ReconstructJPEG(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read jxl data: %w", err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("jxl reconstruct: empty input")
+	}
+
+	var outData *C.uint8_t
+	var outSize C.size_t
+
+	ret := C.jxl_reconstruct_jpeg(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])),
+		C.size_t(len(data)),
+		&outData,
+		&outSize,
+	)
+	if ret == C.JXL_NO_JPEG_RECONSTRUCTION {
+		return ErrNoJPEGReconstruction
+	}
+	if ret != 0 {
+		return fmt.Errorf("jxl jpeg reconstruction failed (code %d)", int(ret))
+	}
+	defer C.free(unsafe.Pointer(outData))
+
+	jpegData := C.GoBytes(unsafe.Pointer(outData), C.int(outSize))
+	_, err = w.Write(jpegData)
+	return err
+}
+
+// jxlEffort returns the encoder effort for opts, defaulting to 7 (squirrel).
+func jxlEffort(opts EncodeOptions) int {
+	if opts.JXLEffort >= 1 && opts.JXLEffort <= 9 {
+		return opts.JXLEffort
+	}
+	return 7
+}
+
 // qualityToJXLDistance converts a 1-100 quality scale to JXL distance (0.0-15.0).
 // distance 0.0 = mathematically lossless
 // distance 1.0 = visually lossless
@@ -352,6 +659,12 @@ var _ AdvancedEncoder = (*jxlEncoder)(nil)
 // Ensure jxlDecoder satisfies Decoder interface at compile time.
 var _ Decoder = (*jxlDecoder)(nil)
 
+// Ensure the JXL codec supports lossless JPEG transcoding in both directions.
+var (
+	_ JPEGRecompressor  = (*jxlEncoder)(nil)
+	_ JPEGReconstructor = (*jxlDecoder)(nil)
+)
+
 func registerJXL(r *Registry) {
 	r.RegisterDecoder(&jxlDecoder{})
 	r.RegisterEncoder(&jxlEncoder{})
@@ -20,6 +20,9 @@ func (e *pngEncoder) Encode(w io.Writer, img image.Image, _ int) error {
 }
 
 func (e *pngEncoder) EncodeWithOptions(w io.Writer, img image.Image, opts EncodeOptions) error {
+	if opts.PNGOptimize {
+		return optimizePNG(w, img)
+	}
 	enc := &png.Encoder{}
 	switch opts.Compression {
 	case 1:
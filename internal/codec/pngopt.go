@@ -0,0 +1,441 @@
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// PNG color types.
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPalette   = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+// PNG row filter types, plus pseudo-strategies that choose per row.
+const (
+	filterNone = iota
+	filterSub
+	filterUp
+	filterAverage
+	filterPaeth
+	filterMinSum  // per row: smallest sum of absolute values (libpng heuristic)
+	filterEntropy // per row: lowest byte entropy
+)
+
+// pngCandidate is one lossless representation of an image: a color type and
+// bit depth with its raw (unfiltered) scanlines.
+type pngCandidate struct {
+	colorType byte
+	depth     int
+	palette   []color.NRGBA
+	trns      []byte // tRNS payload, nil if not needed
+	rows      [][]byte
+	bpp       int // bytes per complete pixel, at least 1 (filter offset)
+}
+
+// pngFinalists is how many of the fastest-compressed trial encodings are
+// recompressed at the best zlib level.
+const pngFinalists = 3
+
+// optimizePNG writes img as the smallest PNG it can find. It reduces the
+// color type and bit depth where that loses nothing (palette, grayscale,
+// dropping opaque alpha, 16 to 8 bits), tries every row filter strategy on
+// each representation, and writes only the IHDR, PLTE, tRNS, IDAT and IEND
+// chunks. Trials are ranked with fast compression and the best few are
+// recompressed at maximum compression.
+func optimizePNG(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return png.Encode(w, img)
+	}
+
+	type trial struct {
+		cand     *pngCandidate
+		strategy int
+		size     int
+	}
+	var trials []trial
+	for _, c := range pngCandidates(img) {
+		for strategy := filterNone; strategy <= filterEntropy; strategy++ {
+			// Below 8 bits per pixel filters rarely help; libpng and
+			// oxipng also default to no filtering there.
+			if c.depth*c.channels() < 8 && strategy != filterNone {
+				break
+			}
+			z, err := deflate(c.filter(strategy), zlib.BestSpeed)
+			if err != nil {
+				return err
+			}
+			trials = append(trials, trial{c, strategy, len(z)})
+		}
+	}
+	sort.SliceStable(trials, func(i, j int) bool { return trials[i].size < trials[j].size })
+
+	var best []byte
+	for _, t := range trials[:min(pngFinalists, len(trials))] {
+		idat, err := deflate(t.cand.filter(t.strategy), zlib.BestCompression)
+		if err != nil {
+			return err
+		}
+		data := t.cand.assemble(b.Dx(), b.Dy(), idat)
+		if best == nil || len(data) < len(best) {
+			best = data
+		}
+	}
+	_, err := w.Write(best)
+	return err
+}
+
+// pngCandidates analyses img and returns the lossless representations worth
+// trying, always including full RGBA.
+func pngCandidates(img image.Image) []*pngCandidate {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Only 16-bit image types carry more than 8 bits per sample. Reading
+	// the others through the 8-bit model keeps them exact, as image/png
+	// does.
+	var at func(x, y int) color.NRGBA64
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		at = func(x, y int) color.NRGBA64 {
+			return color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+		}
+	default:
+		at = func(x, y int) color.NRGBA64 {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			return color.NRGBA64{R: uint16(c.R) * 0x101, G: uint16(c.G) * 0x101, B: uint16(c.B) * 0x101, A: uint16(c.A) * 0x101}
+		}
+	}
+
+	// Collect non-premultiplied 16-bit samples, tracking what reductions
+	// the pixel data allows.
+	pix := make([]color.NRGBA64, w*h)
+	opaque, gray, eightBit := true, true, true
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := at(b.Min.X+x, b.Min.Y+y)
+			pix[y*w+x] = c
+			if c.A != 0xFFFF {
+				opaque = false
+			}
+			if c.R != c.G || c.G != c.B {
+				gray = false
+			}
+			if c.R>>8 != c.R&0xFF || c.G>>8 != c.G&0xFF || c.B>>8 != c.B&0xFF || c.A>>8 != c.A&0xFF {
+				eightBit = false
+			}
+		}
+	}
+
+	depth := 16
+	if eightBit {
+		depth = 8
+	}
+
+	var cands []*pngCandidate
+	add := func(colorType byte, d int, sample func(c color.NRGBA64) []uint16) {
+		cands = append(cands, newSampleCandidate(pix, w, h, colorType, d, sample))
+	}
+
+	add(pngRGBA, depth, func(c color.NRGBA64) []uint16 { return []uint16{c.R, c.G, c.B, c.A} })
+	if opaque {
+		add(pngRGB, depth, func(c color.NRGBA64) []uint16 { return []uint16{c.R, c.G, c.B} })
+	}
+	if gray {
+		add(pngGrayAlpha, depth, func(c color.NRGBA64) []uint16 { return []uint16{c.R, c.A} })
+	}
+	if gray && opaque {
+		d := depth
+		if eightBit {
+			d = grayDepth(pix)
+		}
+		add(pngGray, d, func(c color.NRGBA64) []uint16 { return []uint16{c.R} })
+	}
+	if eightBit {
+		if pc := newPaletteCandidate(pix, w, h); pc != nil {
+			cands = append(cands, pc)
+		}
+	}
+	return cands
+}
+
+// grayDepth returns the smallest grayscale bit depth that represents every
+// 8-bit gray level exactly.
+func grayDepth(pix []color.NRGBA64) int {
+	for _, d := range []int{1, 2, 4} {
+		scale := 255 / (1<<d - 1)
+		ok := true
+		for _, c := range pix {
+			if int(c.R&0xFF)%scale != 0 {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return d
+		}
+	}
+	return 8
+}
+
+// newSampleCandidate packs each pixel's samples at the given depth.
+func newSampleCandidate(pix []color.NRGBA64, w, h int, colorType byte, depth int, sample func(color.NRGBA64) []uint16) *pngCandidate {
+	c := &pngCandidate{colorType: colorType, depth: depth}
+	channels := len(sample(color.NRGBA64{}))
+	bits := channels * depth
+	c.bpp = max(1, bits/8)
+	rowBytes := (w*bits + 7) / 8
+	for y := 0; y < h; y++ {
+		row := make([]byte, rowBytes)
+		bitPos := 0
+		for x := 0; x < w; x++ {
+			for _, s := range sample(pix[y*w+x]) {
+				switch depth {
+				case 16:
+					binary.BigEndian.PutUint16(row[bitPos/8:], s)
+				case 8:
+					row[bitPos/8] = byte(s)
+				default:
+					v := byte(s&0xFF) / byte(255/(1<<depth-1))
+					row[bitPos/8] |= v << (8 - depth - bitPos%8)
+				}
+				bitPos += depth
+			}
+		}
+		c.rows = append(c.rows, row)
+	}
+	return c
+}
+
+// newPaletteCandidate builds an indexed representation, or returns nil if
+// the image has more than 256 colors. Translucent entries are sorted first
+// so the tRNS chunk can be truncated after the last one.
+func newPaletteCandidate(pix []color.NRGBA64, w, h int) *pngCandidate {
+	index := make(map[color.NRGBA]int)
+	var palette []color.NRGBA
+	for _, p := range pix {
+		c := color.NRGBA{R: uint8(p.R), G: uint8(p.G), B: uint8(p.B), A: uint8(p.A)}
+		if _, ok := index[c]; !ok {
+			if len(palette) == 256 {
+				return nil
+			}
+			index[c] = len(palette)
+			palette = append(palette, c)
+		}
+	}
+
+	sort.SliceStable(palette, func(i, j int) bool {
+		return palette[i].A != 0xFF && palette[j].A == 0xFF
+	})
+	for i, c := range palette {
+		index[c] = i
+	}
+
+	depth := 8
+	switch {
+	case len(palette) <= 2:
+		depth = 1
+	case len(palette) <= 4:
+		depth = 2
+	case len(palette) <= 16:
+		depth = 4
+	}
+
+	c := &pngCandidate{colorType: pngPalette, depth: depth, palette: palette, bpp: 1}
+	for _, p := range palette {
+		if p.A != 0xFF {
+			c.trns = append(c.trns, p.A)
+		}
+	}
+	rowBytes := (w*depth + 7) / 8
+	for y := 0; y < h; y++ {
+		row := make([]byte, rowBytes)
+		for x := 0; x < w; x++ {
+			p := pix[y*w+x]
+			i := byte(index[color.NRGBA{R: uint8(p.R), G: uint8(p.G), B: uint8(p.B), A: uint8(p.A)}])
+			bitPos := x * depth
+			row[bitPos/8] |= i << (8 - depth - bitPos%8)
+		}
+		c.rows = append(c.rows, row)
+	}
+	return c
+}
+
+// channels returns the number of samples per pixel.
+func (c *pngCandidate) channels() int {
+	switch c.colorType {
+	case pngRGB:
+		return 3
+	case pngGrayAlpha:
+		return 2
+	case pngRGBA:
+		return 4
+	}
+	return 1
+}
+
+// filter returns the filtered scanlines, each prefixed by its filter type.
+func (c *pngCandidate) filter(strategy int) []byte {
+	raw := make([]byte, 0, len(c.rows)*(len(c.rows[0])+1))
+	prev := make([]byte, len(c.rows[0]))
+	var scratch [5][]byte
+	for i := range scratch {
+		scratch[i] = make([]byte, len(prev))
+	}
+	for _, row := range c.rows {
+		ft := strategy
+		if strategy >= filterMinSum {
+			ft = c.chooseFilter(row, prev, scratch, strategy)
+		}
+		applyFilter(scratch[ft], row, prev, c.bpp, ft)
+		raw = append(raw, byte(ft))
+		raw = append(raw, scratch[ft]...)
+		prev = row
+	}
+	return raw
+}
+
+func deflate(raw []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// assemble builds the PNG file around compressed image data.
+func (c *pngCandidate) assemble(w, h int, idat []byte) []byte {
+	var out bytes.Buffer
+	out.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8] = byte(c.depth)
+	ihdr[9] = c.colorType
+	writePNGChunk(&out, "IHDR", ihdr)
+	if c.palette != nil {
+		plte := make([]byte, 0, 3*len(c.palette))
+		for _, p := range c.palette {
+			plte = append(plte, p.R, p.G, p.B)
+		}
+		writePNGChunk(&out, "PLTE", plte)
+	}
+	if c.trns != nil {
+		writePNGChunk(&out, "tRNS", c.trns)
+	}
+	writePNGChunk(&out, "IDAT", idat)
+	writePNGChunk(&out, "IEND", nil)
+	return out.Bytes()
+}
+
+// chooseFilter picks the filter for one row by the given heuristic.
+func (c *pngCandidate) chooseFilter(row, prev []byte, scratch [5][]byte, strategy int) int {
+	best, bestScore := 0, -1.0
+	for ft := filterNone; ft <= filterPaeth; ft++ {
+		applyFilter(scratch[ft], row, prev, c.bpp, ft)
+		var score float64
+		if strategy == filterMinSum {
+			for _, b := range scratch[ft] {
+				score += float64(min(b, 0-b)) // |signed byte|
+			}
+		} else {
+			score = byteEntropy(scratch[ft])
+		}
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = ft, score
+		}
+	}
+	return best
+}
+
+// byteEntropy returns the Shannon entropy of b in bits, scaled by length.
+func byteEntropy(b []byte) float64 {
+	var hist [256]int
+	for _, v := range b {
+		hist[v]++
+	}
+	n := float64(len(b))
+	var e float64
+	for _, count := range hist {
+		if count > 0 {
+			p := float64(count) / n
+			e -= float64(count) * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// applyFilter writes the filtered row into out (PNG spec section 9).
+func applyFilter(out, row, prev []byte, bpp, ft int) {
+	for i := range row {
+		var a, up, ul byte
+		if i >= bpp {
+			a = row[i-bpp]
+			ul = prev[i-bpp]
+		}
+		up = prev[i]
+		switch ft {
+		case filterNone:
+			out[i] = row[i]
+		case filterSub:
+			out[i] = row[i] - a
+		case filterUp:
+			out[i] = row[i] - up
+		case filterAverage:
+			out[i] = row[i] - byte((int(a)+int(up))/2)
+		case filterPaeth:
+			out[i] = row[i] - paeth(a, up, ul)
+		}
+	}
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	w.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	w.WriteString(typ)
+	w.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	w.Write(n[:])
+}
@@ -0,0 +1,100 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func optimizeTestImages() map[string]image.Image {
+	const w, h = 64, 48
+	rgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	opaque := image.NewNRGBA(image.Rect(0, 0, w, h))
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	gray16 := image.NewGray16(image.Rect(0, 0, w, h))
+	bilevel := image.NewGray(image.Rect(0, 0, w, h))
+	palette := image.NewNRGBA(image.Rect(0, 0, w, h))
+	colors := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 128}, {0, 0, 0, 0}}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			rgba.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 5), B: uint8(x + y), A: uint8(255 - x)})
+			opaque.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 5), B: uint8(x ^ y), A: 255})
+			gray.SetGray(x, y, color.Gray{Y: uint8(x*3 + y)})
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(x*1000 + y*7)})
+			if (x/8+y/8)%2 == 0 {
+				bilevel.SetGray(x, y, color.Gray{Y: 255})
+			}
+			palette.SetNRGBA(x, y, colors[(x/4+y/4)%len(colors)])
+		}
+	}
+	return map[string]image.Image{
+		"rgba":    rgba,
+		"opaque":  opaque,
+		"gray":    gray,
+		"gray16":  gray16,
+		"bilevel": bilevel,
+		"palette": palette,
+	}
+}
+
+func TestOptimizePNG_Lossless(t *testing.T) {
+	for name, img := range optimizeTestImages() {
+		var buf bytes.Buffer
+		if err := optimizePNG(&buf, img); err != nil {
+			t.Fatalf("%s: optimizePNG: %v", name, err)
+		}
+		got, err := png.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: decode optimized PNG: %v", name, err)
+		}
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				want := color.NRGBA64Model.Convert(img.At(x, y))
+				if have := color.NRGBA64Model.Convert(got.At(x, y)); have != want {
+					t.Fatalf("%s: pixel (%d,%d) = %v, want %v", name, x, y, have, want)
+				}
+			}
+		}
+	}
+}
+
+func TestOptimizePNG_SmallerThanBestCompression(t *testing.T) {
+	for name, img := range optimizeTestImages() {
+		var std, opt bytes.Buffer
+		enc := &png.Encoder{CompressionLevel: png.BestCompression}
+		if err := enc.Encode(&std, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := optimizePNG(&opt, img); err != nil {
+			t.Fatal(err)
+		}
+		if opt.Len() > std.Len() {
+			t.Errorf("%s: optimized %d bytes, standard encoder %d bytes", name, opt.Len(), std.Len())
+		}
+	}
+}
+
+func TestOptimizePNG_ReducesColorType(t *testing.T) {
+	images := optimizeTestImages()
+	for name, want := range map[string]struct {
+		colorType byte
+		depth     byte
+	}{
+		"bilevel": {pngGray, 1},
+		"palette": {pngPalette, 2},
+		"gray16":  {pngGray, 16},
+	} {
+		var buf bytes.Buffer
+		if err := optimizePNG(&buf, images[name]); err != nil {
+			t.Fatal(err)
+		}
+		// IHDR data starts after the 8-byte signature and 8-byte chunk header.
+		ihdr := buf.Bytes()[16:29]
+		if ihdr[9] != want.colorType || ihdr[8] != want.depth {
+			t.Errorf("%s: color type %d depth %d, want %d depth %d", name, ihdr[9], ihdr[8], want.colorType, want.depth)
+		}
+	}
+}
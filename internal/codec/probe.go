@@ -0,0 +1,50 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+const (
+	// probeSize is how much of a HEIC or AVIF file is searched for its
+	// image size.
+	probeSize = 1 << 16
+
+	// maxSide bounds probed sizes, so other bytes that look like an ispe
+	// box are not taken for one.
+	maxSide = 1 << 20
+)
+
+// ProbeDimensions reads the pixel size from the header of the formats the
+// standard library and golang.org/x/image can probe, and from the largest
+// "ispe" (image spatial extent) property of HEIC and AVIF files, without
+// decoding the image. ok is false for other formats.
+func ProbeDimensions(r io.ReadSeeker) (w, h int, ok bool) {
+	if cfg, _, err := image.DecodeConfig(r); err == nil {
+		return cfg.Width, cfg.Height, true
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, false
+	}
+	head := make([]byte, probeSize)
+	n, _ := io.ReadFull(r, head)
+	head = head[:n]
+
+	// ispe: size (20), "ispe", version and flags, width, height
+	for {
+		i := bytes.Index(head, []byte("ispe"))
+		if i < 0 || i+16 > len(head) {
+			break
+		}
+		box := i >= 4 && binary.BigEndian.Uint32(head[i-4:]) == 20
+		iw := int(binary.BigEndian.Uint32(head[i+8:]))
+		ih := int(binary.BigEndian.Uint32(head[i+12:]))
+		if box && iw <= maxSide && ih <= maxSide && iw*ih > w*h {
+			w, h = iw, ih
+		}
+		head = head[i+4:]
+	}
+	return w, h, w > 0 && h > 0
+}
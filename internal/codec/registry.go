@@ -100,6 +100,8 @@ func ParseFormat(s string) (Format, error) {
 		return ORF, nil
 	case "rw2":
 		return RW2, nil
+	case "auto":
+		return Auto, nil
 	default:
 		return "", fmt.Errorf("unsupported format: %q", s)
 	}
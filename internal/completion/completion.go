@@ -8,7 +8,7 @@ func GenerateBash() string {
     cur="${COMP_WORDS[COMP_CWORD]}"
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
-    formats="jpg jpeg png gif webp tiff bmp heic avif jxl arw raf orf rw2"
+    formats="jpg jpeg png gif webp tiff bmp heic avif jxl auto arw raf orf rw2"
     completions="bash zsh fish"
 
     case "${prev}" in
@@ -40,20 +40,36 @@ func GenerateBash() string {
             COMPREPLY=( $(compgen -W "0 1 2 3" -- "${cur}") )
             return 0
             ;;
+        --chroma)
+            COMPREPLY=( $(compgen -W "444 422 420" -- "${cur}") )
+            return 0
+            ;;
+        --avif-depth)
+            COMPREPLY=( $(compgen -W "8 10 12" -- "${cur}") )
+            return 0
+            ;;
+        --keep-exif|--strip-exif)
+            COMPREPLY=( $(compgen -W "gps serial owner camera exposure datetime copyright artist description software makernote thumbnail image other privacy" -- "${cur}") )
+            return 0
+            ;;
+        --sidecar)
+            COMPREPLY=( $(compgen -W "json xmp" -- "${cur}") )
+            return 0
+            ;;
         -q|--quality|-j|--jobs|--width|--height|--max-dim)
             return 0
             ;;
-        --sepia|--brightness|--contrast|--blur|--watermark-size|--watermark-opacity|--dedup-threshold|--contact-cols|--contact-size|--webp-method|--rate-limit|--request-timeout|--max-upload|--watch-debounce|--watch-retry)
+        --sepia|--brightness|--contrast|--blur|--watermark-size|--watermark-opacity|--dedup-threshold|--contact-cols|--contact-size|--webp-method|--rate-limit|--request-timeout|--max-upload|--watch-debounce|--watch-retry|--auto-min-ssim|--jxl-effort|--jxl-distance|--avif-speed|--avif-alpha-quality|--job-timeout)
             return 0
             ;;
-        --api-key|--cors-origins|--watch-ignore)
+        --api-key|--cors-origins|--watch-ignore|--auto-formats|--xmp-title|--xmp-creator|--xmp-rights|--xmp-keywords|--iptc-caption|--iptc-byline|--iptc-copyright|--iptc-keywords|--op|--variant|--max-memory)
             return 0
             ;;
         -o|--output)
             COMPREPLY=( $(compgen -d -- "${cur}") )
             return 0
             ;;
-        -c|--config)
+        -c|--config|--manifest|--journal)
             COMPREPLY=( $(compgen -f -- "${cur}") )
             return 0
             ;;
@@ -66,13 +82,13 @@ func GenerateBash() string {
     esac
 
     if [[ "${cur}" == --* ]]; then
-        opts="--format --quality --jobs --output --recursive --preserve-metadata --watch --config --overwrite --dry-run --verbose --version --help --width --height --max-dim --strip-metadata --template --completion --auto-rotate --crop --crop-ratio --crop-gravity --smart-crop --watermark --watermark-pos --watermark-opacity --preset --backup --json --tree --scan --dedup --dedup-threshold --ssim --contact-sheet --contact-cols --contact-size --palette --grayscale --sepia --brightness --contrast --sharpen --blur --invert --progressive --png-compression --webp-method --lossless --watermark-size --watermark-color --watermark-bg --interpolation --api-key --rate-limit --cors-origins --request-timeout --max-upload --watch-debounce --watch-ignore --watch-retry"
+        opts="--format --quality --jobs --max-memory --job-timeout --output --recursive --preserve-metadata --watch --config --overwrite --dry-run --incremental --manifest --prune --journal --resume --retry-failed --verbose --version --help --width --height --max-dim --strip-metadata --template --variant --completion --auto-rotate --crop --crop-ratio --crop-gravity --smart-crop --watermark --watermark-pos --watermark-opacity --preset --backup --preserve-attrs --json --tree --scan --dedup --dedup-threshold --ssim --contact-sheet --contact-cols --contact-size --palette --grayscale --sepia --brightness --contrast --sharpen --blur --invert --progressive --png-compression --webp-method --lossless --optimize --chroma --jxl-effort --jxl-distance --avif-speed --avif-alpha-quality --avif-depth --reencode-jpeg --keep-exif --strip-exif --xmp-title --xmp-creator --xmp-rights --xmp-keywords --iptc-caption --iptc-byline --iptc-copyright --iptc-keywords --sidecar --from-sidecar --auto-formats --auto-min-ssim --watermark-size --watermark-color --watermark-bg --op --interpolation --api-key --rate-limit --cors-origins --request-timeout --max-upload --watch-debounce --watch-ignore --watch-retry"
         COMPREPLY=( $(compgen -W "${opts}" -- "${cur}") )
         return 0
     fi
 
     if [[ "${cur}" == -* ]]; then
-        opts="-f -q -j -o -r -m -w -c -v -V -h -s --format --quality --jobs --output --recursive --preserve-metadata --watch --config --overwrite --dry-run --verbose --version --help --width --height --max-dim --strip-metadata --template --completion --auto-rotate --crop --crop-ratio --crop-gravity --smart-crop --watermark --watermark-pos --watermark-opacity --preset --backup --json --tree --scan --dedup --dedup-threshold --ssim --contact-sheet --contact-cols --contact-size --palette --grayscale --sepia --brightness --contrast --sharpen --blur --invert --progressive --png-compression --webp-method --lossless --watermark-size --watermark-color --watermark-bg --interpolation --api-key --rate-limit --cors-origins --request-timeout --max-upload --watch-debounce --watch-ignore --watch-retry"
+        opts="-f -q -j -o -r -m -w -c -v -V -h -s --format --quality --jobs --max-memory --job-timeout --output --recursive --preserve-metadata --watch --config --overwrite --dry-run --incremental --manifest --prune --journal --resume --retry-failed --verbose --version --help --width --height --max-dim --strip-metadata --template --variant --completion --auto-rotate --crop --crop-ratio --crop-gravity --smart-crop --watermark --watermark-pos --watermark-opacity --preset --backup --preserve-attrs --json --tree --scan --dedup --dedup-threshold --ssim --contact-sheet --contact-cols --contact-size --palette --grayscale --sepia --brightness --contrast --sharpen --blur --invert --progressive --png-compression --webp-method --lossless --optimize --chroma --jxl-effort --jxl-distance --avif-speed --avif-alpha-quality --avif-depth --reencode-jpeg --keep-exif --strip-exif --xmp-title --xmp-creator --xmp-rights --xmp-keywords --iptc-caption --iptc-byline --iptc-copyright --iptc-keywords --sidecar --from-sidecar --auto-formats --auto-min-ssim --watermark-size --watermark-color --watermark-bg --op --interpolation --api-key --rate-limit --cors-origins --request-timeout --max-upload --watch-debounce --watch-ignore --watch-retry"
         COMPREPLY=( $(compgen -W "${opts}" -- "${cur}") )
         return 0
     fi
@@ -90,7 +106,7 @@ func GenerateZsh() string {
 
 _pixshift() {
     local -a formats completions presets gravities positions interpolations
-    formats=(jpg jpeg png gif webp tiff bmp heic avif jxl arw raf orf rw2)
+    formats=(jpg jpeg png gif webp tiff bmp heic avif jxl auto arw raf orf rw2)
     completions=(bash zsh fish)
     presets=(web thumbnail print archive)
     gravities=(center north south east west)
@@ -101,6 +117,8 @@ _pixshift() {
         '(-f --format)'{-f,--format}'[output format]:format:(${formats})' \
         '(-q --quality)'{-q,--quality}'[quality level]:quality:' \
         '(-j --jobs)'{-j,--jobs}'[number of parallel jobs]:jobs:' \
+        '--max-memory[memory budget for running jobs]:size:' \
+        '--job-timeout[per-job timeout in seconds]:timeout:' \
         '(-o --output)'{-o,--output}'[output directory]:directory:_directories' \
         '(-r --recursive)'{-r,--recursive}'[process directories recursively]' \
         '(-m --preserve-metadata)'{-m,--preserve-metadata}'[preserve image metadata]' \
@@ -108,6 +126,12 @@ _pixshift() {
         '(-c --config)'{-c,--config}'[config file path]:config:_files' \
         '--overwrite[overwrite existing files]' \
         '--dry-run[show what would be done without doing it]' \
+        '--incremental[rebuild only changed outputs]' \
+        '--manifest[manifest file for --incremental]:manifest:_files' \
+        '--prune[delete orphaned outputs]' \
+        '--journal[journal file of finished jobs]:journal:_files' \
+        '--resume[skip jobs finished in an earlier run]' \
+        '--retry-failed[resume, converting failed jobs again]' \
         '(-v --verbose)'{-v,--verbose}'[enable verbose output]' \
         '(-V --version)'{-V,--version}'[show version]' \
         '(-h --help)'{-h,--help}'[show help]' \
@@ -116,6 +140,7 @@ _pixshift() {
         '--max-dim[maximum dimension]:max-dim:' \
         '(-s --strip-metadata)'{-s,--strip-metadata}'[strip image metadata]' \
         '--template[output filename template]:template:' \
+        '--variant[write an output variant]:variant:' \
         '--completion[generate shell completion]:shell:(${completions})' \
         '--auto-rotate[auto-rotate images based on EXIF orientation]' \
         '--crop[crop to WxH dimensions]:dimensions:' \
@@ -127,8 +152,10 @@ _pixshift() {
         '--watermark-size[watermark font size]:size:' \
         '--watermark-color[watermark text color]:color:' \
         '--watermark-bg[watermark background color]:color:' \
+        '--op[append an operation to the chain]:op:' \
         '--preset[apply a built-in preset]:preset:(${presets})' \
         '--backup[create backup before overwriting]' \
+        '--preserve-attrs[give outputs the input file mode and modification time]' \
         '--json[output results as JSON]' \
         '--tree[display directory tree of image files]' \
         '--dedup[find duplicate images using perceptual hashing]' \
@@ -151,6 +178,28 @@ _pixshift() {
         '--png-compression[PNG compression level (0-3)]:level:(0 1 2 3)' \
         '--webp-method[WebP compression method (0-6)]:method:' \
         '--lossless[enable lossless encoding]' \
+        '--optimize[optimize PNG output for size]' \
+        '--chroma[chroma subsampling for AVIF/HEIC]:chroma:(420)' \
+        '--jxl-effort[JXL encoder effort (1-9)]:effort:' \
+        '--jxl-distance[JXL Butteraugli distance]:distance:' \
+        '--avif-speed[AVIF encoder speed (0-10)]:speed:' \
+        '--avif-alpha-quality[AVIF alpha channel quality]:quality:' \
+        '--avif-depth[AVIF bit depth]:depth:(8)' \
+        '--reencode-jpeg[re-encode JPEG from pixels instead of lossless transcode, rotate or crop]' \
+        '--keep-exif[EXIF groups/tags to keep]:exif:(gps serial owner camera exposure datetime copyright artist description software makernote thumbnail image other privacy)' \
+        '--strip-exif[EXIF groups/tags to strip]:exif:(gps serial owner camera exposure datetime copyright artist description software makernote thumbnail image other privacy)' \
+        '--xmp-title[XMP title]:title:' \
+        '--xmp-creator[XMP creator]:creator:' \
+        '--xmp-rights[XMP rights statement]:rights:' \
+        '--xmp-keywords[XMP keywords (comma-separated)]:keywords:' \
+        '--iptc-caption[IPTC caption]:caption:' \
+        '--iptc-byline[IPTC by-line]:byline:' \
+        '--iptc-copyright[IPTC copyright notice]:copyright:' \
+        '--iptc-keywords[IPTC keywords (comma-separated)]:keywords:' \
+        '--sidecar[write metadata sidecar]:sidecar:(json xmp)' \
+        '--from-sidecar[read metadata from the input sidecar]' \
+        '--auto-formats[candidate formats for auto output]:formats:' \
+        '--auto-min-ssim[minimum SSIM for auto format candidates]:ssim:' \
         '--interpolation[resize interpolation method]:method:(${interpolations})' \
         '--api-key[require Bearer token authentication]:key:' \
         '--rate-limit[max requests per minute per IP]:limit:' \
@@ -175,7 +224,7 @@ func GenerateFish() string {
 complete -c pixshift -f
 
 # Format flag
-complete -c pixshift -s f -l format -x -d 'Output format' -a 'jpg jpeg png gif webp tiff bmp heic avif jxl arw raf orf rw2'
+complete -c pixshift -s f -l format -x -d 'Output format' -a 'jpg jpeg png gif webp tiff bmp heic avif jxl auto arw raf orf rw2'
 
 # Quality flag
 complete -c pixshift -s q -l quality -x -d 'Quality level'
@@ -183,6 +232,12 @@ complete -c pixshift -s q -l quality -x -d 'Quality level'
 # Jobs flag
 complete -c pixshift -s j -l jobs -x -d 'Number of parallel jobs'
 
+# Max memory flag
+complete -c pixshift -l max-memory -x -d 'Memory budget for running jobs (e.g. 4G)'
+
+# Job timeout flag
+complete -c pixshift -l job-timeout -x -d 'Per-job timeout in seconds'
+
 # Output directory flag
 complete -c pixshift -s o -l output -r -F -d 'Output directory'
 
@@ -204,6 +259,24 @@ complete -c pixshift -l overwrite -d 'Overwrite existing files'
 # Dry run flag
 complete -c pixshift -l dry-run -d 'Show what would be done without doing it'
 
+# Incremental flag
+complete -c pixshift -l incremental -d 'Rebuild only outputs whose input or settings changed'
+
+# Manifest flag
+complete -c pixshift -l manifest -r -F -d 'Manifest file for --incremental'
+
+# Prune flag
+complete -c pixshift -l prune -d 'Delete outputs of removed inputs (with --incremental)'
+
+# Journal flag
+complete -c pixshift -l journal -r -F -d 'Journal file of finished jobs'
+
+# Resume flag
+complete -c pixshift -l resume -d 'Skip jobs finished in an earlier run'
+
+# Retry failed flag
+complete -c pixshift -l retry-failed -d 'Resume, converting failed jobs again'
+
 # Verbose flag
 complete -c pixshift -s v -l verbose -d 'Enable verbose output'
 
@@ -228,6 +301,9 @@ complete -c pixshift -s s -l strip-metadata -d 'Strip image metadata'
 # Template flag
 complete -c pixshift -l template -x -d 'Output filename template'
 
+# Variant flag
+complete -c pixshift -l variant -x -d 'Write an output variant'
+
 # Completion flag
 complete -c pixshift -l completion -x -d 'Generate shell completion' -a 'bash zsh fish'
 
@@ -260,6 +336,9 @@ complete -c pixshift -l preset -x -d 'Apply a built-in preset' -a 'web thumbnail
 # Backup flag
 complete -c pixshift -l backup -d 'Create backup before overwriting'
 
+# Preserve attributes flag
+complete -c pixshift -l preserve-attrs -d 'Give outputs the input file mode and modification time'
+
 # JSON output flag
 complete -c pixshift -l json -d 'Output results as JSON'
 
@@ -330,6 +409,56 @@ complete -c pixshift -l webp-method -x -d 'WebP compression method (0-6)'
 # Lossless flag
 complete -c pixshift -l lossless -d 'Enable lossless encoding'
 
+# PNG optimize flag
+complete -c pixshift -l optimize -d 'Optimize PNG output for size'
+
+# Chroma flag
+complete -c pixshift -l chroma -x -d 'Chroma subsampling for AVIF/HEIC' -a '420'
+
+# JXL effort flag
+complete -c pixshift -l jxl-effort -x -d 'JXL encoder effort (1-9)'
+
+# JXL distance flag
+complete -c pixshift -l jxl-distance -x -d 'JXL Butteraugli distance'
+
+# AVIF speed flag
+complete -c pixshift -l avif-speed -x -d 'AVIF encoder speed (0-10)'
+
+# AVIF alpha quality flag
+complete -c pixshift -l avif-alpha-quality -x -d 'AVIF alpha channel quality'
+
+# AVIF depth flag
+complete -c pixshift -l avif-depth -x -d 'AVIF bit depth' -a '8'
+
+# Reencode JPEG flag
+complete -c pixshift -l reencode-jpeg -d 'Re-encode JPEG from pixels instead of lossless transcode, rotate or crop'
+
+# EXIF policy flags
+complete -c pixshift -l keep-exif -x -d 'EXIF groups/tags to keep' -a 'gps serial owner camera exposure datetime copyright artist description software makernote thumbnail image other privacy'
+complete -c pixshift -l strip-exif -x -d 'EXIF groups/tags to strip' -a 'gps serial owner camera exposure datetime copyright artist description software makernote thumbnail image other privacy'
+
+# XMP field flags
+complete -c pixshift -l xmp-title -x -d 'XMP title (dc:title)'
+complete -c pixshift -l xmp-creator -x -d 'XMP creator (dc:creator)'
+complete -c pixshift -l xmp-rights -x -d 'XMP rights statement (dc:rights)'
+complete -c pixshift -l xmp-keywords -x -d 'XMP keywords, comma-separated (dc:subject)'
+
+# IPTC field flags
+complete -c pixshift -l iptc-caption -x -d 'IPTC caption/abstract'
+complete -c pixshift -l iptc-byline -x -d 'IPTC by-line'
+complete -c pixshift -l iptc-copyright -x -d 'IPTC copyright notice'
+complete -c pixshift -l iptc-keywords -x -d 'IPTC keywords, comma-separated'
+
+# Sidecar flags
+complete -c pixshift -l sidecar -x -d 'Write a metadata sidecar' -a 'json xmp'
+complete -c pixshift -l from-sidecar -d 'Read metadata from the input sidecar'
+
+# Auto formats flag
+complete -c pixshift -l auto-formats -x -d 'Candidate formats for auto output'
+
+# Auto min SSIM flag
+complete -c pixshift -l auto-min-ssim -x -d 'Minimum SSIM for auto format candidates'
+
 # Watermark size flag
 complete -c pixshift -l watermark-size -x -d 'Watermark font size'
 
@@ -339,6 +468,9 @@ complete -c pixshift -l watermark-color -x -d 'Watermark text color'
 # Watermark background flag
 complete -c pixshift -l watermark-bg -x -d 'Watermark background color'
 
+# Operation chain flag
+complete -c pixshift -l op -x -d 'Append an operation to the chain'
+
 # Interpolation flag
 complete -c pixshift -l interpolation -x -d 'Resize interpolation method' -a 'nearest bilinear catmullrom'
 
@@ -0,0 +1,188 @@
+// Package journal records the progress of a batch run, one line per
+// finished job, so an interrupted or partly failed run can be resumed
+// without converting the finished files again.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+// FileName is the default name of the journal file.
+const FileName = ".pixshift-journal.jsonl"
+
+// Status is the outcome of a journaled job.
+type Status string
+
+const (
+	Done   Status = "done"
+	Failed Status = "failed"
+)
+
+// Record is one line of the journal.
+type Record struct {
+	Input   string    `json:"input"` // absolute input path
+	Job     string    `json:"job"`   // see pipeline.Job.Fingerprint
+	Status  Status    `json:"status"`
+	Outputs []string  `json:"outputs,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Journal is an append-only log of finished jobs. The file is created when
+// the first job is recorded, and each record is written as it finishes,
+// so a run killed part way keeps the records of the jobs before it. Its
+// methods are safe for concurrent use.
+type Journal struct {
+	path   string
+	resume bool
+
+	mu      sync.Mutex
+	f       *os.File
+	records map[string]Record // by input path; the last record wins
+}
+
+// Open opens the journal at path. With resume, the records already in the
+// file are read, and new records are appended to them; a missing file
+// gives an empty journal. Without it, the file is replaced once the first
+// job is recorded.
+func Open(path string, resume bool) (*Journal, error) {
+	j := &Journal{path: path, resume: resume, records: make(map[string]Record)}
+	if !resume {
+		return j, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var r Record
+		// A run killed while writing leaves a partial last line
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.Input == "" {
+			continue
+		}
+		j.records[r.Input] = r
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// Status returns the recorded outcome of the job. Jobs whose input was not
+// recorded, or was recorded with other settings or output paths, have
+// none.
+func (j *Journal) Status(job pipeline.Job) (Status, bool) {
+	j.mu.Lock()
+	r, ok := j.records[absPath(job.InputPath)]
+	j.mu.Unlock()
+	if !ok || r.Job != job.Fingerprint() {
+		return "", false
+	}
+	return r.Status, true
+}
+
+// Record appends the outcome of a finished job. The submitted job is
+// fingerprinted, so Status matches Auto jobs whichever format they chose.
+// Jobs cancelled before they finished are not recorded, so a resumed run
+// converts them.
+func (j *Journal) Record(res pipeline.Result) error {
+	if errors.Is(res.Error, context.Canceled) {
+		return nil
+	}
+	job := res.SubmittedJob()
+	r := Record{
+		Input:  absPath(job.InputPath),
+		Job:    job.Fingerprint(),
+		Status: Done,
+		Time:   time.Now().UTC(),
+	}
+	if res.Error != nil {
+		r.Status, r.Error = Failed, res.Error.Error()
+	} else {
+		r.Outputs = res.Job.OutputPaths()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if !j.resume {
+			flag |= os.O_TRUNC
+		}
+		if j.f, err = os.OpenFile(j.path, flag, 0644); err != nil {
+			return fmt.Errorf("write journal: %w", err)
+		}
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	j.records[r.Input] = r
+	return nil
+}
+
+// Failed reports whether any job's last record is a failure.
+func (j *Journal) Failed() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, r := range j.records {
+		if r.Status == Failed {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the journal's file path.
+func (j *Journal) Path() string { return j.path }
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// Remove closes and deletes the journal file, once a run has nothing left
+// to resume.
+func (j *Journal) Remove() error {
+	if err := j.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,157 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+func TestJournal_Resume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	job := func(name string) pipeline.Job {
+		return pipeline.Job{
+			InputPath:    filepath.Join(dir, name+".png"),
+			OutputPath:   filepath.Join(dir, name+".webp"),
+			OutputFormat: codec.WebP,
+			Quality:      80,
+		}
+	}
+	a, b, c := job("a"), job("b"), job("c")
+
+	j, err := Open(path, false)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, r := range []pipeline.Result{
+		{Job: a},
+		{Job: b, Error: errors.New("decode failed")},
+		{Job: c, Error: context.Canceled},
+	} {
+		if err := j.Record(r); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if !j.Failed() {
+		t.Error("Failed = false with a failed job")
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A run killed while writing leaves a partial line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"input": "`)
+	f.Close()
+
+	if j, err = Open(path, true); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer j.Close()
+	for _, tt := range []struct {
+		job    pipeline.Job
+		status Status
+		ok     bool
+	}{
+		{a, Done, true},
+		{b, Failed, true},
+		{c, "", false},
+	} {
+		if status, ok := j.Status(tt.job); status != tt.status || ok != tt.ok {
+			t.Errorf("%s: status = %q, %v; want %q, %v", tt.job.InputPath, status, ok, tt.status, tt.ok)
+		}
+	}
+
+	changed := a
+	changed.Quality = 60
+	if _, ok := j.Status(changed); ok {
+		t.Error("job with a changed quality has a status")
+	}
+
+	// A retried job's new record replaces the failure
+	if err := j.Record(pipeline.Result{Job: b}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if status, _ := j.Status(b); status != Done {
+		t.Errorf("retried job: status = %q, want %q", status, Done)
+	}
+	if j.Failed() {
+		t.Error("Failed = true after the failure was retried")
+	}
+}
+
+func TestJournal_AutoFormat(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.png")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	job := pipeline.Job{
+		InputPath:    in,
+		OutputPath:   filepath.Join(dir, "out", "a.auto"),
+		OutputFormat: codec.Auto,
+		Quality:      80,
+		AutoFormats:  []codec.Format{codec.JPEG, codec.PNG},
+	}
+
+	j, err := Open(filepath.Join(dir, FileName), false)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer j.Close()
+	res := pipeline.NewPipeline(codec.DefaultRegistry()).ExecuteJob(job)
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if err := j.Record(res); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if status, ok := j.Status(job); status != Done || !ok {
+		t.Errorf("auto job: status = %q, %v; want %q, true", status, ok, Done)
+	}
+}
+
+func TestJournal_Remove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j, err := Open(path, false)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, ok := j.Status(pipeline.Job{InputPath: "old"}); ok {
+		t.Error("new journal has records")
+	}
+	if err := j.Record(pipeline.Result{Job: pipeline.Job{InputPath: "a.png"}}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 || string(data[:4]) == "old\n" {
+		t.Errorf("journal not replaced: %q", data)
+	}
+	if err := j.Remove(); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("journal not removed: %v", err)
+	}
+}
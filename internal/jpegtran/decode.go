@@ -0,0 +1,470 @@
+package jpegtran
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// JPEG marker codes.
+const (
+	markerSOF0 = 0xC0 // baseline DCT
+	markerSOF1 = 0xC1 // extended sequential DCT, Huffman
+	markerDHT  = 0xC4
+	markerDAC  = 0xCC
+	markerRST0 = 0xD0
+	markerRST7 = 0xD7
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDRI  = 0xDD
+	markerAPP0 = 0xE0
+	markerAPPF = 0xEF
+	markerCOM  = 0xFE
+)
+
+var errTruncated = errors.New("jpegtran: truncated JPEG data")
+
+// block holds the 64 quantized DCT coefficients of an 8x8 block in natural
+// (row-major) order.
+type block [64]int16
+
+// component is one color channel of a frame. Its blocks cover the whole
+// MCU grid, including the padding blocks past the right and bottom edges.
+type component struct {
+	id     byte
+	h, v   int // sampling factors
+	tq     byte
+	bw, bh int // blocks per row and per column
+	blocks []block
+	seen   bool // already coded by a scan
+}
+
+// segment is an APPn or COM marker segment copied through to the output.
+type segment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegImage is a decoded coefficient-domain JPEG.
+type jpegImage struct {
+	sof           byte
+	width, height int
+	comps         []*component
+	hmax, vmax    int
+	qt            [4]*[64]uint16 // natural order
+	qtPrec        [4]byte
+	markers       []segment
+}
+
+// decode parses a sequential Huffman-coded 8-bit JPEG into coefficients.
+func decode(data []byte) (*jpegImage, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, errors.New("jpegtran: not a JPEG file")
+	}
+
+	img := &jpegImage{}
+	var dc, ac [4]*huffTable
+	restart := 0
+	scans := 0
+	pos := 2
+
+	for {
+		// Find the next marker, skipping any stray bytes and fill bytes.
+		for pos+1 < len(data) && (data[pos] != 0xFF || data[pos+1] == 0xFF || data[pos+1] == 0) {
+			pos++
+		}
+		if pos+1 >= len(data) {
+			if scans > 0 {
+				break // tolerate a missing EOI
+			}
+			return nil, errTruncated
+		}
+		marker := data[pos+1]
+		pos += 2
+
+		if marker == markerEOI {
+			break
+		}
+		if marker >= markerRST0 && marker <= markerRST7 {
+			continue
+		}
+		if pos+2 > len(data) {
+			return nil, errTruncated
+		}
+		n := int(binary.BigEndian.Uint16(data[pos:]))
+		if n < 2 || pos+n > len(data) {
+			return nil, errTruncated
+		}
+		seg := data[pos+2 : pos+n]
+		pos += n
+
+		switch {
+		case marker == markerSOF0 || marker == markerSOF1:
+			if img.comps != nil {
+				return nil, errors.New("jpegtran: multiple frames")
+			}
+			if err := img.parseSOF(marker, seg); err != nil {
+				return nil, err
+			}
+		case marker >= 0xC2 && marker <= 0xCF && marker != markerDHT && marker != markerDAC:
+			return nil, fmt.Errorf("%w: unsupported coding process (SOF%d)", ErrUnsupported, marker-0xC0)
+		case marker == markerDAC:
+			return nil, fmt.Errorf("%w: arithmetic coding", ErrUnsupported)
+		case marker == markerDHT:
+			if err := parseDHT(seg, &dc, &ac); err != nil {
+				return nil, err
+			}
+		case marker == markerDQT:
+			if err := img.parseDQT(seg); err != nil {
+				return nil, err
+			}
+		case marker == markerDRI:
+			if len(seg) < 2 {
+				return nil, errTruncated
+			}
+			restart = int(binary.BigEndian.Uint16(seg))
+		case marker == markerSOS:
+			if img.comps == nil {
+				return nil, errors.New("jpegtran: scan before frame header")
+			}
+			consumed, err := img.decodeScan(seg, data[pos:], &dc, &ac, restart)
+			if err != nil {
+				return nil, err
+			}
+			pos += consumed
+			scans++
+		case (marker >= markerAPP0 && marker <= markerAPPF) || marker == markerCOM:
+			img.markers = append(img.markers, segment{marker: marker, data: append([]byte(nil), seg...)})
+		}
+	}
+
+	if img.comps == nil {
+		return nil, errors.New("jpegtran: missing frame header")
+	}
+	for _, c := range img.comps {
+		if !c.seen {
+			return nil, errors.New("jpegtran: component missing from scans")
+		}
+		if img.qt[c.tq] == nil {
+			return nil, errors.New("jpegtran: missing quantization table")
+		}
+	}
+	return img, nil
+}
+
+func (img *jpegImage) parseSOF(marker byte, seg []byte) error {
+	if len(seg) < 6 {
+		return errTruncated
+	}
+	if seg[0] != 8 {
+		return fmt.Errorf("%w: %d-bit precision", ErrUnsupported, seg[0])
+	}
+	img.sof = marker
+	img.height = int(binary.BigEndian.Uint16(seg[1:]))
+	img.width = int(binary.BigEndian.Uint16(seg[3:]))
+	nf := int(seg[5])
+	if img.width == 0 || img.height == 0 {
+		return fmt.Errorf("%w: image height defined by DNL", ErrUnsupported)
+	}
+	if nf == 0 || nf > 4 || len(seg) < 6+3*nf {
+		return errors.New("jpegtran: invalid frame header")
+	}
+
+	img.hmax, img.vmax = 1, 1
+	for i := 0; i < nf; i++ {
+		p := seg[6+3*i:]
+		c := &component{id: p[0], h: int(p[1] >> 4), v: int(p[1] & 0x0F), tq: p[2]}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return errors.New("jpegtran: invalid component parameters")
+		}
+		img.hmax = max(img.hmax, c.h)
+		img.vmax = max(img.vmax, c.v)
+		img.comps = append(img.comps, c)
+	}
+	// A single-component image has 8x8 MCUs whatever factors it declares.
+	if nf == 1 {
+		img.comps[0].h, img.comps[0].v = 1, 1
+		img.hmax, img.vmax = 1, 1
+	}
+
+	mcuW, mcuH := img.mcuSize()
+	mcusX := (img.width + mcuW - 1) / mcuW
+	mcusY := (img.height + mcuH - 1) / mcuH
+	for _, c := range img.comps {
+		c.bw = mcusX * c.h
+		c.bh = mcusY * c.v
+		c.blocks = make([]block, c.bw*c.bh)
+	}
+	return nil
+}
+
+func (img *jpegImage) parseDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&0x0F
+		if pq > 1 || tq > 3 {
+			return errors.New("jpegtran: invalid quantization table")
+		}
+		size := 64 * int(pq+1)
+		if len(seg) < 1+size {
+			return errTruncated
+		}
+		q := new([64]uint16)
+		for i := 0; i < 64; i++ {
+			if pq == 0 {
+				q[zigzag[i]] = uint16(seg[1+i])
+			} else {
+				q[zigzag[i]] = binary.BigEndian.Uint16(seg[1+2*i:])
+			}
+		}
+		img.qt[tq] = q
+		img.qtPrec[tq] = pq
+		seg = seg[1+size:]
+	}
+	return nil
+}
+
+func parseDHT(seg []byte, dc, ac *[4]*huffTable) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return errTruncated
+		}
+		tc, th := seg[0]>>4, seg[0]&0x0F
+		if tc > 1 || th > 3 {
+			return errors.New("jpegtran: invalid Huffman table")
+		}
+		var counts [16]byte
+		copy(counts[:], seg[1:17])
+		total := 0
+		for _, n := range counts {
+			total += int(n)
+		}
+		if total > 256 || len(seg) < 17+total {
+			return errors.New("jpegtran: invalid Huffman table")
+		}
+		t := newHuffTable(counts, seg[17:17+total])
+		if tc == 0 {
+			dc[th] = t
+		} else {
+			ac[th] = t
+		}
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+// decodeScan decodes one sequential scan and returns the number of entropy
+// coded bytes consumed.
+func (img *jpegImage) decodeScan(hdr, data []byte, dc, ac *[4]*huffTable, restart int) (int, error) {
+	if len(hdr) < 1 {
+		return 0, errTruncated
+	}
+	ns := int(hdr[0])
+	if ns < 1 || ns > len(img.comps) || len(hdr) < 4+2*ns {
+		return 0, errors.New("jpegtran: invalid scan header")
+	}
+	comps := make([]*component, ns)
+	dcs := make([]*huffTable, ns)
+	acs := make([]*huffTable, ns)
+	for i := 0; i < ns; i++ {
+		id, tables := hdr[1+2*i], hdr[2+2*i]
+		for _, c := range img.comps {
+			if c.id == id {
+				comps[i] = c
+			}
+		}
+		if comps[i] == nil || comps[i].seen {
+			return 0, errors.New("jpegtran: invalid scan component")
+		}
+		comps[i].seen = true
+		dcs[i], acs[i] = dc[tables>>4&3], ac[tables&3]
+		if dcs[i] == nil || acs[i] == nil {
+			return 0, errors.New("jpegtran: missing Huffman table")
+		}
+	}
+	ss, se, ahal := hdr[1+2*ns], hdr[2+2*ns], hdr[3+2*ns]
+	if ss != 0 || se != 63 || ahal != 0 {
+		return 0, fmt.Errorf("%w: progressive scan", ErrUnsupported)
+	}
+
+	br := &bitReader{data: data}
+	pred := make([]int32, ns)
+
+	// startMCU processes a restart marker when one is due before the next
+	// MCU.
+	mcu := 0
+	startMCU := func() error {
+		if restart > 0 && mcu > 0 && mcu%restart == 0 {
+			if err := br.restart(); err != nil {
+				return err
+			}
+			for i := range pred {
+				pred[i] = 0
+			}
+		}
+		mcu++
+		return nil
+	}
+
+	if ns == 1 {
+		// Non-interleaved: each MCU is a single block, covering only the
+		// blocks that hold image data.
+		c := comps[0]
+		bw := (ceilDiv(img.width*c.h, img.hmax) + 7) / 8
+		bh := (ceilDiv(img.height*c.v, img.vmax) + 7) / 8
+		for by := 0; by < bh; by++ {
+			for bx := 0; bx < bw; bx++ {
+				if err := startMCU(); err != nil {
+					return 0, err
+				}
+				if err := br.decodeBlock(&c.blocks[by*c.bw+bx], &pred[0], dcs[0], acs[0]); err != nil {
+					return 0, err
+				}
+			}
+		}
+		return br.pos, nil
+	}
+
+	mcusX := comps[0].bw / comps[0].h
+	mcusY := comps[0].bh / comps[0].v
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			if err := startMCU(); err != nil {
+				return 0, err
+			}
+			for i, c := range comps {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						b := &c.blocks[(my*c.v+v)*c.bw+mx*c.h+h]
+						if err := br.decodeBlock(b, &pred[i], dcs[i], acs[i]); err != nil {
+							return 0, err
+						}
+					}
+				}
+			}
+		}
+	}
+	return br.pos, nil
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// bitReader reads entropy-coded data one bit at a time, removing stuffed
+// zero bytes. It never reads ahead, so the position after the last MCU is
+// exactly the end of the scan.
+type bitReader struct {
+	data []byte
+	pos  int
+	cur  byte
+	n    uint
+}
+
+func (br *bitReader) bit() (int32, error) {
+	if br.n == 0 {
+		if br.pos >= len(br.data) {
+			return 0, errTruncated
+		}
+		b := br.data[br.pos]
+		br.pos++
+		if b == 0xFF {
+			if br.pos >= len(br.data) {
+				return 0, errTruncated
+			}
+			if br.data[br.pos] != 0 {
+				return 0, fmt.Errorf("jpegtran: unexpected marker 0x%02X in scan data", br.data[br.pos])
+			}
+			br.pos++
+		}
+		br.cur, br.n = b, 8
+	}
+	br.n--
+	return int32(br.cur>>br.n) & 1, nil
+}
+
+func (br *bitReader) bits(n int) (int32, error) {
+	var v int32
+	for i := 0; i < n; i++ {
+		b, err := br.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+// restart discards the remaining bits of the current byte and consumes
+// the RSTn marker that must follow.
+func (br *bitReader) restart() error {
+	br.n = 0
+	for br.pos < len(br.data) && br.data[br.pos] == 0xFF && br.pos+1 < len(br.data) && br.data[br.pos+1] == 0xFF {
+		br.pos++
+	}
+	if br.pos+1 >= len(br.data) || br.data[br.pos] != 0xFF ||
+		br.data[br.pos+1] < markerRST0 || br.data[br.pos+1] > markerRST7 {
+		return errors.New("jpegtran: missing restart marker")
+	}
+	br.pos += 2
+	return nil
+}
+
+// decodeBlock decodes one block into natural order.
+func (br *bitReader) decodeBlock(b *block, pred *int32, dc, ac *huffTable) error {
+	s, err := dc.decode(br)
+	if err != nil {
+		return err
+	}
+	diff, err := br.receiveExtend(int(s))
+	if err != nil {
+		return err
+	}
+	*pred += diff
+	b[0] = int16(*pred)
+
+	for k := 1; k < 64; {
+		rs, err := ac.decode(br)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), int(rs&0x0F)
+		if s == 0 {
+			if r != 15 {
+				break // EOB
+			}
+			k += 16
+			continue
+		}
+		k += r
+		if k > 63 {
+			return errors.New("jpegtran: invalid AC coefficient run")
+		}
+		v, err := br.receiveExtend(s)
+		if err != nil {
+			return err
+		}
+		b[zigzag[k]] = int16(v)
+		k++
+	}
+	return nil
+}
+
+// receiveExtend reads an s-bit magnitude category value (F.2.2.1).
+func (br *bitReader) receiveExtend(s int) (int32, error) {
+	if s == 0 {
+		return 0, nil
+	}
+	if s > 16 {
+		return 0, errors.New("jpegtran: invalid coefficient size")
+	}
+	v, err := br.bits(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v, nil
+}
@@ -0,0 +1,233 @@
+package jpegtran
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// encode writes the image as a single-scan sequential JPEG with optimized
+// Huffman tables. Component 0 uses table 0 and the remaining components
+// share table 1, as libjpeg does.
+func (img *jpegImage) encode() ([]byte, error) {
+	if len(img.comps) > 1 {
+		units := 0
+		for _, c := range img.comps {
+			units += c.h * c.v
+		}
+		if units > 10 {
+			return nil, errors.New("jpegtran: too many blocks per MCU")
+		}
+	}
+
+	// First pass gathers symbol statistics, the second writes the data.
+	w := &entropyWriter{count: true}
+	img.encodeScan(w)
+	var used [2]bool
+	for t := 0; t < 2; t++ {
+		if hasSymbols(w.dcFreq[t]) {
+			used[t] = true
+			w.dc[t] = optimalHuffman(w.dcFreq[t])
+			w.ac[t] = optimalHuffman(w.acFreq[t])
+		}
+	}
+	w.count = false
+	img.encodeScan(w)
+	w.flush()
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, markerSOI})
+	for _, s := range img.markers {
+		writeSegment(&buf, s.marker, s.data)
+	}
+
+	var qused [4]bool
+	for _, c := range img.comps {
+		qused[c.tq] = true
+	}
+	for tq, q := range img.qt {
+		if !qused[tq] {
+			continue
+		}
+		seg := []byte{img.qtPrec[tq]<<4 | byte(tq)}
+		for i := 0; i < 64; i++ {
+			v := q[zigzag[i]]
+			if img.qtPrec[tq] == 0 {
+				seg = append(seg, byte(v))
+			} else {
+				seg = binary.BigEndian.AppendUint16(seg, v)
+			}
+		}
+		writeSegment(&buf, markerDQT, seg)
+	}
+
+	sof := []byte{8}
+	sof = binary.BigEndian.AppendUint16(sof, uint16(img.height))
+	sof = binary.BigEndian.AppendUint16(sof, uint16(img.width))
+	sof = append(sof, byte(len(img.comps)))
+	for _, c := range img.comps {
+		sof = append(sof, c.id, byte(c.h<<4|c.v), c.tq)
+	}
+	writeSegment(&buf, img.sof, sof)
+
+	for t := 0; t < 2; t++ {
+		if !used[t] {
+			continue
+		}
+		var dht []byte
+		for class, spec := range []*huffSpec{w.dc[t], w.ac[t]} {
+			dht = append(dht, byte(class<<4|t))
+			dht = append(dht, spec.counts[:]...)
+			dht = append(dht, spec.vals...)
+		}
+		writeSegment(&buf, markerDHT, dht)
+	}
+
+	sos := []byte{byte(len(img.comps))}
+	for i, c := range img.comps {
+		t := tableFor(i)
+		sos = append(sos, c.id, byte(t<<4|t))
+	}
+	sos = append(sos, 0, 63, 0)
+	writeSegment(&buf, markerSOS, sos)
+
+	buf.Write(w.buf)
+	buf.Write([]byte{0xFF, markerEOI})
+	return buf.Bytes(), nil
+}
+
+func writeSegment(buf *bytes.Buffer, marker byte, data []byte) {
+	buf.Write([]byte{0xFF, marker})
+	var n [2]byte
+	binary.BigEndian.PutUint16(n[:], uint16(len(data)+2))
+	buf.Write(n[:])
+	buf.Write(data)
+}
+
+func tableFor(comp int) int {
+	if comp == 0 {
+		return 0
+	}
+	return 1
+}
+
+func hasSymbols(freq [256]int64) bool {
+	for _, f := range freq {
+		if f != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeScan entropy codes every block of the image in scan order.
+func (img *jpegImage) encodeScan(w *entropyWriter) {
+	pred := make([]int32, len(img.comps))
+
+	if len(img.comps) == 1 {
+		c := img.comps[0]
+		for i := range c.blocks {
+			w.encodeBlock(&c.blocks[i], &pred[0], 0)
+		}
+		return
+	}
+
+	mcusX := img.comps[0].bw / img.comps[0].h
+	mcusY := img.comps[0].bh / img.comps[0].v
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			for i, c := range img.comps {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						b := &c.blocks[(my*c.v+v)*c.bw+mx*c.h+h]
+						w.encodeBlock(b, &pred[i], tableFor(i))
+					}
+				}
+			}
+		}
+	}
+}
+
+// entropyWriter Huffman codes blocks. In counting mode it only records
+// symbol frequencies.
+type entropyWriter struct {
+	count          bool
+	dcFreq, acFreq [2][256]int64
+	dc, ac         [2]*huffSpec
+
+	buf []byte
+	acc uint32
+	n   uint
+}
+
+func (w *entropyWriter) encodeBlock(b *block, pred *int32, t int) {
+	diff := int32(b[0]) - *pred
+	*pred = int32(b[0])
+	s, v := category(diff)
+	w.symbol(&w.dcFreq[t], w.dc[t], byte(s))
+	w.bits(v, s)
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		c := b[zigzag[k]]
+		if c == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			w.symbol(&w.acFreq[t], w.ac[t], 0xF0)
+			run -= 16
+		}
+		s, v := category(int32(c))
+		w.symbol(&w.acFreq[t], w.ac[t], byte(run<<4|s))
+		w.bits(v, s)
+		run = 0
+	}
+	if run > 0 {
+		w.symbol(&w.acFreq[t], w.ac[t], 0x00) // EOB
+	}
+}
+
+// category returns the magnitude category of v and its additional bits
+// (T.81 F.1.2.1).
+func category(v int32) (int, uint32) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	s := bits.Len32(uint32(a))
+	return s, uint32(v) & (1<<s - 1)
+}
+
+func (w *entropyWriter) symbol(freq *[256]int64, spec *huffSpec, sym byte) {
+	if w.count {
+		freq[sym]++
+		return
+	}
+	w.bits(uint32(spec.code[sym]), int(spec.size[sym]))
+}
+
+func (w *entropyWriter) bits(v uint32, n int) {
+	if w.count || n == 0 {
+		return
+	}
+	w.acc = w.acc<<uint(n) | v&(1<<n-1)
+	w.n += uint(n)
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.buf = append(w.buf, b)
+		if b == 0xFF {
+			w.buf = append(w.buf, 0x00)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the final byte with one bits.
+func (w *entropyWriter) flush() {
+	if w.n > 0 {
+		w.bits(1<<(8-w.n)-1, int(8-w.n))
+	}
+}
@@ -0,0 +1,179 @@
+package jpegtran
+
+import "errors"
+
+// zigzag maps zig-zag scan positions to natural-order indices.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// huffTable is a Huffman decoding table built as in ITU T.81 F.2.2.3.
+type huffTable struct {
+	maxcode [17]int32
+	valptr  [17]int32
+	mincode [17]int32
+	vals    []byte
+}
+
+func newHuffTable(counts [16]byte, vals []byte) *huffTable {
+	t := &huffTable{vals: append([]byte(nil), vals...)}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		t.valptr[l] = k
+		t.mincode[l] = code
+		code += n
+		k += n
+		if n > 0 {
+			t.maxcode[l] = code - 1
+		} else {
+			t.maxcode[l] = -1
+		}
+		code <<= 1
+	}
+	return t
+}
+
+var errBadCode = errors.New("jpegtran: invalid Huffman code")
+
+func (t *huffTable) decode(br *bitReader) (byte, error) {
+	var code int32
+	for l := 1; l <= 16; l++ {
+		b, err := br.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | b
+		if t.maxcode[l] >= 0 && code <= t.maxcode[l] {
+			i := int(t.valptr[l] + code - t.mincode[l])
+			if i >= len(t.vals) {
+				return 0, errBadCode
+			}
+			return t.vals[i], nil
+		}
+	}
+	return 0, errBadCode
+}
+
+// huffSpec is a Huffman table as stored in a DHT segment, together with
+// the derived encoding codes.
+type huffSpec struct {
+	counts [16]byte
+	vals   []byte
+	code   [256]uint16
+	size   [256]uint8
+}
+
+// optimalHuffman builds a length-limited Huffman table for the symbol
+// frequencies in freq, following ITU T.81 K.2 as implemented by libjpeg's
+// jpeg_gen_optimal_table.
+func optimalHuffman(freq [256]int64) *huffSpec {
+	var f [257]int64
+	copy(f[:], freq[:])
+	f[256] = 1 // reserved symbol so no code is all ones
+
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// Find the two least frequent symbols, preferring the larger
+		// symbol value on ties.
+		c1, c2 := -1, -1
+		var v int64 = 1 << 62
+		for i := 0; i <= 256; i++ {
+			if f[i] != 0 && f[i] <= v {
+				v, c1 = f[i], i
+			}
+		}
+		v = 1 << 62
+		for i := 0; i <= 256; i++ {
+			if f[i] != 0 && f[i] <= v && i != c1 {
+				v, c2 = f[i], i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+
+		f[c1] += f[c2]
+		f[c2] = 0
+
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var bits [33]int
+	for i := 0; i <= 256; i++ {
+		if codesize[i] > 0 {
+			bits[codesize[i]]++
+		}
+	}
+
+	// Limit code lengths to 16 bits (K.2, Figure K.3).
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// Remove the reserved symbol from the longest code length.
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	s := &huffSpec{}
+	for l := 1; l <= 16; l++ {
+		s.counts[l-1] = byte(bits[l])
+	}
+	for l := 1; l <= 32; l++ {
+		for sym := 0; sym < 256; sym++ {
+			if codesize[sym] == l {
+				s.vals = append(s.vals, byte(sym))
+			}
+		}
+	}
+	s.buildCodes()
+	return s
+}
+
+// buildCodes derives the code for each symbol (T.81 C.2).
+func (s *huffSpec) buildCodes() {
+	code, k := uint16(0), 0
+	for l := 1; l <= 16; l++ {
+		for n := 0; n < int(s.counts[l-1]); n++ {
+			sym := s.vals[k]
+			s.code[sym] = code
+			s.size[sym] = uint8(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+}
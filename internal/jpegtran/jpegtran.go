@@ -0,0 +1,255 @@
+// Package jpegtran implements lossless JPEG transformations in the DCT
+// coefficient domain, in the manner of libjpeg's jpegtran. Rotations, flips
+// and MCU-aligned crops rearrange the quantized coefficients directly, so
+// the image is never decoded to pixels and no generation loss occurs.
+package jpegtran
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/DanielTso/pixshift/internal/metadata"
+	"github.com/DanielTso/pixshift/internal/transform"
+)
+
+// ErrUnsupported is returned when a JPEG cannot be transformed losslessly,
+// either because its coding process is not supported (progressive,
+// arithmetic, 12-bit) or because the image dimensions are not aligned to
+// the MCU grid the requested transform needs. Callers should fall back to
+// a pixel-domain transform.
+var ErrUnsupported = errors.New("jpegtran: lossless transform not possible")
+
+// Options describes a lossless transformation.
+type Options struct {
+	// Orientation is the EXIF orientation (2-8) to correct. 0 and 1 leave
+	// the image orientation unchanged.
+	Orientation int
+	// Crop is applied after the orientation correction, using the same
+	// geometry as transform.Crop. The crop origin is moved up and left to
+	// the nearest MCU boundary; the requested size is kept.
+	Crop transform.CropOptions
+	// CopyMetadata keeps EXIF, XMP and other APPn/COM segments. JFIF, ICC
+	// profile and Adobe segments are always kept. When the orientation is
+	// corrected the EXIF orientation tag is reset to 1.
+	CopyMetadata bool
+}
+
+// op is a primitive coefficient-domain transform.
+type op int
+
+const (
+	opFlipH op = iota
+	opFlipV
+	opTranspose
+)
+
+// orientationOps maps an EXIF orientation to the primitive transforms that
+// undo it, matching transform.AutoRotate.
+var orientationOps = map[int][]op{
+	2: {opFlipH},
+	3: {opFlipH, opFlipV},
+	4: {opFlipV},
+	5: {opTranspose},
+	6: {opTranspose, opFlipH},
+	7: {opTranspose, opFlipH, opFlipV},
+	8: {opTranspose, opFlipV},
+}
+
+// Transform applies opts to the baseline JPEG in data and returns the new
+// JPEG. It returns an error wrapping ErrUnsupported if the transform
+// cannot be performed losslessly.
+func Transform(data []byte, opts Options) ([]byte, error) {
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := orientationOps[opts.Orientation]
+	for _, o := range ops {
+		if err := img.apply(o); err != nil {
+			return nil, err
+		}
+	}
+
+	if rect, ok := transform.CropRect(image.Rect(0, 0, img.width, img.height), opts.Crop); ok {
+		img.crop(rect)
+	}
+
+	img.filterMarkers(opts.CopyMetadata, len(ops) > 0)
+	return img.encode()
+}
+
+// mcuSize returns the MCU dimensions in pixels.
+func (img *jpegImage) mcuSize() (int, int) {
+	return 8 * img.hmax, 8 * img.vmax
+}
+
+// apply performs a primitive transform on every component. Flips require
+// the flipped dimension to be a whole number of MCUs; otherwise the
+// partial edge MCU would move into the visible image.
+func (img *jpegImage) apply(o op) error {
+	mcuW, mcuH := img.mcuSize()
+	switch o {
+	case opFlipH:
+		if img.width%mcuW != 0 {
+			return fmt.Errorf("%w: width %d is not a multiple of the %d-pixel MCU", ErrUnsupported, img.width, mcuW)
+		}
+		for _, c := range img.comps {
+			c.flipH()
+		}
+	case opFlipV:
+		if img.height%mcuH != 0 {
+			return fmt.Errorf("%w: height %d is not a multiple of the %d-pixel MCU", ErrUnsupported, img.height, mcuH)
+		}
+		for _, c := range img.comps {
+			c.flipV()
+		}
+	case opTranspose:
+		for _, c := range img.comps {
+			c.transpose()
+		}
+		for _, q := range img.qt {
+			if q != nil {
+				transposeBlock(q)
+			}
+		}
+		img.width, img.height = img.height, img.width
+		img.hmax, img.vmax = img.vmax, img.hmax
+	}
+	return nil
+}
+
+// crop restricts the image to rect, moving its origin to the enclosing
+// MCU boundary.
+func (img *jpegImage) crop(rect image.Rectangle) {
+	mcuW, mcuH := img.mcuSize()
+	x0 := rect.Min.X - rect.Min.X%mcuW
+	y0 := rect.Min.Y - rect.Min.Y%mcuH
+	w, h := rect.Dx(), rect.Dy()
+	mcusX := (w + mcuW - 1) / mcuW
+	mcusY := (h + mcuH - 1) / mcuH
+
+	for _, c := range img.comps {
+		bx0 := x0 / mcuW * c.h
+		by0 := y0 / mcuH * c.v
+		bw := mcusX * c.h
+		bh := mcusY * c.v
+		blocks := make([]block, bw*bh)
+		for y := 0; y < bh; y++ {
+			sy := by0 + y
+			if sy >= c.bh {
+				break
+			}
+			for x := 0; x < bw; x++ {
+				sx := bx0 + x
+				if sx >= c.bw {
+					break
+				}
+				blocks[y*bw+x] = c.blocks[sy*c.bw+sx]
+			}
+		}
+		c.blocks, c.bw, c.bh = blocks, bw, bh
+	}
+	img.width, img.height = w, h
+}
+
+// filterMarkers drops metadata segments unless keep is set, and resets the
+// EXIF orientation when the pixels were reoriented.
+func (img *jpegImage) filterMarkers(keep, reoriented bool) {
+	var out []segment
+	for _, s := range img.markers {
+		if !keep && !essentialSegment(s) {
+			continue
+		}
+		if reoriented && isEXIFSegment(s) {
+			m := &metadata.Metadata{EXIFRaw: s.data}
+			m.SetOrientation(1)
+		}
+		out = append(out, s)
+	}
+	img.markers = out
+}
+
+// essentialSegment reports whether s affects how the image is rendered:
+// JFIF (APP0), ICC profile (APP2) and Adobe color transform (APP14).
+func essentialSegment(s segment) bool {
+	switch s.marker {
+	case 0xE0:
+		return hasPrefix(s.data, "JFIF\x00") || hasPrefix(s.data, "JFXX\x00")
+	case 0xE2:
+		return hasPrefix(s.data, "ICC_PROFILE\x00")
+	case 0xEE:
+		return hasPrefix(s.data, "Adobe")
+	}
+	return false
+}
+
+func isEXIFSegment(s segment) bool {
+	return s.marker == 0xE1 && hasPrefix(s.data, "Exif\x00\x00")
+}
+
+func hasPrefix(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && string(b[:len(prefix)]) == prefix
+}
+
+// flipH mirrors the component horizontally: block columns are reversed
+// and odd horizontal frequencies change sign.
+func (c *component) flipH() {
+	for y := 0; y < c.bh; y++ {
+		row := c.blocks[y*c.bw : (y+1)*c.bw]
+		for l, r := 0, len(row)-1; l < r; l, r = l+1, r-1 {
+			row[l], row[r] = row[r], row[l]
+		}
+		for i := range row {
+			for v := 0; v < 8; v++ {
+				for u := 1; u < 8; u += 2 {
+					row[i][v*8+u] = -row[i][v*8+u]
+				}
+			}
+		}
+	}
+}
+
+// flipV mirrors the component vertically: block rows are reversed and odd
+// vertical frequencies change sign.
+func (c *component) flipV() {
+	for t, b := 0, c.bh-1; t < b; t, b = t+1, b-1 {
+		top := c.blocks[t*c.bw : (t+1)*c.bw]
+		bot := c.blocks[b*c.bw : (b+1)*c.bw]
+		for i := range top {
+			top[i], bot[i] = bot[i], top[i]
+		}
+	}
+	for i := range c.blocks {
+		for v := 1; v < 8; v += 2 {
+			for u := 0; u < 8; u++ {
+				c.blocks[i][v*8+u] = -c.blocks[i][v*8+u]
+			}
+		}
+	}
+}
+
+// transpose mirrors the component across its main diagonal.
+func (c *component) transpose() {
+	blocks := make([]block, len(c.blocks))
+	for y := 0; y < c.bh; y++ {
+		for x := 0; x < c.bw; x++ {
+			b := c.blocks[y*c.bw+x]
+			transposeBlock((*[64]int16)(&b))
+			blocks[x*c.bh+y] = b
+		}
+	}
+	c.blocks = blocks
+	c.bw, c.bh = c.bh, c.bw
+	c.h, c.v = c.v, c.h
+}
+
+// transposeBlock transposes an 8x8 array in natural order.
+func transposeBlock[T int16 | uint16](b *[64]T) {
+	for v := 0; v < 8; v++ {
+		for u := v + 1; u < 8; u++ {
+			b[v*8+u], b[u*8+v] = b[u*8+v], b[v*8+u]
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package jpegtran
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+	"github.com/DanielTso/pixshift/internal/transform"
+)
+
+// testJPEG encodes a w×h gradient with a diagonal feature so that every
+// orientation produces distinct pixels.
+func testJPEG(t *testing.T, w, h int, gray bool) []byte {
+	t.Helper()
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				g.SetGray(x, y, color.Gray{Y: uint8((x*255/w + y*3) % 256)})
+			}
+		}
+		img = g
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				rgba.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: uint8((x + 2*y) % 256), A: 255})
+			}
+		}
+		img = rgba
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeJPEG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding output: %v", err)
+	}
+	return img
+}
+
+// meanAbsDiff compares two images channel by channel.
+func meanAbsDiff(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	var sum, n float64
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}} {
+				diff := float64(d[0]>>8) - float64(d[1]>>8)
+				if diff < 0 {
+					diff = -diff
+				}
+				sum += diff
+				n++
+			}
+		}
+	}
+	return sum / n
+}
+
+func TestTransform_MatchesPixelRotation(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		src := testJPEG(t, 64, 48, gray)
+		orig := decodeJPEG(t, src)
+		for o := 2; o <= 8; o++ {
+			out, err := Transform(src, Options{Orientation: o})
+			if err != nil {
+				t.Fatalf("gray=%v orientation %d: %v", gray, o, err)
+			}
+			got := decodeJPEG(t, out)
+			want := transform.AutoRotate(orig, o)
+			if got.Bounds().Size() != want.Bounds().Size() {
+				t.Fatalf("gray=%v orientation %d: size %v, want %v", gray, o, got.Bounds().Size(), want.Bounds().Size())
+			}
+			if d := meanAbsDiff(got, want); d > 2 {
+				t.Errorf("gray=%v orientation %d: mean difference %.2f from pixel rotation", gray, o, d)
+			}
+		}
+	}
+}
+
+func TestTransform_RoundTripIsExact(t *testing.T) {
+	src := testJPEG(t, 64, 48, false)
+	data := src
+	for i := 0; i < 4; i++ {
+		var err error
+		data, err = Transform(data, Options{Orientation: 6})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	orig := decodeJPEG(t, src).(*image.YCbCr)
+	got := decodeJPEG(t, data).(*image.YCbCr)
+	if !bytes.Equal(orig.Y, got.Y) || !bytes.Equal(orig.Cb, got.Cb) || !bytes.Equal(orig.Cr, got.Cr) {
+		t.Error("four 90° rotations did not reproduce the original pixels")
+	}
+}
+
+func TestTransform_UnalignedFlip(t *testing.T) {
+	src := testJPEG(t, 60, 48, false)
+	if _, err := Transform(src, Options{Orientation: 2}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("flipping a 60px wide 4:2:0 image: err = %v, want ErrUnsupported", err)
+	}
+	// Transposition has no alignment requirement.
+	if _, err := Transform(src, Options{Orientation: 5}); err != nil {
+		t.Errorf("transposing a 60px wide image: %v", err)
+	}
+}
+
+func TestTransform_Crop(t *testing.T) {
+	src := testJPEG(t, 64, 48, false)
+	out, err := Transform(src, Options{Crop: transform.CropOptions{Width: 32, Height: 32, Gravity: "center"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeJPEG(t, out)
+	if got.Bounds().Dx() != 32 || got.Bounds().Dy() != 32 {
+		t.Fatalf("crop size %v, want 32x32", got.Bounds().Size())
+	}
+	// The centered origin (16,8) snaps to the 16x16 MCU grid at (16,0).
+	orig := decodeJPEG(t, src).(*image.YCbCr)
+	want := orig.SubImage(image.Rect(16, 0, 48, 32))
+	if d := meanAbsDiff(got, want); d > 2 {
+		t.Errorf("cropped pixels differ from source region by %.2f", d)
+	}
+}
+
+func TestTransform_ResetsOrientation(t *testing.T) {
+	src := testJPEG(t, 64, 48, false)
+	exif := testEXIF(6)
+	app1 := []byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}
+	withEXIF := append(append(append([]byte{}, src[:2]...), app1...), exif...)
+	withEXIF = append(withEXIF, src[2:]...)
+
+	out, err := Transform(withEXIF, Options{Orientation: 6, CopyMetadata: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metadata.Extract(bytes.NewReader(out), codec.JPEG)
+	if err != nil || !meta.HasEXIF() {
+		t.Fatalf("EXIF not preserved: %v", err)
+	}
+	if o := meta.Orientation(); o != 1 {
+		t.Errorf("orientation = %d, want 1", o)
+	}
+
+	stripped, err := Transform(withEXIF, Options{Orientation: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, []byte("Exif\x00\x00")) {
+		t.Error("EXIF kept without CopyMetadata")
+	}
+}
+
+func TestTransform_Progressive(t *testing.T) {
+	src := testJPEG(t, 16, 16, false)
+	// Relabel the frame as progressive; the coding process check happens
+	// before any scan is decoded.
+	i := bytes.Index(src, []byte{0xFF, 0xC0})
+	src[i+1] = 0xC2
+	if _, err := Transform(src, Options{Orientation: 3}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+}
+
+// testEXIF builds a little-endian EXIF blob holding only an orientation tag.
+func testEXIF(orientation int) []byte {
+	return []byte{
+		'E', 'x', 'i', 'f', 0, 0,
+		'I', 'I', 42, 0, 8, 0, 0, 0,
+		1, 0,
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0,
+		0, 0, 0, 0,
+	}
+}
@@ -0,0 +1,234 @@
+// Package manifest records what a batch run built, so later runs can
+// rebuild only the outputs whose source or settings changed and remove
+// outputs whose source is gone.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+// FileName is the default name of the manifest file.
+const FileName = ".pixshift-cache.json"
+
+// version is the manifest file format version. Files of another version
+// are ignored, so every output is rebuilt once.
+const version = 1
+
+// Entry records one input and the outputs built from it.
+type Entry struct {
+	InputHash  string   `json:"input_hash"`  // SHA-256 of the input's contents
+	InputSize  int64    `json:"input_size"`  // size and modification time let
+	InputMtime int64    `json:"input_mtime"` // unchanged inputs skip hashing
+	ParamsHash string   `json:"params_hash"` // see ParamsHash
+	Outputs    []string `json:"outputs"`
+}
+
+// Manifest maps inputs to the outputs built from them. Paths are stored
+// relative to the manifest's directory. Its methods are safe for
+// concurrent use.
+type Manifest struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+type file struct {
+	Version int              `json:"version"`
+	Entries map[string]Entry `json:"entries"`
+}
+
+// Load reads the manifest at path. A missing file, or one written by
+// another format version, gives an empty manifest.
+func Load(path string) (*Manifest, error) {
+	m := &Manifest{path: path, entries: make(map[string]Entry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+	if f.Version == version && f.Entries != nil {
+		m.entries = f.Entries
+	}
+	return m, nil
+}
+
+// Save writes the manifest back to its path, through a temporary file so
+// an interrupted save keeps the previous manifest.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(file{Version: version, Entries: m.entries}, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return nil
+}
+
+// UpToDate reports whether the outputs recorded for the job's input are
+// up to date: the input's contents and the job's parameters, including
+// its output paths, are unchanged and the recorded outputs all exist. It
+// also returns the input's hash, to be passed to Record once the job
+// succeeds; an input whose size and modification time are unchanged is
+// not hashed again.
+func (m *Manifest) UpToDate(job pipeline.Job) (upToDate bool, inputHash string, err error) {
+	info, err := os.Stat(job.InputPath)
+	if err != nil {
+		return false, "", err
+	}
+	m.mu.Lock()
+	e, ok := m.entries[m.key(job.InputPath)]
+	m.mu.Unlock()
+
+	if ok && e.InputSize == info.Size() && e.InputMtime == info.ModTime().UnixNano() {
+		inputHash = e.InputHash
+	} else if inputHash, err = hashFile(job.InputPath); err != nil {
+		return false, "", err
+	}
+	if !ok || e.InputHash != inputHash || e.ParamsHash != m.paramsHash(job) {
+		return false, inputHash, nil
+	}
+	for _, out := range e.Outputs {
+		if _, err := os.Stat(m.resolve(out)); err != nil {
+			return false, inputHash, nil
+		}
+	}
+	return true, inputHash, nil
+}
+
+// Record stores the outputs the job wrote, which differ from its output
+// paths when the format was chosen automatically. job is the job as
+// submitted (see pipeline.Result.SubmittedJob), so that UpToDate matches
+// it on the next run. It returns the outputs previously recorded for the
+// input that the job no longer writes.
+func (m *Manifest) Record(job pipeline.Job, inputHash string, outputs []string) (stale []string, err error) {
+	info, err := os.Stat(job.InputPath)
+	if err != nil {
+		return nil, err
+	}
+	outKeys := m.keys(outputs)
+	params := m.paramsHash(job)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(job.InputPath)
+	for _, old := range m.entries[key].Outputs {
+		if !slices.Contains(outKeys, old) {
+			stale = append(stale, m.resolve(old))
+		}
+	}
+	m.entries[key] = Entry{
+		InputHash:  inputHash,
+		InputSize:  info.Size(),
+		InputMtime: info.ModTime().UnixNano(),
+		ParamsHash: params,
+		Outputs:    outKeys,
+	}
+	return stale, nil
+}
+
+// Orphans removes the entries whose input no longer exists and returns
+// their outputs, sorted.
+func (m *Manifest) Orphans() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orphans []string
+	for key, e := range m.entries {
+		if _, err := os.Stat(m.resolve(key)); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		for _, out := range e.Outputs {
+			orphans = append(orphans, m.resolve(out))
+		}
+		delete(m.entries, key)
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// key returns path relative to the manifest's directory, or absolute when
+// it has no relative form.
+func (m *Manifest) key(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	dir, err := filepath.Abs(filepath.Dir(m.path))
+	if err == nil {
+		if rel, err := filepath.Rel(dir, abs); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(abs)
+}
+
+func (m *Manifest) keys(paths []string) []string {
+	keys := make([]string, len(paths))
+	for i, p := range paths {
+		keys[i] = m.key(p)
+	}
+	return keys
+}
+
+// resolve turns a key back into a path usable from the working directory.
+func (m *Manifest) resolve(key string) string {
+	p := filepath.FromSlash(key)
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(m.path), p)
+}
+
+// paramsHash returns a hash of everything in the job that affects its
+// outputs, so a changed quality, size, operation, metadata setting or
+// output path rebuilds them.
+func (m *Manifest) paramsHash(job pipeline.Job) string {
+	job.InputPath, job.BackupOriginal = "", false
+	if job.OutputPath != "" {
+		job.OutputPath = m.key(job.OutputPath)
+	}
+	job.Variants = slices.Clone(job.Variants)
+	for i := range job.Variants {
+		job.Variants[i].OutputPath = m.key(job.Variants[i].OutputPath)
+	}
+	return job.Fingerprint()
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
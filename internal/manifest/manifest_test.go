@@ -0,0 +1,180 @@
+package manifest
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/pipeline"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifest_UpToDate(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.png")
+	out := filepath.Join(dir, "out", "a.webp")
+	writeFile(t, in, "image")
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, out, "output")
+	job := pipeline.Job{InputPath: in, OutputPath: out, OutputFormat: codec.WebP, Quality: 80}
+
+	path := filepath.Join(dir, FileName)
+	m, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	ok, hash, err := m.UpToDate(job)
+	if err != nil || ok || hash == "" {
+		t.Fatalf("new input: up to date = %v, hash %q, err %v", ok, hash, err)
+	}
+	if _, err := m.Record(job, hash, []string{out}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if m, err = Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if ok, _, _ := m.UpToDate(job); !ok {
+		t.Error("unchanged job is not up to date")
+	}
+
+	changed := job
+	changed.Quality = 60
+	if ok, _, _ := m.UpToDate(changed); ok {
+		t.Error("job with a changed quality is up to date")
+	}
+	moved := job
+	moved.OutputPath = filepath.Join(dir, "a.webp")
+	if ok, _, _ := m.UpToDate(moved); ok {
+		t.Error("job with a changed output path is up to date")
+	}
+
+	// Same size, new contents and modification time
+	writeFile(t, in, "IMAGE")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(in, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, _ := m.UpToDate(job); ok {
+		t.Error("job with a changed input is up to date")
+	}
+
+	// Touched but unchanged contents keep the outputs
+	writeFile(t, in, "image")
+	if ok, _, _ := m.UpToDate(job); !ok {
+		t.Error("touched input is not up to date")
+	}
+
+	os.Remove(out)
+	if ok, _, _ := m.UpToDate(job); ok {
+		t.Error("job with a missing output is up to date")
+	}
+}
+
+func TestManifest_AutoFormat(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.png")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	job := pipeline.Job{
+		InputPath:    in,
+		OutputPath:   filepath.Join(dir, "out", "a.auto"),
+		OutputFormat: codec.Auto,
+		Quality:      80,
+		AutoFormats:  []codec.Format{codec.JPEG, codec.PNG},
+	}
+
+	m, err := Load(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	_, hash, err := m.UpToDate(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := pipeline.NewPipeline(codec.DefaultRegistry()).ExecuteJob(job)
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if _, err := m.Record(res.SubmittedJob(), hash, []string{res.Job.OutputPath}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if ok, _, _ := m.UpToDate(job); !ok {
+		t.Error("auto job is not up to date after it was recorded")
+	}
+}
+
+func TestManifest_StaleAndOrphans(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Load(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	a, b := filepath.Join(dir, "a.png"), filepath.Join(dir, "b.png")
+	writeFile(t, a, "a")
+	writeFile(t, b, "b")
+
+	jobA := pipeline.Job{InputPath: a, OutputPath: filepath.Join(dir, "a.webp")}
+	if _, err := m.Record(jobA, "h", []string{jobA.OutputPath}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	jobA.OutputPath = filepath.Join(dir, "a.avif")
+	stale, err := m.Record(jobA, "h", []string{jobA.OutputPath})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if want := []string{filepath.Join(dir, "a.webp")}; !reflect.DeepEqual(stale, want) {
+		t.Errorf("stale = %v, want %v", stale, want)
+	}
+
+	jobB := pipeline.Job{InputPath: b, OutputPath: filepath.Join(dir, "b.webp")}
+	if _, err := m.Record(jobB, "h", []string{jobB.OutputPath}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	os.Remove(b)
+	if orphans, want := m.Orphans(), []string{jobB.OutputPath}; !reflect.DeepEqual(orphans, want) {
+		t.Errorf("orphans = %v, want %v", orphans, want)
+	}
+	if orphans := m.Orphans(); len(orphans) != 0 {
+		t.Errorf("orphans after removal = %v", orphans)
+	}
+}
+
+func TestLoad_OtherVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	writeFile(t, path, `{"version": 99, "entries": {"a.png": {"input_hash": "x"}}}`)
+	m, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(m.entries) != 0 {
+		t.Errorf("entries = %v", m.entries)
+	}
+
+	writeFile(t, path, "{")
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for a corrupt manifest")
+	}
+}
@@ -48,7 +48,7 @@ func getFormatsTool() mcp.Tool {
 
 func analyzeImageTool() mcp.Tool {
 	return mcp.NewTool("analyze_image",
-		mcp.WithDescription("Analyze an image file: detect format, dimensions, file size, and EXIF metadata"),
+		mcp.WithDescription("Analyze an image file: detect format, dimensions, file size, and EXIF metadata (camera, lens, exposure, capture date, GPS, plus every decoded tag)"),
 		mcp.WithString("path", mcp.Required(), mcp.Description("Absolute path to the image file")),
 		mcp.WithToolAnnotation(mcp.ToolAnnotation{
 			ReadOnlyHint:    mcp.ToBoolPtr(true),
@@ -124,20 +124,22 @@ func (s *Server) handleConvertImage() server.ToolHandlerFunc {
 
 		pipe := pipeline.NewPipeline(s.registry)
 		start := time.Now()
-		inputSize, outputSize, err := pipe.Execute(job)
+		res := pipe.ExecuteJobContext(ctx, job)
 		durationMs := time.Since(start).Milliseconds()
 
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("conversion failed: %v", err)), nil
+		if res.Error != nil {
+			return mcp.NewToolResultError(conversionFailed(res.Error)), nil
 		}
 
+		// Report the file written: auto output resolves its format and
+		// extension per image
 		result := map[string]any{
-			"output_path":  outputPath,
-			"input_size":   inputSize,
-			"output_size":  outputSize,
+			"output_path":  res.Job.OutputPath,
+			"input_size":   res.InputSize,
+			"output_size":  res.OutputSize,
 			"duration_ms":  durationMs,
 			"input_format": "auto-detected",
-			"output_format": string(outputFormat),
+			"output_format": string(res.Job.OutputFormat),
 		}
 
 		data, _ := json.MarshalIndent(result, "", "  ")
@@ -218,10 +220,16 @@ func (s *Server) handleAnalyzeImage() server.ToolHandlerFunc {
 
 		var hasExif bool
 		var orientation int
+		var exif *metadata.EXIF
+		var iptc metadata.IPTCFields
 		meta, metaErr := metadata.Extract(f, format)
 		if metaErr == nil && meta.HasEXIF() {
 			hasExif = true
 			orientation = meta.Orientation()
+			exif, _ = meta.EXIF()
+		}
+		if metaErr == nil {
+			iptc, _ = meta.IPTCFields()
 		}
 
 		result := map[string]any{
@@ -240,6 +248,15 @@ func (s *Server) handleAnalyzeImage() server.ToolHandlerFunc {
 			result["exif_orientation"] = orientation
 		}
 
+		if exif != nil {
+			result["exif"] = exif.Summary()
+			result["exif_tags"] = exif.Fields()
+		}
+
+		if !iptc.IsZero() {
+			result["iptc"] = iptc
+		}
+
 		data, _ := json.MarshalIndent(result, "", "  ")
 		return mcp.NewToolResultText(string(data)), nil
 	}
@@ -273,3 +290,12 @@ func (s *Server) handleCompareImages() server.ToolHandlerFunc {
 		return mcp.NewToolResultText(string(data)), nil
 	}
 }
+
+// conversionFailed describes a failed conversion, naming the kind of
+// failure so clients can tell a bad image from a failure to write it.
+func conversionFailed(err error) string {
+	if kind := pipeline.ErrorKind(err); kind != nil {
+		return fmt.Sprintf("conversion failed (%v): %v", kind, err)
+	}
+	return fmt.Sprintf("conversion failed: %v", err)
+}
@@ -0,0 +1,622 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ISO base media file format (HEIF/AVIF) support: the EXIF block is an
+// item of type "Exif" described in the meta box (iinf, iloc) and linked to
+// the primary image with a "cdsc" reference. Its payload is a 4-byte
+// offset to the TIFF header followed by the EXIF data. An XMP packet is a
+// "mime" item with content type application/rdf+xml, linked the same way.
+
+var errBMFF = errors.New("invalid ISO-BMFF data")
+
+// bmffBox is a parsed box. data is the payload after the header (and, for
+// full boxes, still including the version/flags word).
+type bmffBox struct {
+	typ   string
+	start int // offset of the box header in the parent buffer
+	end   int // offset just past the box
+	data  []byte
+}
+
+// parseBoxes splits buf into consecutive boxes.
+func parseBoxes(buf []byte) ([]bmffBox, error) {
+	var boxes []bmffBox
+	for off := 0; off < len(buf); {
+		if off+8 > len(buf) {
+			return nil, fmt.Errorf("%w: truncated box header at %d", errBMFF, off)
+		}
+		size := uint64(binary.BigEndian.Uint32(buf[off:]))
+		typ := string(buf[off+4 : off+8])
+		hdr := 8
+		switch size {
+		case 0:
+			size = uint64(len(buf) - off)
+		case 1:
+			if off+16 > len(buf) {
+				return nil, fmt.Errorf("%w: truncated large box header at %d", errBMFF, off)
+			}
+			size = binary.BigEndian.Uint64(buf[off+8:])
+			hdr = 16
+		}
+		if size < uint64(hdr) || uint64(off)+size > uint64(len(buf)) {
+			return nil, fmt.Errorf("%w: box %q at %d has bad size %d", errBMFF, typ, off, size)
+		}
+		end := off + int(size)
+		boxes = append(boxes, bmffBox{typ: typ, start: off, end: end, data: buf[off+hdr : end]})
+		off = end
+	}
+	return boxes, nil
+}
+
+// makeBox serializes a box with a 32-bit size.
+func makeBox(typ string, payload ...[]byte) []byte {
+	n := 8
+	for _, p := range payload {
+		n += len(p)
+	}
+	out := make([]byte, 8, n)
+	binary.BigEndian.PutUint32(out, uint32(n))
+	copy(out[4:], typ)
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// fullBoxHeader returns the version/flags word of a full box.
+func fullBoxHeader(version byte, flags uint32) []byte {
+	return []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+}
+
+// bmffReader reads big-endian fields of a box payload.
+type bmffReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *bmffReader) uint(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.off+n > len(r.buf) {
+		r.err = fmt.Errorf("%w: truncated box payload", errBMFF)
+		return 0
+	}
+	var v uint64
+	for _, b := range r.buf[r.off : r.off+n] {
+		v = v<<8 | uint64(b)
+	}
+	r.off += n
+	return v
+}
+
+func putUint(buf []byte, n int, v uint64) []byte {
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(8*i)))
+	}
+	return buf
+}
+
+// ilocItem is an entry of the item location box.
+type ilocItem struct {
+	id                 uint32
+	constructionMethod uint16
+	dataRefIndex       uint16
+	baseOffset         uint64
+	extents            []ilocExtent
+}
+
+type ilocExtent struct {
+	index, offset, length uint64
+}
+
+// ilocBox is a parsed item location box (versions 0-2).
+type ilocBox struct {
+	version   byte
+	indexSize int
+	items     []ilocItem
+}
+
+func parseIloc(data []byte) (*ilocBox, error) {
+	r := &bmffReader{buf: data}
+	version := byte(r.uint(1))
+	r.uint(3)
+	if version > 2 {
+		return nil, fmt.Errorf("%w: iloc version %d", errBMFF, version)
+	}
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	if version == 0 {
+		indexSize = 0
+	}
+	countSize := 2
+	if version == 2 {
+		countSize = 4
+	}
+	box := &ilocBox{version: version, indexSize: indexSize}
+	count := r.uint(countSize)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		var it ilocItem
+		it.id = uint32(r.uint(countSize))
+		if version > 0 {
+			it.constructionMethod = uint16(r.uint(2) & 0xF)
+		}
+		it.dataRefIndex = uint16(r.uint(2))
+		it.baseOffset = r.uint(baseSize)
+		extents := r.uint(2)
+		for j := uint64(0); j < extents && r.err == nil; j++ {
+			var e ilocExtent
+			e.index = r.uint(indexSize)
+			e.offset = r.uint(offsetSize)
+			e.length = r.uint(lengthSize)
+			it.extents = append(it.extents, e)
+		}
+		box.items = append(box.items, it)
+	}
+	return box, r.err
+}
+
+// bytes serializes the box payload. Offsets and lengths are written with 8
+// bytes if any value needs it and 4 bytes otherwise.
+func (b *ilocBox) bytes() []byte {
+	size := 4
+	for _, it := range b.items {
+		for _, e := range it.extents {
+			if it.baseOffset > 0xFFFFFFFF || e.offset > 0xFFFFFFFF || e.length > 0xFFFFFFFF {
+				size = 8
+			}
+		}
+	}
+	countSize := 2
+	if b.version == 2 {
+		countSize = 4
+	}
+	out := fullBoxHeader(b.version, 0)
+	out = append(out, byte(size<<4|size), byte(size<<4|b.indexSize))
+	out = putUint(out, countSize, uint64(len(b.items)))
+	for _, it := range b.items {
+		out = putUint(out, countSize, uint64(it.id))
+		if b.version > 0 {
+			out = putUint(out, 2, uint64(it.constructionMethod))
+		}
+		out = putUint(out, 2, uint64(it.dataRefIndex))
+		out = putUint(out, size, it.baseOffset)
+		out = putUint(out, 2, uint64(len(it.extents)))
+		for _, e := range it.extents {
+			out = putUint(out, b.indexSize, e.index)
+			out = putUint(out, size, e.offset)
+			out = putUint(out, size, e.length)
+		}
+	}
+	return out
+}
+
+// itemInfo is the type of an item and, for "mime" items, its content type.
+type itemInfo struct {
+	typ         string
+	contentType string
+}
+
+// isXMP reports whether the item holds an XMP packet.
+func (i itemInfo) isXMP() bool {
+	return i.typ == "mime" && i.contentType == xmpMIME
+}
+
+// parseItemTypes returns the item IDs and types listed in an iinf box.
+func parseItemTypes(data []byte) (map[uint32]itemInfo, error) {
+	r := &bmffReader{buf: data}
+	version := r.uint(1)
+	r.uint(3)
+	countSize := 2
+	if version > 0 {
+		countSize = 4
+	}
+	r.uint(countSize)
+	if r.err != nil {
+		return nil, r.err
+	}
+	entries, err := parseBoxes(data[r.off:])
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[uint32]itemInfo)
+	for _, infe := range entries {
+		if infe.typ != "infe" || len(infe.data) < 4 {
+			continue
+		}
+		er := &bmffReader{buf: infe.data}
+		v := er.uint(1)
+		er.uint(3)
+		if v < 2 {
+			continue // legacy entries carry no item type
+		}
+		idSize := 2
+		if v == 3 {
+			idSize = 4
+		}
+		id := uint32(er.uint(idSize))
+		er.uint(2) // protection index
+		typ := er.uint(4)
+		if er.err != nil {
+			return nil, er.err
+		}
+		info := itemInfo{typ: string([]byte{byte(typ >> 24), byte(typ >> 16), byte(typ >> 8), byte(typ)})}
+		if info.typ == "mime" {
+			// item_name and content_type are null-terminated strings
+			fields := bytes.SplitN(infe.data[er.off:], []byte{0}, 3)
+			if len(fields) > 1 {
+				info.contentType = string(fields[1])
+			}
+		}
+		types[id] = info
+	}
+	return types, nil
+}
+
+// primaryItem returns the item ID in a pitm box.
+func primaryItem(data []byte) (uint32, error) {
+	r := &bmffReader{buf: data}
+	version := r.uint(1)
+	r.uint(3)
+	size := 2
+	if version > 0 {
+		size = 4
+	}
+	id := uint32(r.uint(size))
+	return id, r.err
+}
+
+// newItem is an item added to a HEIF/AVIF file.
+type newItem struct {
+	itemInfo
+	id   uint32
+	data []byte
+}
+
+// injectIntoBMFF adds the EXIF block as an "Exif" item and the XMP packet
+// as a "mime" item of a HEIF/AVIF file, replacing existing items of the
+// same kind. Both describe the primary image. The item data is appended
+// in a new mdat box, and the file offsets of items stored after the meta
+// box are shifted by the meta box growth.
+func injectIntoBMFF(data []byte, meta *Metadata) ([]byte, error) {
+	top, err := parseBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	metaIdx := -1
+	for i, b := range top {
+		if b.typ == "meta" {
+			metaIdx = i
+			break
+		}
+	}
+	if metaIdx < 0 || len(top[metaIdx].data) < 4 {
+		return nil, fmt.Errorf("%w: no meta box", errBMFF)
+	}
+	metaBox := top[metaIdx]
+	children, err := parseBoxes(metaBox.data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	var iloc *ilocBox
+	var types map[uint32]itemInfo
+	var primary uint32
+	var irefVersion byte
+	for _, c := range children {
+		switch c.typ {
+		case "iloc":
+			iloc, err = parseIloc(c.data)
+		case "iinf":
+			types, err = parseItemTypes(c.data)
+		case "pitm":
+			primary, err = primaryItem(c.data)
+		case "iref":
+			if len(c.data) > 0 {
+				irefVersion = c.data[0]
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if iloc == nil || types == nil || primary == 0 {
+		return nil, fmt.Errorf("%w: meta box lacks iloc, iinf or pitm", errBMFF)
+	}
+
+	// Drop existing items of the kinds being added so the new ones are
+	// the only ones.
+	drop := make(map[uint32]bool)
+	var maxID uint32
+	for id, info := range types {
+		if info.typ == "Exif" && meta.HasEXIF() || info.isXMP() && meta.HasXMP() {
+			drop[id] = true
+		}
+		maxID = max(maxID, id)
+	}
+	for _, it := range iloc.items {
+		maxID = max(maxID, it.id)
+	}
+	var items []newItem
+	if meta.HasEXIF() {
+		// The payload starts with the offset of the TIFF header
+		items = append(items, newItem{itemInfo{typ: "Exif"}, 0, append([]byte{0, 0, 0, 0}, meta.TIFF()...)})
+	}
+	if meta.HasXMP() {
+		items = append(items, newItem{itemInfo{"mime", xmpMIME}, 0, meta.XMP})
+	}
+	for i := range items {
+		items[i].id = maxID + 1 + uint32(i)
+	}
+	if last := items[len(items)-1].id; last > 0xFFFF && (iloc.version < 2 || irefVersion == 0) {
+		return nil, fmt.Errorf("%w: item ID %d does not fit the meta box", errBMFF, last)
+	}
+
+	// Build the new meta box with placeholder locations for the new
+	// items, measure its growth, then fix up the offsets.
+	kept := iloc.items[:0:0]
+	for _, it := range iloc.items {
+		if !drop[it.id] {
+			kept = append(kept, it)
+		}
+	}
+	for _, it := range items {
+		kept = append(kept, ilocItem{id: it.id, extents: []ilocExtent{{length: uint64(len(it.data))}}})
+	}
+	iloc.items = kept
+	added := iloc.items[len(iloc.items)-len(items):]
+
+	buildMeta := func() []byte {
+		payload := [][]byte{metaBox.data[:4]}
+		hasIref := false
+		for _, c := range children {
+			raw := metaBox.data[4:][c.start:c.end]
+			switch c.typ {
+			case "iloc":
+				raw = makeBox("iloc", iloc.bytes())
+			case "iinf":
+				raw = appendInfe(c, items, drop)
+			case "iref":
+				hasIref = true
+				raw = appendCdsc(c, items, primary, drop)
+			}
+			payload = append(payload, raw)
+		}
+		if !hasIref {
+			iref := [][]byte{fullBoxHeader(0, 0)}
+			for _, it := range items {
+				iref = append(iref, cdscBox(0, it.id, primary))
+			}
+			payload = append(payload, makeBox("iref", iref...))
+		}
+		return makeBox("meta", payload...)
+	}
+
+	newMeta := buildMeta()
+	delta := uint64(len(newMeta) - (metaBox.end - metaBox.start))
+	for i := range iloc.items[:len(iloc.items)-len(items)] {
+		it := &iloc.items[i]
+		if it.constructionMethod != 0 || it.dataRefIndex != 0 {
+			continue
+		}
+		for j := range it.extents {
+			if it.baseOffset+it.extents[j].offset >= uint64(metaBox.end) {
+				it.extents[j].offset += delta
+			}
+		}
+	}
+	offset := uint64(len(data)) + delta + 8
+	var mdat [][]byte
+	for i, it := range items {
+		added[i].extents[0].offset = offset
+		offset += uint64(len(it.data))
+		mdat = append(mdat, it.data)
+	}
+	newMeta = buildMeta()
+	if uint64(len(newMeta)-(metaBox.end-metaBox.start)) != delta {
+		// Offsets crossed the 32-bit boundary and widened the iloc box
+		return nil, fmt.Errorf("%w: file too large to add metadata", errBMFF)
+	}
+
+	out := make([]byte, 0, offset)
+	out = append(out, data[:metaBox.start]...)
+	out = append(out, newMeta...)
+	out = append(out, data[metaBox.end:]...)
+	// A last box sized "to end of file" needs an explicit size now
+	if last := top[len(top)-1]; binary.BigEndian.Uint32(data[last.start:]) == 0 && last.start >= metaBox.end {
+		size := last.end - last.start
+		if size > 0xFFFFFFFF {
+			return nil, fmt.Errorf("%w: file too large to add metadata", errBMFF)
+		}
+		binary.BigEndian.PutUint32(out[last.start+int(delta):], uint32(size))
+	}
+	out = append(out, makeBox("mdat", mdat...)...)
+	return out, nil
+}
+
+// appendInfe returns the iinf box with infe entries for the new items
+// added and entries of the replaced items removed.
+func appendInfe(iinf bmffBox, items []newItem, drop map[uint32]bool) []byte {
+	version := iinf.data[0]
+	countSize := 2
+	if version > 0 {
+		countSize = 4
+	}
+	hdrLen := 4 + countSize
+	entries, _ := parseBoxes(iinf.data[hdrLen:])
+
+	var body [][]byte
+	for _, e := range entries {
+		raw := iinf.data[hdrLen:][e.start:e.end]
+		if e.typ == "infe" && len(e.data) >= 6 {
+			v, idSize := e.data[0], 2
+			if v == 3 {
+				idSize = 4
+			}
+			r := &bmffReader{buf: e.data, off: 4}
+			if v >= 2 && drop[uint32(r.uint(idSize))] {
+				continue
+			}
+		}
+		body = append(body, raw)
+	}
+
+	for _, it := range items {
+		infeVersion, idSize := byte(2), 2
+		if it.id > 0xFFFF {
+			infeVersion, idSize = 3, 4
+		}
+		infe := fullBoxHeader(infeVersion, 0)
+		infe = putUint(infe, idSize, uint64(it.id))
+		infe = append(infe, 0, 0)      // item_protection_index
+		infe = append(infe, it.typ...) // item_type
+		infe = append(infe, 0)         // item_name
+		if it.typ == "mime" {
+			infe = append(append(infe, it.contentType...), 0)
+		}
+		body = append(body, makeBox("infe", infe))
+	}
+
+	head := append([]byte(nil), iinf.data[:4]...)
+	head = putUint(head, countSize, uint64(len(body)))
+	return makeBox("iinf", append([][]byte{head}, body...)...)
+}
+
+// cdscBox is a "content describes" reference from one item to another.
+func cdscBox(version byte, from, to uint32) []byte {
+	size := 2
+	if version > 0 {
+		size = 4
+	}
+	var b []byte
+	b = putUint(b, size, uint64(from))
+	b = putUint(b, 2, 1)
+	b = putUint(b, size, uint64(to))
+	return makeBox("cdsc", b)
+}
+
+// appendCdsc returns the iref box with cdsc references from the new
+// items to the primary image added and references from the replaced
+// items removed.
+func appendCdsc(iref bmffBox, items []newItem, to uint32, drop map[uint32]bool) []byte {
+	version := iref.data[0]
+	idSize := 2
+	if version > 0 {
+		idSize = 4
+	}
+	payload := [][]byte{iref.data[:4]}
+	refs, _ := parseBoxes(iref.data[4:])
+	for _, ref := range refs {
+		r := &bmffReader{buf: ref.data}
+		if drop[uint32(r.uint(idSize))] {
+			continue
+		}
+		payload = append(payload, iref.data[4:][ref.start:ref.end])
+	}
+	for _, it := range items {
+		payload = append(payload, cdscBox(version, it.id, to))
+	}
+	return makeBox("iref", payload...)
+}
+
+// extractFromBMFF returns the data of the "Exif" item and the XMP "mime"
+// item of a HEIF/AVIF file, following their iloc entries (file offsets or
+// the idat box).
+func extractFromBMFF(data []byte) (*Metadata, error) {
+	top, err := parseBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	var children []bmffBox
+	for _, b := range top {
+		if b.typ == "meta" && len(b.data) >= 4 {
+			if children, err = parseBoxes(b.data[4:]); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	var iloc *ilocBox
+	var types map[uint32]itemInfo
+	var idat []byte
+	for _, c := range children {
+		switch c.typ {
+		case "iloc":
+			iloc, err = parseIloc(c.data)
+		case "iinf":
+			types, err = parseItemTypes(c.data)
+		case "idat":
+			idat = c.data
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if iloc == nil || types == nil {
+		return nil, fmt.Errorf("no EXIF or XMP item found in ISO-BMFF file")
+	}
+
+	var exif, xmp []byte
+	for _, it := range iloc.items {
+		info := types[it.id]
+		switch {
+		case info.typ == "Exif" && exif == nil:
+			payload, err := itemData(data, idat, it)
+			if err != nil {
+				return nil, err
+			}
+			// The payload starts with the offset of the TIFF header
+			if len(payload) < 4 {
+				return nil, fmt.Errorf("%w: EXIF item too small", errBMFF)
+			}
+			skip := uint64(binary.BigEndian.Uint32(payload)) + 4
+			if skip >= uint64(len(payload)) {
+				return nil, fmt.Errorf("%w: EXIF item header offset out of range", errBMFF)
+			}
+			if exif, err = wrapTIFF(payload[skip:]); err != nil {
+				return nil, err
+			}
+		case info.isXMP() && xmp == nil:
+			if xmp, err = itemData(data, idat, it); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found("ISO-BMFF file", &Metadata{EXIFRaw: exif, XMP: xmp})
+}
+
+// itemData concatenates the extents of an item stored in the file
+// (construction method 0) or in the idat box (method 1).
+func itemData(file, idat []byte, it ilocItem) ([]byte, error) {
+	var src []byte
+	switch {
+	case it.constructionMethod == 0 && it.dataRefIndex == 0:
+		src = file
+	case it.constructionMethod == 1:
+		src = idat
+	default:
+		return nil, fmt.Errorf("%w: unsupported item construction method %d", errBMFF, it.constructionMethod)
+	}
+	var out []byte
+	for _, e := range it.extents {
+		start := it.baseOffset + e.offset
+		end := start + e.length
+		if e.length == 0 {
+			end = uint64(len(src))
+		}
+		if start > end || end > uint64(len(src)) {
+			return nil, fmt.Errorf("%w: item %d extent out of range", errBMFF, it.id)
+		}
+		out = append(out, src[start:end]...)
+	}
+	return out, nil
+}
@@ -0,0 +1,474 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// TIFF field types.
+const (
+	TypeByte      = 1
+	TypeASCII     = 2
+	TypeShort     = 3
+	TypeLong      = 4
+	TypeRational  = 5
+	TypeSByte     = 6
+	TypeUndefined = 7
+	TypeSShort    = 8
+	TypeSLong     = 9
+	TypeSRational = 10
+	TypeFloat     = 11
+	TypeDouble    = 12
+	TypeIFD       = 13
+)
+
+// typeSizes gives the byte size of one value of each TIFF type.
+var typeSizes = map[uint16]int{
+	TypeByte: 1, TypeASCII: 1, TypeShort: 2, TypeLong: 4, TypeRational: 8,
+	TypeSByte: 1, TypeUndefined: 1, TypeSShort: 2, TypeSLong: 4, TypeSRational: 8,
+	TypeFloat: 4, TypeDouble: 8, TypeIFD: 4,
+}
+
+var typeNames = map[uint16]string{
+	TypeByte: "BYTE", TypeASCII: "ASCII", TypeShort: "SHORT", TypeLong: "LONG", TypeRational: "RATIONAL",
+	TypeSByte: "SBYTE", TypeUndefined: "UNDEFINED", TypeSShort: "SSHORT", TypeSLong: "SLONG",
+	TypeSRational: "SRATIONAL", TypeFloat: "FLOAT", TypeDouble: "DOUBLE", TypeIFD: "IFD",
+}
+
+// Pointer tags linking IFDs. They are resolved while parsing and are not
+// kept in the tag lists.
+const (
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+	tagInteropIFD  = 0xA005
+	tagThumbOffset = 0x0201
+	tagThumbLength = 0x0202
+)
+
+// maxIFDEntries bounds the entry count of a single IFD to reject garbage.
+const maxIFDEntries = 1024
+
+// tiffMagics are the header magic numbers accepted after the byte order:
+// standard TIFF, Olympus ORF ("RO", "RS") and Panasonic RW2 (0x55). The
+// raw formats otherwise share the TIFF structure.
+var tiffMagics = map[uint16]bool{42: true, 0x4F52: true, 0x5352: true, 0x55: true}
+
+// ErrInvalidEXIF is returned for EXIF data that is not a valid TIFF
+// structure.
+var ErrInvalidEXIF = errors.New("invalid EXIF data")
+
+// IFD names used in Field.IFD.
+const (
+	IFD0       = "IFD0"
+	IFDExif    = "Exif"
+	IFDGPS     = "GPS"
+	IFDInterop = "Interop"
+	IFD1       = "IFD1"
+)
+
+// EXIF is a parsed EXIF block: the tags of the primary image directory
+// (IFD0), its Exif, GPS and Interoperability sub-directories, and the
+// thumbnail directory (IFD1) with its JPEG thumbnail.
+type EXIF struct {
+	ByteOrder binary.ByteOrder
+	IFD0      []Tag
+	Exif      []Tag
+	GPS       []Tag
+	Interop   []Tag
+	IFD1      []Tag
+	Thumbnail []byte
+}
+
+// Tag is a single EXIF entry. Data holds the value bytes in the EXIF byte
+// order.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+
+	order binary.ByteOrder
+}
+
+// EXIF parses the raw EXIF data.
+func (m *Metadata) EXIF() (*EXIF, error) {
+	if !m.HasEXIF() {
+		return nil, ErrInvalidEXIF
+	}
+	return ParseEXIF(m.EXIFRaw)
+}
+
+// ParseEXIF parses an EXIF block, with or without the "Exif\0\0" prefix,
+// or the IFDs of a TIFF-based image file.
+// Damaged sub-directories are skipped; only an unreadable TIFF header or
+// IFD0 is an error.
+func ParseEXIF(raw []byte) (*EXIF, error) {
+	raw = trimEXIFPrefix(raw)
+	if len(raw) < 8 {
+		return nil, ErrInvalidEXIF
+	}
+	e := &EXIF{}
+	switch string(raw[:2]) {
+	case "II":
+		e.ByteOrder = binary.LittleEndian
+	case "MM":
+		e.ByteOrder = binary.BigEndian
+	default:
+		return nil, ErrInvalidEXIF
+	}
+	if !tiffMagics[e.ByteOrder.Uint16(raw[2:4])] {
+		return nil, ErrInvalidEXIF
+	}
+
+	p := &ifdParser{tiff: raw, order: e.ByteOrder, seen: make(map[uint32]bool)}
+	ifd0, next, err := p.parse(e.ByteOrder.Uint32(raw[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	var exifOff, gpsOff, interopOff uint32
+	e.IFD0, exifOff, gpsOff, _ = splitPointers(ifd0)
+	if exifOff != 0 {
+		if tags, _, err := p.parse(exifOff); err == nil {
+			e.Exif, _, _, interopOff = splitPointers(tags)
+		}
+	}
+	if gpsOff != 0 {
+		if tags, _, err := p.parse(gpsOff); err == nil {
+			e.GPS = tags
+		}
+	}
+	if interopOff != 0 {
+		if tags, _, err := p.parse(interopOff); err == nil {
+			e.Interop = tags
+		}
+	}
+	if next != 0 {
+		if tags, _, err := p.parse(next); err == nil {
+			e.IFD1, e.Thumbnail = p.splitThumbnail(tags)
+		}
+	}
+	return e, nil
+}
+
+// trimEXIFPrefix strips the "Exif\0\0" APP1 identifier if present.
+func trimEXIFPrefix(raw []byte) []byte {
+	if len(raw) > 6 && string(raw[:6]) == "Exif\x00\x00" {
+		return raw[6:]
+	}
+	return raw
+}
+
+// splitPointers removes the sub-IFD pointer tags from tags and returns
+// their offsets.
+func splitPointers(tags []Tag) (rest []Tag, exif, gps, interop uint32) {
+	for _, t := range tags {
+		v, _ := t.Uint(0)
+		switch t.ID {
+		case tagExifIFD:
+			exif = uint32(v)
+		case tagGPSIFD:
+			gps = uint32(v)
+		case tagInteropIFD:
+			interop = uint32(v)
+		default:
+			rest = append(rest, t)
+		}
+	}
+	return rest, exif, gps, interop
+}
+
+type ifdParser struct {
+	tiff  []byte
+	order binary.ByteOrder
+	seen  map[uint32]bool
+}
+
+// parse reads the IFD at off and returns its tags and the offset of the
+// next IFD in the chain.
+func (p *ifdParser) parse(off uint32) ([]Tag, uint32, error) {
+	if p.seen[off] {
+		return nil, 0, fmt.Errorf("%w: IFD loop at offset %d", ErrInvalidEXIF, off)
+	}
+	p.seen[off] = true
+
+	start := int(off)
+	if off < 8 || start+2 > len(p.tiff) {
+		return nil, 0, fmt.Errorf("%w: IFD offset %d out of range", ErrInvalidEXIF, off)
+	}
+	n := int(p.order.Uint16(p.tiff[start:]))
+	if n > maxIFDEntries || start+2+n*12 > len(p.tiff) {
+		return nil, 0, fmt.Errorf("%w: IFD at offset %d is truncated", ErrInvalidEXIF, off)
+	}
+
+	tags := make([]Tag, 0, n)
+	for i := 0; i < n; i++ {
+		entry := p.tiff[start+2+i*12:]
+		t := Tag{
+			ID:    p.order.Uint16(entry[0:]),
+			Type:  p.order.Uint16(entry[2:]),
+			Count: p.order.Uint32(entry[4:]),
+			order: p.order,
+		}
+		size, ok := typeSizes[t.Type]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(t.Count)
+		if total <= 4 {
+			t.Data = append([]byte(nil), entry[8:8+total]...)
+		} else {
+			valOff := uint64(p.order.Uint32(entry[8:]))
+			if valOff+total > uint64(len(p.tiff)) {
+				continue
+			}
+			t.Data = append([]byte(nil), p.tiff[valOff:valOff+total]...)
+		}
+		tags = append(tags, t)
+	}
+
+	var next uint32
+	if end := start + 2 + n*12; end+4 <= len(p.tiff) {
+		next = p.order.Uint32(p.tiff[end:])
+	}
+	return tags, next, nil
+}
+
+// splitThumbnail extracts the JPEG thumbnail referenced by IFD1 and
+// removes its offset and length tags.
+func (p *ifdParser) splitThumbnail(tags []Tag) ([]Tag, []byte) {
+	var off, length int64 = -1, -1
+	var rest []Tag
+	for _, t := range tags {
+		switch t.ID {
+		case tagThumbOffset:
+			off, _ = t.Uint(0)
+		case tagThumbLength:
+			length, _ = t.Uint(0)
+		default:
+			rest = append(rest, t)
+		}
+	}
+	if off <= 0 || length <= 0 || off+length > int64(len(p.tiff)) {
+		return rest, nil
+	}
+	return rest, append([]byte(nil), p.tiff[off:off+length]...)
+}
+
+// Name returns the tag's EXIF name in the given IFD, or a hex ID if the
+// tag is not known.
+func (t Tag) Name(ifd string) string {
+	if name, ok := tagNames[ifd][t.ID]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", t.ID)
+}
+
+// count returns the number of complete values in Data.
+func (t Tag) count() int {
+	size := typeSizes[t.Type]
+	if size == 0 {
+		return 0
+	}
+	return min(int(t.Count), len(t.Data)/size)
+}
+
+// String returns the value of an ASCII tag, or of an UNDEFINED tag holding
+// text, with trailing NULs and spaces removed.
+func (t Tag) String() string {
+	if t.Type != TypeASCII && t.Type != TypeUndefined && t.Type != TypeByte {
+		return ""
+	}
+	s := string(t.Data)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// Uint returns the i-th value of an integer tag.
+func (t Tag) Uint(i int) (int64, bool) {
+	if i >= t.count() {
+		return 0, false
+	}
+	switch t.Type {
+	case TypeByte, TypeUndefined:
+		return int64(t.Data[i]), true
+	case TypeSByte:
+		return int64(int8(t.Data[i])), true
+	case TypeShort:
+		return int64(t.order.Uint16(t.Data[2*i:])), true
+	case TypeSShort:
+		return int64(int16(t.order.Uint16(t.Data[2*i:]))), true
+	case TypeLong, TypeIFD:
+		return int64(t.order.Uint32(t.Data[4*i:])), true
+	case TypeSLong:
+		return int64(int32(t.order.Uint32(t.Data[4*i:]))), true
+	}
+	return 0, false
+}
+
+// Rational returns the i-th numerator and denominator of a rational tag.
+func (t Tag) Rational(i int) (num, den int64, ok bool) {
+	if i >= t.count() {
+		return 0, 0, false
+	}
+	switch t.Type {
+	case TypeRational:
+		return int64(t.order.Uint32(t.Data[8*i:])), int64(t.order.Uint32(t.Data[8*i+4:])), true
+	case TypeSRational:
+		return int64(int32(t.order.Uint32(t.Data[8*i:]))), int64(int32(t.order.Uint32(t.Data[8*i+4:]))), true
+	}
+	return 0, 0, false
+}
+
+// Float returns the i-th value of a numeric tag as a float64.
+func (t Tag) Float(i int) (float64, bool) {
+	switch t.Type {
+	case TypeRational, TypeSRational:
+		num, den, ok := t.Rational(i)
+		if !ok || den == 0 {
+			return 0, false
+		}
+		return float64(num) / float64(den), true
+	case TypeFloat:
+		if i >= t.count() {
+			return 0, false
+		}
+		return float64(math.Float32frombits(t.order.Uint32(t.Data[4*i:]))), true
+	case TypeDouble:
+		if i >= t.count() {
+			return 0, false
+		}
+		return math.Float64frombits(t.order.Uint64(t.Data[8*i:])), true
+	}
+	v, ok := t.Uint(i)
+	return float64(v), ok
+}
+
+// maxFieldBytes is the largest binary value rendered in full by Value.
+const maxFieldBytes = 64
+
+// Value returns the tag's value as a JSON-friendly Go value: a string for
+// ASCII tags, a number or slice of numbers for numeric tags, rationals as
+// "num/den" strings, and short binary values as byte slices.
+func (t Tag) Value() any {
+	n := t.count()
+	switch t.Type {
+	case TypeASCII:
+		return t.String()
+	case TypeUndefined, TypeByte, TypeSByte:
+		if t.Type == TypeUndefined && isPrintable(t.Data) {
+			return t.String()
+		}
+		if len(t.Data) > maxFieldBytes {
+			return fmt.Sprintf("(%d bytes)", len(t.Data))
+		}
+		if n == 1 {
+			v, _ := t.Uint(0)
+			return v
+		}
+		vals := make([]int64, n)
+		for i := range vals {
+			vals[i], _ = t.Uint(i)
+		}
+		return vals
+	case TypeRational, TypeSRational:
+		vals := make([]string, n)
+		for i := range vals {
+			num, den, _ := t.Rational(i)
+			vals[i] = fmt.Sprintf("%d/%d", num, den)
+		}
+		if n == 1 {
+			return vals[0]
+		}
+		return vals
+	case TypeFloat, TypeDouble:
+		vals := make([]float64, n)
+		for i := range vals {
+			vals[i], _ = t.Float(i)
+		}
+		if n == 1 {
+			return vals[0]
+		}
+		return vals
+	}
+	vals := make([]int64, n)
+	for i := range vals {
+		vals[i], _ = t.Uint(i)
+	}
+	if n == 1 {
+		return vals[0]
+	}
+	return vals
+}
+
+func isPrintable(b []byte) bool {
+	b = bytes.TrimRight(b, "\x00 ")
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < 0x20 || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// Field is a decoded tag for display and JSON output.
+type Field struct {
+	IFD   string `json:"ifd"`
+	ID    uint16 `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// Fields returns every tag of every directory in file order: IFD0, Exif,
+// GPS, Interop, IFD1.
+func (e *EXIF) Fields() []Field {
+	var fields []Field
+	for _, dir := range e.directories() {
+		for _, t := range dir.tags {
+			fields = append(fields, Field{
+				IFD:   dir.name,
+				ID:    t.ID,
+				Name:  t.Name(dir.name),
+				Type:  typeNames[t.Type],
+				Value: t.Value(),
+			})
+		}
+	}
+	return fields
+}
+
+type directory struct {
+	name string
+	tags []Tag
+}
+
+func (e *EXIF) directories() []directory {
+	return []directory{
+		{IFD0, e.IFD0}, {IFDExif, e.Exif}, {IFDGPS, e.GPS}, {IFDInterop, e.Interop}, {IFD1, e.IFD1},
+	}
+}
+
+// Lookup returns the tag with the given ID from the named IFD.
+func (e *EXIF) Lookup(ifd string, id uint16) (Tag, bool) {
+	for _, dir := range e.directories() {
+		if dir.name != ifd {
+			continue
+		}
+		for _, t := range dir.tags {
+			if t.ID == id {
+				return t, true
+			}
+		}
+	}
+	return Tag{}, false
+}
@@ -0,0 +1,215 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// testEntry is an IFD entry for buildEXIFBlob. value holds the raw value
+// bytes in the blob's byte order.
+type testEntry struct {
+	id    uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiEntry(id uint16, s string) testEntry {
+	return testEntry{id, TypeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func shortEntry(bo binary.ByteOrder, id uint16, v uint16) testEntry {
+	b := make([]byte, 2)
+	bo.PutUint16(b, v)
+	return testEntry{id, TypeShort, 1, b}
+}
+
+func rationalEntry(bo binary.ByteOrder, id uint16, vals ...uint32) testEntry {
+	b := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		bo.PutUint32(b[4*i:], v)
+	}
+	return testEntry{id, TypeRational, uint32(len(vals) / 2), b}
+}
+
+// buildEXIFBlob lays out IFD0 with optional Exif and GPS sub-IFDs.
+func buildEXIFBlob(bo binary.ByteOrder, ifd0, exif, gps []testEntry) []byte {
+	buf := []byte("Exif\x00\x00")
+	tiff := make([]byte, 8)
+	if bo == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	bo.PutUint16(tiff[2:], 42)
+	bo.PutUint32(tiff[4:], 8)
+
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+	if exif != nil {
+		ifd0 = append(ifd0, testEntry{tagExifIFD, TypeLong, 1, make([]byte, 4)})
+	}
+	if gps != nil {
+		ifd0 = append(ifd0, testEntry{tagGPSIFD, TypeLong, 1, make([]byte, 4)})
+	}
+
+	// Directories are written back to back, each followed by its data.
+	var write func(entries []testEntry) int
+	write = func(entries []testEntry) int {
+		start := len(tiff)
+		dataOff := start + ifdSize(len(entries))
+		dir := make([]byte, ifdSize(len(entries)))
+		bo.PutUint16(dir, uint16(len(entries)))
+		var data []byte
+		for i, e := range entries {
+			ent := dir[2+12*i:]
+			bo.PutUint16(ent[0:], e.id)
+			bo.PutUint16(ent[2:], e.typ)
+			bo.PutUint32(ent[4:], e.count)
+			if len(e.value) <= 4 {
+				copy(ent[8:], e.value)
+			} else {
+				bo.PutUint32(ent[8:], uint32(dataOff+len(data)))
+				data = append(data, e.value...)
+			}
+		}
+		tiff = append(tiff, dir...)
+		tiff = append(tiff, data...)
+		return start
+	}
+
+	ifd0Off := write(ifd0)
+	patch := func(id uint16, off int) {
+		n := int(bo.Uint16(tiff[ifd0Off:]))
+		for i := 0; i < n; i++ {
+			ent := tiff[ifd0Off+2+12*i:]
+			if bo.Uint16(ent) == id {
+				bo.PutUint32(ent[8:], uint32(off))
+			}
+		}
+	}
+	if exif != nil {
+		patch(tagExifIFD, write(exif))
+	}
+	if gps != nil {
+		patch(tagGPSIFD, write(gps))
+	}
+	return append(buf, tiff...)
+}
+
+func sampleEXIF(bo binary.ByteOrder) []byte {
+	return buildEXIFBlob(bo,
+		[]testEntry{
+			asciiEntry(TagMake, "Canon"),
+			asciiEntry(TagModel, "EOS R5"),
+			shortEntry(bo, TagOrientation, 6),
+			asciiEntry(TagArtist, "Jane Doe"),
+			asciiEntry(TagCopyright, "(c) Jane Doe"),
+		},
+		[]testEntry{
+			rationalEntry(bo, TagExposureTime, 1, 250),
+			rationalEntry(bo, TagFNumber, 28, 10),
+			shortEntry(bo, TagISO, 400),
+			asciiEntry(TagDateTimeOriginal, "2024:05:01 10:30:00"),
+			asciiEntry(TagOffsetTimeOriginal, "+02:00"),
+			rationalEntry(bo, TagFocalLength, 50, 1),
+			asciiEntry(TagBodySerialNumber, "012345"),
+			asciiEntry(TagLensModel, "RF50mm F1.8 STM"),
+		},
+		[]testEntry{
+			asciiEntry(TagGPSLatitudeRef, "N"),
+			rationalEntry(bo, TagGPSLatitude, 48, 1, 51, 1, 30, 1),
+			asciiEntry(TagGPSLongitudeRef, "W"),
+			rationalEntry(bo, TagGPSLongitude, 2, 1, 17, 1, 24, 1),
+			{TagGPSAltitudeRef, TypeByte, 1, []byte{0}},
+			rationalEntry(bo, TagGPSAltitude, 355, 10),
+		},
+	)
+}
+
+func TestParseEXIF_Summary(t *testing.T) {
+	for name, bo := range map[string]binary.ByteOrder{"LE": binary.LittleEndian, "BE": binary.BigEndian} {
+		e, err := ParseEXIF(sampleEXIF(bo))
+		if err != nil {
+			t.Fatalf("%s: ParseEXIF: %v", name, err)
+		}
+		s := e.Summary()
+		if s.Make != "Canon" || s.Model != "EOS R5" || s.LensModel != "RF50mm F1.8 STM" {
+			t.Errorf("%s: camera = %q %q %q", name, s.Make, s.Model, s.LensModel)
+		}
+		if s.Orientation != 6 || s.ISO != 400 || s.FNumber != 2.8 || s.FocalLength != 50 {
+			t.Errorf("%s: orientation %d iso %d f/%g %gmm", name, s.Orientation, s.ISO, s.FNumber, s.FocalLength)
+		}
+		if s.ExposureTime != "1/250" {
+			t.Errorf("%s: exposure = %q, want 1/250", name, s.ExposureTime)
+		}
+		if s.Artist != "Jane Doe" || s.Copyright != "(c) Jane Doe" || s.BodySerialNumber != "012345" {
+			t.Errorf("%s: artist %q copyright %q serial %q", name, s.Artist, s.Copyright, s.BodySerialNumber)
+		}
+		want := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+		if s.DateTimeOriginal == nil || !s.DateTimeOriginal.Equal(want) {
+			t.Errorf("%s: capture time = %v, want %v", name, s.DateTimeOriginal, want)
+		}
+		if s.GPS == nil {
+			t.Fatalf("%s: GPS missing", name)
+		}
+		if s.GPS.Latitude < 48.858 || s.GPS.Latitude > 48.859 || s.GPS.Longitude > -2.29 || s.GPS.Longitude < -2.291 {
+			t.Errorf("%s: position = %f,%f", name, s.GPS.Latitude, s.GPS.Longitude)
+		}
+		if s.GPS.Altitude == nil || *s.GPS.Altitude != 35.5 {
+			t.Errorf("%s: altitude = %v", name, s.GPS.Altitude)
+		}
+	}
+}
+
+func TestParseEXIF_Fields(t *testing.T) {
+	e, err := ParseEXIF(sampleEXIF(binary.LittleEndian))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := e.Fields()
+	if len(fields) != 5+8+6 {
+		t.Fatalf("got %d fields, want 19 (pointer tags must be dropped)", len(fields))
+	}
+	byName := make(map[string]Field)
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+	if f := byName["ExposureTime"]; f.IFD != IFDExif || f.Value != "1/250" || f.Type != "RATIONAL" {
+		t.Errorf("ExposureTime field = %+v", f)
+	}
+	if f := byName["GPSLatitude"]; f.IFD != IFDGPS {
+		t.Errorf("GPSLatitude field = %+v", f)
+	}
+	if f := byName["Orientation"]; f.Value != int64(6) {
+		t.Errorf("Orientation value = %#v", f.Value)
+	}
+}
+
+func TestParseEXIF_Invalid(t *testing.T) {
+	for name, raw := range map[string][]byte{
+		"empty":     nil,
+		"bad order": []byte("XX\x2a\x00\x08\x00\x00\x00"),
+		"bad magic": []byte("II\x2b\x00\x08\x00\x00\x00"),
+		"bad ifd":   []byte("II\x2a\x00\xff\x00\x00\x00"),
+	} {
+		if _, err := ParseEXIF(raw); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseEXIF_IFDLoop(t *testing.T) {
+	bo := binary.LittleEndian
+	raw := buildEXIFBlob(bo, []testEntry{shortEntry(bo, TagOrientation, 1)}, nil, nil)
+	// Point IFD0's next-IFD offset back at itself.
+	tiff := raw[6:]
+	bo.PutUint32(tiff[8+2+12:], 8)
+	e, err := ParseEXIF(raw)
+	if err != nil {
+		t.Fatalf("ParseEXIF: %v", err)
+	}
+	if len(e.IFD1) != 0 {
+		t.Error("looping IFD chain should not produce IFD1 tags")
+	}
+}
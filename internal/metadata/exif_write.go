@@ -0,0 +1,151 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// Bytes serializes the EXIF block as "Exif\0\0" followed by a TIFF
+// structure in e.ByteOrder. Directories are written in the order IFD0,
+// Exif, Interop, GPS, IFD1, each followed by its out-of-line values, and
+// the sub-IFD and thumbnail pointers are recomputed. Empty directories
+// are omitted.
+//
+// Values are relocated, so maker notes that use absolute offsets may no
+// longer be readable by vendor tools.
+func (e *EXIF) Bytes() []byte {
+	w := &ifdWriter{order: e.ByteOrder, buf: make([]byte, 8)}
+	if e.ByteOrder == binary.BigEndian {
+		copy(w.buf, "MM")
+	} else {
+		copy(w.buf, "II")
+	}
+	e.ByteOrder.PutUint16(w.buf[2:], 42)
+	ifd0, _ := w.writeTree(e)
+	w.patch(4, ifd0)
+	return append([]byte("Exif\x00\x00"), w.buf...)
+}
+
+// writeTree appends IFD0 with its Exif, Interop and GPS directories, then
+// IFD1 and the thumbnail. It returns the offset of IFD0 and the position
+// of its next-IFD field, which links to IFD1 if there is one.
+func (w *ifdWriter) writeTree(e *EXIF) (ifd0Off, next0 int) {
+	hasExif := len(e.Exif) > 0 || len(e.Interop) > 0
+	hasThumb := len(e.Thumbnail) > 0
+
+	ifd0 := e.IFD0
+	if hasExif {
+		ifd0 = append(slices.Clip(ifd0), pointerTag(tagExifIFD))
+	}
+	if len(e.GPS) > 0 {
+		ifd0 = append(slices.Clip(ifd0), pointerTag(tagGPSIFD))
+	}
+	ifd0Off = w.align()
+	ptr0, next0 := w.writeIFD(ifd0)
+
+	if hasExif {
+		exif := e.Exif
+		if len(e.Interop) > 0 {
+			exif = append(slices.Clip(exif), pointerTag(tagInteropIFD))
+		}
+		w.patch(ptr0[tagExifIFD], w.align())
+		ptrExif, _ := w.writeIFD(exif)
+		if len(e.Interop) > 0 {
+			w.patch(ptrExif[tagInteropIFD], w.align())
+			w.writeIFD(e.Interop)
+		}
+	}
+	if len(e.GPS) > 0 {
+		w.patch(ptr0[tagGPSIFD], w.align())
+		w.writeIFD(e.GPS)
+	}
+	if len(e.IFD1) > 0 || hasThumb {
+		ifd1 := e.IFD1
+		if hasThumb {
+			length := pointerTag(tagThumbLength)
+			w.order.PutUint32(length.Data, uint32(len(e.Thumbnail)))
+			ifd1 = append(slices.Clip(ifd1), pointerTag(tagThumbOffset), length)
+		}
+		w.patch(next0, w.align())
+		ptr1, _ := w.writeIFD(ifd1)
+		if hasThumb {
+			w.patch(ptr1[tagThumbOffset], len(w.buf))
+			w.buf = append(w.buf, e.Thumbnail...)
+		}
+	}
+	return ifd0Off, next0
+}
+
+// pointerTag returns a placeholder LONG entry for an offset that is
+// patched once the target is written.
+func pointerTag(id uint16) Tag {
+	return Tag{ID: id, Type: TypeLong, Count: 1, Data: make([]byte, 4)}
+}
+
+type ifdWriter struct {
+	order binary.ByteOrder
+	buf   []byte
+}
+
+// align pads the buffer to a word boundary and returns its length.
+func (w *ifdWriter) align() int {
+	if len(w.buf)%2 == 1 {
+		w.buf = append(w.buf, 0)
+	}
+	return len(w.buf)
+}
+
+func (w *ifdWriter) patch(pos, value int) {
+	w.order.PutUint32(w.buf[pos:], uint32(value))
+}
+
+// writeIFD appends a directory with tags sorted by ID, followed by the
+// values that do not fit in an entry. It returns the position of each
+// entry's value field, for patching pointer tags, and of the next-IFD
+// offset.
+func (w *ifdWriter) writeIFD(tags []Tag) (valuePos map[uint16]int, nextPos int) {
+	tags = slices.Clone(tags)
+	slices.SortStableFunc(tags, func(a, b Tag) int { return int(a.ID) - int(b.ID) })
+
+	start := w.align()
+	dirSize := 2 + 12*len(tags) + 4
+	w.buf = append(w.buf, make([]byte, dirSize)...)
+	w.order.PutUint16(w.buf[start:], uint16(len(tags)))
+
+	valuePos = make(map[uint16]int, len(tags))
+	for i, t := range tags {
+		entry := start + 2 + 12*i
+		w.order.PutUint16(w.buf[entry:], t.ID)
+		w.order.PutUint16(w.buf[entry+2:], t.Type)
+		w.order.PutUint32(w.buf[entry+4:], t.Count)
+		valuePos[t.ID] = entry + 8
+		if len(t.Data) <= 4 {
+			copy(w.buf[entry+8:entry+12], t.Data)
+			continue
+		}
+		off := w.align()
+		w.buf = append(w.buf, t.Data...)
+		w.patch(entry+8, off)
+	}
+	return valuePos, start + 2 + 12*len(tags)
+}
+
+// withOrder returns the tag with its value converted to byte order bo.
+func (t Tag) withOrder(bo binary.ByteOrder) Tag {
+	if t.order == nil || t.order == bo {
+		t.order = bo
+		return t
+	}
+	size := typeSizes[t.Type]
+	if t.Type == TypeRational || t.Type == TypeSRational {
+		size = 4 // numerator and denominator are swapped separately
+	}
+	data := slices.Clone(t.Data)
+	if size > 1 {
+		for i := 0; i+size <= len(data); i += size {
+			slices.Reverse(data[i : i+size])
+		}
+	}
+	t.Data, t.order = data, bo
+	return t
+}
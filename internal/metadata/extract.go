@@ -2,28 +2,38 @@ package metadata
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 
 	"github.com/DanielTso/pixshift/internal/codec"
 )
 
-// Extract reads EXIF metadata from a source image file.
+// Extract reads the EXIF, XMP and IPTC metadata of a source image file.
+// Every format that can carry them is supported; GIF and BMP cannot. IPTC
+// is read from JPEG and TIFF-based files. It is an error if none is
+// present.
 func Extract(r io.ReadSeeker, format codec.Format) (*Metadata, error) {
+	var extract func(data []byte) (*Metadata, error)
 	switch format {
 	case codec.JPEG:
-		return extractFromJPEG(r)
-	case codec.HEIC:
-		return extractFromHEIC(r)
-	case codec.TIFF, codec.CR2, codec.NEF, codec.DNG:
-		return extractFromTIFF(r)
+		extract = extractFromJPEG
+	case codec.PNG:
+		extract = extractFromPNG
+	case codec.WebP:
+		extract = extractFromWebP
+	case codec.HEIC, codec.AVIF:
+		extract = extractFromBMFF
+	case codec.JXL:
+		extract = extractFromJXL
+	case codec.TIFF, codec.CR2, codec.NEF, codec.DNG, codec.ARW, codec.ORF, codec.RW2:
+		extract = extractFromTIFF
+	case codec.RAF:
+		extract = extractFromRAF
 	default:
 		return nil, fmt.Errorf("EXIF extraction not supported for %s", format)
 	}
-}
 
-// extractFromJPEG finds the APP1 EXIF segment in a JPEG file.
-func extractFromJPEG(r io.ReadSeeker) (*Metadata, error) {
 	data, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
@@ -31,113 +41,137 @@ func extractFromJPEG(r io.ReadSeeker) (*Metadata, error) {
 	if _, err := r.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
+	return extract(data)
+}
 
-	exifData := findJPEGExifSegment(data)
-	if exifData == nil {
-		return nil, fmt.Errorf("no EXIF data found in JPEG")
+// found returns the metadata, or an error naming the container if it is
+// empty.
+func found(container string, meta *Metadata) (*Metadata, error) {
+	if meta.IsEmpty() {
+		return nil, fmt.Errorf("no metadata found in %s", container)
 	}
-	return &Metadata{EXIFRaw: exifData}, nil
+	return meta, nil
+}
+
+// APP1 identifiers of EXIF and XMP segments.
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte(nsXMP + "\x00")
+)
+
+// extractFromJPEG reads the APP1 EXIF and XMP segments and the IPTC
+// resource of the APP13 segments of a JPEG file.
+func extractFromJPEG(data []byte) (*Metadata, error) {
+	meta := &Metadata{
+		EXIFRaw: findJPEGAPP1(data, exifHeader),
+		IPTC:    iptcFromIRB(jpegIRB(data)),
+	}
+	if xmp := findJPEGAPP1(data, xmpHeader); xmp != nil {
+		meta.XMP = xmp[len(xmpHeader):]
+	}
+	return found("JPEG", meta)
 }
 
 // findJPEGExifSegment scans JPEG data for an APP1 marker containing EXIF data.
 // Returns the full APP1 payload (including "Exif\0\0" prefix).
 func findJPEGExifSegment(data []byte) []byte {
-	exifHeader := []byte("Exif\x00\x00")
+	return findJPEGAPP1(data, exifHeader)
+}
+
+// findJPEGAPP1 returns the payload of the first APP1 segment starting
+// with header, or nil.
+func findJPEGAPP1(data, header []byte) []byte {
+	if segs := findJPEGSegments(data, 0xE1, header); len(segs) > 0 {
+		return segs[0]
+	}
+	return nil
+}
 
+// findJPEGSegments returns the payloads of the marker segments before the
+// image data that start with header.
+func findJPEGSegments(data []byte, marker byte, header []byte) [][]byte {
 	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
 		return nil
 	}
 
+	var segs [][]byte
 	i := 2
 	for i < len(data)-3 {
 		if data[i] != 0xFF {
 			break
 		}
-		marker := data[i+1]
-
-		// APP1 marker
-		if marker == 0xE1 {
-			segLen := int(data[i+2])<<8 | int(data[i+3])
-			segData := data[i+4 : i+2+segLen]
-			if bytes.HasPrefix(segData, exifHeader) {
-				return segData
-			}
-		}
+		m := data[i+1]
 
 		// SOS marker means we've reached image data
-		if marker == 0xDA {
+		if m == 0xDA {
 			break
 		}
 
-		// Skip to next marker
-		if i+3 < len(data) {
-			segLen := int(data[i+2])<<8 | int(data[i+3])
-			i += 2 + segLen
-		} else {
+		segLen := int(data[i+2])<<8 | int(data[i+3])
+		if segLen < 2 || i+2+segLen > len(data) {
 			break
 		}
-	}
-	return nil
-}
-
-// extractFromHEIC extracts EXIF from a HEIC file.
-// HEIC stores EXIF in an "Exif" box within the ISO BMFF container.
-func extractFromHEIC(r io.ReadSeeker) (*Metadata, error) {
-	data, err := io.ReadAll(r)
-	if err != nil {
-		return nil, err
-	}
-	if _, err := r.Seek(0, io.SeekStart); err != nil {
-		return nil, err
-	}
 
-	// Search for the "Exif" box in the ISOBMFF structure.
-	// The Exif box contains: 4-byte offset + TIFF header (starting with "MM" or "II").
-	exifMarker := []byte("Exif")
-	idx := bytes.Index(data, exifMarker)
-	if idx < 4 {
-		return nil, fmt.Errorf("no EXIF box found in HEIC")
-	}
-
-	// Read the box size from 4 bytes before the "Exif" marker
-	boxStart := idx - 4
-	boxSize := int(data[boxStart])<<24 | int(data[boxStart+1])<<16 |
-		int(data[boxStart+2])<<8 | int(data[boxStart+3])
+		if m == marker {
+			segData := data[i+4 : i+2+segLen]
+			if bytes.HasPrefix(segData, header) {
+				segs = append(segs, segData)
+			}
+		}
 
-	if boxSize <= 8 || boxStart+boxSize > len(data) {
-		return nil, fmt.Errorf("invalid EXIF box size in HEIC")
+		// Skip to next marker
+		i += 2 + segLen
 	}
+	return segs
+}
 
-	// The EXIF payload starts after the box header (8 bytes) + 4-byte TIFF offset
-	payloadStart := idx + 4 + 4 // "Exif" + 4-byte offset
-	if payloadStart >= boxStart+boxSize {
-		return nil, fmt.Errorf("EXIF payload too small in HEIC")
+// wrapTIFF returns TIFF-structured EXIF data in APP1 form ("Exif\0\0"
+// followed by the TIFF header), accepting data with or without the prefix.
+func wrapTIFF(tiff []byte) ([]byte, error) {
+	tiff = trimEXIFPrefix(tiff)
+	if len(tiff) < 8 || (string(tiff[:2]) != "II" && string(tiff[:2]) != "MM") {
+		return nil, ErrInvalidEXIF
 	}
-
-	exifPayload := data[payloadStart : boxStart+boxSize]
-
-	// Wrap as APP1 segment format: "Exif\0\0" + TIFF data
-	wrapped := append([]byte("Exif\x00\x00"), exifPayload...)
-	return &Metadata{EXIFRaw: wrapped}, nil
+	return append([]byte("Exif\x00\x00"), tiff...), nil
 }
 
-// extractFromTIFF extracts EXIF from TIFF-based files (TIFF, CR2, NEF, DNG).
-// These formats store EXIF in IFD entries within the TIFF structure.
-func extractFromTIFF(r io.ReadSeeker) (*Metadata, error) {
-	data, err := io.ReadAll(r)
+// extractFromTIFF extracts EXIF from TIFF-based files (TIFF, CR2, NEF,
+// DNG, ARW, ORF, RW2). The IFD chain is parsed and rebuilt as a compact
+// standalone EXIF block rather than copying the file, which holds the
+// image data.
+func extractFromTIFF(data []byte) (*Metadata, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("file too small for TIFF")
+	}
+	e, err := ParseEXIF(data)
 	if err != nil {
 		return nil, err
 	}
-	if _, err := r.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+	meta := &Metadata{EXIFRaw: e.standalone().Bytes()}
+	if t, ok := e.Lookup(IFD0, tagXMP); ok {
+		meta.XMP = t.Data
 	}
-
-	if len(data) < 8 {
-		return nil, fmt.Errorf("file too small for TIFF")
+	if t, ok := e.Lookup(IFD0, tagIPTC); ok {
+		meta.IPTC = t.Data
 	}
+	return meta, nil
+}
 
-	// For TIFF-based files, the entire file header IS the EXIF structure.
-	// We wrap it in APP1 format for consistent injection.
-	wrapped := append([]byte("Exif\x00\x00"), data...)
-	return &Metadata{EXIFRaw: wrapped}, nil
+// extractFromRAF reads the metadata of the JPEG preview embedded in a
+// Fujifilm RAF file, whose offset and length are stored at bytes 84-91 of
+// the header.
+func extractFromRAF(data []byte) (*Metadata, error) {
+	if len(data) < 92 || !bytes.HasPrefix(data, []byte("FUJIFILMCCD-RAW")) {
+		return nil, fmt.Errorf("invalid RAF header")
+	}
+	off := uint64(binary.BigEndian.Uint32(data[84:]))
+	length := uint64(binary.BigEndian.Uint32(data[88:]))
+	if off+length > uint64(len(data)) {
+		return nil, fmt.Errorf("RAF JPEG preview out of range")
+	}
+	meta, err := extractFromJPEG(data[off : off+length])
+	if err != nil {
+		return nil, fmt.Errorf("RAF preview: %w", err)
+	}
+	return meta, nil
 }
@@ -0,0 +1,170 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"slices"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"golang.org/x/image/tiff"
+)
+
+// checkExtract runs Extract on data and checks that the result is the
+// EXIF block want.
+func checkExtract(t *testing.T, format codec.Format, data, want []byte) {
+	t.Helper()
+	meta, err := Extract(bytes.NewReader(data), format)
+	if err != nil {
+		t.Fatalf("Extract(%s): %v", format, err)
+	}
+	if !bytes.Equal(meta.TIFF(), trimEXIFPrefix(want)) {
+		t.Errorf("Extract(%s) returned different EXIF data", format)
+	}
+	if string(meta.EXIFRaw[:6]) != "Exif\x00\x00" {
+		t.Errorf("Extract(%s) EXIFRaw lacks the Exif prefix", format)
+	}
+}
+
+func TestExtract_PNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage())
+	exif := sampleEXIF(binary.LittleEndian)
+	out, err := injectIntoPNG(buf.Bytes(), &Metadata{EXIFRaw: exif})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExtract(t, codec.PNG, out, exif)
+
+	if _, err := Extract(bytes.NewReader(buf.Bytes()), codec.PNG); err == nil {
+		t.Error("expected error for PNG without eXIf")
+	}
+}
+
+func TestExtract_WebP(t *testing.T) {
+	exif := sampleEXIF(binary.BigEndian)
+	out, err := injectIntoWebP(writeWebP([]riffChunk{vp8lChunk(8, 8, false)}), &Metadata{EXIFRaw: exif})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExtract(t, codec.WebP, out, exif)
+
+	// Chunk written with the APP1 prefix
+	prefixed := writeWebP([]riffChunk{{fourCC: "VP8X", data: make([]byte, 10)}, {fourCC: "EXIF", data: exif}})
+	checkExtract(t, codec.WebP, prefixed, exif)
+}
+
+func TestExtract_BMFF(t *testing.T) {
+	exif := sampleEXIF(binary.LittleEndian)
+	out, err := injectIntoBMFF(buildHEIF([]byte("Exif is not a box here")), &Metadata{EXIFRaw: exif})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExtract(t, codec.HEIC, out, exif)
+	checkExtract(t, codec.AVIF, out, exif)
+
+	if _, err := Extract(bytes.NewReader(buildHEIF([]byte("Exif"))), codec.HEIC); err == nil {
+		t.Error("expected error for HEIF without an Exif item")
+	}
+}
+
+func TestExtract_JXL(t *testing.T) {
+	exif := sampleEXIF(binary.BigEndian)
+	payload := slices.Concat([]byte{0, 0, 0, 0}, trimEXIFPrefix(exif))
+	container := slices.Concat(jxlSignature,
+		makeBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl ")),
+		makeBox("Exif", payload),
+		makeBox("jxlc", []byte{0xFF, 0x0A}))
+	checkExtract(t, codec.JXL, container, exif)
+
+	if _, err := Extract(bytes.NewReader([]byte{0xFF, 0x0A, 0, 0}), codec.JXL); err == nil {
+		t.Error("expected error for a bare JXL codestream")
+	}
+}
+
+func TestExtract_RAF(t *testing.T) {
+	exif := sampleEXIF(binary.BigEndian)
+	jpegData := buildTestJPEGWithExif(exif)
+	header := make([]byte, 100)
+	copy(header, "FUJIFILMCCD-RAW 0201")
+	binary.BigEndian.PutUint32(header[84:], uint32(len(header)))
+	binary.BigEndian.PutUint32(header[88:], uint32(len(jpegData)))
+	checkExtract(t, codec.RAF, append(header, jpegData...), exif)
+}
+
+func TestExtract_ORFMagic(t *testing.T) {
+	tiff := trimEXIFPrefix(sampleEXIF(binary.LittleEndian))
+	orf := slices.Clone(tiff)
+	copy(orf[2:], "RO")
+	meta, err := Extract(bytes.NewReader(orf), codec.ORF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := meta.EXIF()
+	if err != nil {
+		t.Fatalf("ORF EXIF does not parse: %v", err)
+	}
+	if e.Summary().Make != "Canon" {
+		t.Errorf("Make = %q", e.Summary().Make)
+	}
+}
+
+func TestExtract_TIFFCompact(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	if err := tiff.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	file, err := injectIntoTIFF(buf.Bytes(), &Metadata{EXIFRaw: sampleEXIF(binary.BigEndian)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := Extract(bytes.NewReader(file), codec.TIFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.EXIFRaw) > 2048 {
+		t.Errorf("EXIF block is %d bytes, the image data was copied", len(meta.EXIFRaw))
+	}
+	e, err := meta.EXIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint16{0x0100, 0x0111, 0x0117} {
+		if _, ok := e.Lookup(IFD0, id); ok {
+			t.Errorf("layout tag %#04x was kept", id)
+		}
+	}
+	if s := e.Summary(); s.Make != "Canon" || s.GPS == nil || s.DateTimeOriginal == nil || meta.Orientation() != 6 {
+		t.Errorf("summary = %+v", s)
+	}
+}
+
+func TestReplaceJPEGEXIF_APP1Limit(t *testing.T) {
+	jpegData := buildTestJPEGNoExif()
+	bo := binary.LittleEndian
+
+	big, _ := ParseEXIF(sampleEXIF(bo))
+	big.Thumbnail = make([]byte, 70000)
+	out, err := ReplaceJPEGEXIF(jpegData, &Metadata{EXIFRaw: big.Bytes()})
+	if err != nil {
+		t.Fatalf("oversized thumbnail should be dropped: %v", err)
+	}
+	meta, err := Extract(bytes.NewReader(out), codec.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := meta.EXIF(); e.Thumbnail != nil || e.Summary().Make != "Canon" {
+		t.Error("expected the EXIF without its thumbnail")
+	}
+
+	huge, _ := ParseEXIF(sampleEXIF(bo))
+	huge.Exif = append(huge.Exif, Tag{ID: TagMakerNote, Type: TypeUndefined, Count: 70000, Data: make([]byte, 70000), order: bo})
+	if _, err := ReplaceJPEGEXIF(jpegData, &Metadata{EXIFRaw: huge.Bytes()}); !errors.Is(err, ErrEXIFTooLarge) {
+		t.Errorf("err = %v, want ErrEXIFTooLarge", err)
+	}
+}
@@ -290,7 +290,7 @@ func TestExtractTIFF(t *testing.T) {
 		t.Error("expected TIFF metadata to have EXIF data")
 	}
 
-	// The TIFF extractor wraps the entire file with "Exif\0\0" prefix
+	// The TIFF extractor rebuilds the IFDs as an APP1-style EXIF block
 	if len(meta.EXIFRaw) < 6 {
 		t.Fatalf("EXIFRaw too short: %d bytes", len(meta.EXIFRaw))
 	}
@@ -329,13 +329,13 @@ func TestExtractTIFF_BigEndian(t *testing.T) {
 	}
 }
 
-func TestExtract_UnsupportedFormat(t *testing.T) {
+func TestExtract_TruncatedPNG(t *testing.T) {
 	data := []byte{0x89, 0x50, 0x4E, 0x47} // PNG magic bytes
 
 	r := bytes.NewReader(data)
 	_, err := Extract(r, codec.PNG)
 	if err == nil {
-		t.Error("expected error for unsupported format (PNG), got nil")
+		t.Error("expected error for truncated PNG, got nil")
 	}
 }
 
@@ -349,13 +349,13 @@ func TestExtract_UnsupportedFormat_GIF(t *testing.T) {
 	}
 }
 
-func TestExtract_UnsupportedFormat_WebP(t *testing.T) {
+func TestExtract_TruncatedWebP(t *testing.T) {
 	data := []byte("RIFF....WEBP")
 
 	r := bytes.NewReader(data)
 	_, err := Extract(r, codec.WebP)
 	if err == nil {
-		t.Error("expected error for unsupported format (WebP), got nil")
+		t.Error("expected error for WebP without chunks, got nil")
 	}
 }
 
@@ -425,25 +425,23 @@ func TestExtract_DNG(t *testing.T) {
 }
 
 func TestInject_UnsupportedFormat(t *testing.T) {
-	tmpFile := t.TempDir() + "/test.webp"
+	tmpFile := t.TempDir() + "/test.gif"
 	os.WriteFile(tmpFile, []byte("fake"), 0644)
 
 	meta := &Metadata{EXIFRaw: buildTestEXIF(1, false)}
-	err := Inject(tmpFile, codec.WebP, meta)
+	err := Inject(tmpFile, codec.GIF, meta)
 	if err == nil {
-		t.Error("expected error for unsupported injection format (WebP), got nil")
+		t.Error("expected error for unsupported injection format (GIF), got nil")
 	}
 }
 
-func TestInject_TIFFNoOp(t *testing.T) {
-	// TIFF injection is a no-op (returns nil)
+func TestInject_InvalidTIFF(t *testing.T) {
 	tmpFile := t.TempDir() + "/test.tiff"
 	os.WriteFile(tmpFile, []byte("fake"), 0644)
 
 	meta := &Metadata{EXIFRaw: buildTestEXIF(1, false)}
-	err := Inject(tmpFile, codec.TIFF, meta)
-	if err != nil {
-		t.Errorf("Inject(TIFF) should be a no-op, got error: %v", err)
+	if err := Inject(tmpFile, codec.TIFF, meta); err == nil {
+		t.Error("expected error for invalid TIFF output, got nil")
 	}
 }
 
@@ -0,0 +1,131 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+
+	"github.com/DanielTso/pixshift/internal/resize"
+)
+
+// Tags describing the image and its thumbnail.
+const (
+	tagImageWidth     = 0x0100
+	tagImageLength    = 0x0101
+	tagCompression    = 0x0103
+	tagXResolution    = 0x011A
+	tagYResolution    = 0x011B
+	tagResolutionUnit = 0x0128
+
+	compressionJPEG = 6
+)
+
+// Regenerated EXIF thumbnails fit in thumbnailSize pixels and are encoded
+// at thumbnailQuality.
+const (
+	thumbnailSize    = 160
+	thumbnailQuality = 75
+)
+
+// Geometry describes the output image of a job that reoriented, cropped
+// or resized the pixels.
+type Geometry struct {
+	Width, Height int
+	// Upright is set when the EXIF orientation was applied to the pixels.
+	Upright bool
+	// Thumbnail is the image a new IFD1 thumbnail is made from; nil drops
+	// the thumbnail.
+	Thumbnail image.Image
+}
+
+// WithGeometry returns a copy of m whose EXIF describes the output image:
+// the orientation is reset to 1 if g.Upright, the pixel dimensions are
+// rewritten, and a source thumbnail is regenerated from g.Thumbnail or
+// dropped. Without EXIF m is returned unchanged.
+func (m *Metadata) WithGeometry(g Geometry) (*Metadata, error) {
+	if !m.HasEXIF() {
+		return m, nil
+	}
+	e, err := m.EXIF()
+	if err != nil {
+		return nil, err
+	}
+
+	if g.Upright {
+		e.IFD0 = setUintTag(e.IFD0, e.ByteOrder, TagOrientation, 1, false)
+	}
+	e.IFD0 = setUintTag(e.IFD0, e.ByteOrder, tagImageWidth, g.Width, false)
+	e.IFD0 = setUintTag(e.IFD0, e.ByteOrder, tagImageLength, g.Height, false)
+	if len(e.Exif) > 0 {
+		e.Exif = setUintTag(e.Exif, e.ByteOrder, TagPixelXDimension, g.Width, true)
+		e.Exif = setUintTag(e.Exif, e.ByteOrder, TagPixelYDimension, g.Height, true)
+	}
+
+	if len(e.Thumbnail) > 0 {
+		e.Thumbnail = nil
+		if g.Thumbnail != nil {
+			e.Thumbnail = encodeThumbnail(g.Thumbnail)
+		}
+	}
+	if len(e.Thumbnail) > 0 {
+		e.IFD1 = thumbnailIFD(e.IFD1, e.ByteOrder)
+	} else {
+		e.IFD1 = nil
+	}
+
+	out := *m
+	out.EXIFRaw = e.Bytes()
+	return &out, nil
+}
+
+// setUintTag sets tag id to v, as a SHORT if the existing tag is one and
+// v fits, else as a LONG. A missing tag is added only if add is set.
+func setUintTag(tags []Tag, bo binary.ByteOrder, id uint16, v int, add bool) []Tag {
+	i := -1
+	for j, t := range tags {
+		if t.ID == id {
+			i = j
+			break
+		}
+	}
+	if i < 0 && !add {
+		return tags
+	}
+	t := Tag{ID: id, Type: TypeLong, Count: 1, Data: make([]byte, 4), order: bo}
+	if i >= 0 && tags[i].Type == TypeShort && v <= 0xFFFF {
+		t.Type, t.Data = TypeShort, t.Data[:2]
+		bo.PutUint16(t.Data, uint16(v))
+	} else {
+		bo.PutUint32(t.Data, uint32(v))
+	}
+	if i < 0 {
+		return append(tags, t)
+	}
+	tags[i] = t
+	return tags
+}
+
+// thumbnailIFD returns the IFD1 tags for a regenerated JPEG thumbnail:
+// the source's resolution tags and the compression.
+func thumbnailIFD(ifd1 []Tag, bo binary.ByteOrder) []Tag {
+	var out []Tag
+	for _, t := range ifd1 {
+		switch t.ID {
+		case tagXResolution, tagYResolution, tagResolutionUnit:
+			out = append(out, t)
+		}
+	}
+	return setUintTag(out, bo, tagCompression, compressionJPEG, true)
+}
+
+// encodeThumbnail scales img to thumbnail size and encodes it as a JPEG,
+// or returns nil if encoding fails.
+func encodeThumbnail(img image.Image) []byte {
+	img = resize.Resize(img, resize.ResizeOptions{MaxDim: thumbnailSize, Interpolation: "bilinear"})
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
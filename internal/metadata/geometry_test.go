@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// geometryEXIF returns sample EXIF with pixel dimensions and a thumbnail.
+func geometryEXIF(t *testing.T, bo binary.ByteOrder) *Metadata {
+	t.Helper()
+	e, err := ParseEXIF(sampleEXIF(bo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Exif = setUintTag(e.Exif, bo, TagPixelXDimension, 6000, true)
+	e.Exif = setUintTag(e.Exif, bo, TagPixelYDimension, 4000, true)
+	e.IFD1 = thumbnailIFD([]Tag{{ID: tagXResolution, Type: TypeRational, Count: 1, Data: make([]byte, 8), order: bo}}, bo)
+	e.Thumbnail = []byte{0xFF, 0xD8, 0xFF, 0xD9}
+	return &Metadata{EXIFRaw: e.Bytes(), XMP: []byte("<x/>")}
+}
+
+func uintTag(t *testing.T, e *EXIF, ifd string, id uint16) int64 {
+	t.Helper()
+	tag, ok := e.Lookup(ifd, id)
+	if !ok {
+		t.Fatalf("%s tag %#04x missing", ifd, id)
+	}
+	v, _ := tag.Uint(0)
+	return v
+}
+
+func TestWithGeometry(t *testing.T) {
+	for name, bo := range map[string]binary.ByteOrder{"LE": binary.LittleEndian, "BE": binary.BigEndian} {
+		m := geometryEXIF(t, bo)
+		out, err := m.WithGeometry(Geometry{
+			Width:     300,
+			Height:    450,
+			Upright:   true,
+			Thumbnail: image.NewRGBA(image.Rect(0, 0, 300, 450)),
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(out.XMP) != "<x/>" {
+			t.Errorf("%s: XMP lost", name)
+		}
+		if out.Orientation() != 1 {
+			t.Errorf("%s: orientation = %d, want 1", name, out.Orientation())
+		}
+		e, err := out.EXIF()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h := uintTag(t, e, IFDExif, TagPixelXDimension), uintTag(t, e, IFDExif, TagPixelYDimension); w != 300 || h != 450 {
+			t.Errorf("%s: pixel dimensions = %dx%d", name, w, h)
+		}
+		if e.Summary().Make != "Canon" {
+			t.Errorf("%s: other tags lost", name)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(e.Thumbnail))
+		if err != nil {
+			t.Fatalf("%s: thumbnail: %v", name, err)
+		}
+		if cfg.Width != 106 || cfg.Height != thumbnailSize {
+			t.Errorf("%s: thumbnail = %dx%d", name, cfg.Width, cfg.Height)
+		}
+		if uintTag(t, e, IFD1, tagCompression) != compressionJPEG {
+			t.Errorf("%s: thumbnail compression not JPEG", name)
+		}
+		if _, ok := e.Lookup(IFD1, tagXResolution); !ok {
+			t.Errorf("%s: thumbnail resolution dropped", name)
+		}
+	}
+}
+
+func TestWithGeometry_DropsThumbnail(t *testing.T) {
+	out, err := geometryEXIF(t, binary.LittleEndian).WithGeometry(Geometry{Width: 10, Height: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without Upright the pixels still need the orientation
+	if out.Orientation() != 6 {
+		t.Errorf("orientation = %d, want 6", out.Orientation())
+	}
+	e, _ := out.EXIF()
+	if e.Thumbnail != nil || e.IFD1 != nil {
+		t.Error("thumbnail was not dropped")
+	}
+	if w := uintTag(t, e, IFDExif, TagPixelXDimension); w != 10 {
+		t.Errorf("PixelXDimension = %d", w)
+	}
+
+	m := &Metadata{XMP: []byte("<x/>")}
+	if same, err := m.WithGeometry(Geometry{Width: 1, Height: 1}); err != nil || same != m {
+		t.Error("metadata without EXIF should be returned unchanged")
+	}
+}
@@ -0,0 +1,239 @@
+package metadata
+
+import (
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+// tagICCProfile holds the ICC profile of a TIFF file.
+const tagICCProfile = 0x8773
+
+// iccHeader identifies JPEG APP2 segments carrying an ICC profile.
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+// ErrInvalidICC is returned for data that is not an ICC profile.
+var ErrInvalidICC = errors.New("invalid ICC profile")
+
+// ExtractICC reads the embedded ICC profile of an image file. It returns
+// nil without error if the file has none. JXL profiles are compressed in
+// the codestream and are not read.
+func ExtractICC(r io.ReadSeeker, format codec.Format) ([]byte, error) {
+	var extract func(data []byte) ([]byte, error)
+	switch format {
+	case codec.JPEG:
+		extract = jpegICC
+	case codec.PNG:
+		extract = pngICC
+	case codec.WebP:
+		extract = webpICC
+	case codec.HEIC, codec.AVIF:
+		extract = bmffICC
+	case codec.TIFF, codec.CR2, codec.NEF, codec.DNG, codec.ARW, codec.ORF, codec.RW2:
+		extract = tiffICC
+	default:
+		return nil, fmt.Errorf("ICC extraction not supported for %s", format)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return extract(data)
+}
+
+// jpegICC joins the profile chunks of the APP2 segments, which carry
+// their sequence number and the chunk count after the identifier.
+func jpegICC(data []byte) ([]byte, error) {
+	segs := findJPEGSegments(data, 0xE2, iccHeader)
+	if len(segs) == 0 {
+		return nil, nil
+	}
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	for _, seg := range segs {
+		if len(seg) < len(iccHeader)+2 {
+			return nil, fmt.Errorf("%w: truncated APP2 segment", ErrInvalidICC)
+		}
+		chunks = append(chunks, chunk{seg[len(iccHeader)], seg[len(iccHeader)+2:]})
+	}
+	slices.SortStableFunc(chunks, func(a, b chunk) int { return cmp.Compare(a.seq, b.seq) })
+	var icc []byte
+	for _, c := range chunks {
+		icc = append(icc, c.data...)
+	}
+	return icc, nil
+}
+
+// pngICC inflates the profile of the iCCP chunk: a profile name, a
+// compression method byte and the zlib stream.
+func pngICC(data []byte) ([]byte, error) {
+	chunks, err := parsePNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chunks {
+		if c.typ != "iCCP" {
+			continue
+		}
+		i := bytes.IndexByte(c.data, 0)
+		if i < 0 || i+2 > len(c.data) {
+			return nil, fmt.Errorf("%w: malformed iCCP chunk", ErrInvalidICC)
+		}
+		r, err := zlib.NewReader(bytes.NewReader(c.data[i+2:]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidICC, err)
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return nil, nil
+}
+
+// webpICC returns the data of the ICCP chunk.
+func webpICC(data []byte) ([]byte, error) {
+	chunks, err := parseWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chunks {
+		if c.fourCC == "ICCP" {
+			return c.data, nil
+		}
+	}
+	return nil, nil
+}
+
+// bmffICC returns the first restricted or unrestricted ICC colour
+// property of a HEIF/AVIF file (meta/iprp/ipco/colr).
+func bmffICC(data []byte) ([]byte, error) {
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range []string{"meta", "iprp", "ipco"} {
+		var next []bmffBox
+		for _, b := range boxes {
+			if b.typ != path {
+				continue
+			}
+			payload := b.data
+			if path == "meta" { // full box
+				if len(payload) < 4 {
+					return nil, fmt.Errorf("%w: truncated meta box", errBMFF)
+				}
+				payload = payload[4:]
+			}
+			if next, err = parseBoxes(payload); err != nil {
+				return nil, err
+			}
+			break
+		}
+		boxes = next
+	}
+	for _, b := range boxes {
+		if b.typ == "colr" && len(b.data) > 4 {
+			if typ := string(b.data[:4]); typ == "prof" || typ == "rICC" {
+				return b.data[4:], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// tiffICC returns the profile of IFD0 tag 34675.
+func tiffICC(data []byte) ([]byte, error) {
+	e, err := ParseEXIF(data)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := e.Lookup(IFD0, tagICCProfile); ok {
+		return t.Data, nil
+	}
+	return nil, nil
+}
+
+// ICCSummary describes an ICC profile from its header and description.
+type ICCSummary struct {
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`     // e.g. "4.3"
+	Class       string `json:"class"`       // e.g. "mntr" for display profiles
+	ColorSpace  string `json:"color_space"` // e.g. "RGB", "CMYK", "GRAY"
+	PCS         string `json:"pcs"`         // profile connection space: "XYZ" or "Lab"
+	Size        int    `json:"size"`
+}
+
+// ParseICC reads the header and the profile description tag of an ICC
+// profile.
+func ParseICC(data []byte) (*ICCSummary, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, ErrInvalidICC
+	}
+	s := &ICCSummary{
+		Version:    fmt.Sprintf("%d.%d", data[8], data[9]>>4),
+		Class:      strings.TrimSpace(string(data[12:16])),
+		ColorSpace: strings.TrimSpace(string(data[16:20])),
+		PCS:        strings.TrimSpace(string(data[20:24])),
+		Size:       len(data),
+	}
+	n := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < n && 132+12*i+12 <= len(data); i++ {
+		entry := data[132+12*i:]
+		if string(entry[:4]) != "desc" {
+			continue
+		}
+		off := uint64(binary.BigEndian.Uint32(entry[4:]))
+		size := uint64(binary.BigEndian.Uint32(entry[8:]))
+		if off+size <= uint64(len(data)) {
+			s.Description = iccText(data[off : off+size])
+		}
+		break
+	}
+	return s, nil
+}
+
+// iccText decodes a textDescriptionType (ICC v2) or the first record of a
+// multiLocalizedUnicodeType (ICC v4).
+func iccText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+	switch string(tag[:4]) {
+	case "desc":
+		n := uint64(binary.BigEndian.Uint32(tag[8:]))
+		if n > uint64(len(tag)-12) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		n := uint64(binary.BigEndian.Uint32(tag[20:]))
+		off := uint64(binary.BigEndian.Uint32(tag[24:]))
+		if off+n > uint64(len(tag)) {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[off+uint64(2*i):])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
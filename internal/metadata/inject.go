@@ -2,47 +2,112 @@ package metadata
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 
 	"github.com/DanielTso/pixshift/internal/codec"
 )
 
-// Inject writes EXIF metadata into an output image file.
-// Currently supports JPEG and TIFF outputs.
+// Inject writes EXIF, XMP and IPTC metadata into an output image file,
+// rewriting it in place; see InjectBytes.
 func Inject(outputPath string, format codec.Format, meta *Metadata) error {
-	if !meta.HasEXIF() {
+	if meta.IsEmpty() {
 		return nil
 	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return fmt.Errorf("read output %s: %w", format, err)
+	}
+	out, err := InjectBytes(data, format, meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, out, 0644)
+}
+
+// InjectBytes returns an encoded image with EXIF, XMP and IPTC metadata
+// written into it. JPEG, PNG, WebP, TIFF, AVIF and HEIC are supported. JXL
+// images get their metadata from the encoder (codec.EncodeOptions.EXIF and
+// XMP) instead. Formats other than JPEG and TIFF have no place for IPTC,
+// so its fields are carried over into the XMP packet.
+func InjectBytes(data []byte, format codec.Format, meta *Metadata) ([]byte, error) {
+	if meta.IsEmpty() {
+		return data, nil
+	}
 
+	var inject func(data []byte, meta *Metadata) ([]byte, error)
 	switch format {
 	case codec.JPEG:
-		return injectIntoJPEG(outputPath, meta)
+		inject = ReplaceJPEGMetadata
+	case codec.PNG:
+		inject = injectIntoPNG
+	case codec.WebP:
+		inject = injectIntoWebP
 	case codec.TIFF:
-		// TIFF files already contain EXIF in their IFD structure;
-		// injection would require full TIFF rewrite. Skip for v1.
-		return nil
+		inject = injectIntoTIFF
+	case codec.AVIF, codec.HEIC:
+		inject = injectIntoBMFF
+	case codec.JXL:
+		return nil, fmt.Errorf("metadata for JXL must be passed to the encoder")
 	default:
-		return fmt.Errorf("EXIF injection not supported for %s", format)
+		return nil, fmt.Errorf("metadata injection not supported for %s", format)
+	}
+
+	if format != codec.JPEG && format != codec.TIFF {
+		var err error
+		if meta, err = meta.WithIPTCInXMP(); err != nil {
+			return nil, fmt.Errorf("inject %s: %w", format, err)
+		}
 	}
-}
 
-// injectIntoJPEG inserts an APP1 EXIF segment into a JPEG file.
-func injectIntoJPEG(path string, meta *Metadata) error {
-	data, err := os.ReadFile(path)
+	out, err := inject(data, meta)
 	if err != nil {
-		return fmt.Errorf("read output JPEG: %w", err)
+		return nil, fmt.Errorf("inject %s: %w", format, err)
+	}
+	return out, nil
+}
+
+// ReplaceJPEGMetadata returns the JPEG data with the EXIF, XMP and IPTC
+// segments present in meta replaced. Parts meta does not carry are left as
+// they are.
+func ReplaceJPEGMetadata(data []byte, meta *Metadata) ([]byte, error) {
+	var err error
+	if meta.HasEXIF() {
+		if data, err = ReplaceJPEGEXIF(data, meta); err != nil {
+			return nil, err
+		}
+	}
+	if meta.HasXMP() {
+		if data, err = replaceJPEGXMP(data, meta.XMP); err != nil {
+			return nil, err
+		}
+	}
+	if meta.HasIPTC() {
+		if data, err = replaceJPEGIPTC(data, meta.IPTC); err != nil {
+			return nil, err
+		}
 	}
+	return data, nil
+}
 
+// ReplaceJPEGEXIF returns the JPEG data with its APP1 EXIF segments
+// replaced by meta's EXIF, placed right after SOI.
+func ReplaceJPEGEXIF(data []byte, meta *Metadata) ([]byte, error) {
 	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
-		return fmt.Errorf("output is not a valid JPEG")
+		return nil, fmt.Errorf("output is not a valid JPEG")
 	}
 
 	// Strip any existing APP1 EXIF segment from the output
 	cleaned := stripExistingExif(data)
 
 	// Build new APP1 segment
-	app1 := buildAPP1Segment(meta.EXIFRaw)
+	payload, err := fitAPP1(meta.EXIFRaw)
+	if err != nil {
+		return nil, err
+	}
+	app1 := buildAPP1Segment(payload)
 
 	// Insert APP1 right after SOI marker (FF D8)
 	var buf bytes.Buffer
@@ -50,13 +115,75 @@ func injectIntoJPEG(path string, meta *Metadata) error {
 	buf.Write(app1)         // New APP1 with EXIF
 	buf.Write(cleaned[2:])  // Rest of JPEG
 
-	return os.WriteFile(path, buf.Bytes(), 0644)
+	return buf.Bytes(), nil
+}
+
+// replaceJPEGXMP returns the JPEG data with its APP1 XMP segments replaced
+// by packet, placed after the JFIF and EXIF segments as readers expect.
+func replaceJPEGXMP(data, packet []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("output is not a valid JPEG")
+	}
+	payload := append(slices.Clip(xmpHeader), packet...)
+	if len(payload) > maxAPP1Payload {
+		return nil, fmt.Errorf("%w (%d bytes)", ErrXMPTooLarge, len(payload))
+	}
+
+	cleaned := stripJPEGSegments(data, 0xE1, xmpHeader)
+	i := jpegSegmentsEnd(cleaned, func(marker byte, payload []byte) bool {
+		return marker == 0xE0 || marker == 0xE1 && bytes.HasPrefix(payload, exifHeader)
+	})
+
+	var buf bytes.Buffer
+	buf.Write(cleaned[:i])
+	buf.Write(buildAPP1Segment(payload))
+	buf.Write(cleaned[i:])
+	return buf.Bytes(), nil
+}
+
+// maxAPP1Payload is the largest APP1 payload: the 16-bit segment length
+// counts its own two bytes.
+const maxAPP1Payload = 0xFFFF - 2
+
+// ErrEXIFTooLarge is returned when EXIF data does not fit in a JPEG APP1
+// segment, even without its thumbnail.
+var ErrEXIFTooLarge = errors.New("EXIF data exceeds the 64 KB JPEG APP1 limit")
+
+// ErrXMPTooLarge is returned when an XMP packet does not fit in a JPEG
+// APP1 segment. Extended XMP is not written.
+var ErrXMPTooLarge = errors.New("XMP packet exceeds the 64 KB JPEG APP1 limit")
+
+// fitAPP1 returns the APP1 payload ("Exif\0\0" and the TIFF data) for
+// raw, dropping the thumbnail if the block is too large for one segment.
+func fitAPP1(raw []byte) ([]byte, error) {
+	payload := append([]byte("Exif\x00\x00"), trimEXIFPrefix(raw)...)
+	if len(payload) <= maxAPP1Payload {
+		return payload, nil
+	}
+	if e, err := ParseEXIF(payload); err == nil && (len(e.IFD1) > 0 || len(e.Thumbnail) > 0) {
+		e.IFD1, e.Thumbnail = nil, nil
+		if payload = e.Bytes(); len(payload) <= maxAPP1Payload {
+			return payload, nil
+		}
+	}
+	return nil, fmt.Errorf("%w (%d bytes)", ErrEXIFTooLarge, len(payload))
 }
 
 // stripExistingExif removes any existing APP1 EXIF segments from JPEG data.
 func stripExistingExif(data []byte) []byte {
-	exifHeader := []byte("Exif\x00\x00")
+	return stripJPEGSegments(data, 0xE1, exifHeader)
+}
+
+// StripJPEGMetadata removes the APP1 (EXIF, XMP and extended XMP) and
+// APP13 (Photoshop IPTC) segments from JPEG data. The ICC profile and the
+// image data are kept.
+func StripJPEGMetadata(data []byte) []byte {
+	return stripJPEGSegments(stripJPEGSegments(data, 0xE1, nil), 0xED, photoshopHeader)
+}
 
+// stripJPEGSegments removes the marker segments whose payload starts with
+// header.
+func stripJPEGSegments(data []byte, marker byte, header []byte) []byte {
 	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
 		return data
 	}
@@ -71,10 +198,10 @@ func stripExistingExif(data []byte) []byte {
 			result.Write(data[i:])
 			break
 		}
-		marker := data[i+1]
+		m := data[i+1]
 
 		// SOS marker: copy everything from here onward
-		if marker == 0xDA {
+		if m == 0xDA {
 			result.Write(data[i:])
 			break
 		}
@@ -87,10 +214,10 @@ func stripExistingExif(data []byte) []byte {
 			break
 		}
 
-		// Skip APP1 EXIF segments, keep everything else
-		if marker == 0xE1 && segLen >= 6 {
+		// Skip matching segments, keep everything else
+		if m == marker && segLen >= 2 {
 			segPayload := data[i+4 : segEnd]
-			if bytes.HasPrefix(segPayload, exifHeader) {
+			if bytes.HasPrefix(segPayload, header) {
 				i = segEnd
 				continue
 			}
@@ -103,13 +230,31 @@ func stripExistingExif(data []byte) []byte {
 	return result.Bytes()
 }
 
+// jpegSegmentsEnd returns the offset after the run of marker segments
+// following SOI for which skip returns true.
+func jpegSegmentsEnd(data []byte, skip func(marker byte, payload []byte) bool) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		segEnd := i + 2 + (int(data[i+2])<<8 | int(data[i+3]))
+		if segEnd > len(data) || segEnd < i+4 || !skip(data[i+1], data[i+4:segEnd]) {
+			break
+		}
+		i = segEnd
+	}
+	return i
+}
+
 // buildAPP1Segment constructs an APP1 marker segment from raw EXIF data.
 func buildAPP1Segment(exifRaw []byte) []byte {
-	// APP1 format: FF E1 <length-2-bytes> <payload>
-	// Length includes the 2 length bytes but not the marker bytes.
-	length := len(exifRaw) + 2
+	return buildSegment(0xE1, exifRaw)
+}
+
+// buildSegment constructs a marker segment: FF <marker> <length> <payload>.
+// The length includes its own 2 bytes but not the marker bytes.
+func buildSegment(marker byte, payload []byte) []byte {
+	length := len(payload) + 2
 	return append(
-		[]byte{0xFF, 0xE1, byte(length >> 8), byte(length & 0xFF)},
-		exifRaw...,
+		[]byte{0xFF, marker, byte(length >> 8), byte(length & 0xFF)},
+		payload...,
 	)
 }
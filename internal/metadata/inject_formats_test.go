@@ -0,0 +1,267 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"golang.org/x/image/tiff"
+)
+
+// injectFile writes data to a temp file, injects meta and returns the
+// rewritten file.
+func injectFile(t *testing.T, format codec.Format, data []byte, meta *Metadata) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out."+string(format))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Inject(path, format, meta); err != nil {
+		t.Fatalf("Inject(%s): %v", format, err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	img.Set(0, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 128})
+	return img
+}
+
+func TestInject_PNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	meta := &Metadata{EXIFRaw: sampleEXIF(binary.BigEndian)}
+
+	out := injectFile(t, codec.PNG, buf.Bytes(), meta)
+	out = injectFile(t, codec.PNG, out, meta) // replaces, does not duplicate
+
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("decode after inject: %v", err)
+	}
+	chunks, err := parsePNGChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, c := range chunks {
+		if c.typ == "eXIf" {
+			if !bytes.Equal(c.data, meta.TIFF()) {
+				t.Error("eXIf chunk does not hold the EXIF data")
+			}
+		}
+		if c.typ == "eXIf" || c.typ == "IDAT" {
+			types = append(types, c.typ)
+		}
+	}
+	if len(types) < 2 || types[0] != "eXIf" || types[1] != "IDAT" {
+		t.Errorf("chunk order = %v, want one eXIf before IDAT", types)
+	}
+}
+
+// vp8lChunk returns a VP8L bitstream header for the given canvas.
+func vp8lChunk(width, height int, alpha bool) riffChunk {
+	bits := uint32(width-1) | uint32(height-1)<<14
+	if alpha {
+		bits |= 1 << 28
+	}
+	data := append([]byte{0x2f}, binary.LittleEndian.AppendUint32(nil, bits)...)
+	return riffChunk{fourCC: "VP8L", data: append(data, 1, 2, 3)}
+}
+
+func TestInject_WebPSimple(t *testing.T) {
+	meta := &Metadata{EXIFRaw: sampleEXIF(binary.LittleEndian)}
+	out := injectFile(t, codec.WebP, writeWebP([]riffChunk{vp8lChunk(300, 200, true)}), meta)
+
+	chunks, err := parseWebPChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(out[4:]) != uint32(len(out)-8) {
+		t.Errorf("RIFF size = %d, file size %d", binary.LittleEndian.Uint32(out[4:]), len(out))
+	}
+	if len(chunks) != 3 || chunks[0].fourCC != "VP8X" || chunks[1].fourCC != "VP8L" || chunks[2].fourCC != "EXIF" {
+		t.Fatalf("unexpected chunks %v", chunks)
+	}
+	vp8x := chunks[0].data
+	if vp8x[0] != vp8xAlpha|vp8xEXIF {
+		t.Errorf("VP8X flags = %#x", vp8x[0])
+	}
+	w := int(vp8x[4]) | int(vp8x[5])<<8 | int(vp8x[6])<<16
+	h := int(vp8x[7]) | int(vp8x[8])<<8 | int(vp8x[9])<<16
+	if w+1 != 300 || h+1 != 200 {
+		t.Errorf("canvas = %dx%d, want 300x200", w+1, h+1)
+	}
+	if !bytes.Equal(chunks[2].data, meta.TIFF()) {
+		t.Error("EXIF chunk does not hold the EXIF data")
+	}
+}
+
+func TestInject_WebPExtended(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = vp8xEXIF
+	in := writeWebP([]riffChunk{
+		{fourCC: "VP8X", data: vp8x},
+		vp8lChunk(1, 1, false),
+		{fourCC: "EXIF", data: []byte("old")},
+		{fourCC: "XMP ", data: []byte("<x/>")},
+	})
+	meta := &Metadata{EXIFRaw: sampleEXIF(binary.BigEndian)}
+	chunks, err := parseWebPChunks(injectFile(t, codec.WebP, in, meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, c := range chunks {
+		order = append(order, c.fourCC)
+	}
+	if want := []string{"VP8X", "VP8L", "EXIF", "XMP "}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("chunks = %q, want %q", order, want)
+	}
+	if !bytes.Equal(chunks[2].data, meta.TIFF()) {
+		t.Error("old EXIF chunk was not replaced")
+	}
+}
+
+func TestInject_TIFFMerge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Big-endian EXIF into a little-endian TIFF
+	meta := &Metadata{EXIFRaw: sampleEXIF(binary.BigEndian)}
+	out := injectFile(t, codec.TIFF, buf.Bytes(), meta)
+
+	img, err := tiff.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode after inject: %v", err)
+	}
+	if img.Bounds() != testImage().Bounds() {
+		t.Errorf("bounds = %v", img.Bounds())
+	}
+
+	got, err := ParseEXIF(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, _ := ParseEXIF(meta.EXIFRaw)
+	s := got.Summary()
+	if s.Make != "Canon" || s.Model != "EOS R5" || s.GPS == nil || s.Orientation != 6 {
+		t.Errorf("merged summary = %+v", s)
+	}
+	if !reflect.DeepEqual(s, src.Summary()) {
+		t.Errorf("summary changed:\n got %+v\nwant %+v", s, src.Summary())
+	}
+	if width, ok := got.Lookup(IFD0, 0x0100); !ok {
+		t.Error("ImageWidth was dropped")
+	} else if v, _ := width.Uint(0); v != 4 {
+		t.Errorf("ImageWidth = %d, want 4", v)
+	}
+}
+
+// buildHEIF returns a minimal HEIF file with one coded item whose data is
+// stored in an mdat box after the meta box.
+func buildHEIF(pixels []byte) []byte {
+	ftyp := makeBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	infe := makeBox("infe", fullBoxHeader(2, 0), []byte{0, 1, 0, 0}, []byte("hvc1\x00"))
+	meta := func(offset uint32) []byte {
+		iloc := fullBoxHeader(0, 0)
+		iloc = append(iloc, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1)
+		iloc = binary.BigEndian.AppendUint32(iloc, offset)
+		iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(pixels)))
+		return makeBox("meta", fullBoxHeader(0, 0),
+			makeBox("hdlr", fullBoxHeader(0, 0), make([]byte, 4), []byte("pict"), make([]byte, 13)),
+			makeBox("pitm", fullBoxHeader(0, 0), []byte{0, 1}),
+			makeBox("iinf", fullBoxHeader(0, 0), []byte{0, 1}, infe),
+			makeBox("iloc", iloc))
+	}
+	offset := len(ftyp) + len(meta(0)) + 8
+	return slices.Concat(ftyp, meta(uint32(offset)), makeBox("mdat", pixels))
+}
+
+// bmffItemData returns the data of the first item of the given type.
+func bmffItemData(t *testing.T, data []byte, typ string) []byte {
+	t.Helper()
+	top, err := parseBoxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range top {
+		if b.typ != "meta" {
+			continue
+		}
+		children, err := parseBoxes(b.data[4:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types map[uint32]itemInfo
+		var iloc *ilocBox
+		for _, c := range children {
+			switch c.typ {
+			case "iinf":
+				types, err = parseItemTypes(c.data)
+			case "iloc":
+				iloc, err = parseIloc(c.data)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, it := range iloc.items {
+			if types[it.id].typ == typ {
+				e := it.extents[0]
+				start := it.baseOffset + e.offset
+				return data[start : start+e.length]
+			}
+		}
+	}
+	t.Fatalf("no %q item", typ)
+	return nil
+}
+
+func TestInject_BMFF(t *testing.T) {
+	pixels := []byte("coded image data")
+	meta := &Metadata{EXIFRaw: sampleEXIF(binary.LittleEndian)}
+
+	out := injectFile(t, codec.HEIC, buildHEIF(pixels), meta)
+	out = injectFile(t, codec.HEIC, out, meta) // replaces the Exif item
+
+	if got := bmffItemData(t, out, "hvc1"); !bytes.Equal(got, pixels) {
+		t.Errorf("image item data = %q, want %q", got, pixels)
+	}
+	exif := bmffItemData(t, out, "Exif")
+	if !bytes.Equal(exif[:4], []byte{0, 0, 0, 0}) || !bytes.Equal(exif[4:], meta.TIFF()) {
+		t.Error("Exif item does not hold the EXIF data")
+	}
+	if n := bytes.Count(out, []byte("Exif\x00")); n != 1 {
+		t.Errorf("found %d Exif item entries, want 1", n)
+	}
+	if n := bytes.Count(out, []byte("cdsc")); n != 1 {
+		t.Errorf("found %d cdsc references, want 1", n)
+	}
+}
+
+func TestInject_JXLNeedsEncoder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jxl")
+	os.WriteFile(path, []byte{0xFF, 0x0A}, 0644)
+	if err := Inject(path, codec.JXL, &Metadata{EXIFRaw: buildTestEXIF(1, false)}); err == nil {
+		t.Error("expected error for JXL injection")
+	}
+}
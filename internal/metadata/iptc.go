@@ -0,0 +1,452 @@
+package metadata
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// IPTC-IIM support. The Information Interchange Model stores editorial
+// metadata as a sequence of datasets, each a 0x1C marker, record and
+// dataset numbers and a 16-bit length (with the high bit set, the size of
+// a longer length instead). JPEG files carry the datasets as resource
+// 0x0404 of a Photoshop image resource block (IRB) in APP13, TIFF files in
+// tag 33723.
+
+// tagIPTC holds the IPTC-IIM datasets of a TIFF file.
+const tagIPTC = 0x83BB
+
+// IIM datasets of the envelope (1) and application (2) records.
+const (
+	iptcCharset       = 90 // record 1: coded character set
+	iptcRecordVersion = 0
+	iptcObjectName    = 5
+	iptcKeywords      = 25
+	iptcDateCreated   = 55
+	iptcByline        = 80
+	iptcCity          = 90
+	iptcCountry       = 101
+	iptcHeadline      = 105
+	iptcCredit        = 110
+	iptcSource        = 115
+	iptcCopyright     = 116
+	iptcCaption       = 120
+)
+
+// charsetUTF8 is the ISO 2022 escape sequence selecting UTF-8.
+var charsetUTF8 = []byte("\x1b%G")
+
+// ErrInvalidIPTC is returned for malformed IPTC-IIM data.
+var ErrInvalidIPTC = errors.New("invalid IPTC data")
+
+// iptcDataset is one IIM dataset.
+type iptcDataset struct {
+	record, dataset byte
+	data            []byte
+}
+
+// parseIPTC splits IIM data into datasets. Padding after the last
+// dataset is ignored.
+func parseIPTC(data []byte) ([]iptcDataset, error) {
+	var out []iptcDataset
+	for off := 0; off < len(data) && data[off] == 0x1C; {
+		if off+5 > len(data) {
+			return nil, fmt.Errorf("%w: dataset at %d is truncated", ErrInvalidIPTC, off)
+		}
+		ds := iptcDataset{record: data[off+1], dataset: data[off+2]}
+		n := int(binary.BigEndian.Uint16(data[off+3:]))
+		off += 5
+		if n&0x8000 != 0 {
+			// Extended dataset: the low bits give the size of the length
+			size := n & 0x7FFF
+			if size == 0 || size > 4 || off+size > len(data) {
+				return nil, fmt.Errorf("%w: bad extended length at %d", ErrInvalidIPTC, off)
+			}
+			n = 0
+			for _, b := range data[off : off+size] {
+				n = n<<8 | int(b)
+			}
+			off += size
+		}
+		if n < 0 || off+n > len(data) {
+			return nil, fmt.Errorf("%w: dataset %d:%d is truncated", ErrInvalidIPTC, ds.record, ds.dataset)
+		}
+		ds.data = data[off : off+n]
+		out = append(out, ds)
+		off += n
+	}
+	return out, nil
+}
+
+// writeIPTC serializes datasets.
+func writeIPTC(datasets []iptcDataset) []byte {
+	var buf bytes.Buffer
+	for _, ds := range datasets {
+		buf.Write([]byte{0x1C, ds.record, ds.dataset})
+		if n := len(ds.data); n <= 0x7FFF {
+			buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+		} else {
+			buf.Write([]byte{0x80, 4})
+			buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+		}
+		buf.Write(ds.data)
+	}
+	return buf.Bytes()
+}
+
+// isUTF8IPTC reports whether the datasets declare UTF-8 text.
+func isUTF8IPTC(datasets []iptcDataset) bool {
+	for _, ds := range datasets {
+		if ds.record == 1 && ds.dataset == iptcCharset {
+			return bytes.Equal(ds.data, charsetUTF8)
+		}
+	}
+	return false
+}
+
+// decodeIPTCString returns the text of a dataset. Without a UTF-8
+// declaration, valid UTF-8 is still taken as such (many writers omit it)
+// and anything else is read as Latin-1.
+func decodeIPTCString(data []byte, utf8Declared bool) string {
+	data = bytes.TrimRight(data, "\x00")
+	if utf8Declared || utf8.Valid(data) {
+		return strings.TrimSpace(string(data))
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+// IPTCFields are the common editorial datasets of an IPTC-IIM record.
+type IPTCFields struct {
+	ObjectName  string   `json:"object_name,omitempty"`  // 2:05
+	Headline    string   `json:"headline,omitempty"`     // 2:105
+	Caption     string   `json:"caption,omitempty"`      // 2:120 Caption/Abstract
+	Byline      string   `json:"byline,omitempty"`       // 2:80, repeated bylines joined with "; "
+	Credit      string   `json:"credit,omitempty"`       // 2:110
+	Source      string   `json:"source,omitempty"`       // 2:115
+	Copyright   string   `json:"copyright,omitempty"`    // 2:116 Copyright Notice
+	City        string   `json:"city,omitempty"`         // 2:90
+	Country     string   `json:"country,omitempty"`      // 2:101 Country/Primary Location Name
+	DateCreated string   `json:"date_created,omitempty"` // 2:55, CCYYMMDD
+	Keywords    []string `json:"keywords,omitempty"`     // 2:25
+}
+
+// textFields returns the single-valued fields with their dataset numbers.
+func (f *IPTCFields) textFields() []struct {
+	dataset byte
+	value   *string
+} {
+	return []struct {
+		dataset byte
+		value   *string
+	}{
+		{iptcObjectName, &f.ObjectName},
+		{iptcDateCreated, &f.DateCreated},
+		{iptcByline, &f.Byline},
+		{iptcCity, &f.City},
+		{iptcCountry, &f.Country},
+		{iptcHeadline, &f.Headline},
+		{iptcCredit, &f.Credit},
+		{iptcSource, &f.Source},
+		{iptcCopyright, &f.Copyright},
+		{iptcCaption, &f.Caption},
+	}
+}
+
+// IsZero reports whether no field is set.
+func (f IPTCFields) IsZero() bool {
+	for _, s := range f.textFields() {
+		if *s.value != "" {
+			return false
+		}
+	}
+	return len(f.Keywords) == 0
+}
+
+// HasIPTC returns true if IPTC-IIM data is present.
+func (m *Metadata) HasIPTC() bool {
+	return m != nil && len(m.IPTC) > 0
+}
+
+// IPTCFields decodes the common datasets of the IPTC record.
+func (m *Metadata) IPTCFields() (IPTCFields, error) {
+	var f IPTCFields
+	if !m.HasIPTC() {
+		return f, nil
+	}
+	datasets, err := parseIPTC(m.IPTC)
+	if err != nil {
+		return f, err
+	}
+	declared := isUTF8IPTC(datasets)
+	fields := f.textFields()
+	for _, ds := range datasets {
+		if ds.record != 2 {
+			continue
+		}
+		text := decodeIPTCString(ds.data, declared)
+		if ds.dataset == iptcKeywords {
+			f.Keywords = append(f.Keywords, text)
+			continue
+		}
+		for _, s := range fields {
+			if s.dataset != ds.dataset {
+				continue
+			}
+			if *s.value != "" && ds.dataset == iptcByline {
+				*s.value += "; " + text
+			} else if *s.value == "" {
+				*s.value = text
+			}
+		}
+	}
+	return f, nil
+}
+
+// WithIPTCFields returns a copy of m whose IPTC record has the fields set
+// in f, replacing existing datasets. The record is rewritten as UTF-8,
+// converting Latin-1 text; other datasets are kept. Without IPTC data a
+// new record is created. A zero f returns m unchanged.
+func (m *Metadata) WithIPTCFields(f IPTCFields) (*Metadata, error) {
+	if f.IsZero() {
+		return m, nil
+	}
+	out := &Metadata{}
+	if m != nil {
+		*out = *m
+	}
+	var datasets []iptcDataset
+	if out.HasIPTC() {
+		var err error
+		if datasets, err = parseIPTC(out.IPTC); err != nil {
+			return nil, err
+		}
+	}
+
+	replace := make(map[byte]bool)
+	var added []iptcDataset
+	for _, s := range f.textFields() {
+		if *s.value != "" {
+			replace[s.dataset] = true
+			added = append(added, iptcDataset{2, s.dataset, []byte(*s.value)})
+		}
+	}
+	if len(f.Keywords) > 0 {
+		replace[iptcKeywords] = true
+		for _, k := range f.Keywords {
+			added = append(added, iptcDataset{2, iptcKeywords, []byte(k)})
+		}
+	}
+
+	declared := isUTF8IPTC(datasets)
+	kept := []iptcDataset{{1, iptcCharset, charsetUTF8}}
+	hasVersion := false
+	for _, ds := range datasets {
+		switch {
+		case ds.record == 1 && ds.dataset == iptcCharset:
+			continue
+		case ds.record == 2 && replace[ds.dataset]:
+			continue
+		case ds.record == 2 && ds.dataset == iptcRecordVersion:
+			hasVersion = true
+		case ds.record == 2 && ds.dataset < 200 && !declared:
+			// Text datasets; 2:200 and up hold binary preview data
+			ds.data = []byte(decodeIPTCString(ds.data, false))
+		}
+		kept = append(kept, ds)
+	}
+	if !hasVersion {
+		kept = append(kept, iptcDataset{2, iptcRecordVersion, []byte{0, 4}})
+	}
+	kept = append(kept, added...)
+	// Datasets are ordered by record and number; repeats keep their order
+	key := func(ds iptcDataset) int { return int(ds.record)<<8 | int(ds.dataset) }
+	slices.SortStableFunc(kept, func(a, b iptcDataset) int { return cmp.Compare(key(a), key(b)) })
+	out.IPTC = writeIPTC(kept)
+	return out, nil
+}
+
+// WithIPTCInXMP returns a copy of m for formats that have no place for
+// IPTC-IIM data: the IPTC object name, caption, byline, copyright and
+// keywords are merged into the XMP packet as dc:title, dc:description,
+// dc:creator, dc:rights and dc:subject where the packet does not already
+// set them, following the IPTC Core mapping, and the IPTC data is
+// dropped. Without IPTC data m is returned unchanged.
+func (m *Metadata) WithIPTCInXMP() (*Metadata, error) {
+	if !m.HasIPTC() {
+		return m, nil
+	}
+	iptc, err := m.IPTCFields()
+	if err != nil {
+		return nil, err
+	}
+	existing, err := m.XMPFields()
+	if err != nil {
+		return nil, err
+	}
+	var f XMPFields
+	if existing.Title == "" {
+		f.Title = iptc.ObjectName
+	}
+	if existing.Description == "" {
+		f.Description = iptc.Caption
+	}
+	if existing.Creator == "" {
+		f.Creator = iptc.Byline
+	}
+	if existing.Rights == "" {
+		f.Rights = iptc.Copyright
+	}
+	if len(existing.Keywords) == 0 {
+		f.Keywords = iptc.Keywords
+	}
+	out, err := m.WithXMPFields(f)
+	if err != nil {
+		return nil, err
+	}
+	if out == m {
+		copied := *m
+		out = &copied
+	}
+	out.IPTC = nil
+	return out, nil
+}
+
+// Photoshop image resource blocks.
+var photoshopHeader = []byte("Photoshop 3.0\x00")
+
+// Image resource IDs.
+const (
+	irbIPTC       = 0x0404
+	irbIPTCDigest = 0x0425 // MD5 of the IPTC data, stale once it changes
+)
+
+// irbResource is one Photoshop image resource. name is the padded Pascal
+// string as stored.
+type irbResource struct {
+	id   uint16
+	name []byte
+	data []byte
+}
+
+// parseIRB splits a Photoshop image resource block into its resources.
+func parseIRB(data []byte) ([]irbResource, error) {
+	var out []irbResource
+	for off := 0; off+4 <= len(data); {
+		if string(data[off:off+4]) != "8BIM" {
+			return nil, fmt.Errorf("%w: bad image resource signature at %d", ErrInvalidIPTC, off)
+		}
+		if off+7 > len(data) {
+			return nil, fmt.Errorf("%w: image resource at %d is truncated", ErrInvalidIPTC, off)
+		}
+		r := irbResource{id: binary.BigEndian.Uint16(data[off+4:])}
+		// Pascal string padded to an even size
+		nameLen := int(data[off+6]) + 1
+		nameLen += nameLen % 2
+		start := off + 6 + nameLen
+		if start+4 > len(data) {
+			return nil, fmt.Errorf("%w: image resource at %d is truncated", ErrInvalidIPTC, off)
+		}
+		r.name = data[off+6 : start]
+		n := int(binary.BigEndian.Uint32(data[start:]))
+		start += 4
+		if n < 0 || start+n > len(data) {
+			return nil, fmt.Errorf("%w: image resource %#04x is truncated", ErrInvalidIPTC, r.id)
+		}
+		r.data = data[start : start+n]
+		out = append(out, r)
+		off = start + n + n%2
+	}
+	return out, nil
+}
+
+// writeIRB serializes image resources.
+func writeIRB(resources []irbResource) []byte {
+	var buf bytes.Buffer
+	for _, r := range resources {
+		buf.WriteString("8BIM")
+		buf.Write(binary.BigEndian.AppendUint16(nil, r.id))
+		if len(r.name) == 0 {
+			buf.Write([]byte{0, 0})
+		} else {
+			buf.Write(r.name)
+		}
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(r.data))))
+		buf.Write(r.data)
+		if len(r.data)%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+// jpegIRB returns the Photoshop image resource block of a JPEG file, which
+// may be split across several APP13 segments, or nil.
+func jpegIRB(data []byte) []byte {
+	var irb []byte
+	for _, seg := range findJPEGSegments(data, 0xED, photoshopHeader) {
+		irb = append(irb, seg[len(photoshopHeader):]...)
+	}
+	return irb
+}
+
+// iptcFromIRB returns the IPTC resource of an image resource block, or
+// nil if there is none or the block is malformed.
+func iptcFromIRB(irb []byte) []byte {
+	resources, err := parseIRB(irb)
+	if err != nil {
+		return nil
+	}
+	for _, r := range resources {
+		if r.id == irbIPTC {
+			return r.data
+		}
+	}
+	return nil
+}
+
+// ErrIPTCTooLarge is returned when the IPTC data does not fit in a JPEG
+// APP13 segment.
+var ErrIPTCTooLarge = errors.New("IPTC data exceeds the 64 KB JPEG APP13 limit")
+
+// replaceJPEGIPTC returns the JPEG data with the IPTC resource of its
+// APP13 image resource block replaced by iim. Other resources are kept,
+// except the digest of the old IPTC data. The segment is placed after the
+// JFIF, EXIF and XMP segments.
+func replaceJPEGIPTC(data, iim []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("output is not a valid JPEG")
+	}
+
+	resources, _ := parseIRB(jpegIRB(data)) // a malformed block is replaced
+	var kept []irbResource
+	for _, r := range resources {
+		if r.id != irbIPTC && r.id != irbIPTCDigest {
+			kept = append(kept, r)
+		}
+	}
+	kept = append(kept, irbResource{id: irbIPTC, data: iim})
+	payload := append(slices.Clip(photoshopHeader), writeIRB(kept)...)
+	if len(payload) > maxAPP1Payload {
+		return nil, fmt.Errorf("%w (%d bytes)", ErrIPTCTooLarge, len(payload))
+	}
+
+	cleaned := stripJPEGSegments(data, 0xED, photoshopHeader)
+	i := jpegSegmentsEnd(cleaned, func(marker byte, _ []byte) bool {
+		return marker == 0xE0 || marker == 0xE1
+	})
+
+	var buf bytes.Buffer
+	buf.Write(cleaned[:i])
+	buf.Write(buildSegment(0xED, payload))
+	buf.Write(cleaned[i:])
+	return buf.Bytes(), nil
+}
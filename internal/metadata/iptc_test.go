@@ -0,0 +1,194 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"golang.org/x/image/tiff"
+)
+
+// sampleIPTC is a Latin-1 record without a character set declaration.
+func sampleIPTC() []byte {
+	return writeIPTC([]iptcDataset{
+		{2, iptcRecordVersion, []byte{0, 4}},
+		{2, iptcObjectName, []byte("Harbor")},
+		{2, iptcKeywords, []byte("sea")},
+		{2, iptcKeywords, []byte("caf\xe9")},
+		{2, iptcByline, []byte("Ann")},
+		{2, iptcByline, []byte("Bo")},
+		{2, iptcCity, []byte("M\xe1laga")},
+	})
+}
+
+func TestParseIPTC(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 0x9000)
+	data := writeIPTC([]iptcDataset{{2, iptcCaption, long}, {2, iptcCity, []byte("Oslo")}})
+	data = append(data, 0, 0) // padding
+	datasets, err := parseIPTC(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datasets) != 2 || !bytes.Equal(datasets[0].data, long) || string(datasets[1].data) != "Oslo" {
+		t.Errorf("unexpected datasets %d", len(datasets))
+	}
+	if _, err := parseIPTC(data[:20]); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
+
+func TestIPTCFields_Latin1(t *testing.T) {
+	f, err := (&Metadata{IPTC: sampleIPTC()}).IPTCFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := IPTCFields{
+		ObjectName: "Harbor",
+		Byline:     "Ann; Bo",
+		City:       "Málaga",
+		Keywords:   []string{"sea", "café"},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("fields = %+v, want %+v", f, want)
+	}
+}
+
+func TestWithIPTCFields_Merge(t *testing.T) {
+	m := &Metadata{EXIFRaw: []byte("exif"), IPTC: sampleIPTC()}
+	out, err := m.WithIPTCFields(IPTCFields{Caption: "Boats at dawn", Byline: "Cy", Keywords: []string{"boat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.IPTC, sampleIPTC()) || !bytes.Equal(out.EXIFRaw, m.EXIFRaw) {
+		t.Error("source modified or EXIF changed")
+	}
+	datasets, err := parseIPTC(out.IPTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isUTF8IPTC(datasets) {
+		t.Error("record does not declare UTF-8")
+	}
+	got, _ := out.IPTCFields()
+	want := IPTCFields{
+		ObjectName: "Harbor",
+		Caption:    "Boats at dawn",
+		Byline:     "Cy",
+		City:       "Málaga",
+		Keywords:   []string{"boat"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %+v, want %+v", got, want)
+	}
+	for i := 1; i < len(datasets); i++ {
+		a, b := datasets[i-1], datasets[i]
+		if a.record > b.record || a.record == b.record && a.dataset > b.dataset {
+			t.Fatalf("datasets out of order at %d", i)
+		}
+	}
+}
+
+func TestWithIPTCFields_NewRecord(t *testing.T) {
+	out, err := (*Metadata)(nil).WithIPTCFields(IPTCFields{Copyright: "© Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := out.IPTCFields(); f.Copyright != "© Ann" {
+		t.Errorf("copyright = %q", f.Copyright)
+	}
+	m := &Metadata{}
+	if same, _ := m.WithIPTCFields(IPTCFields{}); same != m {
+		t.Error("zero fields should return the metadata unchanged")
+	}
+}
+
+func TestIPTC_JPEGRoundTrip(t *testing.T) {
+	// An existing block with another resource and a stale digest
+	other := irbResource{id: 0x03ED, data: []byte("resolution")}
+	irb := writeIRB([]irbResource{other, {id: irbIPTC, data: sampleIPTC()}, {id: irbIPTCDigest, data: make([]byte, 16)}})
+	src := buildTestJPEGNoExif()
+	src = append(src[:2:2], append(buildSegment(0xED, append(bytes.Clone(photoshopHeader), irb...)), src[2:]...)...)
+
+	meta, err := Extract(bytes.NewReader(src), codec.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(meta.IPTC, sampleIPTC()) {
+		t.Fatal("IPTC not extracted")
+	}
+	meta, err = meta.WithIPTCFields(IPTCFields{Headline: "Dawn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.EXIFRaw = sampleEXIF(binary.BigEndian)
+	out := injectFile(t, codec.JPEG, src, meta)
+
+	got, err := Extract(bytes.NewReader(out), codec.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := got.IPTCFields(); f.Headline != "Dawn" || f.ObjectName != "Harbor" {
+		t.Errorf("fields = %+v", f)
+	}
+	resources, err := parseIRB(jpegIRB(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint16
+	for _, r := range resources {
+		ids = append(ids, r.id)
+	}
+	if !reflect.DeepEqual(ids, []uint16{other.id, irbIPTC}) {
+		t.Errorf("resources = %#04x", ids)
+	}
+	if n := len(findJPEGSegments(out, 0xED, photoshopHeader)); n != 1 {
+		t.Errorf("found %d APP13 segments, want 1", n)
+	}
+	if bytes.Index(out, photoshopHeader) < bytes.Index(out, exifHeader) {
+		t.Error("APP13 segment placed before EXIF segment")
+	}
+}
+
+func TestIPTC_TIFFRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	tiff.Encode(&buf, testImage(), nil)
+	out := injectFile(t, codec.TIFF, buf.Bytes(), &Metadata{IPTC: sampleIPTC()})
+	got, err := Extract(bytes.NewReader(out), codec.TIFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.IPTC, sampleIPTC()) {
+		t.Errorf("IPTC = %q", got.IPTC)
+	}
+}
+
+func TestIPTC_MirroredIntoXMP(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage())
+	meta, err := (&Metadata{IPTC: sampleIPTC(), XMP: []byte(samplePacket)}).
+		WithIPTCFields(IPTCFields{ObjectName: "Pier", Caption: "Boats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := injectFile(t, codec.PNG, buf.Bytes(), meta)
+
+	got, err := Extract(bytes.NewReader(out), codec.PNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.HasIPTC() {
+		t.Error("PNG should not carry IPTC data")
+	}
+	f, err := got.XMPFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The packet's own title wins over the IPTC object name
+	want := XMPFields{Title: "Harbor", Description: "Boats", Creator: "Ann; Bo", Keywords: []string{"sea", "café"}}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("fields = %+v, want %+v", f, want)
+	}
+}
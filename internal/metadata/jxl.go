@@ -0,0 +1,52 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// jxlSignature is the signature box that starts a JPEG XL container. A
+// bare codestream (starting FF 0A) carries no metadata.
+var jxlSignature = []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")
+
+// extractFromJXL returns the data of the "Exif" and "xml " boxes of a
+// JPEG XL container. The Exif payload starts with the offset of the TIFF
+// header.
+func extractFromJXL(data []byte) (*Metadata, error) {
+	if !bytes.HasPrefix(data, jxlSignature) {
+		return nil, fmt.Errorf("no EXIF or XMP data found in JXL (bare codestream)")
+	}
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	var exif, xmp []byte
+	for _, b := range boxes {
+		switch b.typ {
+		case "Exif":
+			if exif != nil {
+				continue
+			}
+			if len(b.data) < 4 {
+				return nil, fmt.Errorf("JXL Exif box too small")
+			}
+			skip := uint64(binary.BigEndian.Uint32(b.data)) + 4
+			if skip >= uint64(len(b.data)) {
+				return nil, fmt.Errorf("JXL Exif box header offset out of range")
+			}
+			if exif, err = wrapTIFF(b.data[skip:]); err != nil {
+				return nil, err
+			}
+		case "xml ":
+			if xmp == nil {
+				xmp = b.data
+			}
+		case "brob":
+			if len(b.data) >= 4 && (string(b.data[:4]) == "Exif" || string(b.data[:4]) == "xml ") {
+				return nil, fmt.Errorf("JXL %q box is Brotli-compressed, which is not supported", b.data[:4])
+			}
+		}
+	}
+	return found("JXL", &Metadata{EXIFRaw: exif, XMP: xmp})
+}
@@ -2,10 +2,15 @@ package metadata
 
 import "encoding/binary"
 
-// Metadata holds raw EXIF data extracted from a source image.
+// Metadata holds the raw EXIF data, XMP packet and IPTC-IIM datasets
+// extracted from a source image.
 type Metadata struct {
 	// EXIFRaw contains the raw EXIF bytes (APP1 payload after "Exif\0\0" prefix).
 	EXIFRaw []byte
+	// XMP contains the XMP packet (XML).
+	XMP []byte
+	// IPTC contains the IPTC-IIM datasets (the Photoshop 0x0404 resource).
+	IPTC []byte
 }
 
 // HasEXIF returns true if EXIF data is present.
@@ -13,12 +18,52 @@ func (m *Metadata) HasEXIF() bool {
 	return m != nil && len(m.EXIFRaw) > 0
 }
 
+// IsEmpty reports whether m carries no EXIF, XMP or IPTC data.
+func (m *Metadata) IsEmpty() bool {
+	return !m.HasEXIF() && !m.HasXMP() && !m.HasIPTC()
+}
+
+// TIFF returns the EXIF data without the "Exif\0\0" prefix, as stored
+// in PNG, WebP, HEIF and JXL files.
+func (m *Metadata) TIFF() []byte {
+	if !m.HasEXIF() {
+		return nil
+	}
+	return trimEXIFPrefix(m.EXIFRaw)
+}
+
 // Orientation parses the EXIF orientation tag (0x0112) from raw EXIF bytes.
 // Returns 0 if the orientation cannot be determined.
 func (m *Metadata) Orientation() int {
-	if !m.HasEXIF() {
+	raw, bo, off := m.orientationValue()
+	if raw == nil {
 		return 0
 	}
+	orientation := int(bo.Uint16(raw[off : off+2]))
+	if orientation >= 1 && orientation <= 8 {
+		return orientation
+	}
+	return 0
+}
+
+// SetOrientation rewrites the EXIF orientation tag in place. It returns
+// false if the EXIF data has no orientation tag.
+func (m *Metadata) SetOrientation(orientation int) bool {
+	raw, bo, off := m.orientationValue()
+	if raw == nil {
+		return false
+	}
+	bo.PutUint16(raw[off:off+2], uint16(orientation))
+	return true
+}
+
+// orientationValue locates the value of the orientation tag in IFD0. It
+// returns the TIFF data, its byte order and the offset of the value, or a
+// nil slice if the tag is absent.
+func (m *Metadata) orientationValue() ([]byte, binary.ByteOrder, int) {
+	if !m.HasEXIF() {
+		return nil, nil, 0
+	}
 
 	raw := m.EXIFRaw
 
@@ -29,7 +74,7 @@ func (m *Metadata) Orientation() int {
 	}
 
 	if len(raw) < 8 {
-		return 0
+		return nil, nil, 0
 	}
 
 	// Determine byte order from TIFF header.
@@ -40,18 +85,18 @@ func (m *Metadata) Orientation() int {
 	case raw[0] == 'M' && raw[1] == 'M':
 		bo = binary.BigEndian
 	default:
-		return 0
+		return nil, nil, 0
 	}
 
 	// Verify TIFF magic number (42).
 	if bo.Uint16(raw[2:4]) != 42 {
-		return 0
+		return nil, nil, 0
 	}
 
 	// Read offset to first IFD.
 	ifdOffset := int(bo.Uint32(raw[4:8]))
 	if ifdOffset < 8 || ifdOffset+2 > len(raw) {
-		return 0
+		return nil, nil, 0
 	}
 
 	// Read number of IFD entries.
@@ -68,14 +113,9 @@ func (m *Metadata) Orientation() int {
 		tag := bo.Uint16(raw[offset : offset+2])
 		if tag == 0x0112 { // Orientation tag
 			// Type should be SHORT (3), count should be 1.
-			valOffset := offset + 8
-			orientation := int(bo.Uint16(raw[valOffset : valOffset+2]))
-			if orientation >= 1 && orientation <= 8 {
-				return orientation
-			}
-			return 0
+			return raw, bo, offset + 8
 		}
 	}
 
-	return 0
+	return nil, nil, 0
 }
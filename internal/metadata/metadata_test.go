@@ -172,3 +172,22 @@ func TestOrientation_NoOrientationTag(t *testing.T) {
 		t.Error("Orientation() with no orientation tag should return 0")
 	}
 }
+
+func TestSetOrientation(t *testing.T) {
+	for _, bigEndian := range []bool{false, true} {
+		m := &Metadata{EXIFRaw: buildTestEXIF(6, bigEndian)}
+		if !m.SetOrientation(1) {
+			t.Fatal("SetOrientation() returned false for EXIF with orientation tag")
+		}
+		if got := m.Orientation(); got != 1 {
+			t.Errorf("Orientation() after SetOrientation(1): got %d, want 1", got)
+		}
+	}
+}
+
+func TestSetOrientation_NoEXIF(t *testing.T) {
+	m := &Metadata{}
+	if m.SetOrientation(1) {
+		t.Error("SetOrientation() on empty metadata should return false")
+	}
+}
@@ -0,0 +1,145 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunk is a chunk of a PNG file. raw spans length, type, data and CRC.
+type pngChunk struct {
+	typ  string
+	data []byte
+	raw  []byte
+}
+
+// parsePNGChunks splits a PNG file into its chunks.
+func parsePNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("output is not a valid PNG")
+	}
+	var chunks []pngChunk
+	for off := len(pngSignature); off < len(data); {
+		if off+12 > len(data) {
+			return nil, fmt.Errorf("PNG chunk at %d is truncated", off)
+		}
+		n := int(binary.BigEndian.Uint32(data[off:]))
+		end := off + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, fmt.Errorf("PNG chunk at %d is truncated", off)
+		}
+		chunks = append(chunks, pngChunk{
+			typ:  string(data[off+4 : off+8]),
+			data: data[off+8 : off+8+n],
+			raw:  data[off:end],
+		})
+		off = end
+	}
+	return chunks, nil
+}
+
+// makePNGChunk serializes a chunk with its CRC.
+func makePNGChunk(typ string, data []byte) []byte {
+	out := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	copy(out[4:], typ)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[4:]))
+}
+
+// pngXMPKeyword is the iTXt keyword of an XMP packet.
+const pngXMPKeyword = "XML:com.adobe.xmp"
+
+// injectIntoPNG replaces the eXIf and XMP iTXt chunks of a PNG file with
+// the ones of meta, placed before the first IDAT chunk.
+func injectIntoPNG(data []byte, meta *Metadata) ([]byte, error) {
+	chunks, err := parsePNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	written := false
+	for _, c := range chunks {
+		if c.typ == "eXIf" && meta.HasEXIF() || isPNGXMP(c) && meta.HasXMP() {
+			continue
+		}
+		if c.typ == "IDAT" && !written {
+			if meta.HasEXIF() {
+				buf.Write(makePNGChunk("eXIf", meta.TIFF()))
+			}
+			if meta.HasXMP() {
+				// Uncompressed, with empty language tag and translated keyword
+				itxt := append([]byte(pngXMPKeyword), 0, 0, 0, 0, 0)
+				buf.Write(makePNGChunk("iTXt", append(itxt, meta.XMP...)))
+			}
+			written = true
+		}
+		buf.Write(c.raw)
+	}
+	if !written {
+		return nil, fmt.Errorf("PNG has no IDAT chunk")
+	}
+	return buf.Bytes(), nil
+}
+
+// isPNGXMP reports whether c is an iTXt chunk holding an XMP packet.
+func isPNGXMP(c pngChunk) bool {
+	return c.typ == "iTXt" && bytes.HasPrefix(c.data, []byte(pngXMPKeyword+"\x00"))
+}
+
+// pngXMP returns the XMP packet of an iTXt chunk, inflating it if needed.
+func pngXMP(c pngChunk) ([]byte, error) {
+	rest := c.data[len(pngXMPKeyword)+1:]
+	if len(rest) < 2 {
+		return nil, fmt.Errorf("PNG iTXt chunk is truncated")
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+	// Skip the language tag and translated keyword
+	for range 2 {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return nil, fmt.Errorf("PNG iTXt chunk is truncated")
+		}
+		rest = rest[i+1:]
+	}
+	if !compressed {
+		return rest, nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return nil, fmt.Errorf("PNG iTXt chunk: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// extractFromPNG returns the EXIF data of the eXIf chunk and the XMP
+// packet of the XMP iTXt chunk.
+func extractFromPNG(data []byte) (*Metadata, error) {
+	chunks, err := parsePNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var exif, xmp []byte
+	for _, c := range chunks {
+		switch {
+		case c.typ == "eXIf" && exif == nil:
+			if exif, err = wrapTIFF(c.data); err != nil {
+				return nil, err
+			}
+		case isPNGXMP(c) && xmp == nil:
+			if xmp, err = pngXMP(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found("PNG", &Metadata{EXIFRaw: exif, XMP: xmp})
+}
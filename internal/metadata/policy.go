@@ -0,0 +1,367 @@
+package metadata
+
+import (
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Policy selects the EXIF tags that survive when metadata is preserved.
+// Entries are group names (see Groups) or EXIF tag names such as
+// "GPSAltitude" or "Make", matched case-insensitively.
+//
+// With an empty Keep list every tag is kept except those in Strip. With a
+// non-empty Keep list only the listed tags and the image structure tags
+// (orientation, resolution, color space) are kept. Strip always wins.
+//
+// The gps, serial and owner groups also cover the XMP properties and IPTC
+// datasets that carry the same information, such as exif:GPSLatitude,
+// aux:SerialNumber or the IPTC city and country.
+type Policy struct {
+	Keep  []string
+	Strip []string
+}
+
+// Tag groups usable in a Policy.
+const (
+	GroupGPS         = "gps"         // the whole GPS directory
+	GroupSerial      = "serial"      // body and lens serial numbers, image unique ID
+	GroupOwner       = "owner"       // camera owner name
+	GroupCamera      = "camera"      // make, model and lens
+	GroupExposure    = "exposure"    // shooting settings and other Exif tags
+	GroupDateTime    = "datetime"    // capture, digitize and modify times
+	GroupCopyright   = "copyright"   // copyright notice
+	GroupArtist      = "artist"      // artist and XP author
+	GroupDescription = "description" // image description, user comment, XP title/comment/keywords/subject
+	GroupSoftware    = "software"    // processing software
+	GroupMakerNote   = "makernote"   // vendor maker note
+	GroupThumbnail   = "thumbnail"   // IFD1 and its JPEG thumbnail
+	GroupImage       = "image"       // orientation, resolution, color space and other structure tags
+	GroupOther       = "other"       // unclassified IFD0 tags
+)
+
+var groupNames = []string{
+	GroupGPS, GroupSerial, GroupOwner, GroupCamera, GroupExposure, GroupDateTime, GroupCopyright,
+	GroupArtist, GroupDescription, GroupSoftware, GroupMakerNote, GroupThumbnail, GroupImage, GroupOther,
+}
+
+// groupAliases expand to several groups.
+var groupAliases = map[string][]string{
+	// privacy removes location and anything identifying the camera or its owner.
+	"privacy": {GroupGPS, GroupSerial, GroupOwner, GroupMakerNote},
+}
+
+// Groups returns the names accepted as policy groups, aliases included.
+func Groups() []string {
+	names := slices.Clone(groupNames)
+	for alias := range groupAliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tagGroups assigns tags outside the GPS, Interop and IFD1 directories to
+// groups. Unlisted Exif tags belong to GroupExposure and unlisted IFD0
+// tags to GroupOther.
+var tagGroups = map[string]map[uint16]string{
+	IFD0: {
+		TagMake:             GroupCamera,
+		TagModel:            GroupCamera,
+		0xC614:              GroupCamera, // UniqueCameraModel
+		TagSoftware:         GroupSoftware,
+		TagDateTime:         GroupDateTime,
+		TagArtist:           GroupArtist,
+		0x9C9D:              GroupArtist, // XPAuthor
+		TagCopyright:        GroupCopyright,
+		TagImageDescription: GroupDescription,
+		0x9C9B:              GroupDescription, // XPTitle
+		0x9C9C:              GroupDescription, // XPComment
+		0x9C9E:              GroupDescription, // XPKeywords
+		0x9C9F:              GroupDescription, // XPSubject
+		0xC62F:              GroupSerial,      // CameraSerialNumber (DNG)
+		TagOrientation:      GroupImage,
+		0x011A:              GroupImage, // XResolution
+		0x011B:              GroupImage, // YResolution
+		0x0128:              GroupImage, // ResolutionUnit
+		0x012D:              GroupImage, // TransferFunction
+		0x013E:              GroupImage, // WhitePoint
+		0x013F:              GroupImage, // PrimaryChromaticities
+		0x0211:              GroupImage, // YCbCrCoefficients
+		0x0212:              GroupImage, // YCbCrSubSampling
+		0x0213:              GroupImage, // YCbCrPositioning
+		0x0214:              GroupImage, // ReferenceBlackWhite
+	},
+	IFDExif: {
+		TagLensMake:           GroupCamera,
+		TagLensModel:          GroupCamera,
+		0xA432:                GroupCamera, // LensSpecification
+		TagBodySerialNumber:   GroupSerial,
+		TagLensSerialNumber:   GroupSerial,
+		TagImageUniqueID:      GroupSerial,
+		TagCameraOwnerName:    GroupOwner,
+		TagDateTimeOriginal:   GroupDateTime,
+		TagDateTimeDigitized:  GroupDateTime,
+		0x9010:                GroupDateTime, // OffsetTime
+		TagOffsetTimeOriginal: GroupDateTime,
+		0x9012:                GroupDateTime, // OffsetTimeDigitized
+		0x9290:                GroupDateTime, // SubSecTime
+		0x9291:                GroupDateTime, // SubSecTimeOriginal
+		0x9292:                GroupDateTime, // SubSecTimeDigitized
+		TagUserComment:        GroupDescription,
+		TagMakerNote:          GroupMakerNote,
+		0x9000:                GroupImage, // ExifVersion
+		0x9101:                GroupImage, // ComponentsConfiguration
+		0xA000:                GroupImage, // FlashpixVersion
+		0xA001:                GroupImage, // ColorSpace
+		TagPixelXDimension:    GroupImage,
+		TagPixelYDimension:    GroupImage,
+	},
+}
+
+// tagGroup returns the group of a tag in the named directory.
+func tagGroup(ifd string, id uint16) string {
+	switch ifd {
+	case IFDGPS:
+		return GroupGPS
+	case IFDInterop:
+		return GroupImage
+	case IFD1:
+		return GroupThumbnail
+	}
+	if g, ok := tagGroups[ifd][id]; ok {
+		return g
+	}
+	if ifd == IFDExif {
+		return GroupExposure
+	}
+	return GroupOther
+}
+
+// Namespaces of XMP properties covered by policy groups.
+const (
+	nsExifEX   = "http://cipa.jp/exif/1.0/"
+	nsIptcCore = "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+	nsIptcExt  = "http://iptc.org/std/Iptc4xmpExt/2008-02-29/"
+)
+
+// xmpGroups assigns XMP properties to groups. exif:GPS* properties belong
+// to GroupGPS; other properties are not filtered.
+var xmpGroups = map[string]map[string]string{
+	nsEXIF: {"ImageUniqueID": GroupSerial},
+	nsExifEX: {
+		"BodySerialNumber": GroupSerial,
+		"LensSerialNumber": GroupSerial,
+		"ImageUniqueID":    GroupSerial,
+		"CameraOwnerName":  GroupOwner,
+	},
+	nsAux: {
+		"SerialNumber":     GroupSerial,
+		"LensSerialNumber": GroupSerial,
+		"OwnerName":        GroupOwner,
+	},
+	nsPhotoshop: {"City": GroupGPS, "State": GroupGPS, "Country": GroupGPS},
+	nsIptcCore:  {"Location": GroupGPS, "CountryCode": GroupGPS},
+	nsIptcExt:   {"LocationCreated": GroupGPS, "LocationShown": GroupGPS},
+}
+
+// xmpGroup returns the group of an XMP property, or "" if none.
+func xmpGroup(name xml.Name) string {
+	if name.Space == nsEXIF && strings.HasPrefix(name.Local, "GPS") {
+		return GroupGPS
+	}
+	return xmpGroups[name.Space][name.Local]
+}
+
+// iptcGroups assigns IPTC application record datasets to groups; other
+// datasets are not filtered.
+var iptcGroups = map[byte]string{
+	26:          GroupGPS, // Content Location Code
+	27:          GroupGPS, // Content Location Name
+	iptcCity:    GroupGPS,
+	92:          GroupGPS, // Sub-location
+	95:          GroupGPS, // Province/State
+	100:         GroupGPS, // Country Code
+	iptcCountry: GroupGPS,
+}
+
+// IsZero reports whether the policy keeps everything.
+func (p Policy) IsZero() bool {
+	return len(p.Keep) == 0 && len(p.Strip) == 0
+}
+
+// Validate checks that every entry names a group or a known tag.
+func (p Policy) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+// ParsePolicyList splits a comma-separated list of policy entries.
+func ParsePolicyList(s string) []string {
+	var entries []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			entries = append(entries, part)
+		}
+	}
+	return entries
+}
+
+// tagRef identifies a tag within a directory.
+type tagRef struct {
+	ifd string
+	id  uint16
+}
+
+// selector is a compiled list of policy entries.
+type selector struct {
+	groups map[string]bool
+	tags   map[tagRef]bool
+}
+
+func (s selector) matches(ifd string, id uint16) bool {
+	return s.groups[tagGroup(ifd, id)] || s.tags[tagRef{ifd, id}]
+}
+
+type compiledPolicy struct {
+	keep, strip selector
+	keepAll     bool
+}
+
+func (p Policy) compile() (*compiledPolicy, error) {
+	keep, err := compileSelector(p.Keep)
+	if err != nil {
+		return nil, err
+	}
+	strip, err := compileSelector(p.Strip)
+	if err != nil {
+		return nil, err
+	}
+	return &compiledPolicy{keep: keep, strip: strip, keepAll: len(p.Keep) == 0}, nil
+}
+
+func compileSelector(entries []string) (selector, error) {
+	s := selector{groups: make(map[string]bool), tags: make(map[tagRef]bool)}
+	for _, entry := range entries {
+		name := strings.ToLower(strings.TrimSpace(entry))
+		if groups, ok := groupAliases[name]; ok {
+			for _, g := range groups {
+				s.groups[g] = true
+			}
+			continue
+		}
+		if slices.Contains(groupNames, name) {
+			s.groups[name] = true
+			continue
+		}
+		refs := lookupTagName(name)
+		if len(refs) == 0 {
+			return s, fmt.Errorf("unknown metadata group or EXIF tag %q (groups: %s)", entry, strings.Join(Groups(), ", "))
+		}
+		for _, ref := range refs {
+			s.tags[ref] = true
+		}
+	}
+	return s, nil
+}
+
+// lookupTagName finds the tags with the given lower-case name in IFD0,
+// Exif, GPS and Interop.
+func lookupTagName(name string) []tagRef {
+	var refs []tagRef
+	for _, ifd := range []string{IFD0, IFDExif, IFDGPS, IFDInterop} {
+		for id, n := range tagNames[ifd] {
+			if strings.ToLower(n) == name {
+				refs = append(refs, tagRef{ifd, id})
+			}
+		}
+	}
+	return refs
+}
+
+// keeps reports whether the policy keeps a tag.
+func (c *compiledPolicy) keeps(ifd string, id uint16) bool {
+	if c.strip.matches(ifd, id) {
+		return false
+	}
+	return c.keepAll || c.keep.matches(ifd, id) || tagGroup(ifd, id) == GroupImage
+}
+
+// keepsGroup reports whether the policy keeps a group as a whole.
+func (c *compiledPolicy) keepsGroup(group string) bool {
+	return !c.strip.groups[group] && (c.keepAll || c.keep.groups[group])
+}
+
+// Filter removes the tags the policy does not keep. The thumbnail
+// directory is kept or dropped as a whole with GroupThumbnail.
+func (e *EXIF) Filter(p Policy) error {
+	c, err := p.compile()
+	if err != nil {
+		return err
+	}
+	filter := func(ifd string, tags []Tag) []Tag {
+		var kept []Tag
+		for _, t := range tags {
+			if c.keeps(ifd, t.ID) {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	}
+	e.IFD0 = filter(IFD0, e.IFD0)
+	e.Exif = filter(IFDExif, e.Exif)
+	e.GPS = filter(IFDGPS, e.GPS)
+	e.Interop = filter(IFDInterop, e.Interop)
+	if c.keepsGroup(GroupThumbnail) {
+		return nil
+	}
+	e.IFD1, e.Thumbnail = nil, nil
+	return nil
+}
+
+// ApplyPolicy returns a copy of m whose EXIF has been filtered by p and
+// rewritten. XMP properties and IPTC datasets are removed when the policy
+// drops their group (see xmpGroups and iptcGroups). A zero policy returns
+// m unchanged.
+func (m *Metadata) ApplyPolicy(p Policy) (*Metadata, error) {
+	if p.IsZero() || m.IsEmpty() {
+		return m, nil
+	}
+	c, err := p.compile()
+	if err != nil {
+		return nil, err
+	}
+	out := *m
+	if m.HasEXIF() {
+		e, err := m.EXIF()
+		if err != nil {
+			return nil, err
+		}
+		if err := e.Filter(p); err != nil {
+			return nil, err
+		}
+		out.EXIFRaw = e.Bytes()
+	}
+	drops := func(group string) bool {
+		return group != "" && !c.keepsGroup(group)
+	}
+	if m.HasXMP() {
+		packet, err := rewriteXMP(m.XMP, func(name xml.Name) bool { return drops(xmpGroup(name)) }, "")
+		if err != nil {
+			return nil, err
+		}
+		out.XMP = packet
+	}
+	if m.HasIPTC() {
+		datasets, err := parseIPTC(m.IPTC)
+		if err != nil {
+			return nil, err
+		}
+		out.IPTC = writeIPTC(slices.DeleteFunc(datasets, func(ds iptcDataset) bool {
+			return ds.record == 2 && drops(iptcGroups[ds.dataset])
+		}))
+	}
+	return &out, nil
+}
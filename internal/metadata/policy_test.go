@@ -0,0 +1,173 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func fieldNames(t *testing.T, e *EXIF) map[string]bool {
+	t.Helper()
+	names := make(map[string]bool)
+	for _, f := range e.Fields() {
+		names[f.Name] = true
+	}
+	return names
+}
+
+func shortTag(bo binary.ByteOrder, id, v uint16) Tag {
+	e := shortEntry(bo, id, v)
+	return Tag{ID: e.id, Type: e.typ, Count: e.count, Data: e.value, order: bo}
+}
+
+func TestEXIFBytes_RoundTrip(t *testing.T) {
+	for name, bo := range map[string]binary.ByteOrder{"LE": binary.LittleEndian, "BE": binary.BigEndian} {
+		orig, err := ParseEXIF(sampleEXIF(bo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig.Interop = []Tag{{ID: 0x0001, Type: TypeASCII, Count: 4, Data: []byte("R98\x00"), order: bo}}
+		orig.IFD1 = []Tag{shortTag(bo, 0x0103, 6)}
+		orig.Thumbnail = []byte{0xFF, 0xD8, 0xFF, 0xD9}
+
+		got, err := ParseEXIF(orig.Bytes())
+		if err != nil {
+			t.Fatalf("%s: reparse: %v", name, err)
+		}
+		if !reflect.DeepEqual(got.Fields(), orig.Fields()) {
+			t.Errorf("%s: fields changed:\n got %+v\nwant %+v", name, got.Fields(), orig.Fields())
+		}
+		if !reflect.DeepEqual(got.Thumbnail, orig.Thumbnail) {
+			t.Errorf("%s: thumbnail = %x", name, got.Thumbnail)
+		}
+	}
+}
+
+func TestFilter_StripPrivacy(t *testing.T) {
+	e, _ := ParseEXIF(sampleEXIF(binary.LittleEndian))
+	if err := e.Filter(Policy{Strip: []string{"privacy"}}); err != nil {
+		t.Fatal(err)
+	}
+	names := fieldNames(t, e)
+	for _, gone := range []string{"GPSLatitude", "GPSAltitude", "BodySerialNumber"} {
+		if names[gone] {
+			t.Errorf("%s should be stripped", gone)
+		}
+	}
+	for _, kept := range []string{"Make", "Artist", "Copyright", "DateTimeOriginal", "ExposureTime"} {
+		if !names[kept] {
+			t.Errorf("%s should be kept", kept)
+		}
+	}
+	if len(e.GPS) != 0 {
+		t.Errorf("GPS directory has %d tags", len(e.GPS))
+	}
+}
+
+func TestFilter_KeepOnly(t *testing.T) {
+	e, _ := ParseEXIF(sampleEXIF(binary.BigEndian))
+	if err := e.Filter(Policy{Keep: []string{"copyright", "Artist", "datetime"}}); err != nil {
+		t.Fatal(err)
+	}
+	names := fieldNames(t, e)
+	want := map[string]bool{
+		"Artist": true, "Copyright": true, "DateTimeOriginal": true, "OffsetTimeOriginal": true,
+		"Orientation": true, // image structure tags are always kept
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("kept %v, want %v", names, want)
+	}
+}
+
+func TestFilter_TagNames(t *testing.T) {
+	e, _ := ParseEXIF(sampleEXIF(binary.LittleEndian))
+	e.Thumbnail = []byte{0xFF, 0xD8, 0xFF, 0xD9}
+	if err := e.Filter(Policy{Strip: []string{"GPSAltitude", "make", "thumbnail"}}); err != nil {
+		t.Fatal(err)
+	}
+	names := fieldNames(t, e)
+	if names["GPSAltitude"] || names["Make"] || !names["GPSLatitude"] || !names["Model"] {
+		t.Errorf("unexpected fields %v", names)
+	}
+	if e.Thumbnail != nil {
+		t.Error("thumbnail should be stripped")
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	if err := (Policy{Keep: []string{"gps", "LensModel"}, Strip: []string{"privacy"}}).Validate(); err != nil {
+		t.Errorf("valid policy: %v", err)
+	}
+	if err := (Policy{Strip: []string{"location"}}).Validate(); err == nil {
+		t.Error("expected error for unknown entry")
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	m := &Metadata{EXIFRaw: sampleEXIF(binary.LittleEndian)}
+	if got, _ := m.ApplyPolicy(Policy{}); got != m {
+		t.Error("zero policy should return the metadata unchanged")
+	}
+	out, err := m.ApplyPolicy(Policy{Strip: []string{"gps"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := out.EXIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Summary().GPS != nil || e.Summary().Make != "Canon" {
+		t.Errorf("summary after policy = %+v", e.Summary())
+	}
+	if out.Orientation() != 6 {
+		t.Errorf("orientation = %d, want 6", out.Orientation())
+	}
+}
+
+func TestApplyPolicy_XMPAndIPTC(t *testing.T) {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:aux="http://ns.adobe.com/exif/1.0/aux/"
+    exif:GPSLatitude="48,51.5N" exif:ExposureTime="1/200" aux:SerialNumber="123456">
+   <exif:GPSLongitude>2,17.7E</exif:GPSLongitude>
+   <exif:FNumber>28/10</exif:FNumber>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+	m, err := (&Metadata{XMP: []byte(xmp)}).WithIPTCFields(IPTCFields{City: "Paris", Headline: "Tower"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := m.ApplyPolicy(Policy{Strip: []string{"privacy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(out.XMP)
+	for _, gone := range []string{"GPSLatitude", "GPSLongitude", "SerialNumber"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("XMP still has %s:\n%s", gone, got)
+		}
+	}
+	for _, kept := range []string{`exif:ExposureTime="1/200"`, "<exif:FNumber>28/10</exif:FNumber>"} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("XMP lost %s:\n%s", kept, got)
+		}
+	}
+	iptc, err := out.IPTCFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iptc.City != "" || iptc.Headline != "Tower" {
+		t.Errorf("IPTC after policy = %+v", iptc)
+	}
+
+	out, err = m.ApplyPolicy(Policy{Strip: []string{"makernote"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.XMP) != xmp {
+		t.Errorf("XMP changed by a policy keeping GPS:\n%s", out.XMP)
+	}
+}
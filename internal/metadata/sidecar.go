@@ -0,0 +1,294 @@
+package metadata
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SidecarFormat is the file format of a metadata sidecar.
+type SidecarFormat string
+
+// Sidecar formats.
+const (
+	SidecarJSON SidecarFormat = "json"
+	SidecarXMP  SidecarFormat = "xmp"
+)
+
+// ParseSidecarFormat validates a sidecar format name.
+func ParseSidecarFormat(s string) (SidecarFormat, error) {
+	switch f := SidecarFormat(strings.ToLower(s)); f {
+	case SidecarJSON, SidecarXMP:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown sidecar format %q (use json or xmp)", s)
+}
+
+// SidecarPath returns the path of the sidecar of an image file: the image
+// path with ".json" or ".xmp" appended, so outputs that differ only in
+// extension get distinct sidecars.
+func SidecarPath(path string, format SidecarFormat) string {
+	return path + "." + string(format)
+}
+
+// ErrNoSidecar is returned by FindSidecar when an image has no sidecar.
+var ErrNoSidecar = errors.New("no sidecar found")
+
+// FindSidecar returns the sidecar of an image file: path.json, path.xmp,
+// or the path with its extension replaced by .xmp, as written by most
+// photo editors.
+func FindSidecar(path string) (string, error) {
+	candidates := []string{
+		SidecarPath(path, SidecarJSON),
+		SidecarPath(path, SidecarXMP),
+		strings.TrimSuffix(path, filepath.Ext(path)) + ".xmp",
+	}
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && info.Mode().IsRegular() {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("%w for %s", ErrNoSidecar, path)
+}
+
+// Sidecar is the content of a JSON sidecar: readable summaries of the
+// metadata of an image and, in Raw, the metadata as extracted.
+type Sidecar struct {
+	Source   string      `json:"source,omitempty"`
+	EXIF     *Summary    `json:"exif,omitempty"`
+	EXIFTags []Field     `json:"exif_tags,omitempty"`
+	XMP      *XMPFields  `json:"xmp,omitempty"`
+	IPTC     *IPTCFields `json:"iptc,omitempty"`
+	ICC      *ICCSummary `json:"icc,omitempty"`
+	Raw      SidecarRaw  `json:"raw"`
+}
+
+// SidecarRaw holds the metadata blocks of a sidecar. They are what
+// ReadSidecar restores; the summaries are informational.
+type SidecarRaw struct {
+	EXIF []byte `json:"exif,omitempty"` // with the "Exif\0\0" prefix, base64
+	XMP  string `json:"xmp,omitempty"`
+	IPTC []byte `json:"iptc,omitempty"` // IIM datasets, base64
+}
+
+// NewSidecar describes the metadata and ICC profile of the image source.
+// Either may be nil; blocks that cannot be decoded are kept raw only.
+func NewSidecar(source string, meta *Metadata, icc []byte) *Sidecar {
+	s := &Sidecar{Source: source}
+	if meta != nil {
+		s.Raw = SidecarRaw{EXIF: meta.EXIFRaw, XMP: string(meta.XMP), IPTC: meta.IPTC}
+	}
+	if e, err := meta.EXIF(); err == nil {
+		s.EXIF, s.EXIFTags = e.Summary(), e.Fields()
+	}
+	if f, err := meta.XMPFields(); err == nil && !f.IsZero() {
+		s.XMP = &f
+	}
+	if f, err := meta.IPTCFields(); err == nil && !f.IsZero() {
+		s.IPTC = &f
+	}
+	if len(icc) > 0 {
+		s.ICC, _ = ParseICC(icc)
+	}
+	return s
+}
+
+// Metadata returns the raw metadata of the sidecar.
+func (s *Sidecar) Metadata() *Metadata {
+	m := &Metadata{EXIFRaw: s.Raw.EXIF, IPTC: s.Raw.IPTC}
+	if s.Raw.XMP != "" {
+		m.XMP = []byte(s.Raw.XMP)
+	}
+	return m
+}
+
+// Marshal renders the sidecar. A JSON sidecar holds everything. An XMP
+// sidecar holds the XMP packet with the IPTC fields mapped to Dublin Core
+// and the common EXIF fields and the ICC profile name added as tiff:,
+// exif:, aux: and photoshop: properties; the remaining EXIF and IPTC data
+// is not representable and is left out.
+func (s *Sidecar) Marshal(format SidecarFormat) ([]byte, error) {
+	if format == SidecarJSON {
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+
+	meta, err := s.Metadata().WithIPTCInXMP()
+	if err != nil {
+		return nil, err
+	}
+	// EXIF attribution fills the Dublin Core properties the packet lacks
+	existing, err := meta.XMPFields()
+	if err != nil {
+		return nil, err
+	}
+	var dc XMPFields
+	if s.EXIF != nil {
+		if existing.Description == "" {
+			dc.Description = s.EXIF.Description
+		}
+		if existing.Creator == "" {
+			dc.Creator = s.EXIF.Artist
+		}
+		if existing.Rights == "" {
+			dc.Rights = s.EXIF.Copyright
+		}
+	}
+	if meta, err = meta.WithXMPFields(dc); err != nil {
+		return nil, err
+	}
+
+	props := s.xmpProperties()
+	return rewriteXMP(meta.XMP, func(name xml.Name) bool {
+		for _, p := range props {
+			if p.ns == name.Space && p.name == name.Local {
+				return true
+			}
+		}
+		return false
+	}, xmpDescription(props))
+}
+
+// Write writes the sidecar of the image at path.
+func (s *Sidecar) Write(path string, format SidecarFormat) error {
+	data, err := s.Marshal(format)
+	if err != nil {
+		return fmt.Errorf("sidecar: %w", err)
+	}
+	return os.WriteFile(SidecarPath(path, format), data, 0644)
+}
+
+// ReadSidecar reads the metadata of a sidecar file, choosing the format
+// by its extension. JSON sidecars restore EXIF, XMP and IPTC; XMP
+// sidecars give the XMP packet.
+func ReadSidecar(path string) (*Metadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := xml.Unmarshal(data, new(struct{})); err != nil {
+			return nil, fmt.Errorf("sidecar %s: invalid XMP: %w", path, err)
+		}
+		return &Metadata{XMP: data}, nil
+	}
+	var s Sidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("sidecar %s: %w", path, err)
+	}
+	return s.Metadata(), nil
+}
+
+// Namespaces of the EXIF properties of XMP sidecars.
+const (
+	nsTIFF      = "http://ns.adobe.com/tiff/1.0/"
+	nsEXIF      = "http://ns.adobe.com/exif/1.0/"
+	nsAux       = "http://ns.adobe.com/exif/1.0/aux/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+// xmpPrefixes maps the sidecar namespaces to their usual prefixes.
+var xmpPrefixes = map[string]string{nsTIFF: "tiff", nsEXIF: "exif", nsAux: "aux", nsPhotoshop: "photoshop"}
+
+// xmpProperty is a simple or rdf:Seq valued XMP property.
+type xmpProperty struct {
+	ns, name, value string
+	seq             bool
+}
+
+// xmpProperties maps the EXIF summary and the ICC profile name to XMP
+// properties, following the XMP specification part 2.
+func (s *Sidecar) xmpProperties() []xmpProperty {
+	var props []xmpProperty
+	add := func(ns, name, value string) {
+		if value != "" {
+			props = append(props, xmpProperty{ns: ns, name: name, value: value})
+		}
+	}
+	if e := s.EXIF; e != nil {
+		add(nsTIFF, "Make", e.Make)
+		add(nsTIFF, "Model", e.Model)
+		if e.Orientation > 0 {
+			add(nsTIFF, "Orientation", strconv.Itoa(e.Orientation))
+		}
+		if e.DateTimeOriginal != nil {
+			add(nsEXIF, "DateTimeOriginal", e.DateTimeOriginal.Format(time.RFC3339))
+		}
+		add(nsEXIF, "ExposureTime", e.ExposureTime)
+		add(nsEXIF, "FNumber", xmpRational(e.FNumber))
+		add(nsEXIF, "FocalLength", xmpRational(e.FocalLength))
+		if e.ISO > 0 {
+			props = append(props, xmpProperty{ns: nsEXIF, name: "ISOSpeedRatings", value: strconv.Itoa(e.ISO), seq: true})
+		}
+		if e.PixelWidth > 0 && e.PixelHeight > 0 {
+			add(nsEXIF, "PixelXDimension", strconv.Itoa(e.PixelWidth))
+			add(nsEXIF, "PixelYDimension", strconv.Itoa(e.PixelHeight))
+		}
+		if e.GPS != nil {
+			add(nsEXIF, "GPSLatitude", xmpCoordinate(e.GPS.Latitude, "N", "S"))
+			add(nsEXIF, "GPSLongitude", xmpCoordinate(e.GPS.Longitude, "E", "W"))
+		}
+		add(nsAux, "Lens", e.LensModel)
+		add(nsAux, "SerialNumber", e.BodySerialNumber)
+	}
+	if s.ICC != nil {
+		add(nsPhotoshop, "ICCProfile", s.ICC.Description)
+	}
+	return props
+}
+
+// xmpRational formats a value in tenths as an XMP rational.
+func xmpRational(v float64) string {
+	if v <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/10", int(math.Round(v*10)))
+}
+
+// xmpCoordinate formats a GPS coordinate as "DDD,MM.mmmmK".
+func xmpCoordinate(v float64, pos, neg string) string {
+	ref := pos
+	if v < 0 {
+		ref, v = neg, -v
+	}
+	deg := math.Floor(v)
+	return fmt.Sprintf("%d,%.4f%s", int(deg), (v-deg)*60, ref)
+}
+
+// xmpDescription renders the properties as a self-contained
+// rdf:Description, or returns "" if there are none.
+func xmpDescription(props []xmpProperty) string {
+	if len(props) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`  <rdf:Description rdf:about="" xmlns:rdf="` + nsRDF + `"`)
+	for _, ns := range []string{nsTIFF, nsEXIF, nsAux, nsPhotoshop} {
+		fmt.Fprintf(&b, ` xmlns:%s="%s"`, xmpPrefixes[ns], ns)
+	}
+	b.WriteString(">\n")
+	for _, p := range props {
+		name := xmpPrefixes[p.ns] + ":" + p.name
+		fmt.Fprintf(&b, "   <%s>", name)
+		if p.seq {
+			b.WriteString("<rdf:Seq><rdf:li>")
+		}
+		xml.EscapeText(&b, []byte(p.value))
+		if p.seq {
+			b.WriteString("</rdf:li></rdf:Seq>")
+		}
+		fmt.Fprintf(&b, "</%s>\n", name)
+	}
+	b.WriteString("  </rdf:Description>\n")
+	return b.String()
+}
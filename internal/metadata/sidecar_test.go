@@ -0,0 +1,203 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+// buildICC returns a minimal display profile with a v2 "desc" or v4
+// "mluc" description tag.
+func buildICC(desc string, v4 bool) []byte {
+	var tag []byte
+	if v4 {
+		units := utf16.Encode([]rune(desc))
+		tag = append([]byte("mluc\x00\x00\x00\x00"), 0, 0, 0, 1, 0, 0, 0, 12, 'e', 'n', 'U', 'S')
+		tag = binary.BigEndian.AppendUint32(tag, uint32(2*len(units)))
+		tag = binary.BigEndian.AppendUint32(tag, 28)
+		for _, u := range units {
+			tag = binary.BigEndian.AppendUint16(tag, u)
+		}
+	} else {
+		tag = append([]byte("desc\x00\x00\x00\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(desc)+1))...)
+		tag = append(append(tag, desc...), 0)
+	}
+
+	icc := make([]byte, 128)
+	copy(icc[12:], "mntrRGB XYZ ")
+	copy(icc[36:], "acsp")
+	icc[8], icc[9] = 2, 0x10
+	if v4 {
+		icc[8], icc[9] = 4, 0x30
+	}
+	icc = binary.BigEndian.AppendUint32(icc, 1)
+	icc = append(icc, "desc"...)
+	icc = binary.BigEndian.AppendUint32(icc, 144)
+	icc = binary.BigEndian.AppendUint32(icc, uint32(len(tag)))
+	icc = append(icc, tag...)
+	binary.BigEndian.PutUint32(icc, uint32(len(icc)))
+	return icc
+}
+
+func TestParseICC(t *testing.T) {
+	for _, v4 := range []bool{false, true} {
+		s, err := ParseICC(buildICC("sRGB IEC61966-2.1", v4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &ICCSummary{Description: "sRGB IEC61966-2.1", Version: "2.1", Class: "mntr", ColorSpace: "RGB", PCS: "XYZ", Size: s.Size}
+		if v4 {
+			want.Version = "4.3"
+		}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("v4=%v: summary = %+v, want %+v", v4, s, want)
+		}
+	}
+	if _, err := ParseICC(make([]byte, 200)); err == nil {
+		t.Error("expected error without acsp signature")
+	}
+}
+
+func TestExtractICC(t *testing.T) {
+	icc := buildICC("Display P3", true)
+
+	// JPEG: two APP2 chunks, stored out of order
+	half := len(icc) / 2
+	jpeg := buildTestJPEGNoExif()
+	seg := func(seq byte, data []byte) []byte {
+		return buildSegment(0xE2, append(append(bytes.Clone(iccHeader), seq, 2), data...))
+	}
+	jpeg = append(jpeg[:2:2], append(append(seg(2, icc[half:]), seg(1, icc[:half])...), jpeg[2:]...)...)
+
+	// PNG: iCCP after IHDR
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage())
+	chunks, _ := parsePNGChunks(buf.Bytes())
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(icc)
+	w.Close()
+	pngData := bytes.NewBuffer(append([]byte(nil), pngSignature...))
+	pngData.Write(chunks[0].raw)
+	pngData.Write(makePNGChunk("iCCP", append([]byte("P3\x00\x00"), z.Bytes()...)))
+	for _, c := range chunks[1:] {
+		pngData.Write(c.raw)
+	}
+
+	webp := writeWebP([]riffChunk{{fourCC: "ICCP", data: icc}, vp8lChunk(4, 3, false)})
+
+	for format, data := range map[codec.Format][]byte{codec.JPEG: jpeg, codec.PNG: pngData.Bytes(), codec.WebP: webp} {
+		got, err := ExtractICC(bytes.NewReader(data), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !bytes.Equal(got, icc) {
+			t.Errorf("%s: profile not extracted", format)
+		}
+	}
+	if got, err := ExtractICC(bytes.NewReader(buildTestJPEGNoExif()), codec.JPEG); err != nil || got != nil {
+		t.Errorf("JPEG without profile = %v, %v", got, err)
+	}
+}
+
+func sidecarMetadata(t *testing.T) *Metadata {
+	t.Helper()
+	m, err := (&Metadata{EXIFRaw: sampleEXIF(binary.LittleEndian), XMP: []byte(samplePacket)}).
+		WithIPTCFields(IPTCFields{Caption: "Boats at dawn", Keywords: []string{"sea"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSidecar_JSONRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "photo.webp")
+	meta := sidecarMetadata(t)
+	s := NewSidecar("photo.jpg", meta, buildICC("sRGB", false))
+	if s.EXIF.Make != "Canon" || s.IPTC.Caption != "Boats at dawn" || s.XMP.Title != "Harbor" || s.ICC.Description != "sRGB" {
+		t.Errorf("summaries = %+v %+v %+v %+v", s.EXIF, s.IPTC, s.XMP, s.ICC)
+	}
+	if err := s.Write(image, SidecarJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := FindSidecar(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != image+".json" {
+		t.Errorf("sidecar path = %s", path)
+	}
+	got, err := ReadSidecar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Error("metadata changed in the round trip")
+	}
+}
+
+func TestSidecar_XMP(t *testing.T) {
+	// The source packet already has a tiff:Make, which is replaced
+	packet := strings.Replace(samplePacket, "<xmp:Rating>4</xmp:Rating>",
+		`<xmp:Rating>4</xmp:Rating><tiff:Make xmlns:tiff="`+nsTIFF+`">Old</tiff:Make>`, 1)
+	meta := sidecarMetadata(t)
+	meta.XMP = []byte(packet)
+
+	data, err := NewSidecar("photo.jpg", meta, buildICC("sRGB", false)).Marshal(SidecarXMP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := (&Metadata{XMP: data}).XMPFields()
+	if err != nil {
+		t.Fatalf("invalid packet: %v", err)
+	}
+	// IPTC and EXIF attribution fill the properties the packet lacks
+	want := XMPFields{Title: "Harbor", Description: "Boats at dawn", Creator: "Ann; Bo", Rights: "(c) Jane Doe", Keywords: []string{"sea"}}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("fields = %+v, want %+v", f, want)
+	}
+	out := string(data)
+	for _, s := range []string{
+		"<tiff:Make>Canon</tiff:Make>",
+		"<exif:FNumber>28/10</exif:FNumber>",
+		"<exif:ISOSpeedRatings><rdf:Seq><rdf:li>400</rdf:li></rdf:Seq></exif:ISOSpeedRatings>",
+		"<exif:GPSLatitude>48,51.5000N</exif:GPSLatitude>",
+		"<aux:Lens>RF50mm F1.8 STM</aux:Lens>",
+		"<photoshop:ICCProfile>sRGB</photoshop:ICCProfile>",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("sidecar lacks %s", s)
+		}
+	}
+	if strings.Contains(out, "Old") {
+		t.Error("old tiff:Make was kept")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.xmp")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Editors name sidecars after the image without its extension
+	if found, err := FindSidecar(filepath.Join(dir, "photo.jpg")); err != nil || found != path {
+		t.Fatalf("FindSidecar = %s, %v", found, err)
+	}
+	got, err := ReadSidecar(path)
+	if err != nil || !bytes.Equal(got.XMP, data) || got.HasEXIF() {
+		t.Errorf("ReadSidecar = %+v, %v", got, err)
+	}
+	if _, err := FindSidecar(filepath.Join(dir, "other.jpg")); err == nil {
+		t.Error("expected ErrNoSidecar")
+	}
+}
@@ -0,0 +1,189 @@
+package metadata
+
+import (
+	"fmt"
+	"time"
+)
+
+// Summary holds the commonly used EXIF fields in typed form. Zero values
+// mean the tag is absent.
+type Summary struct {
+	Make             string     `json:"make,omitempty"`
+	Model            string     `json:"model,omitempty"`
+	LensMake         string     `json:"lens_make,omitempty"`
+	LensModel        string     `json:"lens_model,omitempty"`
+	Software         string     `json:"software,omitempty"`
+	Artist           string     `json:"artist,omitempty"`
+	Copyright        string     `json:"copyright,omitempty"`
+	Description      string     `json:"description,omitempty"`
+	BodySerialNumber string     `json:"body_serial_number,omitempty"`
+	LensSerialNumber string     `json:"lens_serial_number,omitempty"`
+	Orientation      int        `json:"orientation,omitempty"`
+	DateTimeOriginal *time.Time `json:"date_time_original,omitempty"`
+	ExposureTime     string     `json:"exposure_time,omitempty"` // e.g. "1/250"
+	FNumber          float64    `json:"f_number,omitempty"`
+	ISO              int        `json:"iso,omitempty"`
+	FocalLength      float64    `json:"focal_length_mm,omitempty"`
+	FocalLength35mm  int        `json:"focal_length_35mm,omitempty"`
+	ExposureBias     float64    `json:"exposure_bias_ev,omitempty"`
+	Flash            *bool      `json:"flash_fired,omitempty"`
+	PixelWidth       int        `json:"pixel_width,omitempty"`
+	PixelHeight      int        `json:"pixel_height,omitempty"`
+	GPS              *GPS       `json:"gps,omitempty"`
+}
+
+// GPS is a decoded GPS position.
+type GPS struct {
+	Latitude  float64    `json:"latitude"`
+	Longitude float64    `json:"longitude"`
+	Altitude  *float64   `json:"altitude_m,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+// exifTimeLayout is the EXIF date/time format.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// Summary extracts the common fields. Capture times carry the
+// OffsetTimeOriginal zone when present and are otherwise in UTC.
+func (e *EXIF) Summary() *Summary {
+	s := &Summary{
+		Make:             e.str(IFD0, TagMake),
+		Model:            e.str(IFD0, TagModel),
+		Software:         e.str(IFD0, TagSoftware),
+		Artist:           e.str(IFD0, TagArtist),
+		Copyright:        e.str(IFD0, TagCopyright),
+		Description:      e.str(IFD0, TagImageDescription),
+		LensMake:         e.str(IFDExif, TagLensMake),
+		LensModel:        e.str(IFDExif, TagLensModel),
+		BodySerialNumber: e.str(IFDExif, TagBodySerialNumber),
+		LensSerialNumber: e.str(IFDExif, TagLensSerialNumber),
+		Orientation:      e.int(IFD0, TagOrientation),
+		ISO:              e.int(IFDExif, TagISO),
+		FocalLength35mm:  e.int(IFDExif, TagFocalLength35mm),
+		PixelWidth:       e.int(IFDExif, TagPixelXDimension),
+		PixelHeight:      e.int(IFDExif, TagPixelYDimension),
+		FNumber:          e.float(IFDExif, TagFNumber),
+		FocalLength:      e.float(IFDExif, TagFocalLength),
+		ExposureBias:     e.float(IFDExif, TagExposureBias),
+	}
+
+	if t, ok := e.Lookup(IFDExif, TagExposureTime); ok {
+		s.ExposureTime = formatExposure(t)
+	}
+	if t, ok := e.Lookup(IFDExif, TagFlash); ok {
+		if v, ok := t.Uint(0); ok {
+			fired := v&1 == 1
+			s.Flash = &fired
+		}
+	}
+
+	captured := e.str(IFDExif, TagDateTimeOriginal)
+	if captured == "" {
+		captured = e.str(IFD0, TagDateTime)
+	}
+	if captured != "" {
+		loc := time.UTC
+		if off := e.str(IFDExif, TagOffsetTimeOriginal); off != "" {
+			if t, err := time.Parse("-07:00", off); err == nil {
+				loc = t.Location()
+			}
+		}
+		if t, err := time.ParseInLocation(exifTimeLayout, captured, loc); err == nil {
+			s.DateTimeOriginal = &t
+		}
+	}
+
+	s.GPS = e.gps()
+	return s
+}
+
+func (e *EXIF) str(ifd string, id uint16) string {
+	t, ok := e.Lookup(ifd, id)
+	if !ok {
+		return ""
+	}
+	return t.String()
+}
+
+func (e *EXIF) int(ifd string, id uint16) int {
+	t, ok := e.Lookup(ifd, id)
+	if !ok {
+		return 0
+	}
+	v, _ := t.Uint(0)
+	return int(v)
+}
+
+func (e *EXIF) float(ifd string, id uint16) float64 {
+	t, ok := e.Lookup(ifd, id)
+	if !ok {
+		return 0
+	}
+	v, _ := t.Float(0)
+	return v
+}
+
+// formatExposure renders an exposure time the way cameras display it:
+// "1/250" below a second, "2.5" above.
+func formatExposure(t Tag) string {
+	num, den, ok := t.Rational(0)
+	if !ok || num <= 0 || den <= 0 {
+		return ""
+	}
+	if num >= den {
+		return fmt.Sprintf("%g", float64(num)/float64(den))
+	}
+	return fmt.Sprintf("1/%g", float64(den)/float64(num))
+}
+
+// gps decodes the GPS position, or returns nil if there is none.
+func (e *EXIF) gps() *GPS {
+	lat, okLat := e.coordinate(TagGPSLatitude, TagGPSLatitudeRef, "S")
+	lon, okLon := e.coordinate(TagGPSLongitude, TagGPSLongitudeRef, "W")
+	if !okLat || !okLon {
+		return nil
+	}
+	g := &GPS{Latitude: lat, Longitude: lon}
+
+	if t, ok := e.Lookup(IFDGPS, TagGPSAltitude); ok {
+		if alt, ok := t.Float(0); ok {
+			if e.int(IFDGPS, TagGPSAltitudeRef) == 1 {
+				alt = -alt
+			}
+			g.Altitude = &alt
+		}
+	}
+
+	if date := e.str(IFDGPS, TagGPSDateStamp); date != "" {
+		if ts, ok := e.Lookup(IFDGPS, TagGPSTimeStamp); ok {
+			h, _ := ts.Float(0)
+			m, _ := ts.Float(1)
+			sec, _ := ts.Float(2)
+			if d, err := time.Parse("2006:01:02", date); err == nil {
+				t := d.Add(time.Duration(h*float64(time.Hour) + m*float64(time.Minute) + sec*float64(time.Second)))
+				g.Time = &t
+			}
+		}
+	}
+	return g
+}
+
+// coordinate decodes a degrees/minutes/seconds GPS coordinate, negated
+// when the reference tag equals neg.
+func (e *EXIF) coordinate(id, refID uint16, neg string) (float64, bool) {
+	t, ok := e.Lookup(IFDGPS, id)
+	if !ok {
+		return 0, false
+	}
+	d, ok1 := t.Float(0)
+	m, ok2 := t.Float(1)
+	sec, ok3 := t.Float(2)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, false
+	}
+	v := d + m/60 + sec/3600
+	if e.str(IFDGPS, refID) == neg {
+		v = -v
+	}
+	return v, true
+}
@@ -0,0 +1,132 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// tagXMP holds the XMP packet of a TIFF file (type BYTE).
+const tagXMP = 0x02BC
+
+// isTIFFLayoutTag reports whether an IFD0 tag describes how the image
+// data of a TIFF file is stored. Such tags are meaningless outside their
+// file and are dropped from standalone EXIF blocks.
+func isTIFFLayoutTag(id uint16) bool {
+	switch {
+	case id >= 0x00FE && id <= 0x010A: // subfile type, dimensions, compression, photometric, fill order
+		return true
+	case id == 0x0111 || id >= 0x0115 && id <= 0x0119: // strips, samples per pixel, sample values
+		return true
+	case id >= 0x0122 && id <= 0x0125: // gray response, T4/T6 options
+		return true
+	case id >= 0x0140 && id <= 0x0145: // color map, halftone hints, tiles
+		return true
+	case id >= 0x0200 && id <= 0x0214: // old-style JPEG and YCbCr layout
+		return true
+	case id >= 0xC612: // DNG raw data description
+		return true
+	}
+	switch id {
+	case 0x011C, 0x013D, 0x014A, 0x0152, 0x0153: // planar config, predictor, SubIFDs, extra samples, sample format
+		return true
+	case 0x8773: // ICC profile of the source pixels
+		return true
+	case tagExifIFD, tagGPSIFD:
+		return true
+	}
+	return false
+}
+
+// standalone returns the EXIF of a TIFF-based image file reduced to a
+// self-contained block: the descriptive IFD0 tags with the Exif, GPS and
+// Interop directories. Image layout tags, the XMP packet and IPTC data
+// (carried in Metadata.XMP and IPTC), further image directories and the
+// thumbnail are dropped.
+func (e *EXIF) standalone() *EXIF {
+	out := &EXIF{ByteOrder: e.ByteOrder, Exif: e.Exif, GPS: e.GPS, Interop: e.Interop}
+	for _, t := range e.IFD0 {
+		if !isTIFFLayoutTag(t.ID) && t.ID != tagXMP && t.ID != tagIPTC {
+			out.IFD0 = append(out.IFD0, t)
+		}
+	}
+	return out
+}
+
+// injectIntoTIFF merges the metadata into a TIFF file. IFD0 of the file
+// is rewritten at the end with the source's descriptive tags added (the
+// file's own tags win), the XMP packet in tag 700 and the IPTC data in
+// tag 33723, and the source's Exif, GPS and Interop directories are
+// appended in the file's byte order. The image data is not moved; the
+// source thumbnail is dropped.
+func injectIntoTIFF(data []byte, meta *Metadata) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("output is not a valid TIFF")
+	}
+	var bo binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("output is not a valid TIFF")
+	}
+	if bo.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("output is not a valid TIFF")
+	}
+
+	src := &EXIF{ByteOrder: bo}
+	if meta.HasEXIF() {
+		var err error
+		if src, err = ParseEXIF(meta.EXIFRaw); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &ifdParser{tiff: data, order: bo, seen: make(map[uint32]bool)}
+	ifd0, next, err := p.parse(bo.Uint32(data[4:]))
+	if err != nil {
+		return nil, fmt.Errorf("output TIFF: %w", err)
+	}
+
+	merged := &EXIF{ByteOrder: bo}
+	present := make(map[uint16]bool)
+	for _, t := range ifd0 {
+		if meta.HasEXIF() && (t.ID == tagExifIFD || t.ID == tagGPSIFD) {
+			continue // replaced by the source directories
+		}
+		if meta.HasXMP() && t.ID == tagXMP || meta.HasIPTC() && t.ID == tagIPTC {
+			continue
+		}
+		merged.IFD0 = append(merged.IFD0, t)
+		present[t.ID] = true
+	}
+	for _, t := range src.standalone().IFD0 {
+		if !present[t.ID] {
+			merged.IFD0 = append(merged.IFD0, t.withOrder(bo))
+		}
+	}
+	if meta.HasXMP() {
+		merged.IFD0 = append(merged.IFD0, Tag{ID: tagXMP, Type: TypeByte, Count: uint32(len(meta.XMP)), Data: meta.XMP, order: bo})
+	}
+	if meta.HasIPTC() {
+		merged.IFD0 = append(merged.IFD0, Tag{ID: tagIPTC, Type: TypeUndefined, Count: uint32(len(meta.IPTC)), Data: meta.IPTC, order: bo})
+	}
+	convert := func(tags []Tag) []Tag {
+		out := make([]Tag, len(tags))
+		for i, t := range tags {
+			out[i] = t.withOrder(bo)
+		}
+		return out
+	}
+	merged.Exif = convert(src.Exif)
+	merged.GPS = convert(src.GPS)
+	merged.Interop = convert(src.Interop)
+
+	w := &ifdWriter{order: bo, buf: slices.Clip(data)}
+	ifd0Off, next0 := w.writeTree(merged)
+	w.patch(next0, int(next))
+	w.patch(4, ifd0Off)
+	return w.buf, nil
+}
@@ -0,0 +1,172 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// VP8X feature flags.
+const (
+	vp8xAlpha = 0x10
+	vp8xEXIF  = 0x08
+	vp8xXMP   = 0x04
+)
+
+// riffChunk is a chunk of a WebP file.
+type riffChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// parseWebPChunks splits a WebP file into its RIFF chunks.
+func parseWebPChunks(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("output is not a valid WebP")
+	}
+	end := 8 + int(binary.LittleEndian.Uint32(data[4:]))
+	if end > len(data) {
+		end = len(data)
+	}
+	var chunks []riffChunk
+	for off := 12; off+8 <= end; {
+		n := int(binary.LittleEndian.Uint32(data[off+4:]))
+		if off+8+n > end {
+			return nil, fmt.Errorf("WebP chunk %q at %d is truncated", data[off:off+4], off)
+		}
+		chunks = append(chunks, riffChunk{fourCC: string(data[off : off+4]), data: data[off+8 : off+8+n]})
+		off += 8 + n + n%2
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("WebP has no chunks")
+	}
+	return chunks, nil
+}
+
+// webpCanvas returns the canvas size and alpha flag of a simple-format
+// (VP8 or VP8L) WebP bitstream.
+func webpCanvas(c riffChunk) (width, height int, alpha bool, err error) {
+	switch c.fourCC {
+	case "VP8 ":
+		// 3-byte frame tag, start code 9d 01 2a, then 14-bit dimensions
+		if len(c.data) < 10 || !bytes.Equal(c.data[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, false, fmt.Errorf("invalid VP8 bitstream")
+		}
+		width = int(binary.LittleEndian.Uint16(c.data[6:]) & 0x3FFF)
+		height = int(binary.LittleEndian.Uint16(c.data[8:]) & 0x3FFF)
+		return width, height, false, nil
+	case "VP8L":
+		// Signature 0x2f, then 14-bit width-1, 14-bit height-1, alpha bit
+		if len(c.data) < 5 || c.data[0] != 0x2f {
+			return 0, 0, false, fmt.Errorf("invalid VP8L bitstream")
+		}
+		bits := binary.LittleEndian.Uint32(c.data[1:])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, bits>>28&1 == 1, nil
+	}
+	return 0, 0, false, fmt.Errorf("unexpected WebP chunk %q", c.fourCC)
+}
+
+// injectIntoWebP stores the metadata in the EXIF and "XMP " chunks of a
+// WebP file, converting a simple-format file to the extended (VP8X)
+// format first. Existing chunks of the kinds meta carries are replaced;
+// the metadata chunks go last, EXIF before XMP, as the container
+// specification orders them.
+func injectIntoWebP(data []byte, meta *Metadata) ([]byte, error) {
+	chunks, err := parseWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if chunks[0].fourCC != "VP8X" {
+		width, height, alpha, err := webpCanvas(chunks[0])
+		if err != nil {
+			return nil, err
+		}
+		vp8x := make([]byte, 10)
+		if alpha {
+			vp8x[0] = vp8xAlpha
+		}
+		putUint24LE(vp8x[4:], width-1)
+		putUint24LE(vp8x[7:], height-1)
+		chunks = append([]riffChunk{{fourCC: "VP8X", data: vp8x}}, chunks...)
+	} else if len(chunks[0].data) < 10 {
+		return nil, fmt.Errorf("WebP VP8X chunk is truncated")
+	}
+
+	var exif, xmp *riffChunk
+	if meta.HasEXIF() {
+		exif = &riffChunk{fourCC: "EXIF", data: meta.TIFF()}
+	}
+	if meta.HasXMP() {
+		xmp = &riffChunk{fourCC: "XMP ", data: meta.XMP}
+	}
+	header := append([]byte(nil), chunks[0].data...)
+	out := []riffChunk{{fourCC: "VP8X", data: header}}
+	for _, c := range chunks[1:] {
+		switch c.fourCC {
+		case "EXIF":
+			if exif == nil {
+				exif = &c
+			}
+		case "XMP ":
+			if xmp == nil {
+				xmp = &c
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	header[0] &^= vp8xEXIF | vp8xXMP
+	if exif != nil {
+		header[0] |= vp8xEXIF
+		out = append(out, *exif)
+	}
+	if xmp != nil {
+		header[0] |= vp8xXMP
+		out = append(out, *xmp)
+	}
+
+	return writeWebP(out), nil
+}
+
+// writeWebP serializes RIFF chunks as a WebP file.
+func writeWebP(chunks []riffChunk) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		buf.WriteString(c.fourCC)
+		buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(c.data))))
+		buf.Write(c.data)
+		if len(c.data)%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func putUint24LE(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// extractFromWebP returns the data of the EXIF and "XMP " chunks. Some
+// writers keep the "Exif\0\0" prefix in the EXIF chunk; it is accepted.
+func extractFromWebP(data []byte) (*Metadata, error) {
+	chunks, err := parseWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var exif, xmp []byte
+	for _, c := range chunks {
+		switch {
+		case c.fourCC == "EXIF" && exif == nil:
+			if exif, err = wrapTIFF(c.data); err != nil {
+				return nil, err
+			}
+		case c.fourCC == "XMP " && xmp == nil:
+			xmp = c.data
+		}
+	}
+	return found("WebP", &Metadata{EXIFRaw: exif, XMP: xmp})
+}
@@ -0,0 +1,281 @@
+package metadata
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// XMP namespaces.
+const (
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+	nsXMP = "http://ns.adobe.com/xap/1.0/"
+)
+
+// xmpMIME is the content type of XMP items in HEIF/AVIF files.
+const xmpMIME = "application/rdf+xml"
+
+// XMPFields are the common Dublin Core properties of an XMP packet.
+type XMPFields struct {
+	Title       string   `json:"title,omitempty"`       // dc:title (x-default)
+	Description string   `json:"description,omitempty"` // dc:description (x-default)
+	Creator     string   `json:"creator,omitempty"`     // dc:creator
+	Rights      string   `json:"rights,omitempty"`      // dc:rights (x-default)
+	Keywords    []string `json:"keywords,omitempty"`    // dc:subject
+}
+
+// IsZero reports whether no field is set.
+func (f XMPFields) IsZero() bool {
+	return f.Title == "" && f.Description == "" && f.Creator == "" && f.Rights == "" && len(f.Keywords) == 0
+}
+
+// properties returns the dc properties set in f, in packet order.
+func (f XMPFields) properties() []string {
+	var props []string
+	if f.Title != "" {
+		props = append(props, "title")
+	}
+	if f.Description != "" {
+		props = append(props, "description")
+	}
+	if f.Creator != "" {
+		props = append(props, "creator")
+	}
+	if f.Rights != "" {
+		props = append(props, "rights")
+	}
+	if len(f.Keywords) > 0 {
+		props = append(props, "subject")
+	}
+	return props
+}
+
+// HasXMP returns true if an XMP packet is present.
+func (m *Metadata) HasXMP() bool {
+	return m != nil && len(m.XMP) > 0
+}
+
+// XMPFields reads the common properties of the XMP packet.
+func (m *Metadata) XMPFields() (XMPFields, error) {
+	var f XMPFields
+	if !m.HasXMP() {
+		return f, nil
+	}
+	d := xml.NewDecoder(bytes.NewReader(m.XMP))
+	var prop string // dc property being read
+	var items []string
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return f, fmt.Errorf("invalid XMP: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == nsDC {
+				prop, items = t.Name.Local, nil
+			}
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			switch {
+			case prop != "" && t.Name.Space == nsRDF && t.Name.Local == "li":
+				items = append(items, strings.TrimSpace(text.String()))
+			case t.Name.Space == nsDC && t.Name.Local == prop:
+				if len(items) == 0 {
+					items = []string{strings.TrimSpace(text.String())}
+				}
+				switch prop {
+				case "title":
+					f.Title = items[0]
+				case "description":
+					f.Description = items[0]
+				case "creator":
+					f.Creator = strings.Join(items, "; ")
+				case "rights":
+					f.Rights = items[0]
+				case "subject":
+					f.Keywords = items
+				}
+				prop = ""
+			}
+		}
+	}
+}
+
+// WithXMPFields returns a copy of m whose XMP packet has the properties
+// set in f, replacing existing values. Without a packet a new one is
+// created. A zero f returns m unchanged.
+func (m *Metadata) WithXMPFields(f XMPFields) (*Metadata, error) {
+	if f.IsZero() {
+		return m, nil
+	}
+	out := &Metadata{}
+	if m != nil {
+		*out = *m
+	}
+	packet, err := mergeXMP(out.XMP, f)
+	if err != nil {
+		return nil, err
+	}
+	out.XMP = packet
+	return out, nil
+}
+
+// mergeXMP removes the dc properties set in f from packet and adds them in
+// a new rdf:Description. The rest of the packet is kept byte for byte.
+func mergeXMP(packet []byte, f XMPFields) ([]byte, error) {
+	replace := f.properties()
+	return rewriteXMP(packet, func(name xml.Name) bool {
+		return name.Space == nsDC && slices.Contains(replace, name.Local)
+	}, f.description())
+}
+
+// rewriteXMP removes the properties matched by drop from packet, in
+// element or attribute form, and inserts desc, an rdf:Description, at the
+// end of rdf:RDF. Without a packet a new one is created.
+func rewriteXMP(packet []byte, drop func(xml.Name) bool, desc string) ([]byte, error) {
+	if len(packet) == 0 {
+		packet = []byte(`<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+	}
+	type span struct{ start, end int64 }
+	var dropped []span
+	var dropStart int64 = -1
+	var dropDepth, depth int
+	rdfEnd := int64(-1)
+	var scopes []map[string]string // namespace prefixes declared per element
+
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	for {
+		pos := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XMP: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			scope := make(map[string]string)
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					scope[a.Name.Local] = a.Value
+				}
+			}
+			scopes = append(scopes, scope)
+			if dropStart >= 0 {
+				break
+			}
+			if drop(t.Name) {
+				dropStart, dropDepth = pos, depth
+				break
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == "" || a.Name.Space == "xmlns" || !drop(a.Name) {
+					continue
+				}
+				for _, prefix := range xmlPrefixes(scopes, a.Name.Space) {
+					attr := regexp.MustCompile(`\s+` + regexp.QuoteMeta(prefix+":"+a.Name.Local) + `\s*=\s*("[^"]*"|'[^']*')`)
+					if loc := attr.FindIndex(packet[pos:d.InputOffset()]); loc != nil {
+						dropped = append(dropped, span{pos + int64(loc[0]), pos + int64(loc[1])})
+					}
+				}
+			}
+		case xml.EndElement:
+			if dropStart >= 0 && depth == dropDepth {
+				dropped = append(dropped, span{dropStart, d.InputOffset()})
+				dropStart = -1
+			}
+			if t.Name.Space == nsRDF && t.Name.Local == "RDF" {
+				rdfEnd = pos
+			}
+			scopes = scopes[:len(scopes)-1]
+			depth--
+		}
+	}
+	if rdfEnd < 0 {
+		return nil, fmt.Errorf("invalid XMP: no rdf:RDF element")
+	}
+
+	slices.SortFunc(dropped, func(a, b span) int { return cmp.Compare(a.start, b.start) })
+	var buf bytes.Buffer
+	var last int64
+	for _, s := range dropped {
+		buf.Write(packet[last:s.start])
+		last = s.end
+	}
+	buf.Write(packet[last:rdfEnd])
+	buf.WriteString(desc)
+	buf.Write(packet[rdfEnd:])
+	return buf.Bytes(), nil
+}
+
+// xmlPrefixes returns the prefixes bound to the namespace uri in the
+// innermost scope of scopes.
+func xmlPrefixes(scopes []map[string]string, uri string) []string {
+	var prefixes []string
+	seen := make(map[string]bool)
+	for i := len(scopes) - 1; i >= 0; i-- {
+		for prefix, ns := range scopes[i] {
+			if !seen[prefix] && ns == uri {
+				prefixes = append(prefixes, prefix)
+			}
+			seen[prefix] = true
+		}
+	}
+	return prefixes
+}
+
+// description renders the fields as an rdf:Description that declares the
+// namespaces it uses, so it can be placed in any packet.
+func (f XMPFields) description() string {
+	var b strings.Builder
+	b.WriteString(`  <rdf:Description rdf:about="" xmlns:rdf="` + nsRDF + `" xmlns:dc="` + nsDC + `">` + "\n")
+	writeArray := func(prop, kind string, items []string, lang bool) {
+		fmt.Fprintf(&b, "   <dc:%s><rdf:%s>", prop, kind)
+		for _, item := range items {
+			if lang {
+				b.WriteString(`<rdf:li xml:lang="x-default">`)
+			} else {
+				b.WriteString("<rdf:li>")
+			}
+			xml.EscapeText(&b, []byte(item))
+			b.WriteString("</rdf:li>")
+		}
+		fmt.Fprintf(&b, "</rdf:%s></dc:%s>\n", kind, prop)
+	}
+	if f.Title != "" {
+		writeArray("title", "Alt", []string{f.Title}, true)
+	}
+	if f.Description != "" {
+		writeArray("description", "Alt", []string{f.Description}, true)
+	}
+	if f.Creator != "" {
+		writeArray("creator", "Seq", []string{f.Creator}, false)
+	}
+	if f.Rights != "" {
+		writeArray("rights", "Alt", []string{f.Rights}, true)
+	}
+	if len(f.Keywords) > 0 {
+		writeArray("subject", "Bag", f.Keywords, false)
+	}
+	b.WriteString("  </rdf:Description>\n")
+	return b.String()
+}
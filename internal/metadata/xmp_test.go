@@ -0,0 +1,197 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"golang.org/x/image/tiff"
+)
+
+const samplePacket = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+   <xmp:Rating>4</xmp:Rating>
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Harbor</rdf:li></rdf:Alt></dc:title>
+   <dc:creator><rdf:Seq><rdf:li>Ann</rdf:li><rdf:li>Bo</rdf:li></rdf:Seq></dc:creator>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestXMPFields_Parse(t *testing.T) {
+	f, err := (&Metadata{XMP: []byte(samplePacket)}).XMPFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := XMPFields{Title: "Harbor", Creator: "Ann; Bo"}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("fields = %+v, want %+v", f, want)
+	}
+}
+
+func TestWithXMPFields_Merge(t *testing.T) {
+	m := &Metadata{EXIFRaw: []byte("exif"), XMP: []byte(samplePacket)}
+	set := XMPFields{Title: "Pier & Boats", Rights: "CC BY 4.0", Keywords: []string{"sea", "boat"}}
+	out, err := m.WithXMPFields(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.EXIFRaw, m.EXIFRaw) || string(m.XMP) != samplePacket {
+		t.Error("EXIF changed or source packet modified")
+	}
+	got, err := out.XMPFields()
+	if err != nil {
+		t.Fatalf("merged packet: %v", err)
+	}
+	want := set
+	want.Creator = "Ann; Bo"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %+v, want %+v", got, want)
+	}
+	packet := string(out.XMP)
+	if !strings.Contains(packet, "<xmp:Rating>4</xmp:Rating>") {
+		t.Error("unrelated property was dropped")
+	}
+	if strings.Count(packet, "<dc:title>") != 1 {
+		t.Error("old title was not replaced")
+	}
+}
+
+func TestWithXMPFields_NewPacket(t *testing.T) {
+	out, err := (*Metadata)(nil).WithXMPFields(XMPFields{Creator: "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.HasEXIF() {
+		t.Error("unexpected EXIF")
+	}
+	if f, err := out.XMPFields(); err != nil || f.Creator != "Ann" {
+		t.Errorf("fields = %+v, %v", f, err)
+	}
+	if _, err := (&Metadata{XMP: []byte("<x/>")}).WithXMPFields(XMPFields{Title: "t"}); err == nil {
+		t.Error("expected error for packet without rdf:RDF")
+	}
+}
+
+func TestXMP_RoundTrip(t *testing.T) {
+	meta := &Metadata{EXIFRaw: sampleEXIF(binary.LittleEndian), XMP: []byte(samplePacket)}
+
+	var pngData, tiffData bytes.Buffer
+	png.Encode(&pngData, testImage())
+	tiff.Encode(&tiffData, testImage(), nil)
+	jpeg := buildTestJPEGNoExif()
+
+	tests := []struct {
+		format codec.Format
+		data   []byte
+	}{
+		{codec.JPEG, jpeg},
+		{codec.PNG, pngData.Bytes()},
+		{codec.WebP, writeWebP([]riffChunk{vp8lChunk(4, 3, false)})},
+		{codec.TIFF, tiffData.Bytes()},
+		{codec.HEIC, buildHEIF([]byte("coded image data"))},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.format), func(t *testing.T) {
+			out := injectFile(t, tc.format, tc.data, meta)
+			out = injectFile(t, tc.format, out, meta) // replaces, does not duplicate
+
+			got, err := Extract(bytes.NewReader(out), tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got.XMP) != samplePacket {
+				t.Errorf("XMP = %q", got.XMP)
+			}
+			if !got.HasEXIF() {
+				t.Error("EXIF was lost")
+			}
+			// TIFF and HEIF injection append the new data and leave the
+			// old data unreferenced, so count the references there
+			switch tc.format {
+			case codec.TIFF:
+				e, _ := ParseEXIF(out)
+				n := 0
+				for _, tag := range e.IFD0 {
+					if tag.ID == tagXMP {
+						n++
+					}
+				}
+				if n != 1 {
+					t.Errorf("found %d XMP tags, want 1", n)
+				}
+			case codec.HEIC:
+				if n := bytes.Count(out, []byte("mime\x00"+xmpMIME)); n != 1 {
+					t.Errorf("found %d XMP items, want 1", n)
+				}
+			default:
+				if n := bytes.Count(out, []byte("<x:xmpmeta")); n != 1 {
+					t.Errorf("found %d XMP packets, want 1", n)
+				}
+			}
+		})
+	}
+}
+
+func TestXMP_OnlyKeepsEXIF(t *testing.T) {
+	// Injecting only XMP leaves the output's EXIF alone
+	exif := sampleEXIF(binary.BigEndian)
+	out := injectFile(t, codec.JPEG, buildTestJPEGWithExif(exif), &Metadata{XMP: []byte(samplePacket)})
+	got, err := Extract(bytes.NewReader(out), codec.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.EXIFRaw, exif) || string(got.XMP) != samplePacket {
+		t.Error("EXIF or XMP not as expected")
+	}
+	// The XMP segment follows the EXIF segment
+	if bytes.Index(out, xmpHeader) < bytes.Index(out, exifHeader) {
+		t.Error("XMP segment placed before EXIF segment")
+	}
+}
+
+func TestExtract_PNGCompressedXMP(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage())
+	chunks, _ := parsePNGChunks(buf.Bytes())
+
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write([]byte(samplePacket))
+	w.Close()
+	itxt := append([]byte(pngXMPKeyword), 0, 1, 0, 'e', 'n', 0, 0)
+	itxt = append(itxt, z.Bytes()...)
+
+	out := bytes.NewBuffer(append([]byte(nil), pngSignature...))
+	out.Write(chunks[0].raw) // IHDR
+	out.Write(makePNGChunk("iTXt", itxt))
+	for _, c := range chunks[1:] {
+		out.Write(c.raw)
+	}
+	meta, err := Extract(bytes.NewReader(out.Bytes()), codec.PNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(meta.XMP) != samplePacket {
+		t.Errorf("XMP = %q", meta.XMP)
+	}
+}
+
+func TestExtract_JXLXMP(t *testing.T) {
+	data := append(append([]byte(nil), jxlSignature...), makeBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl "))...)
+	data = append(data, makeBox("xml ", []byte(samplePacket))...)
+	meta, err := Extract(bytes.NewReader(data), codec.JXL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.HasEXIF() || string(meta.XMP) != samplePacket {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+}
@@ -0,0 +1,282 @@
+// Package naming expands output path templates with placeholders resolved
+// from the input file, its pixels and its metadata.
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+)
+
+// DefaultDateLayout is the layout of {date} without an explicit one.
+const DefaultDateLayout = "2006-01-02"
+
+// Unknown replaces values that cannot be determined, such as {camera} for
+// a file without EXIF.
+const Unknown = "unknown"
+
+// segment is a literal run or a placeholder with its optional argument.
+type segment struct {
+	literal string
+	key     string
+	arg     string
+}
+
+// Template is a parsed output path template. It is safe for concurrent
+// use; {counter} counts the expansions of the template.
+type Template struct {
+	pattern  string
+	segments []segment
+	counter  atomic.Int64
+
+	needsMeta, needsSize, needsHash bool
+}
+
+// Parse parses a template. Placeholders are {name}, {ext} and {format}
+// (the input name and extension and the output format), {parent} (the
+// input's directory name), {date} or {date:LAYOUT} (capture date as a Go
+// time layout, falling back to the file's modification time), {camera},
+// {lens}, {width}, {height}, {hash8} (first 8 hex digits of the file's
+// SHA-256) and {counter} or {counter:DIGITS}.
+func Parse(pattern string) (*Template, error) {
+	t := &Template{pattern: pattern}
+	rest := pattern
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.segments = append(t.segments, segment{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.segments = append(t.segments, segment{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unclosed {", pattern)
+		}
+		key, arg, _ := strings.Cut(rest[open+1:open+end], ":")
+		if err := t.add(key, arg); err != nil {
+			return nil, fmt.Errorf("template %q: %w", pattern, err)
+		}
+		rest = rest[open+end+1:]
+	}
+	return t, nil
+}
+
+// add validates a placeholder and records what it needs.
+func (t *Template) add(key, arg string) error {
+	switch key {
+	case "name", "ext", "format", "parent", "hash8", "camera", "lens", "width", "height":
+		if arg != "" {
+			return fmt.Errorf("{%s} takes no argument", key)
+		}
+	case "date":
+		if arg == "" {
+			arg = DefaultDateLayout
+		}
+	case "counter":
+		if arg != "" {
+			if n, err := strconv.Atoi(arg); err != nil || n < 1 || n > 12 {
+				return fmt.Errorf("{counter:%s}: digits must be 1-12", arg)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown placeholder {%s}", key)
+	}
+	switch key {
+	case "date", "camera", "lens":
+		t.needsMeta = true
+	case "width", "height":
+		t.needsSize = true
+	case "hash8":
+		t.needsHash = true
+	}
+	t.segments = append(t.segments, segment{key: key, arg: arg})
+	return nil
+}
+
+// String returns the template pattern.
+func (t *Template) String() string {
+	return t.pattern
+}
+
+// HasPlaceholders reports whether the template is more than a literal.
+func (t *Template) HasPlaceholders() bool {
+	for _, s := range t.segments {
+		if s.key != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand resolves the template for an input file converted to format.
+// Values read from the file that cannot be determined become Unknown, so
+// expansion does not fail; an unreadable file fails its conversion later.
+// Values taken from metadata are made safe for use as a path element.
+func (t *Template) Expand(inputPath string, format codec.Format) string {
+	src := t.inspect(inputPath)
+	base := filepath.Base(inputPath)
+	ext := filepath.Ext(base)
+
+	var b strings.Builder
+	for _, s := range t.segments {
+		switch s.key {
+		case "":
+			b.WriteString(s.literal)
+		case "name":
+			b.WriteString(base[:len(base)-len(ext)])
+		case "ext":
+			b.WriteString(strings.TrimPrefix(ext, "."))
+		case "format":
+			b.WriteString(string(format))
+		case "parent":
+			b.WriteString(filepath.Base(filepath.Dir(inputPath)))
+		case "date":
+			b.WriteString(src.date.Format(s.arg))
+		case "camera":
+			b.WriteString(src.camera)
+		case "lens":
+			b.WriteString(src.lens)
+		case "width":
+			b.WriteString(src.width)
+		case "height":
+			b.WriteString(src.height)
+		case "hash8":
+			b.WriteString(src.hash)
+		case "counter":
+			n := t.counter.Add(1)
+			if s.arg != "" {
+				fmt.Fprintf(&b, "%0*d", mustAtoi(s.arg), n)
+			} else {
+				fmt.Fprintf(&b, "%d", n)
+			}
+		}
+	}
+	return b.String()
+}
+
+// Mark returns the template's {counter} position, for Reset to give back
+// the numbers taken by expansions for a file that is then skipped. Mark
+// and Reset are not for use while the template is expanded concurrently.
+func (t *Template) Mark() int64 {
+	return t.counter.Load()
+}
+
+// Reset restores the {counter} position returned by Mark.
+func (t *Template) Reset(mark int64) {
+	t.counter.Store(mark)
+}
+
+// OutputName expands the template as an output file name, adding the
+// format's default extension if the result has none.
+func (t *Template) OutputName(inputPath string, format codec.Format) string {
+	name := t.Expand(inputPath, format)
+	if filepath.Ext(name) == "" {
+		name += codec.DefaultExtension(format)
+	}
+	return name
+}
+
+func mustAtoi(s string) int {
+	n, _ := strconv.Atoi(s) // validated by Parse
+	return n
+}
+
+// source holds the values a template reads from the input file.
+type source struct {
+	date                time.Time
+	camera, lens        string
+	width, height, hash string
+}
+
+// inspect reads only what the template needs from the input file.
+func (t *Template) inspect(path string) source {
+	src := source{camera: Unknown, lens: Unknown, width: Unknown, height: Unknown, hash: Unknown}
+	if !t.needsMeta && !t.needsSize && !t.needsHash {
+		return src
+	}
+	if info, err := os.Stat(path); err == nil {
+		src.date = info.ModTime()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return src
+	}
+	defer f.Close()
+	format, err := codec.DetectFormat(f, path)
+	if err != nil {
+		format = ""
+	}
+
+	if t.needsMeta && format != "" {
+		f.Seek(0, io.SeekStart)
+		if meta, err := metadata.Extract(f, format); err == nil {
+			if e, err := meta.EXIF(); err == nil {
+				s := e.Summary()
+				if s.DateTimeOriginal != nil {
+					src.date = *s.DateTimeOriginal
+				}
+				src.camera = pathElement(camera(s.Make, s.Model))
+				src.lens = pathElement(s.LensModel)
+			}
+		}
+	}
+	if t.needsSize {
+		f.Seek(0, io.SeekStart)
+		// Only the header is read: decoding the image here would double
+		// the job's decode cost
+		if w, h, ok := codec.ProbeDimensions(f); ok {
+			src.width, src.height = strconv.Itoa(w), strconv.Itoa(h)
+		}
+	}
+	if t.needsHash {
+		f.Seek(0, io.SeekStart)
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err == nil {
+			src.hash = hex.EncodeToString(h.Sum(nil))[:8]
+		}
+	}
+	return src
+}
+
+// camera joins make and model, leaving out the make when the model
+// already starts with it ("Canon" + "Canon EOS R5", "NIKON CORPORATION" +
+// "NIKON D850").
+func camera(maker, model string) string {
+	brand, _, _ := strings.Cut(maker, " ")
+	if model == "" {
+		return maker
+	}
+	if brand != "" && strings.HasPrefix(strings.ToLower(model), strings.ToLower(brand)) {
+		return model
+	}
+	return strings.TrimSpace(maker + " " + model)
+}
+
+// pathElement makes a metadata value usable as a file or directory name:
+// separators and characters invalid on common file systems become "_",
+// and an empty value becomes Unknown.
+func pathElement(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.Trim(strings.TrimSpace(s), ".")
+	if s == "" {
+		return Unknown
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+)
+
+func ascii(id uint16, s string) metadata.Tag {
+	return metadata.Tag{ID: id, Type: metadata.TypeASCII, Count: uint32(len(s) + 1), Data: append([]byte(s), 0)}
+}
+
+// writeJPEG writes a 40x30 JPEG with the given EXIF tags, if any.
+func writeJPEG(t *testing.T, path string, ifd0, exif []metadata.Tag) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, image.NewGray(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if ifd0 == nil && exif == nil {
+		return
+	}
+	e := &metadata.EXIF{ByteOrder: binary.LittleEndian, IFD0: ifd0, Exif: exif}
+	if err := metadata.Inject(path, codec.JPEG, &metadata.Metadata{EXIFRaw: e.Bytes()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, p := range []string{"{nope}", "{name", "{name:x}", "{counter:0}", "{counter:abc}"} {
+		if _, err := Parse(p); err == nil {
+			t.Errorf("Parse(%q): expected error", p)
+		}
+	}
+	tmpl, err := Parse("photos/out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.HasPlaceholders() {
+		t.Error("literal template reported placeholders")
+	}
+}
+
+func TestExpand_FileName(t *testing.T) {
+	tmpl, err := Parse("{parent}/{name}-{ext}.{format}_{counter:3}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"trip/IMG_1-heic.webp_001", "trip/IMG_1-heic.webp_002"} {
+		if got := tmpl.Expand("/photos/trip/IMG_1.heic", codec.WebP); got != want {
+			t.Errorf("expansion %d = %q, want %q", i+1, got, want)
+		}
+	}
+
+	tmpl, _ = Parse("{name}_{counter}")
+	if got := tmpl.OutputName("a/b.png", codec.JPEG); got != "b_1.jpg" {
+		t.Errorf("OutputName = %q, want b_1.jpg", got)
+	}
+}
+
+func TestExpand_Metadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "IMG_0001.jpg")
+	writeJPEG(t, path,
+		[]metadata.Tag{ascii(metadata.TagMake, "Canon"), ascii(metadata.TagModel, "Canon EOS R5")},
+		[]metadata.Tag{ascii(metadata.TagDateTimeOriginal, "2024:05:01 10:30:00"), ascii(metadata.TagLensModel, "RF24-105mm F4/L")})
+
+	tmpl, err := Parse("{date:2006/01/02}/{camera}/{lens}_{width}x{height}_{hash8}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	sum := sha256.Sum256(data)
+	want := "2024/05/01/Canon EOS R5/RF24-105mm F4_L_40x30_" + hex.EncodeToString(sum[:])[:8]
+	if got := tmpl.Expand(path, codec.WebP); got != want {
+		t.Errorf("Expand = %q, want %q", got, want)
+	}
+}
+
+func TestExpand_WithoutMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.jpg")
+	writeJPEG(t, path, nil, nil)
+	mtime := time.Date(2023, 12, 24, 8, 0, 0, 0, time.Local)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, _ := Parse("{date}/{camera}-{lens}")
+	if got := tmpl.Expand(path, codec.PNG); got != "2023-12-24/unknown-unknown" {
+		t.Errorf("Expand = %q", got)
+	}
+}
+
+func TestCamera(t *testing.T) {
+	tests := []struct{ make, model, want string }{
+		{"Canon", "Canon EOS R5", "Canon EOS R5"},
+		{"NIKON CORPORATION", "NIKON D850", "NIKON D850"},
+		{"FUJIFILM", "X-T5", "FUJIFILM X-T5"},
+		{"Apple", "", "Apple"},
+	}
+	for _, tt := range tests {
+		if got := camera(tt.make, tt.model); got != tt.want {
+			t.Errorf("camera(%q, %q) = %q, want %q", tt.make, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestExpand_ConcurrentCounter(t *testing.T) {
+	tmpl, _ := Parse("{counter:4}")
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := tmpl.Expand("x.jpg", codec.PNG)
+			mu.Lock()
+			seen[name] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 50 || !seen["0001"] || !seen["0050"] {
+		t.Errorf("counter values not unique: %d distinct", len(seen))
+	}
+}
+
+func TestMarkReset(t *testing.T) {
+	tmpl, _ := Parse("{counter}")
+	tmpl.Expand("a.jpg", codec.PNG)
+	mark := tmpl.Mark()
+	tmpl.Expand("b.jpg", codec.PNG) // skipped
+	tmpl.Reset(mark)
+	if got := tmpl.Expand("c.jpg", codec.PNG); got != "2" {
+		t.Errorf("after Reset: %q, want 2", got)
+	}
+}
@@ -0,0 +1,323 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/ssim"
+)
+
+// DefaultAutoMinSSIM is the minimum SSIM a candidate encoding must reach
+// against the transformed source to be eligible for Auto output.
+const DefaultAutoMinSSIM = 0.95
+
+// AutoTrial records the outcome of trial-encoding one candidate format.
+type AutoTrial struct {
+	Format  codec.Format
+	Size    int64
+	SSIM    float64 // 0 for animated trials, which are not scored
+	Quality int     // quality the candidate settled on; 0 if it has no quality setting
+	Err     error   // non-nil if the candidate was skipped or failed to encode
+}
+
+// AutoDecision records how an Auto output format was resolved.
+type AutoDecision struct {
+	Format  codec.Format
+	SSIM    float64
+	Quality int // quality of the chosen encoding; 0 if the format has none
+	Trials  []AutoTrial
+
+	path string // the output path the job was submitted with
+}
+
+// autoCandidates returns the configured candidate formats for an Auto job.
+func autoCandidates(job Job) []codec.Format {
+	if len(job.AutoFormats) > 0 {
+		return job.AutoFormats
+	}
+	return codec.DefaultAutoCandidates
+}
+
+// animatedCandidates returns the candidates whose encoder can write animations.
+func (p *Pipeline) animatedCandidates(job Job) []codec.Format {
+	var formats []codec.Format
+	for _, f := range autoCandidates(job) {
+		enc, err := p.Registry.Encoder(f)
+		if err != nil {
+			continue
+		}
+		if _, ok := enc.(codec.MultiFrameEncoder); ok {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// autoQualityStep is the granularity of the Auto quality search.
+const autoQualityStep = 5
+
+// chooseFormat trial-encodes img in each candidate format and returns the
+// smallest encoding whose SSIM against img meets the threshold. Lossy
+// formats are encoded at the lowest quality, up to the job's, that meets
+// it (see searchQuality), so candidates are compared at the same fidelity.
+// Formats that cannot carry the image's alpha channel are skipped. If no
+// candidate meets the threshold, the most faithful encoding is used.
+func (p *Pipeline) chooseFormat(ctx context.Context, img image.Image, job Job) (*AutoDecision, []byte, error) {
+	minSSIM := job.AutoMinSSIM
+	if minSSIM <= 0 {
+		minSSIM = DefaultAutoMinSSIM
+	}
+	hasAlpha := !isOpaque(img)
+
+	decision := &AutoDecision{}
+	var best, fallback []byte
+	bestIdx, fallbackIdx := -1, -1
+
+	for _, f := range autoCandidates(job) {
+		trial := AutoTrial{Format: f}
+		if isInput(job, autoOutputPath(job.OutputPath, f)) {
+			trial.Err = errReplacesInput
+			decision.Trials = append(decision.Trials, trial)
+			continue
+		}
+		data, score, quality, err := p.searchQuality(ctx, img, f, job, hasAlpha, minSSIM)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+		if err != nil {
+			trial.Err = err
+			decision.Trials = append(decision.Trials, trial)
+			continue
+		}
+		trial.Size = int64(len(data))
+		trial.SSIM = score
+		trial.Quality = quality
+		decision.Trials = append(decision.Trials, trial)
+		idx := len(decision.Trials) - 1
+
+		if score >= minSSIM && (bestIdx < 0 || len(data) < len(best)) {
+			best, bestIdx = data, idx
+		}
+		if fallbackIdx < 0 || score > decision.Trials[fallbackIdx].SSIM {
+			fallback, fallbackIdx = data, idx
+		}
+	}
+
+	if bestIdx < 0 {
+		best, bestIdx = fallback, fallbackIdx
+	}
+	if bestIdx < 0 {
+		return nil, nil, stageError(ErrEncode, "auto", fmt.Errorf("no candidate format could encode the image: %w", lastTrialErr(decision.Trials)))
+	}
+	decision.Format = decision.Trials[bestIdx].Format
+	decision.SSIM = decision.Trials[bestIdx].SSIM
+	decision.Quality = decision.Trials[bestIdx].Quality
+	return decision, best, nil
+}
+
+// searchQuality encodes img as format f at the lowest quality whose SSIM
+// meets minSSIM, bisecting over steps of autoQualityStep up to the job's
+// quality. If even the job's quality falls short, the most faithful
+// encoding is returned. Formats without a quality setting, lossless jobs
+// and JXL jobs with an explicit distance are encoded once, as configured,
+// and report quality 0.
+func (p *Pipeline) searchQuality(ctx context.Context, img image.Image, f codec.Format, job Job, hasAlpha bool, minSSIM float64) (data []byte, score float64, quality int, err error) {
+	if !searchesQuality(f, job) {
+		data, score, err = p.trialEncode(ctx, img, f, job, hasAlpha)
+		return data, score, 0, err
+	}
+	ceiling := job.Quality
+	if ceiling <= 0 || ceiling > 100 {
+		ceiling = 100
+	}
+	var qualities []int
+	for q := autoQualityStep; q < ceiling; q += autoQualityStep {
+		qualities = append(qualities, q)
+	}
+	qualities = append(qualities, ceiling)
+
+	// SSIM grows with quality, so the lowest passing quality is found by
+	// bisection; a failing probe only becomes the result if none pass
+	var pass bool
+	for lo, hi := 0, len(qualities)-1; lo <= hi; {
+		mid := (lo + hi) / 2
+		trialJob := job
+		trialJob.Quality, trialJob.EncodeOpts.Quality = qualities[mid], qualities[mid]
+		d, s, err := p.trialEncode(ctx, img, f, trialJob, hasAlpha)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		switch {
+		case s >= minSSIM:
+			data, score, quality, pass = d, s, qualities[mid], true
+			hi = mid - 1
+		case !pass && (data == nil || s > score):
+			data, score, quality = d, s, qualities[mid]
+			lo = mid + 1
+		default:
+			lo = mid + 1
+		}
+	}
+	return data, score, quality, nil
+}
+
+// searchesQuality reports whether searchQuality tunes the quality of
+// format f for the job.
+func searchesQuality(f codec.Format, job Job) bool {
+	if job.EncodeOpts.Lossless {
+		return false
+	}
+	switch f {
+	case codec.JPEG, codec.WebP, codec.AVIF, codec.HEIC:
+		return true
+	case codec.JXL:
+		return job.EncodeOpts.JXLDistance == 0
+	}
+	return false
+}
+
+// trialEncode encodes img as format f in memory, decodes it again and
+// returns the encoded bytes with their SSIM against img.
+func (p *Pipeline) trialEncode(ctx context.Context, img image.Image, f codec.Format, job Job, hasAlpha bool) ([]byte, float64, error) {
+	if hasAlpha && !codec.SupportsAlpha(f) {
+		return nil, 0, fmt.Errorf("%s does not support alpha", f)
+	}
+	enc, err := p.Registry.Encoder(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	dec, err := p.Registry.Decoder(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, enc, img, job); err != nil {
+		return nil, 0, fmt.Errorf("encode %s: %w", f, err)
+	}
+	decoded, err := dec.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode %s: %w", f, err)
+	}
+	score, err := ssim.CompareContext(ctx, img, decoded)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), score, nil
+}
+
+// chooseAnimatedFormat encodes anim with every animation-capable candidate
+// and returns the smallest result.
+func (p *Pipeline) chooseAnimatedFormat(ctx context.Context, anim *codec.AnimatedImage, job Job) (*AutoDecision, []byte, error) {
+	decision := &AutoDecision{}
+	var best []byte
+	bestIdx := -1
+
+	for _, f := range p.animatedCandidates(job) {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		trial := AutoTrial{Format: f}
+		if isInput(job, autoOutputPath(job.OutputPath, f)) {
+			trial.Err = errReplacesInput
+			decision.Trials = append(decision.Trials, trial)
+			continue
+		}
+		enc, _ := p.Registry.Encoder(f)
+		var buf bytes.Buffer
+		if err := enc.(codec.MultiFrameEncoder).EncodeAll(&buf, anim); err != nil {
+			trial.Err = fmt.Errorf("encode animated %s: %w", f, err)
+			decision.Trials = append(decision.Trials, trial)
+			continue
+		}
+		trial.Size = int64(buf.Len())
+		decision.Trials = append(decision.Trials, trial)
+		if bestIdx < 0 || buf.Len() < len(best) {
+			best, bestIdx = buf.Bytes(), len(decision.Trials)-1
+		}
+	}
+
+	if bestIdx < 0 {
+		return nil, nil, stageError(ErrEncode, "auto", fmt.Errorf("no candidate format could encode the animation: %w", lastTrialErr(decision.Trials)))
+	}
+	decision.Format = decision.Trials[bestIdx].Format
+	return decision, best, nil
+}
+
+// resolveAuto rewrites the job for the chosen format, replacing the output
+// path's extension, and records the decision in res.
+func resolveAuto(job Job, res *Result, decision *AutoDecision) Job {
+	decision.path = job.OutputPath
+	job.OutputFormat = decision.Format
+	job.OutputPath = autoOutputPath(job.OutputPath, decision.Format)
+	res.Job.OutputFormat = job.OutputFormat
+	res.Job.OutputPath = job.OutputPath
+	res.Auto = decision
+	return job
+}
+
+// autoOutputPath returns the path Auto output at path is written to in
+// format f.
+func autoOutputPath(path string, f codec.Format) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + codec.DefaultExtension(f)
+}
+
+// AutoOutputPaths returns the paths the job's Auto output at path may be
+// written to: one per candidate format, except a path that is the input,
+// since that candidate is never chosen.
+func AutoOutputPaths(job Job, path string) []string {
+	var paths []string
+	for _, f := range autoCandidates(job) {
+		if p := autoOutputPath(path, f); !isInput(job, p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// isInput reports whether path is the job's input file.
+func isInput(job Job, path string) bool {
+	in, err := os.Stat(job.InputPath)
+	if err != nil {
+		return filepath.Clean(path) == filepath.Clean(job.InputPath)
+	}
+	out, err := os.Stat(path)
+	return err == nil && os.SameFile(in, out)
+}
+
+// errReplacesInput is the error of an Auto candidate whose output would
+// replace the input.
+var errReplacesInput = errors.New("output would replace the input")
+
+// lastTrialErr returns the error of the last failed trial.
+func lastTrialErr(trials []AutoTrial) error {
+	for i := len(trials) - 1; i >= 0; i-- {
+		if trials[i].Err != nil {
+			return trials[i].Err
+		}
+	}
+	return fmt.Errorf("no candidates configured")
+}
+
+// isOpaque reports whether every pixel of img is fully opaque.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
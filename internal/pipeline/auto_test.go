@@ -0,0 +1,225 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+func TestExecuteJob_AutoChoosesSmallest(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+	outputPath := filepath.Join(dir, "output.auto")
+
+	p := NewPipeline(codec.DefaultRegistry())
+	res := p.ExecuteJob(Job{
+		InputPath:    inputPath,
+		OutputPath:   outputPath,
+		OutputFormat: codec.Auto,
+		Quality:      90,
+		AutoFormats:  []codec.Format{codec.PNG, codec.JPEG},
+	})
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if res.Auto == nil {
+		t.Fatal("Result.Auto should be set for auto output")
+	}
+	if len(res.Auto.Trials) != 2 {
+		t.Fatalf("got %d trials, want 2", len(res.Auto.Trials))
+	}
+
+	// The chosen format must be the smallest trial that met the threshold
+	var chosen AutoTrial
+	for _, trial := range res.Auto.Trials {
+		if trial.Err != nil {
+			t.Fatalf("trial %s failed: %v", trial.Format, trial.Err)
+		}
+		if trial.Format == res.Auto.Format {
+			chosen = trial
+		}
+	}
+	for _, trial := range res.Auto.Trials {
+		if trial.SSIM >= DefaultAutoMinSSIM && trial.Size < chosen.Size {
+			t.Errorf("chose %s (%d bytes) but %s was smaller (%d bytes)", chosen.Format, chosen.Size, trial.Format, trial.Size)
+		}
+	}
+
+	if res.Job.OutputFormat != res.Auto.Format {
+		t.Errorf("Result.Job.OutputFormat = %q, want %q", res.Job.OutputFormat, res.Auto.Format)
+	}
+	wantPath := filepath.Join(dir, "output"+codec.DefaultExtension(res.Auto.Format))
+	if res.Job.OutputPath != wantPath {
+		t.Errorf("Result.Job.OutputPath = %q, want %q", res.Job.OutputPath, wantPath)
+	}
+	info, err := os.Stat(wantPath)
+	if err != nil {
+		t.Fatalf("output file not found: %v", err)
+	}
+	if info.Size() != res.OutputSize {
+		t.Errorf("output file size = %d, want %d", info.Size(), res.OutputSize)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Error("placeholder .auto output should not be created")
+	}
+	if sub := res.SubmittedJob(); sub.OutputFormat != codec.Auto || sub.OutputPath != outputPath {
+		t.Errorf("SubmittedJob() output = %q %q, want auto %q", sub.OutputFormat, sub.OutputPath, outputPath)
+	}
+}
+
+func TestExecuteJob_AutoSkipsFormatsWithoutAlpha(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "alpha.png")
+
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 50, B: 50, A: uint8(x * 6)})
+		}
+	}
+	f, err := os.Create(inputPath)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	f.Close()
+
+	p := NewPipeline(codec.DefaultRegistry())
+	res := p.ExecuteJob(Job{
+		InputPath:    inputPath,
+		OutputPath:   filepath.Join(dir, "out.auto"),
+		OutputFormat: codec.Auto,
+		Quality:      90,
+		AutoFormats:  []codec.Format{codec.JPEG, codec.PNG},
+	})
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if res.Auto.Format != codec.PNG {
+		t.Errorf("chose %q, want png", res.Auto.Format)
+	}
+	if res.Auto.Trials[0].Err == nil {
+		t.Error("jpeg trial should be skipped for an image with alpha")
+	}
+}
+
+func TestExecuteJob_AutoNoCandidates(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+
+	// A registry that can decode the input but has no encoder for the candidate
+	dec, err := codec.DefaultRegistry().Decoder(codec.JPEG)
+	if err != nil {
+		t.Fatalf("Decoder: %v", err)
+	}
+	reg := codec.NewRegistry()
+	reg.RegisterDecoder(dec)
+
+	p := NewPipeline(reg)
+	res := p.ExecuteJob(Job{
+		InputPath:    inputPath,
+		OutputPath:   filepath.Join(dir, "out.auto"),
+		OutputFormat: codec.Auto,
+		AutoFormats:  []codec.Format{codec.WebP},
+	})
+	if res.Error == nil {
+		t.Fatal("expected error when no candidate can be encoded")
+	}
+}
+
+func TestExecuteJob_AutoKeepsInput(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+	before, err := os.ReadFile(inputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test.jpg -> test.auto, where JPEG output would replace the input
+	p := NewPipeline(codec.DefaultRegistry())
+	job := Job{
+		InputPath:    inputPath,
+		OutputPath:   filepath.Join(dir, "test.auto"),
+		OutputFormat: codec.Auto,
+		AutoFormats:  []codec.Format{codec.JPEG, codec.PNG},
+	}
+	res := p.ExecuteJob(job)
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if res.Auto.Format != codec.PNG || !errors.Is(res.Auto.Trials[0].Err, errReplacesInput) {
+		t.Errorf("chose %s, JPEG trial error %v", res.Auto.Format, res.Auto.Trials[0].Err)
+	}
+	if after, _ := os.ReadFile(inputPath); !bytes.Equal(before, after) {
+		t.Error("input was overwritten")
+	}
+	if paths := AutoOutputPaths(job, job.OutputPath); len(paths) != 1 || paths[0] != res.Job.OutputPath {
+		t.Errorf("AutoOutputPaths = %v, want [%s]", paths, res.Job.OutputPath)
+	}
+
+	// The PNG now exists, and NoOverwrite keeps it
+	info, _ := os.Stat(res.Job.OutputPath)
+	job.NoOverwrite = true
+	res = p.ExecuteJob(job)
+	if !errors.Is(res.Error, fs.ErrExist) {
+		t.Errorf("error = %v, want fs.ErrExist", res.Error)
+	}
+	if again, _ := os.Stat(res.Job.OutputPath); !again.ModTime().Equal(info.ModTime()) {
+		t.Error("existing output was replaced")
+	}
+}
+
+func TestSearchQuality(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8((x ^ y) * 4), A: 255})
+		}
+	}
+	p := NewPipeline(codec.DefaultRegistry())
+	ctx := context.Background()
+	job := Job{Quality: 95}
+
+	full, _, err := p.trialEncode(ctx, img, codec.JPEG, job, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, score, quality, err := p.searchQuality(ctx, img, codec.JPEG, job, false, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score < 0.9 || quality >= 95 || quality%autoQualityStep != 0 {
+		t.Errorf("searched quality %d with SSIM %.4f, want below 95 with SSIM >= 0.9", quality, score)
+	}
+	if len(data) >= len(full) {
+		t.Errorf("searched encoding is %d bytes, quality 95 is %d", len(data), len(full))
+	}
+
+	// A lower quality must fall short of the target
+	lower := job
+	lower.Quality = quality - autoQualityStep
+	if _, s, err := p.trialEncode(ctx, img, codec.JPEG, lower, false); err == nil && s >= 0.9 {
+		t.Errorf("quality %d also reaches SSIM %.4f", lower.Quality, s)
+	}
+
+	// An unreachable target settles on the job's quality
+	if _, _, quality, err = p.searchQuality(ctx, img, codec.JPEG, job, false, 1.01); err != nil || quality != 95 {
+		t.Errorf("unreachable target: quality %d, err %v; want 95", quality, err)
+	}
+
+	// Formats without a quality setting are encoded once
+	if _, _, quality, err = p.searchQuality(ctx, img, codec.PNG, job, false, 0.9); err != nil || quality != 0 {
+		t.Errorf("png: quality %d, err %v; want 0", quality, err)
+	}
+}
@@ -0,0 +1,34 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails reads once ctx is done, so decoders reading the
+// input stop at their next read instead of finishing the image.
+type contextReader struct {
+	ctx context.Context
+	io.ReadSeeker
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadSeeker.Read(p)
+}
+
+// contextWriter fails writes once ctx is done, so encoders stop at their
+// next write.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
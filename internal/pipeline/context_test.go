@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+// countdownContext reports cancellation after its first n Err calls, so a
+// job can be cancelled at each point the pipeline checks.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestExecuteContext_Cancelled(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out := filepath.Join(dir, "out.png")
+	p := NewPipeline(codec.DefaultRegistry())
+	_, _, err := p.ExecuteContext(ctx, Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputPath:   out,
+		OutputFormat: codec.PNG,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Error("cancelled job created its output")
+	}
+}
+
+func TestExecuteContext_NoPartialOutput(t *testing.T) {
+	dir := t.TempDir()
+	in := createTestJPEG(t, dir)
+	p := NewPipeline(codec.DefaultRegistry())
+
+	for _, format := range []codec.Format{codec.PNG, codec.Auto} {
+		// Cancel at every check in turn until the job gets through
+		for n := 0; ; n++ {
+			out := filepath.Join(dir, "out.png")
+			res := p.ExecuteJobContext(&countdownContext{Context: context.Background(), n: n}, Job{
+				InputPath:    in,
+				OutputPath:   out,
+				OutputFormat: format,
+				Quality:      80,
+				Blur:         1,
+				Ops:          []Op{{Kind: OpSmartCrop, Width: 40, Height: 40}},
+			})
+			if res.Error == nil {
+				os.Remove(res.Job.OutputPath)
+				break
+			}
+			if res.Error != context.Canceled {
+				t.Fatalf("%s, cancelled after %d checks: error = %v", format, n, res.Error)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Fatalf("%s, cancelled after %d checks: left %d files", format, n, len(entries)-1)
+			}
+			if n > 10000 {
+				t.Fatalf("%s: job never completed", format)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DanielTso/pixshift/internal/metadata"
+)
+
+// PanicError is the error of a job whose conversion panicked, e.g. in a
+// decoder given a malformed image. The panic is confined to the job;
+// crashes inside C code cannot be recovered.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the panicking goroutine's stack
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// TimeoutError is the error of a job that ran longer than the pipeline's
+// JobTimeout. It matches context.DeadlineExceeded with errors.Is.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// Errors that classify why a job failed, for use with errors.Is. A failed
+// job's error matches at most one of them; see ErrorKind. Cancellation,
+// timeouts, panics and invalid operations match none.
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	ErrDecode            = errors.New("decode failed")
+	ErrEncode            = errors.New("encode failed")
+	ErrMetadata          = errors.New("metadata failed")
+	ErrLimit             = errors.New("limit exceeded") // e.g. EXIF too large for a JPEG segment
+	ErrIO                = errors.New("i/o error")
+)
+
+// kinds lists the error kinds, for ErrorKind.
+var kinds = []error{ErrUnsupportedFormat, ErrDecode, ErrEncode, ErrMetadata, ErrLimit, ErrIO}
+
+// ErrorKind returns the kind of a job's error: one of ErrUnsupportedFormat,
+// ErrDecode, ErrEncode, ErrMetadata, ErrLimit and ErrIO, or nil.
+func ErrorKind(err error) error {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// Error is the error of a job stage that failed. It matches its Kind and
+// whatever its cause matches with errors.Is and errors.As.
+type Error struct {
+	Kind error  // ErrDecode, ErrIO, ...
+	Op   string // the stage, e.g. "decode png"; may be empty
+	Err  error  // the cause
+}
+
+func (e *Error) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error { return []error{e.Kind, e.Err} }
+
+// stageError returns an *Error of the given kind for a failed stage.
+func stageError(kind error, op string, err error) error {
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// metadataError is stageError for metadata failures. Metadata that does
+// not fit in the output's metadata segments exceeds a limit.
+func metadataError(op string, err error) error {
+	kind := ErrMetadata
+	if errors.Is(err, metadata.ErrEXIFTooLarge) || errors.Is(err, metadata.ErrXMPTooLarge) || errors.Is(err, metadata.ErrIPTCTooLarge) {
+		kind = ErrLimit
+	}
+	return stageError(kind, op, err)
+}
@@ -0,0 +1,275 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+)
+
+// panickingDecoder panics like a decoder given a malformed image.
+type panickingDecoder struct{ codec.Decoder }
+
+func (panickingDecoder) Decode(io.ReadSeeker) (image.Image, error) {
+	var pix []byte
+	_ = pix[1]
+	return nil, nil
+}
+
+// stuckDecoder blocks until release is closed, ignoring cancellation
+// like a decoder stuck in C code.
+type stuckDecoder struct {
+	codec.Decoder
+	release chan struct{}
+}
+
+func (d stuckDecoder) Decode(r io.ReadSeeker) (image.Image, error) {
+	<-d.release
+	return d.Decoder.Decode(r)
+}
+
+func TestExecuteJob_Panic(t *testing.T) {
+	dir := t.TempDir()
+	reg := codec.DefaultRegistry()
+	jpegDec, _ := reg.Decoder(codec.JPEG)
+	reg.RegisterDecoder(panickingDecoder{jpegDec})
+
+	res := NewPipeline(reg).ExecuteJob(Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputPath:   filepath.Join(dir, "out.png"),
+		OutputFormat: codec.PNG,
+	})
+	var pe *PanicError
+	if !errors.As(res.Error, &pe) {
+		t.Fatalf("error = %v, want a *PanicError", res.Error)
+	}
+	if len(pe.Stack) == 0 {
+		t.Error("panic error has no stack")
+	}
+}
+
+func TestExecuteJob_Timeout(t *testing.T) {
+	dir := t.TempDir()
+	reg := codec.DefaultRegistry()
+	jpegDec, _ := reg.Decoder(codec.JPEG)
+	release := make(chan struct{})
+	defer close(release)
+	reg.RegisterDecoder(stuckDecoder{jpegDec, release})
+
+	p := NewPipeline(reg)
+	p.JobTimeout = 50 * time.Millisecond
+	start := time.Now()
+	res := p.ExecuteJob(Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputPath:   filepath.Join(dir, "out.png"),
+		OutputFormat: codec.PNG,
+	})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stuck job returned after %s", elapsed)
+	}
+	var te *TimeoutError
+	if !errors.As(res.Error, &te) || te.Timeout != p.JobTimeout {
+		t.Fatalf("error = %v, want a *TimeoutError", res.Error)
+	}
+	if !errors.Is(res.Error, context.DeadlineExceeded) {
+		t.Error("timeout error does not match context.DeadlineExceeded")
+	}
+}
+
+func TestPool_TimeoutKeepsMemoryReserved(t *testing.T) {
+	dir := t.TempDir()
+	reg := codec.DefaultRegistry()
+	jpegDec, _ := reg.Decoder(codec.JPEG)
+	release := make(chan struct{})
+	reg.RegisterDecoder(stuckDecoder{jpegDec, release})
+
+	small := filepath.Join(dir, "small.png")
+	f, err := os.Create(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	p := NewPipeline(reg)
+	p.JobTimeout = 200 * time.Millisecond
+	pool := NewPool(p, 2)
+	pool.MaxMemory = 1 // every job exceeds the budget, so they run alone
+
+	done := make(chan []Result)
+	go func() {
+		done <- pool.Run(context.Background(), []Job{
+			{InputPath: createTestJPEG(t, dir), OutputPath: filepath.Join(dir, "stuck.png"), OutputFormat: codec.PNG},
+			{InputPath: small, OutputPath: filepath.Join(dir, "small.jpg"), OutputFormat: codec.JPEG},
+		})
+	}()
+
+	// The stuck job has timed out, but its decoder still runs and the
+	// grace period has not passed
+	time.Sleep(300 * time.Millisecond)
+	if _, err := os.Stat(filepath.Join(dir, "small.jpg")); err == nil {
+		t.Error("next job started while the abandoned job still held its memory")
+	}
+	close(release)
+
+	select {
+	case results := <-done:
+		var te *TimeoutError
+		if !errors.As(results[0].Error, &te) {
+			t.Errorf("stuck job: error = %v, want a *TimeoutError", results[0].Error)
+		}
+		if results[1].Error != nil {
+			t.Errorf("next job: %v", results[1].Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool did not finish after the stuck job returned")
+	}
+}
+
+func TestPool_TimeoutNeverReturningReleasesMemory(t *testing.T) {
+	dir := t.TempDir()
+	block := make(chan struct{})
+	defer close(block)
+
+	p := NewPipeline(codec.DefaultRegistry())
+	p.JobTimeout = 50 * time.Millisecond
+	p.Hooks = []Hooks{{
+		PreDecode: func(ctx context.Context, job Job, format codec.Format) error {
+			if filepath.Base(job.InputPath) == "stuck.jpg" {
+				<-block // ignores ctx, like a call that never returns
+			}
+			return nil
+		},
+	}}
+	pool := NewPool(p, 2)
+	pool.MaxMemory = 1 // every job exceeds the budget, so they run alone
+
+	stuck := filepath.Join(dir, "stuck.jpg")
+	if err := os.Rename(createTestJPEG(t, dir), stuck); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []Job{{InputPath: stuck, OutputPath: filepath.Join(dir, "stuck.png"), OutputFormat: codec.PNG}}
+	for i := range 3 {
+		jobs = append(jobs, Job{
+			InputPath:    createTestJPEG(t, dir),
+			OutputPath:   filepath.Join(dir, fmt.Sprintf("out%d.png", i)),
+			OutputFormat: codec.PNG,
+		})
+	}
+
+	done := make(chan []Result)
+	go func() { done <- pool.Run(context.Background(), jobs) }()
+	select {
+	case results := <-done:
+		var te *TimeoutError
+		if !errors.As(results[0].Error, &te) {
+			t.Errorf("stuck job: error = %v, want a *TimeoutError", results[0].Error)
+		}
+		for i, r := range results[1:] {
+			if r.Error != nil {
+				t.Errorf("job %d: %v", i+1, r.Error)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool stalled behind a job that never returns")
+	}
+}
+
+func TestExecuteJob_TimeoutNotReached(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPipeline(codec.DefaultRegistry())
+	p.JobTimeout = time.Minute
+	res := p.ExecuteJob(Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputPath:   filepath.Join(dir, "out.png"),
+		OutputFormat: codec.PNG,
+	})
+	if res.Error != nil || res.OutputSize == 0 {
+		t.Errorf("result = %+v", res)
+	}
+
+	// Cancellation by the caller is not reported as a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res = p.ExecuteJobContext(ctx, Job{InputPath: createTestJPEG(t, dir), OutputPath: filepath.Join(dir, "b.png"), OutputFormat: codec.PNG})
+	if !errors.Is(res.Error, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", res.Error)
+	}
+}
+
+func TestExecuteJob_ErrorKinds(t *testing.T) {
+	dir := t.TempDir()
+	input := createTestJPEG(t, dir)
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	decodeOnly := codec.NewRegistry()
+	jpegDec, _ := codec.DefaultRegistry().Decoder(codec.JPEG)
+	decodeOnly.RegisterDecoder(jpegDec)
+
+	tests := []struct {
+		name string
+		reg  *codec.Registry
+		job  Job
+		kind error
+	}{
+		{"unknown input", nil, Job{InputPath: write("a.dat", "not an image"), OutputFormat: codec.PNG}, ErrUnsupportedFormat},
+		{"no encoder", decodeOnly, Job{InputPath: input, OutputFormat: codec.PNG}, ErrUnsupportedFormat},
+		{"corrupt input", nil, Job{InputPath: write("b.jpg", "\xff\xd8\xff\xe0garbage"), OutputFormat: codec.PNG}, ErrDecode},
+		{"missing input", nil, Job{InputPath: filepath.Join(dir, "none.jpg"), InputFormat: codec.JPEG, OutputFormat: codec.PNG}, ErrIO},
+		{"output under a file", nil, Job{InputPath: input, OutputPath: filepath.Join(input, "out.png"), OutputFormat: codec.PNG}, ErrIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := tt.reg
+			if reg == nil {
+				reg = codec.DefaultRegistry()
+			}
+			if tt.job.OutputPath == "" {
+				tt.job.OutputPath = filepath.Join(dir, tt.name+".png")
+			}
+			res := NewPipeline(reg).ExecuteJob(tt.job)
+			if kind := ErrorKind(res.Error); kind != tt.kind {
+				t.Fatalf("error %v: kind = %v, want %v", res.Error, kind, tt.kind)
+			}
+			var pe *Error
+			if !errors.As(res.Error, &pe) || pe.Kind != tt.kind {
+				t.Errorf("error %v is not an *Error of kind %v", res.Error, tt.kind)
+			}
+		})
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	cause := errors.New("unexpected EOF")
+	err := fmt.Errorf("variant 1 (640): %w", stageError(ErrDecode, "decode png", cause))
+	if got, want := err.Error(), "variant 1 (640): decode png: unexpected EOF"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if ErrorKind(err) != ErrDecode || !errors.Is(err, cause) {
+		t.Errorf("kind = %v, matches cause = %v", ErrorKind(err), errors.Is(err, cause))
+	}
+	if kind := ErrorKind(metadataError("metadata inject", metadata.ErrEXIFTooLarge)); kind != ErrLimit {
+		t.Errorf("oversized EXIF: kind = %v, want ErrLimit", kind)
+	}
+	for _, err := range []error{&PanicError{Value: "x"}, &TimeoutError{}, context.Canceled, nil} {
+		if kind := ErrorKind(err); kind != nil {
+			t.Errorf("ErrorKind(%v) = %v, want nil", err, kind)
+		}
+	}
+}
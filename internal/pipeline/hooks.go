@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+// Hooks are called at the stages of a job, between the pipeline's own
+// steps, to insert custom transforms, collect metrics or veto outputs. Any
+// of them may be nil. A hook that returns an error fails the job with it,
+// wrapped with the stage's name, and nothing more is written.
+//
+// The job passed to PreEncode and PostEncode is that of the output being
+// written: for a variant, its own job with its output path and format; for
+// Auto output, PostEncode gets the chosen format. Jobs run concurrently in
+// a worker pool, so hooks must be safe for concurrent use.
+type Hooks struct {
+	// PreDecode is called once the input's format is known and its
+	// metadata read, before the image is decoded.
+	PreDecode func(ctx context.Context, job Job, format codec.Format) error
+
+	// PostDecode is called with each decoded frame, before the job's
+	// operations.
+	PostDecode ImageHook
+
+	// Transform is called with each frame after the job's operations, and
+	// before those of the variants.
+	Transform ImageHook
+
+	// PreEncode is called with the image of each output, or each frame of
+	// an animated one, before it is encoded.
+	PreEncode ImageHook
+
+	// PostEncode is called with each encoded output, with its metadata,
+	// before it is written. The data it returns is written instead.
+	PostEncode func(ctx context.Context, job Job, data []byte) ([]byte, error)
+}
+
+// ImageHook is called with a frame of the image (0 for still images) and
+// returns the image that replaces it, which may be img itself.
+type ImageHook func(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error)
+
+// hasImageHooks reports whether any hooks see decoded images, which rules
+// out lossless transcoding.
+func (p *Pipeline) hasImageHooks() bool {
+	for _, h := range p.Hooks {
+		if h.PostDecode != nil || h.Transform != nil || h.PreEncode != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pipeline) preDecode(ctx context.Context, job Job, format codec.Format) error {
+	for _, h := range p.Hooks {
+		if h.PreDecode == nil {
+			continue
+		}
+		if err := h.PreDecode(ctx, job, format); err != nil {
+			return fmt.Errorf("pre-decode hook: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) postDecode(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+	return p.imageHooks(ctx, "post-decode", func(h Hooks) ImageHook { return h.PostDecode }, job, frame, img)
+}
+
+// transform applies the job's operations to a frame, then the Transform
+// hooks.
+func (p *Pipeline) transform(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+	img, err := transformImage(ctx, img, job)
+	if err != nil {
+		return nil, err
+	}
+	return p.imageHooks(ctx, "transform", func(h Hooks) ImageHook { return h.Transform }, job, frame, img)
+}
+
+func (p *Pipeline) preEncode(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+	return p.imageHooks(ctx, "pre-encode", func(h Hooks) ImageHook { return h.PreEncode }, job, frame, img)
+}
+
+// imageHooks passes img through the stage's hooks in order.
+func (p *Pipeline) imageHooks(ctx context.Context, stage string, hook func(Hooks) ImageHook, job Job, frame int, img image.Image) (image.Image, error) {
+	for _, h := range p.Hooks {
+		f := hook(h)
+		if f == nil {
+			continue
+		}
+		var err error
+		if img, err = f(ctx, job, frame, img); err != nil {
+			return nil, fmt.Errorf("%s hook: %w", stage, err)
+		}
+		if img == nil {
+			return nil, fmt.Errorf("%s hook returned no image", stage)
+		}
+	}
+	return img, nil
+}
+
+func (p *Pipeline) postEncode(ctx context.Context, job Job, data []byte) ([]byte, error) {
+	for _, h := range p.Hooks {
+		if h.PostEncode == nil {
+			continue
+		}
+		var err error
+		if data, err = h.PostEncode(ctx, job, data); err != nil {
+			return nil, fmt.Errorf("post-encode hook: %w", err)
+		}
+	}
+	return data, nil
+}
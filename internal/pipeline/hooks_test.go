@@ -0,0 +1,138 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+func TestHooks_Stages(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var calls []string
+	record := func(s string) {
+		mu.Lock()
+		calls = append(calls, s)
+		mu.Unlock()
+	}
+	replace := func(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+		record("transform")
+		if w := img.Bounds().Dx(); w != 50 {
+			t.Errorf("transform width = %d, want the resized 50", w)
+		}
+		return image.NewRGBA(image.Rect(0, 0, 10, 10)), nil
+	}
+
+	p := NewPipeline(codec.DefaultRegistry())
+	p.Hooks = []Hooks{
+		{
+			PreDecode: func(ctx context.Context, job Job, format codec.Format) error {
+				record("pre-decode " + string(format))
+				return nil
+			},
+			PostDecode: func(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+				record("post-decode")
+				if w := img.Bounds().Dx(); w != 100 {
+					t.Errorf("post-decode width = %d, want the decoded 100", w)
+				}
+				return img, nil
+			},
+			Transform: replace,
+		},
+		{
+			PreEncode: func(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+				record("pre-encode")
+				if w := img.Bounds().Dx(); w != 10 {
+					t.Errorf("pre-encode width = %d, want the transformed 10", w)
+				}
+				return img, nil
+			},
+			PostEncode: func(ctx context.Context, job Job, data []byte) ([]byte, error) {
+				record("post-encode " + filepath.Base(job.OutputPath))
+				return data, nil
+			},
+		},
+	}
+	out := filepath.Join(dir, "out.png")
+	res := p.ExecuteJob(Job{InputPath: createTestJPEG(t, dir), OutputPath: out, OutputFormat: codec.PNG, MaxDim: 50})
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+
+	want := []string{"pre-decode jpeg", "post-decode", "transform", "pre-encode", "post-encode out.png"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil || cfg.Width != 10 || cfg.Height != 10 {
+		t.Errorf("output = %dx%d, %v; want the hook's 10x10", cfg.Width, cfg.Height, err)
+	}
+}
+
+func TestHooks_Veto(t *testing.T) {
+	dir := t.TempDir()
+	veto := errors.New("too large")
+	p := NewPipeline(codec.DefaultRegistry())
+	p.Hooks = []Hooks{{
+		PostEncode: func(ctx context.Context, job Job, data []byte) ([]byte, error) {
+			return nil, veto
+		},
+	}}
+	out := filepath.Join(dir, "out.png")
+	res := p.ExecuteJob(Job{InputPath: createTestJPEG(t, dir), OutputPath: out, OutputFormat: codec.PNG})
+	if !errors.Is(res.Error, veto) {
+		t.Fatalf("error = %v, want the hook's error", res.Error)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("vetoed output was written: %v", err)
+	}
+}
+
+func TestHooks_Variants(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var decodes int
+	var encoded []string
+	p := NewPipeline(codec.DefaultRegistry())
+	p.Hooks = []Hooks{{
+		PostDecode: func(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+			mu.Lock()
+			decodes++
+			mu.Unlock()
+			return img, nil
+		},
+		PreEncode: func(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+			mu.Lock()
+			encoded = append(encoded, filepath.Base(job.OutputPath))
+			mu.Unlock()
+			return img, nil
+		},
+	}}
+	res := p.ExecuteJob(Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputFormat: codec.PNG,
+		Variants: []Variant{
+			{MaxDim: 40, OutputPath: filepath.Join(dir, "small.png")},
+			{MaxDim: 60, OutputPath: filepath.Join(dir, "large.png")},
+		},
+	})
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if want := []string{"small.png", "large.png"}; decodes != 1 || !reflect.DeepEqual(encoded, want) {
+		t.Errorf("decodes = %d, encoded = %q; want 1 and %q", decodes, encoded, want)
+	}
+}
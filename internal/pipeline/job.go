@@ -1,6 +1,13 @@
 package pipeline
 
-import "github.com/DanielTso/pixshift/internal/codec"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+)
 
 // Job describes a single conversion task.
 type Job struct {
@@ -44,6 +51,60 @@ type Job struct {
 	Invert        bool
 	Interpolation string // "nearest", "bilinear", "catmullrom" (default)
 	EncodeOpts    codec.EncodeOptions
+
+	// v0.9.0 fields
+	AutoFormats  []codec.Format // candidates for codec.Auto output (nil = codec.DefaultAutoCandidates)
+	AutoMinSSIM  float64        // minimum SSIM for an auto candidate (0 = DefaultAutoMinSSIM)
+	ReencodeJPEG bool           // decode through pixels instead of lossless JPEG<->JXL transcoding or JPEG rotation/crop
+
+	MetadataPolicy metadata.Policy        // EXIF tags to keep/strip; a non-zero policy implies PreserveMetadata
+	XMP            metadata.XMPFields     // XMP properties to set; written even when metadata is stripped
+	IPTC           metadata.IPTCFields    // IPTC datasets to set; written even when metadata is stripped
+	Sidecar        metadata.SidecarFormat // write the source metadata next to the output ("" = none)
+	FromSidecar    bool                   // take the metadata from the input's sidecar; implies PreserveMetadata
+	PreserveAttrs  bool                   // give the output the input's permissions and modification time
+
+	// NoOverwrite fails the job instead of replacing an existing output,
+	// e.g. one that Auto output only finds once it has chosen the format.
+	NoOverwrite bool `json:"-"`
+
+	// Ops are applied in order after the operations of the flat transform
+	// fields above; see Operations.
+	Ops []Op
+
+	// Variants, when set, replace OutputPath and OutputFormat: the input
+	// is decoded and transformed once, and each variant is written from
+	// the shared image.
+	Variants []Variant
+}
+
+// OutputPaths returns the paths the job writes: its output path, or those
+// of its variants.
+func (j Job) OutputPaths() []string {
+	if len(j.Variants) == 0 {
+		return []string{j.OutputPath}
+	}
+	paths := make([]string, len(j.Variants))
+	for i, v := range j.Variants {
+		paths[i] = v.OutputPath
+	}
+	return paths
+}
+
+// Fingerprint returns a hash of everything in the job, including its
+// input and output paths. Equal jobs have equal fingerprints across runs.
+func (j Job) Fingerprint() string {
+	data, _ := json.Marshal(struct { // a Job has no unmarshalable fields
+		Job     Job
+		Outputs []string // variant output paths are not marshaled
+	}{j, j.OutputPaths()})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// keepsMetadata reports whether the job copies metadata to the output.
+func (j Job) keepsMetadata() bool {
+	return (j.PreserveMetadata || j.FromSidecar || !j.MetadataPolicy.IsZero()) && !j.StripMetadata
 }
 
 // Result holds the outcome of a conversion job.
@@ -52,4 +113,18 @@ type Result struct {
 	Error      error
 	InputSize  int64
 	OutputSize int64
+	Auto       *AutoDecision   // set when the job's output format was codec.Auto
+	Transcoded bool            // output is a lossless bitstream transcode (JPEG<->JXL or DCT-domain JPEG rotation/crop)
+	Variants   []VariantResult // one per Job.Variants entry; OutputSize is their total
+}
+
+// SubmittedJob returns the job as it was submitted. Job has an Auto output
+// format resolved to the chosen format and path; this restores both, so
+// fingerprints of the job match from one run to the next.
+func (r Result) SubmittedJob() Job {
+	job := r.Job
+	if r.Auto != nil && r.Auto.path != "" {
+		job.OutputFormat, job.OutputPath = codec.Auto, r.Auto.path
+	}
+	return job
 }
@@ -0,0 +1,42 @@
+package pipeline
+
+import (
+	"os"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+const (
+	// bytesPerPixel is the size of a decoded 8-bit RGBA pixel.
+	bytesPerPixel = 4
+
+	// imageCopies is how many full-size images a job holds at once: the
+	// decoded image, a transformed copy and the encoder's buffers.
+	imageCopies = 3
+
+	// unknownRatio estimates the decoded size of inputs whose header
+	// cannot be probed from their file size; compressed photos and raw
+	// files decode to roughly this many times their size.
+	unknownRatio = 16
+)
+
+// EstimateMemory estimates the peak memory of a job in bytes from the
+// pixel size in the input's header, without decoding it. Inputs whose
+// size cannot be read from the header are estimated from their file size,
+// and unreadable inputs as 0.
+func EstimateMemory(job Job) int64 {
+	f, err := os.Open(job.InputPath)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	if w, h, ok := codec.ProbeDimensions(f); ok {
+		return int64(w) * int64(h) * bytesPerPixel * imageCopies
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size() * unknownRatio
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	dir := t.TempDir()
+
+	pngPath := filepath.Join(dir, "a.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100, 80))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pngPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := EstimateMemory(Job{InputPath: pngPath}), int64(100*80*bytesPerPixel*imageCopies); got != want {
+		t.Errorf("png: estimate = %d, want %d", got, want)
+	}
+
+	// A HEIC-like header with a thumbnail and a primary image extent
+	ispe := func(w, h uint32) []byte {
+		b := make([]byte, 20)
+		binary.BigEndian.PutUint32(b, 20)
+		copy(b[4:], "ispe")
+		binary.BigEndian.PutUint32(b[12:], w)
+		binary.BigEndian.PutUint32(b[16:], h)
+		return b
+	}
+	heic := append([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), ispe(320, 240)...)
+	heic = append(heic, ispe(4032, 3024)...)
+	heic = append(heic, "ispe-in-data"...)
+	heicPath := filepath.Join(dir, "a.heic")
+	if err := os.WriteFile(heicPath, heic, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := EstimateMemory(Job{InputPath: heicPath}), int64(4032*3024*bytesPerPixel*imageCopies); got != want {
+		t.Errorf("heic: estimate = %d, want %d", got, want)
+	}
+
+	rawPath := filepath.Join(dir, "a.raw")
+	if err := os.WriteFile(rawPath, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := EstimateMemory(Job{InputPath: rawPath}); got != 1000*unknownRatio {
+		t.Errorf("unknown: estimate = %d, want %d", got, 1000*unknownRatio)
+	}
+	if got := EstimateMemory(Job{InputPath: filepath.Join(dir, "missing")}); got != 0 {
+		t.Errorf("missing: estimate = %d", got)
+	}
+}
+
+func TestScheduler_Budget(t *testing.T) {
+	ctx := context.Background()
+	s := newScheduler(4, []int64{3, 8, 5, 2}, 10)
+
+	next := func(wantIdx int) int64 {
+		t.Helper()
+		idx, cost, ok := s.next(ctx)
+		if !ok || idx != wantIdx {
+			t.Fatalf("next = %d, %v; want %d", idx, ok, wantIdx)
+		}
+		return cost
+	}
+
+	// Largest first, then the largest job that still fits beside it
+	c8 := next(1)
+	c2 := next(3)
+	if s.used != 10 {
+		t.Errorf("used = %d, want 10", s.used)
+	}
+	s.release(c8)
+	next(2)
+	next(0)
+	s.release(c2)
+	if _, _, ok := s.next(ctx); ok {
+		t.Error("next returned a job after all were handed out")
+	}
+}
+
+func TestScheduler_OversizedJobRunsAlone(t *testing.T) {
+	s := newScheduler(2, []int64{1, 50}, 10)
+	idx, cost, ok := s.next(context.Background())
+	if !ok || idx != 1 || cost != 50 {
+		t.Fatalf("next = %d (%d), %v; want the oversized job", idx, cost, ok)
+	}
+
+	// Once cancelled, the remaining job is handed out without waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if idx, _, ok := s.next(ctx); !ok || idx != 0 {
+		t.Errorf("next after cancel = %d, %v", idx, ok)
+	}
+}
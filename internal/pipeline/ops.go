@@ -0,0 +1,391 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/DanielTso/pixshift/internal/resize"
+	"github.com/DanielTso/pixshift/internal/transform"
+)
+
+// OpKind names an image operation.
+type OpKind string
+
+// Operation kinds.
+const (
+	OpAutoRotate OpKind = "auto-rotate"
+	OpSmartCrop  OpKind = "smart-crop"
+	OpCrop       OpKind = "crop"
+	OpResize     OpKind = "resize"
+	OpWatermark  OpKind = "watermark"
+	OpBrightness OpKind = "brightness"
+	OpContrast   OpKind = "contrast"
+	OpSharpen    OpKind = "sharpen"
+	OpBlur       OpKind = "blur"
+	OpGrayscale  OpKind = "grayscale"
+	OpSepia      OpKind = "sepia"
+	OpInvert     OpKind = "invert"
+)
+
+// OpKinds lists the operation kinds in the order the flat Job fields are
+// applied.
+var OpKinds = []OpKind{
+	OpAutoRotate, OpSmartCrop, OpCrop, OpResize, OpWatermark,
+	OpBrightness, OpContrast, OpSharpen, OpBlur, OpGrayscale, OpSepia, OpInvert,
+}
+
+// Op is one image operation of a Job's chain. Only the fields of its kind
+// are used:
+//
+//	resize      Width, Height, MaxDim, Interpolation (default: the job's)
+//	crop        Width and Height, or Ratio ("16:9"); Gravity
+//	smart-crop  Width, Height
+//	watermark   Text, Position, Opacity, Size, Color, Bg
+//	brightness  Value, -100 to 100
+//	contrast    Value, -100 to 100
+//	blur        Value, the radius in pixels
+//	sepia       Value, the intensity from 0 to 1 (0 = 1)
+//
+// auto-rotate, sharpen, grayscale and invert take no parameters.
+type Op struct {
+	Kind OpKind `json:"op" yaml:"op"`
+
+	Width         int    `json:"width,omitempty" yaml:"width,omitempty"`
+	Height        int    `json:"height,omitempty" yaml:"height,omitempty"`
+	MaxDim        int    `json:"max_dim,omitempty" yaml:"max_dim,omitempty"`
+	Interpolation string `json:"interpolation,omitempty" yaml:"interpolation,omitempty"`
+	Ratio         string `json:"ratio,omitempty" yaml:"ratio,omitempty"`
+	Gravity       string `json:"gravity,omitempty" yaml:"gravity,omitempty"`
+
+	Text     string  `json:"text,omitempty" yaml:"text,omitempty"`
+	Position string  `json:"position,omitempty" yaml:"position,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty" yaml:"opacity,omitempty"`
+	Size     float64 `json:"size,omitempty" yaml:"size,omitempty"`
+	Color    string  `json:"color,omitempty" yaml:"color,omitempty"`
+	Bg       string  `json:"bg,omitempty" yaml:"bg,omitempty"`
+
+	Value float64 `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// Validate checks the operation's kind and parameters.
+func (o Op) Validate() error {
+	switch o.Kind {
+	case OpAutoRotate, OpSharpen, OpGrayscale, OpInvert:
+	case OpResize:
+		if o.Width < 0 || o.Height < 0 || o.MaxDim < 0 {
+			return fmt.Errorf("dimensions must not be negative")
+		}
+		if o.Width == 0 && o.Height == 0 && o.MaxDim == 0 {
+			return fmt.Errorf("width, height or max_dim required")
+		}
+		switch o.Interpolation {
+		case "", "nearest", "bilinear", "catmullrom":
+		default:
+			return fmt.Errorf("unknown interpolation %q (use nearest, bilinear or catmullrom)", o.Interpolation)
+		}
+	case OpCrop:
+		if o.Ratio != "" {
+			w, h, ok := parseRatio(o.Ratio)
+			if !ok || w < 1 || h < 1 {
+				return fmt.Errorf("invalid ratio %q (use W:H, e.g. 16:9)", o.Ratio)
+			}
+		} else if o.Width < 1 || o.Height < 1 {
+			return fmt.Errorf("width and height, or ratio, required")
+		}
+		switch o.Gravity {
+		case "", "center", "north", "south", "east", "west":
+		default:
+			return fmt.Errorf("unknown gravity %q (use center, north, south, east or west)", o.Gravity)
+		}
+	case OpSmartCrop:
+		if o.Width < 1 || o.Height < 1 {
+			return fmt.Errorf("width and height required")
+		}
+	case OpWatermark:
+		if o.Text == "" {
+			return fmt.Errorf("text required")
+		}
+		switch o.Position {
+		case "", "top-left", "top-right", "bottom-left", "bottom-right", "center":
+		default:
+			return fmt.Errorf("unknown position %q (use top-left, top-right, bottom-left, bottom-right or center)", o.Position)
+		}
+		if o.Opacity < 0 || o.Opacity > 1 {
+			return fmt.Errorf("opacity must be between 0 and 1")
+		}
+		if o.Size < 0 {
+			return fmt.Errorf("size must not be negative")
+		}
+	case OpBrightness, OpContrast:
+		if o.Value < -100 || o.Value > 100 || o.Value == 0 {
+			return fmt.Errorf("value must be between -100 and 100 and not 0")
+		}
+	case OpBlur:
+		if o.Value <= 0 {
+			return fmt.Errorf("value (radius) must be positive")
+		}
+	case OpSepia:
+		if o.Value < 0 || o.Value > 1 {
+			return fmt.Errorf("value must be between 0 and 1")
+		}
+	case "":
+		return fmt.Errorf("missing operation kind")
+	default:
+		names := make([]string, len(OpKinds))
+		for i, k := range OpKinds {
+			names[i] = string(k)
+		}
+		return fmt.Errorf("unknown operation %q (use %s)", o.Kind, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// ValidateOps validates a chain, naming the first invalid operation.
+func ValidateOps(ops []Op) error {
+	for i, o := range ops {
+		if err := o.Validate(); err != nil {
+			if o.Kind == "" {
+				return fmt.Errorf("op %d: %w", i+1, err)
+			}
+			return fmt.Errorf("op %d (%s): %w", i+1, o.Kind, err)
+		}
+	}
+	return nil
+}
+
+// ParseOp parses the CLI form of an operation: the kind, optionally
+// followed by ":" and comma-separated key=value parameters. resize, crop
+// and smart-crop also take WxH, and the filters a bare value:
+//
+//	resize:max=1200  resize:800x600  crop:ratio=16:9,gravity=north
+//	watermark:text=PROOF,pos=center,opacity=0.3  blur:2.5  grayscale
+//
+// Keys are the Op field names in snake case; pos is short for position.
+func ParseOp(s string) (Op, error) {
+	kind, params, _ := strings.Cut(strings.TrimSpace(s), ":")
+	op := Op{Kind: OpKind(strings.ToLower(kind))}
+	if params != "" {
+		for _, p := range strings.Split(params, ",") {
+			key, value, hasValue := strings.Cut(p, "=")
+			if !hasValue {
+				key, value = "", p
+			}
+			if err := op.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+				return Op{}, fmt.Errorf("op %q: %w", s, err)
+			}
+		}
+	}
+	if err := op.Validate(); err != nil {
+		return Op{}, fmt.Errorf("op %q: %w", s, err)
+	}
+	return op, nil
+}
+
+// set assigns one parameter of the CLI form. An empty key is the bare
+// value: WxH or a number.
+func (o *Op) set(key, value string) error {
+	var err error
+	switch key {
+	case "":
+		switch o.Kind {
+		case OpResize, OpCrop, OpSmartCrop:
+			w, h, ok := strings.Cut(value, "x")
+			if !ok {
+				return fmt.Errorf("%q is not WxH", value)
+			}
+			if o.Width, err = atoiOrZero(w); err == nil {
+				o.Height, err = atoiOrZero(h)
+			}
+		default:
+			o.Value, err = strconv.ParseFloat(value, 64)
+		}
+	case "width":
+		o.Width, err = strconv.Atoi(value)
+	case "height":
+		o.Height, err = strconv.Atoi(value)
+	case "max", "max_dim":
+		o.MaxDim, err = strconv.Atoi(value)
+	case "interpolation":
+		o.Interpolation = value
+	case "ratio":
+		o.Ratio = value
+	case "gravity":
+		o.Gravity = value
+	case "text":
+		o.Text = value
+	case "pos", "position":
+		o.Position = value
+	case "opacity":
+		o.Opacity, err = strconv.ParseFloat(value, 64)
+	case "size":
+		o.Size, err = strconv.ParseFloat(value, 64)
+	case "color":
+		o.Color = value
+	case "bg":
+		o.Bg = value
+	case "value", "radius", "intensity":
+		o.Value, err = strconv.ParseFloat(value, 64)
+	default:
+		return fmt.Errorf("unknown parameter %q", key)
+	}
+	if err != nil {
+		if key == "" {
+			key = "value"
+		}
+		return fmt.Errorf("invalid %s %q", key, value)
+	}
+	return nil
+}
+
+// atoiOrZero parses a dimension of WxH, where either side may be empty.
+func atoiOrZero(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// parseRatio parses "W:H".
+func parseRatio(s string) (int, int, bool) {
+	w, h, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, false
+	}
+	wi, err1 := strconv.Atoi(w)
+	hi, err2 := strconv.Atoi(h)
+	return wi, hi, err1 == nil && err2 == nil
+}
+
+// Operations returns the job's operation chain: the flat transform fields
+// translated in their historical order (auto-rotate, smart crop, crop,
+// resize, watermark, brightness, contrast, sharpen, blur, grayscale, sepia,
+// invert), followed by Ops.
+func (j Job) Operations() []Op {
+	var ops []Op
+	if j.AutoRotate {
+		ops = append(ops, Op{Kind: OpAutoRotate})
+	}
+	if j.SmartCropWidth > 0 && j.SmartCropHeight > 0 {
+		ops = append(ops, Op{Kind: OpSmartCrop, Width: j.SmartCropWidth, Height: j.SmartCropHeight})
+	}
+	if j.CropWidth > 0 || j.CropHeight > 0 || j.CropAspectRatio != "" {
+		ops = append(ops, Op{Kind: OpCrop, Width: j.CropWidth, Height: j.CropHeight, Ratio: j.CropAspectRatio, Gravity: j.CropGravity})
+	}
+	if j.Width > 0 || j.Height > 0 || j.MaxDim > 0 {
+		ops = append(ops, Op{Kind: OpResize, Width: j.Width, Height: j.Height, MaxDim: j.MaxDim})
+	}
+	if j.WatermarkText != "" {
+		ops = append(ops, Op{
+			Kind:     OpWatermark,
+			Text:     j.WatermarkText,
+			Position: j.WatermarkPos,
+			Opacity:  j.WatermarkOpacity,
+			Size:     j.WatermarkSize,
+			Color:    j.WatermarkColor,
+			Bg:       j.WatermarkBg,
+		})
+	}
+	if j.Brightness != 0 {
+		ops = append(ops, Op{Kind: OpBrightness, Value: j.Brightness})
+	}
+	if j.Contrast != 0 {
+		ops = append(ops, Op{Kind: OpContrast, Value: j.Contrast})
+	}
+	if j.Sharpen {
+		ops = append(ops, Op{Kind: OpSharpen})
+	}
+	if j.Blur > 0 {
+		ops = append(ops, Op{Kind: OpBlur, Value: j.Blur})
+	}
+	if j.Grayscale {
+		ops = append(ops, Op{Kind: OpGrayscale})
+	}
+	if j.Sepia > 0 {
+		ops = append(ops, Op{Kind: OpSepia, Value: j.Sepia})
+	}
+	if j.Invert {
+		ops = append(ops, Op{Kind: OpInvert})
+	}
+	return append(ops, j.Ops...)
+}
+
+// ChangesImage reports whether the job transforms the image or optimizes
+// its encoding rather than only converting its format, so a job whose
+// output format is its input format still has work to do. Auto-rotate
+// counts, since the orientation is only known once the file is read; an
+// upright JPEG is then copied rather than re-encoded.
+func (j Job) ChangesImage() bool {
+	return len(j.Operations()) > 0 || j.EncodeOpts.PNGOptimize
+}
+
+// hasOp reports whether the job's chain contains an operation of kind.
+func (j Job) hasOp(kind OpKind) bool {
+	for _, o := range j.Operations() {
+		if o.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// apply performs the operation on img. Smart crop and blur stop once ctx
+// is done.
+func (o Op) apply(ctx context.Context, img image.Image, job Job) (image.Image, error) {
+	switch o.Kind {
+	case OpAutoRotate:
+		if job.EXIFOrientation > 1 {
+			img = transform.AutoRotate(img, job.EXIFOrientation)
+		}
+	case OpSmartCrop:
+		return transform.SmartCropContext(ctx, img, o.Width, o.Height)
+	case OpCrop:
+		img = transform.Crop(img, o.cropOptions())
+	case OpResize:
+		interp := o.Interpolation
+		if interp == "" {
+			interp = job.Interpolation
+		}
+		img = resize.Resize(img, resize.ResizeOptions{
+			Width:         o.Width,
+			Height:        o.Height,
+			MaxDim:        o.MaxDim,
+			Interpolation: interp,
+		})
+	case OpWatermark:
+		img = transform.ApplyWatermark(img, transform.WatermarkOptions{
+			Text:     o.Text,
+			Position: o.Position,
+			Opacity:  o.Opacity,
+			FontSize: o.Size,
+			Color:    o.Color,
+			BgColor:  o.Bg,
+		})
+	case OpBrightness:
+		img = transform.Brightness(img, o.Value)
+	case OpContrast:
+		img = transform.Contrast(img, o.Value)
+	case OpSharpen:
+		img = transform.Sharpen(img)
+	case OpBlur:
+		return transform.BlurContext(ctx, img, o.Value)
+	case OpGrayscale:
+		img = transform.Grayscale(img)
+	case OpSepia:
+		intensity := o.Value
+		if intensity == 0 {
+			intensity = 1
+		}
+		img = transform.Sepia(img, intensity)
+	case OpInvert:
+		img = transform.Invert(img)
+	}
+	return img, nil
+}
+
+// cropOptions returns the transform options of a crop operation.
+func (o Op) cropOptions() transform.CropOptions {
+	return transform.CropOptions{Width: o.Width, Height: o.Height, AspectRatio: o.Ratio, Gravity: o.Gravity}
+}
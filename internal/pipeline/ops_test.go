@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+func TestParseOp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Op
+	}{
+		{"resize:max=1200", Op{Kind: OpResize, MaxDim: 1200}},
+		{"resize:800x", Op{Kind: OpResize, Width: 800}},
+		{"Crop:ratio=16:9,gravity=north", Op{Kind: OpCrop, Ratio: "16:9", Gravity: "north"}},
+		{"smart-crop:400x300", Op{Kind: OpSmartCrop, Width: 400, Height: 300}},
+		{"watermark:text=PROOF,pos=center,opacity=0.3", Op{Kind: OpWatermark, Text: "PROOF", Position: "center", Opacity: 0.3}},
+		{"blur:2.5", Op{Kind: OpBlur, Value: 2.5}},
+		{"brightness:value=-20", Op{Kind: OpBrightness, Value: -20}},
+		{"grayscale", Op{Kind: OpGrayscale}},
+	}
+	for _, tt := range tests {
+		got, err := ParseOp(tt.in)
+		if err != nil {
+			t.Errorf("ParseOp(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseOp(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for in, msg := range map[string]string{
+		"spin":             "unknown operation",
+		"resize":           "width, height or max_dim required",
+		"resize:big":       "not WxH",
+		"crop:ratio=wide":  "invalid ratio",
+		"blur:radius=x":    "invalid radius",
+		"blur":             "must be positive",
+		"sepia:2":          "between 0 and 1",
+		"resize:speed=1":   "unknown parameter",
+		"watermark:pos=up": "text required",
+	} {
+		if _, err := ParseOp(in); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ParseOp(%q) error = %v, want %q", in, err, msg)
+		}
+	}
+}
+
+func TestValidateOps(t *testing.T) {
+	err := ValidateOps([]Op{{Kind: OpGrayscale}, {Kind: OpCrop, Gravity: "up", Width: 1, Height: 1}})
+	if err == nil || err.Error() != `op 2 (crop): unknown gravity "up" (use center, north, south, east or west)` {
+		t.Errorf("error = %v", err)
+	}
+	if err := ValidateOps([]Op{{Width: 1}}); err == nil || !strings.HasPrefix(err.Error(), "op 1: missing") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestJob_Operations(t *testing.T) {
+	job := Job{
+		Invert:     true,
+		MaxDim:     100,
+		AutoRotate: true,
+		Blur:       1,
+		Ops:        []Op{{Kind: OpCrop, Ratio: "1:1"}},
+	}
+	var kinds []OpKind
+	for _, o := range job.Operations() {
+		kinds = append(kinds, o.Kind)
+	}
+	want := []OpKind{OpAutoRotate, OpResize, OpBlur, OpInvert, OpCrop}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("operations = %v, want %v", kinds, want)
+	}
+	if (Job{}).Operations() != nil {
+		t.Error("a job without transforms has operations")
+	}
+}
+
+func TestTransformImage_OpOrder(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 80))
+	// Cropping a 60x60 square first, then resizing to 30 wide gives 30x30;
+	// resizing first leaves the crop larger than the image
+	cropFirst, _ := transformImage(context.Background(), img, Job{Ops: []Op{{Kind: OpCrop, Width: 60, Height: 60}, {Kind: OpResize, Width: 30}}})
+	resizeFirst, _ := transformImage(context.Background(), img, Job{Ops: []Op{{Kind: OpResize, Width: 30}, {Kind: OpCrop, Width: 60, Height: 60}}})
+	if b := cropFirst.Bounds(); b.Dx() != 30 || b.Dy() != 30 {
+		t.Errorf("crop then resize = %v", b.Size())
+	}
+	if b := resizeFirst.Bounds(); b.Dx() != 30 || b.Dy() != 24 {
+		t.Errorf("resize then crop = %v", b.Size())
+	}
+}
+
+func TestCanTransformJPEGLossless_OpOrder(t *testing.T) {
+	base := Job{OutputFormat: codec.JPEG, EXIFOrientation: 6}
+	tests := []struct {
+		name string
+		ops  []Op
+		want bool
+	}{
+		{"rotate then crop", []Op{{Kind: OpAutoRotate}, {Kind: OpCrop, Ratio: "1:1"}}, true},
+		{"crop then rotate", []Op{{Kind: OpCrop, Ratio: "1:1"}, {Kind: OpAutoRotate}}, false},
+		{"two crops", []Op{{Kind: OpCrop, Ratio: "1:1"}, {Kind: OpCrop, Width: 8, Height: 8}}, false},
+		{"crop then resize", []Op{{Kind: OpCrop, Ratio: "1:1"}, {Kind: OpResize, Width: 8}}, false},
+	}
+	for _, tt := range tests {
+		job := base
+		job.Ops = tt.ops
+		if got := canTransformJPEGLossless(codec.JPEG, job); got != tt.want {
+			t.Errorf("%s: lossless = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExecute_InvalidOps(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPipeline(codec.DefaultRegistry())
+	_, _, err := p.Execute(Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputPath:   filepath.Join(dir, "out.png"),
+		OutputFormat: codec.PNG,
+		Ops:          []Op{{Kind: OpBlur}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "op 1 (blur)") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// writeOutput writes an encoded image to the job's output path atomically.
+// The data goes to a temporary file in the output directory, which is
+// synced and then renamed over the output, so a crash or a cancelled job
+// never leaves a truncated file at the final path and a failed job keeps
+// any previous output. A replaced output keeps its permissions; with
+// PreserveAttrs the output takes the input's permissions and modification
+// time instead. With NoOverwrite an existing output fails the job.
+func writeOutput(ctx context.Context, job Job, data []byte) (err error) {
+	if job.NoOverwrite {
+		if _, err := os.Lstat(job.OutputPath); err == nil {
+			return stageError(ErrIO, "write "+job.OutputPath, fs.ErrExist)
+		}
+	}
+	tmp, err := createTemp(job.OutputPath)
+	if err != nil {
+		return stageError(ErrIO, "create "+job.OutputPath, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return stageError(ErrIO, "write "+job.OutputPath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return stageError(ErrIO, "sync "+job.OutputPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return stageError(ErrIO, "write "+job.OutputPath, err)
+	}
+	if err := copyAttrs(tmp.Name(), job); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), job.OutputPath); err != nil {
+		return stageError(ErrIO, "rename "+job.OutputPath, err)
+	}
+	return nil
+}
+
+// createTemp creates a hidden temporary file next to path. Its ".tmp"
+// extension keeps watch mode and directory scans from picking it up.
+func createTemp(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; i < 100; i++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(uint64(rand.Uint32()), 36)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("no unused temporary name in %s", dir)
+}
+
+// copyAttrs gives the temporary file the permissions of the output it
+// replaces, or with PreserveAttrs the permissions and modification time of
+// the input.
+func copyAttrs(tmp string, job Job) error {
+	src := job.OutputPath
+	if job.PreserveAttrs {
+		src = job.InputPath
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		if !job.PreserveAttrs && os.IsNotExist(err) {
+			return nil
+		}
+		return stageError(ErrIO, "preserve attributes", err)
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return stageError(ErrIO, "preserve attributes", err)
+	}
+	if job.PreserveAttrs {
+		if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+			return stageError(ErrIO, "preserve attributes", err)
+		}
+	}
+	return nil
+}
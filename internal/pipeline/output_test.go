@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+func TestExecute_FailedInjectKeepsPreviousOutput(t *testing.T) {
+	dir := t.TempDir()
+	in := createTestJPEGWithExif(t, dir, "in.jpg", buildTestEXIF(1))
+	out := filepath.Join(dir, "out.gif")
+	if err := os.WriteFile(out, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// GIF has no place for EXIF, so the metadata cannot be injected
+	p := NewPipeline(codec.DefaultRegistry())
+	if _, _, err := p.Execute(Job{
+		InputPath:        in,
+		OutputPath:       out,
+		OutputFormat:     codec.GIF,
+		PreserveMetadata: true,
+	}); err == nil {
+		t.Fatal("expected metadata inject error")
+	}
+	if data, _ := os.ReadFile(out); string(data) != "previous" {
+		t.Errorf("previous output replaced by %d bytes", len(data))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("temporary file left behind: %d entries", len(entries))
+	}
+}
+
+func TestExecute_KeepsModeOfReplacedOutput(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.png")
+	if err := os.WriteFile(out, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPipeline(codec.DefaultRegistry())
+	if _, _, err := p.Execute(Job{InputPath: createTestJPEG(t, dir), OutputPath: out, OutputFormat: codec.PNG}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 || info.Size() == 0 {
+		t.Errorf("output mode = %v, size = %d", info.Mode().Perm(), info.Size())
+	}
+}
+
+func TestExecute_PreserveAttrs(t *testing.T) {
+	dir := t.TempDir()
+	in := createTestJPEG(t, dir)
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chmod(in, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(in, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.png")
+	p := NewPipeline(codec.DefaultRegistry())
+	if _, _, err := p.Execute(Job{InputPath: in, OutputPath: out, OutputFormat: codec.PNG, PreserveAttrs: true}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(mtime) {
+		t.Errorf("output mode = %v, mtime = %v", info.Mode().Perm(), info.ModTime())
+	}
+}
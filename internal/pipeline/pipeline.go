@@ -1,20 +1,35 @@
 package pipeline
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
 	"image"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
 
 	"github.com/DanielTso/pixshift/internal/codec"
 	"github.com/DanielTso/pixshift/internal/metadata"
-	"github.com/DanielTso/pixshift/internal/resize"
-	"github.com/DanielTso/pixshift/internal/transform"
 )
 
 // Pipeline executes the detect -> decode -> transform -> encode -> metadata inject flow.
 type Pipeline struct {
 	Registry *codec.Registry
+
+	// JobTimeout limits how long a job may run (0 = no limit). A job that
+	// runs longer fails with a *TimeoutError, also when it is stuck in code
+	// that never checks for cancellation; such a job is abandoned and
+	// finishes in the background without writing its output. Its goroutine
+	// and memory are leaked until the stuck call returns, which may be
+	// never; a Pool keeps the job's memory reserved until then, or for at
+	// most another JobTimeout.
+	JobTimeout time.Duration
+
+	// Hooks are called at the stages of every job, in order; see Hooks.
+	Hooks []Hooks
 }
 
 // NewPipeline creates a pipeline with the given codec registry.
@@ -24,6 +39,109 @@ func NewPipeline(reg *codec.Registry) *Pipeline {
 
 // Execute runs a single conversion job and returns file sizes.
 func (p *Pipeline) Execute(job Job) (inputSize, outputSize int64, err error) {
+	return p.ExecuteContext(context.Background(), job)
+}
+
+// ExecuteContext is Execute with cancellation: see ExecuteJobContext.
+func (p *Pipeline) ExecuteContext(ctx context.Context, job Job) (inputSize, outputSize int64, err error) {
+	res := p.ExecuteJobContext(ctx, job)
+	return res.InputSize, res.OutputSize, res.Error
+}
+
+// ExecuteJob runs a single conversion job and returns its full result. When
+// the job's output format is codec.Auto, the result's Job carries the chosen
+// format and final output path, and Auto records how the choice was made.
+// Transcoded is set when a JPEG<->JXL job was converted, or a JPEG was
+// rotated, cropped or copied, losslessly at the bitstream level.
+func (p *Pipeline) ExecuteJob(job Job) Result {
+	return p.ExecuteJobContext(context.Background(), job)
+}
+
+// ExecuteJobContext is ExecuteJob with cancellation. Decoding, encoding,
+// long-running filters and auto-format trials stop once ctx is done; the
+// result's error is then the context's error and no output is written.
+// A panic during the job is returned as a *PanicError, and a job running
+// longer than JobTimeout fails with a *TimeoutError.
+func (p *Pipeline) ExecuteJobContext(ctx context.Context, job Job) Result {
+	return p.executeJobContext(ctx, job, nil)
+}
+
+// executeJobContext is ExecuteJobContext calling finished, if not nil,
+// once the job has stopped running. That is before it returns, except for
+// a job abandoned after JobTimeout, which is still running.
+func (p *Pipeline) executeJobContext(ctx context.Context, job Job, finished func()) Result {
+	if finished == nil {
+		finished = func() {}
+	}
+	if p.JobTimeout <= 0 {
+		defer finished()
+		return p.executeJob(ctx, job)
+	}
+
+	timeout := &TimeoutError{Timeout: p.JobTimeout}
+	ctx, cancel := context.WithTimeoutCause(ctx, p.JobTimeout, timeout)
+	defer cancel()
+
+	// The job runs on its own goroutine so a decoder that never returns
+	// cannot hold the caller past the timeout
+	done := make(chan Result, 1)
+	go func() {
+		res := p.executeJob(ctx, job)
+		finished()
+		done <- res
+	}()
+	var res Result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		select {
+		case res = <-done:
+		default:
+			res = Result{Job: job, Error: ctx.Err()}
+		}
+	}
+	if errors.Is(res.Error, context.DeadlineExceeded) && context.Cause(ctx) == timeout {
+		res.Error = timeout
+	}
+	return res
+}
+
+// executeJob runs the job, turning a panic into a *PanicError.
+func (p *Pipeline) executeJob(ctx context.Context, job Job) (res Result) {
+	res.Job = job
+	defer func() {
+		if v := recover(); v != nil {
+			res.Error = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	res.InputSize, res.OutputSize, res.Error = p.execute(ctx, job, &res)
+	return res
+}
+
+// execute performs the conversion, recording auto-format and transcoding
+// decisions in res.
+func (p *Pipeline) execute(ctx context.Context, job Job, res *Result) (inputSize, outputSize int64, err error) {
+	if err := ValidateOps(job.Ops); err != nil {
+		return 0, 0, err
+	}
+	if err := ValidateVariants(job.Variants, job.OutputFormat); err != nil {
+		return 0, 0, err
+	}
+	if err := job.EncodeOpts.Validate(); err != nil {
+		return 0, 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	// A cancelled job reports the cancellation rather than the decode or
+	// encode failure it caused
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
 	// Get input file size
 	info, statErr := os.Stat(job.InputPath)
 	if statErr == nil {
@@ -34,14 +152,14 @@ func (p *Pipeline) Execute(job Job) (inputSize, outputSize int64, err error) {
 	if job.BackupOriginal {
 		backupPath := job.InputPath + ".bak"
 		if err := copyFile(job.InputPath, backupPath); err != nil {
-			return inputSize, 0, fmt.Errorf("backup %s: %w", job.InputPath, err)
+			return inputSize, 0, stageError(ErrIO, "backup "+job.InputPath, err)
 		}
 	}
 
 	// Open input file
 	f, err := os.Open(job.InputPath)
 	if err != nil {
-		return inputSize, 0, fmt.Errorf("open %s: %w", job.InputPath, err)
+		return inputSize, 0, stageError(ErrIO, "open "+job.InputPath, err)
 	}
 	defer f.Close()
 
@@ -50,14 +168,30 @@ func (p *Pipeline) Execute(job Job) (inputSize, outputSize int64, err error) {
 	if inputFormat == "" {
 		inputFormat, err = codec.DetectFormat(f, job.InputPath)
 		if err != nil {
-			return inputSize, 0, fmt.Errorf("detect format: %w", err)
+			return inputSize, 0, stageError(ErrUnsupportedFormat, "detect format", err)
+		}
+	}
+
+	// Output templates may name directories that do not exist yet
+	for _, path := range job.OutputPaths() {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return inputSize, 0, stageError(ErrIO, "create output directory", err)
 		}
 	}
 
-	// Extract metadata before decoding (for preservation or auto-rotate)
+	// Extract metadata before decoding (for preservation, auto-rotate or
+	// the sidecar)
 	var meta *metadata.Metadata
-	needMeta := (job.PreserveMetadata && !job.StripMetadata) || job.AutoRotate
-	if needMeta {
+	needMeta := job.keepsMetadata() || job.hasOp(OpAutoRotate) || job.Sidecar != ""
+	if job.FromSidecar {
+		path, err := metadata.FindSidecar(job.InputPath)
+		if err != nil {
+			return inputSize, 0, metadataError("", err)
+		}
+		if meta, err = metadata.ReadSidecar(path); err != nil {
+			return inputSize, 0, metadataError("", err)
+		}
+	} else if needMeta {
 		meta, err = metadata.Extract(f, inputFormat)
 		if err != nil {
 			// Non-fatal: warn but continue without metadata
@@ -65,10 +199,40 @@ func (p *Pipeline) Execute(job Job) (inputSize, outputSize int64, err error) {
 		}
 		// Reset file position after metadata extraction
 		if _, err := f.Seek(0, 0); err != nil {
-			return inputSize, 0, fmt.Errorf("seek: %w", err)
+			return inputSize, 0, stageError(ErrIO, "seek", err)
 		}
 	}
 
+	if job.Sidecar != "" {
+		// The sidecar keeps the source metadata even when the output
+		// drops it. It is written once the output is, next to every
+		// variant, at the path chosen for Auto output.
+		icc, _ := metadata.ExtractICC(f, inputFormat)
+		sidecar := metadata.NewSidecar(job.InputPath, meta, icc)
+		if _, err := f.Seek(0, 0); err != nil {
+			return inputSize, 0, stageError(ErrIO, "seek", err)
+		}
+		defer func() {
+			if v := recover(); v != nil {
+				panic(v) // a job that panicked gets no sidecar
+			}
+			if err != nil {
+				return
+			}
+			if len(res.Variants) == 0 {
+				if err = sidecar.Write(res.Job.OutputPath, job.Sidecar); err != nil {
+					err = stageError(ErrIO, "", err)
+				}
+			}
+			for _, v := range res.Variants {
+				if err = sidecar.Write(v.OutputPath, job.Sidecar); err != nil {
+					err = stageError(ErrIO, "", err)
+					return
+				}
+			}
+		}()
+	}
+
 	// Populate EXIF orientation from extracted metadata
 	if meta != nil {
 		if orient := meta.Orientation(); orient > 0 {
@@ -77,190 +241,276 @@ func (p *Pipeline) Execute(job Job) (inputSize, outputSize int64, err error) {
 	}
 
 	// Don't inject metadata if we only extracted it for auto-rotate
-	injectMeta := job.PreserveMetadata && !job.StripMetadata
+	injectMeta := job.keepsMetadata()
+	if injectMeta && meta != nil {
+		if meta, err = meta.ApplyPolicy(job.MetadataPolicy); err != nil {
+			return inputSize, 0, metadataError("metadata policy", err)
+		}
+	}
+	if !job.XMP.IsZero() || !job.IPTC.IsZero() {
+		// XMP and IPTC fields are written even when the source metadata is dropped
+		if !injectMeta {
+			meta = nil
+		}
+		if meta, err = meta.WithXMPFields(job.XMP); err != nil {
+			return inputSize, 0, metadataError("xmp", err)
+		}
+		if meta, err = meta.WithIPTCFields(job.IPTC); err != nil {
+			return inputSize, 0, metadataError("iptc", err)
+		}
+		injectMeta = true
+	}
+	if injectMeta {
+		if job, err = withEncoderMetadata(job, meta); err != nil {
+			return inputSize, 0, err
+		}
+	}
+
+	if err := p.preDecode(ctx, job, inputFormat); err != nil {
+		return inputSize, 0, err
+	}
+
+	// Variants share one decode and the job's transforms
+	if len(job.Variants) > 0 {
+		outputSize, err = p.executeVariants(ctx, f, inputFormat, job, res, meta, injectMeta)
+		return inputSize, outputSize, err
+	}
+
+	// Lossless JPEG<->JXL transcoding or JPEG rotation/crop (no pixel
+	// decode, so not for hooks that need the image)
+	var data []byte
+	var transcoded bool
+	if !p.hasImageHooks() {
+		if data, transcoded, err = p.transcodeLossless(f, inputFormat, job); err != nil {
+			return inputSize, 0, err
+		}
+	}
+	if transcoded {
+		res.Transcoded = true
+		if data, err = p.postEncode(ctx, job, data); err != nil {
+			return inputSize, 0, err
+		}
+		if err := writeOutput(ctx, job, data); err != nil {
+			return inputSize, 0, err
+		}
+		return inputSize, int64(len(data)), nil
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return inputSize, 0, stageError(ErrIO, "seek", err)
+	}
 
 	// Get decoder
 	dec, err := p.Registry.Decoder(inputFormat)
 	if err != nil {
-		return inputSize, 0, err
+		return inputSize, 0, stageError(ErrUnsupportedFormat, "", err)
 	}
 
-	// Get encoder
-	enc, err := p.Registry.Encoder(job.OutputFormat)
-	if err != nil {
-		return inputSize, 0, err
+	// Get encoder (resolved after trial encoding for auto output)
+	auto := job.OutputFormat == codec.Auto
+	var enc codec.Encoder
+	if !auto {
+		enc, err = p.Registry.Encoder(job.OutputFormat)
+		if err != nil {
+			return inputSize, 0, stageError(ErrUnsupportedFormat, "", err)
+		}
 	}
 
 	// Check for multi-frame support
 	mfDec, isMultiFrame := dec.(codec.MultiFrameDecoder)
 	mfEnc, canEncodeMultiFrame := enc.(codec.MultiFrameEncoder)
+	if auto {
+		canEncodeMultiFrame = len(p.animatedCandidates(job)) > 0
+	}
 
 	if isMultiFrame && canEncodeMultiFrame {
 		// Multi-frame path
-		anim, decErr := mfDec.DecodeAll(f)
+		anim, decErr := mfDec.DecodeAll(contextReader{ctx, f})
 		if decErr != nil {
-			return inputSize, 0, fmt.Errorf("decode animated %s: %w", inputFormat, decErr)
+			return inputSize, 0, stageError(ErrDecode, "decode animated "+string(inputFormat), decErr)
 		}
 
 		if len(anim.Frames) > 1 {
 			// Process each frame
 			for i, frame := range anim.Frames {
-				anim.Frames[i] = transformImage(frame, job)
+				if frame, err = p.postDecode(ctx, job, i, frame); err != nil {
+					return inputSize, 0, err
+				}
+				if frame, err = p.transform(ctx, job, i, frame); err != nil {
+					return inputSize, 0, err
+				}
+				if anim.Frames[i], err = p.preEncode(ctx, job, i, frame); err != nil {
+					return inputSize, 0, err
+				}
 			}
 
-			// Create output file
-			out, createErr := os.Create(job.OutputPath)
-			if createErr != nil {
-				return inputSize, 0, fmt.Errorf("create %s: %w", job.OutputPath, createErr)
+			if auto {
+				decision, animData, autoErr := p.chooseAnimatedFormat(ctx, anim, job)
+				if autoErr != nil {
+					return inputSize, 0, autoErr
+				}
+				job = resolveAuto(job, res, decision)
+				data = animData
+			} else {
+				var buf bytes.Buffer
+				if encErr := mfEnc.EncodeAll(contextWriter{ctx, &buf}, anim); encErr != nil {
+					return inputSize, 0, stageError(ErrEncode, "encode animated "+string(job.OutputFormat), encErr)
+				}
+				data = buf.Bytes()
 			}
-			defer out.Close()
-
-			if encErr := mfEnc.EncodeAll(out, anim); encErr != nil {
-				os.Remove(job.OutputPath)
-				return inputSize, 0, fmt.Errorf("encode animated %s: %w", job.OutputFormat, encErr)
+			if data, err = p.postEncode(ctx, job, data); err != nil {
+				return inputSize, 0, err
 			}
-			out.Close()
-
-			// Get output file size
-			outInfo, outStatErr := os.Stat(job.OutputPath)
-			if outStatErr == nil {
-				outputSize = outInfo.Size()
+			if err := writeOutput(ctx, job, data); err != nil {
+				return inputSize, 0, err
 			}
-			return inputSize, outputSize, nil
+			return inputSize, int64(len(data)), nil
 		}
 		// Single frame animated image - fall through to normal path
 		// Reset file position for normal decode
 		if _, seekErr := f.Seek(0, 0); seekErr != nil {
-			return inputSize, 0, fmt.Errorf("seek: %w", seekErr)
+			return inputSize, 0, stageError(ErrIO, "seek", seekErr)
 		}
 	}
 
 	// Normal single-frame path
-	img, err := dec.Decode(f)
+	img, err := dec.Decode(contextReader{ctx, f})
 	if err != nil {
-		return inputSize, 0, fmt.Errorf("decode %s: %w", inputFormat, err)
+		return inputSize, 0, stageError(ErrDecode, "decode "+string(inputFormat), err)
 	}
 
-	img = transformImage(img, job)
+	if img, err = p.postDecode(ctx, job, 0, img); err != nil {
+		return inputSize, 0, err
+	}
+	if img, err = p.transform(ctx, job, 0, img); err != nil {
+		return inputSize, 0, err
+	}
+	outputSize, err = p.encodeOutput(ctx, img, job, res, meta, injectMeta)
+	return inputSize, outputSize, err
+}
 
-	// Create output file
-	out, err := os.Create(job.OutputPath)
+// encodeOutput encodes img for the job, choosing the format for Auto
+// output, with the kept metadata updated to img's geometry and injected,
+// and writes it to the job's output path. It returns the output size.
+func (p *Pipeline) encodeOutput(ctx context.Context, img image.Image, job Job, res *Result, meta *metadata.Metadata, injectMeta bool) (int64, error) {
+	img, err := p.preEncode(ctx, job, 0, img)
 	if err != nil {
-		return inputSize, 0, fmt.Errorf("create %s: %w", job.OutputPath, err)
+		return 0, err
 	}
-	defer out.Close()
-
-	// Encode (use AdvancedEncoder if available and options are set)
-	opts := job.EncodeOpts
-	useAdvanced := false
-	if _, ok := enc.(codec.AdvancedEncoder); ok {
-		if opts.Progressive || opts.Compression != 0 || opts.WebPMethod != 0 || opts.Lossless {
-			useAdvanced = true
+	if injectMeta && changesGeometry(job) {
+		// The source EXIF describes the image before it was reoriented,
+		// cropped or resized
+		b := img.Bounds()
+		meta, err = meta.WithGeometry(metadata.Geometry{
+			Width:     b.Dx(),
+			Height:    b.Dy(),
+			Upright:   autoRotateOrientation(job) > 1,
+			Thumbnail: img,
+		})
+		if err != nil {
+			return 0, metadataError("exif geometry", err)
 		}
-	}
-	if useAdvanced {
-		if opts.Quality == 0 {
-			opts.Quality = job.Quality
+		if job, err = withEncoderMetadata(job, meta); err != nil {
+			return 0, err
 		}
-		if err := enc.(codec.AdvancedEncoder).EncodeWithOptions(out, img, opts); err != nil {
-			os.Remove(job.OutputPath)
-			return inputSize, 0, fmt.Errorf("encode %s: %w", job.OutputFormat, err)
+	}
+
+	var data []byte
+	if job.OutputFormat == codec.Auto {
+		// Trial-encode the candidates and keep the winning encoding as-is
+		decision, autoData, err := p.chooseFormat(ctx, img, job)
+		if err != nil {
+			return 0, err
 		}
+		job = resolveAuto(job, res, decision)
+		data = autoData
 	} else {
-		if err := enc.Encode(out, img, job.Quality); err != nil {
-			os.Remove(job.OutputPath)
-			return inputSize, 0, fmt.Errorf("encode %s: %w", job.OutputFormat, err)
+		enc, err := p.Registry.Encoder(job.OutputFormat)
+		if err != nil {
+			return 0, stageError(ErrUnsupportedFormat, "", err)
 		}
+		var buf bytes.Buffer
+		if err := encodeImage(contextWriter{ctx, &buf}, enc, img, job); err != nil {
+			return 0, stageError(ErrEncode, "encode "+string(job.OutputFormat), err)
+		}
+		data = buf.Bytes()
 	}
 
-	// Close the file before metadata injection (needs to re-read/write)
-	out.Close()
-
 	// Inject metadata if available (and preservation was requested)
-	if injectMeta && meta.HasEXIF() {
-		if err := metadata.Inject(job.OutputPath, job.OutputFormat, meta); err != nil {
-			// Non-fatal: file was converted but metadata not preserved
-			return inputSize, 0, fmt.Errorf("metadata inject (file converted OK): %w", err)
+	if injectMeta && !meta.IsEmpty() && job.OutputFormat != codec.JXL {
+		if data, err = metadata.InjectBytes(data, job.OutputFormat, meta); err != nil {
+			return 0, metadataError("metadata inject", err)
 		}
 	}
 
-	// Get output file size
-	outInfo, statErr := os.Stat(job.OutputPath)
-	if statErr == nil {
-		outputSize = outInfo.Size()
+	if data, err = p.postEncode(ctx, job, data); err != nil {
+		return 0, err
 	}
 
-	return inputSize, outputSize, nil
+	// The output appears complete, with its metadata, or not at all
+	if err := writeOutput(ctx, job, data); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
 }
 
-// transformImage applies all transforms to a single image frame.
-func transformImage(img image.Image, job Job) image.Image {
-	// Auto-rotate based on EXIF orientation
-	if job.AutoRotate && job.EXIFOrientation > 1 {
-		img = transform.AutoRotate(img, job.EXIFOrientation)
+// withEncoderMetadata returns the job with the metadata the JXL encoder
+// embeds itself, with IPTC fields moved to XMP. Other formats get the
+// metadata injected after encoding.
+func withEncoderMetadata(job Job, meta *metadata.Metadata) (Job, error) {
+	jxlMeta, err := meta.WithIPTCInXMP()
+	if err != nil {
+		return job, metadataError("iptc", err)
 	}
-
-	// Smart crop (entropy-based)
-	if job.SmartCropWidth > 0 && job.SmartCropHeight > 0 {
-		img = transform.SmartCrop(img, job.SmartCropWidth, job.SmartCropHeight)
+	job.EncodeOpts.EXIF, job.EncodeOpts.XMP = jxlMeta.TIFF(), nil
+	if jxlMeta.HasXMP() {
+		job.EncodeOpts.XMP = jxlMeta.XMP
 	}
+	return job, nil
+}
 
-	// Crop if requested
-	if job.CropWidth > 0 || job.CropHeight > 0 || job.CropAspectRatio != "" {
-		img = transform.Crop(img, transform.CropOptions{
-			Width:       job.CropWidth,
-			Height:      job.CropHeight,
-			AspectRatio: job.CropAspectRatio,
-			Gravity:     job.CropGravity,
-		})
+// encodeImage encodes img with enc, using the AdvancedEncoder interface when
+// the encoder supports it and format-specific options are set.
+func encodeImage(w io.Writer, enc codec.Encoder, img image.Image, job Job) error {
+	opts := job.EncodeOpts
+	if enc.Format() != codec.JXL {
+		opts.EXIF, opts.XMP = nil, nil
 	}
-
-	// Resize if requested
-	if job.Width > 0 || job.Height > 0 || job.MaxDim > 0 {
-		img = resize.Resize(img, resize.ResizeOptions{
-			Width:         job.Width,
-			Height:        job.Height,
-			MaxDim:        job.MaxDim,
-			Interpolation: job.Interpolation,
-		})
+	adv, ok := enc.(codec.AdvancedEncoder)
+	if ok && hasAdvancedOptions(opts) {
+		if opts.Quality == 0 {
+			opts.Quality = job.Quality
+		}
+		return adv.EncodeWithOptions(w, img, opts)
 	}
+	return enc.Encode(w, img, job.Quality)
+}
 
-	// Watermark if requested
-	if job.WatermarkText != "" {
-		img = transform.ApplyWatermark(img, transform.WatermarkOptions{
-			Text:     job.WatermarkText,
-			Position: job.WatermarkPos,
-			Opacity:  job.WatermarkOpacity,
-			FontSize: job.WatermarkSize,
-			Color:    job.WatermarkColor,
-			BgColor:  job.WatermarkBg,
-		})
-	}
+// hasAdvancedOptions reports whether any format-specific option is set.
+func hasAdvancedOptions(opts codec.EncodeOptions) bool {
+	return opts.Progressive || opts.Subsample != "" || opts.Compression != 0 || opts.WebPMethod != 0 || opts.Lossless ||
+		opts.JXLEffort != 0 || opts.JXLDistance != 0 ||
+		opts.AVIFSpeed != 0 || opts.AVIFAlphaQuality != 0 || opts.AVIFBitDepth != 0 || opts.PNGOptimize || len(opts.EXIF) > 0 || len(opts.XMP) > 0
+}
 
-	// Apply filters (fixed order: brightness -> contrast -> sharpen -> blur -> grayscale -> sepia -> invert)
-	if job.Brightness != 0 {
-		img = transform.Brightness(img, job.Brightness)
-	}
-	if job.Contrast != 0 {
-		img = transform.Contrast(img, job.Contrast)
-	}
-	if job.Sharpen {
-		img = transform.Sharpen(img)
-	}
-	if job.Blur > 0 {
-		img = transform.Blur(img, job.Blur)
-	}
-	if job.Grayscale {
-		img = transform.Grayscale(img)
-	}
-	if job.Sepia > 0 {
-		img = transform.Sepia(img, job.Sepia)
-	}
-	if job.Invert {
-		img = transform.Invert(img)
-	}
+// transformImage applies the job's operation chain in order, stopping
+// between operations once ctx is done.
+func transformImage(ctx context.Context, img image.Image, job Job) (image.Image, error) {
+	return applyOps(ctx, img, job.Operations(), job)
+}
 
-	return img
+// applyOps applies ops to img in order for the job, stopping between
+// operations once ctx is done.
+func applyOps(ctx context.Context, img image.Image, ops []Op, job Job) (image.Image, error) {
+	for _, op := range ops {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var err error
+		if img, err = op.apply(ctx, img, job); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
 }
 
 // copyFile copies src to dst.
@@ -3,15 +3,19 @@ package pipeline
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"image"
 	"image/color"
 	"image/jpeg"
 	"image/png"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
 )
 
 func createTestJPEG(t *testing.T, dir string) string {
@@ -749,6 +753,284 @@ func TestExecute_MetadataPreserve(t *testing.T) {
 	}
 }
 
+func TestExecute_MetadataPolicy(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEGWithExif(t, dir, "policy_input.jpg", buildTestEXIF(6))
+
+	for name, tc := range map[string]struct {
+		policy      metadata.Policy
+		orientation int
+	}{
+		"keep copyright": {metadata.Policy{Keep: []string{"copyright"}}, 6},
+		"strip tag":      {metadata.Policy{Strip: []string{"Orientation"}}, 0},
+	} {
+		outputPath := filepath.Join(dir, "policy_"+strings.ReplaceAll(name, " ", "_")+".jpg")
+		p := NewPipeline(codec.DefaultRegistry())
+		if _, _, err := p.Execute(Job{
+			InputPath:      inputPath,
+			OutputPath:     outputPath,
+			OutputFormat:   codec.JPEG,
+			Quality:        90,
+			MetadataPolicy: tc.policy,
+		}); err != nil {
+			t.Fatalf("%s: Execute: %v", name, err)
+		}
+
+		f, err := os.Open(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := metadata.Extract(f, codec.JPEG)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: output should carry the filtered EXIF: %v", name, err)
+		}
+		if got := meta.Orientation(); got != tc.orientation {
+			t.Errorf("%s: orientation = %d, want %d", name, got, tc.orientation)
+		}
+	}
+}
+
+func TestExecute_PreserveMetadataPNG(t *testing.T) {
+	dir := t.TempDir()
+	exifData := buildTestEXIF(6)
+	inputPath := createTestJPEGWithExif(t, dir, "png_meta_input.jpg", exifData)
+	outputPath := filepath.Join(dir, "png_meta_output.png")
+
+	p := NewPipeline(codec.DefaultRegistry())
+	if _, _, err := p.Execute(Job{
+		InputPath:        inputPath,
+		OutputPath:       outputPath,
+		OutputFormat:     codec.PNG,
+		PreserveMetadata: true,
+	}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiff := (&metadata.Metadata{EXIFRaw: exifData}).TIFF()
+	if !bytes.Contains(data, append([]byte("eXIf"), tiff...)) {
+		t.Error("PNG output should carry the EXIF data in an eXIf chunk")
+	}
+}
+
+func TestExecute_XMPFields(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEGWithExif(t, dir, "xmp_input.jpg", buildTestEXIF(1))
+	outputPath := filepath.Join(dir, "xmp_output.png")
+
+	p := NewPipeline(codec.DefaultRegistry())
+	fields := metadata.XMPFields{Title: "Harbor", Creator: "Ann", Keywords: []string{"sea", "boat"}}
+	if _, _, err := p.Execute(Job{
+		InputPath:     inputPath,
+		OutputPath:    outputPath,
+		OutputFormat:  codec.PNG,
+		StripMetadata: true,
+		XMP:           fields,
+	}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := metadata.Extract(f, codec.PNG)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if meta.HasEXIF() {
+		t.Error("EXIF should be stripped")
+	}
+	if got, err := meta.XMPFields(); err != nil || !reflect.DeepEqual(got, fields) {
+		t.Errorf("XMP fields = %+v, %v; want %+v", got, err, fields)
+	}
+}
+
+func TestExecute_IPTCFields(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEGWithExif(t, dir, "iptc_input.jpg", buildTestEXIF(1))
+	outputPath := filepath.Join(dir, "iptc_output.jpg")
+
+	p := NewPipeline(codec.DefaultRegistry())
+	fields := metadata.IPTCFields{Caption: "Boats at dawn", Byline: "Ann", Keywords: []string{"sea"}}
+	if _, _, err := p.Execute(Job{
+		InputPath:     inputPath,
+		OutputPath:    outputPath,
+		OutputFormat:  codec.JPEG,
+		Quality:       90,
+		StripMetadata: true,
+		IPTC:          fields,
+	}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := metadata.Extract(f, codec.JPEG)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if meta.HasEXIF() {
+		t.Error("EXIF should be stripped")
+	}
+	if got, err := meta.IPTCFields(); err != nil || !reflect.DeepEqual(got, fields) {
+		t.Errorf("IPTC fields = %+v, %v; want %+v", got, err, fields)
+	}
+}
+
+func TestExecute_SidecarRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	exif := buildTestEXIF(3)
+	inputPath := createTestJPEGWithExif(t, dir, "sidecar_input.jpg", exif)
+	publicPath := filepath.Join(dir, "public.png")
+
+	// Strip the public copy but keep the metadata in a sidecar
+	p := NewPipeline(codec.DefaultRegistry())
+	if _, _, err := p.Execute(Job{
+		InputPath:     inputPath,
+		OutputPath:    publicPath,
+		OutputFormat:  codec.PNG,
+		StripMetadata: true,
+		Sidecar:       metadata.SidecarJSON,
+	}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	f, err := os.Open(publicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadata.Extract(f, codec.PNG); err == nil {
+		t.Error("public output should carry no metadata")
+	}
+	f.Close()
+	if _, err := os.Stat(publicPath + ".json"); err != nil {
+		t.Fatalf("sidecar not written: %v", err)
+	}
+
+	// Restore it from the sidecar
+	restoredPath := filepath.Join(dir, "restored.jpg")
+	if _, _, err := p.Execute(Job{
+		InputPath:    publicPath,
+		OutputPath:   restoredPath,
+		OutputFormat: codec.JPEG,
+		Quality:      90,
+		FromSidecar:  true,
+	}); err != nil {
+		t.Fatalf("Execute from sidecar: %v", err)
+	}
+	f, err = os.Open(restoredPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := metadata.Extract(f, codec.JPEG)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if !bytes.Equal(meta.EXIFRaw, exif) {
+		t.Error("EXIF not restored from the sidecar")
+	}
+
+	// A missing sidecar is an error
+	if _, _, err := p.Execute(Job{
+		InputPath:    inputPath,
+		OutputPath:   filepath.Join(dir, "missing.png"),
+		OutputFormat: codec.PNG,
+		FromSidecar:  true,
+	}); !errors.Is(err, metadata.ErrNoSidecar) {
+		t.Errorf("err = %v, want ErrNoSidecar", err)
+	}
+}
+
+func TestExecute_AutoRotatePNGEXIF(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "rotate_input.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(inputPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Orientation 6 (rotate 90° CW) in an eXIf chunk
+	if err := metadata.Inject(inputPath, codec.PNG, &metadata.Metadata{EXIFRaw: buildTestEXIF(6)}); err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(dir, "rotate_output.png")
+	p := NewPipeline(codec.DefaultRegistry())
+	if _, _, err := p.Execute(Job{
+		InputPath:    inputPath,
+		OutputPath:   outputPath,
+		OutputFormat: codec.PNG,
+		AutoRotate:   true,
+	}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Errorf("output is %dx%d, want 2x4", b.Dx(), b.Dy())
+	}
+}
+
+func TestExecute_AutoRotatePreservesUprightEXIF(t *testing.T) {
+	dir := t.TempDir()
+	// 100x80 pixels with orientation 6 (rotate 90° CW)
+	inputPath := createTestJPEGWithExif(t, dir, "upright_input.jpg", buildTestEXIF(6))
+	outputPath := filepath.Join(dir, "upright_output.png")
+
+	p := NewPipeline(codec.DefaultRegistry())
+	if _, _, err := p.Execute(Job{
+		InputPath:        inputPath,
+		OutputPath:       outputPath,
+		OutputFormat:     codec.PNG,
+		AutoRotate:       true,
+		PreserveMetadata: true,
+		MaxDim:           50,
+	}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := metadata.Extract(f, codec.PNG)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	// Viewers must not rotate the already rotated pixels again
+	if o := meta.Orientation(); o != 1 {
+		t.Errorf("orientation = %d, want 1", o)
+	}
+	f.Seek(0, 0)
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 50 {
+		t.Errorf("output is %dx%d, want 40x50", b.Dx(), b.Dy())
+	}
+}
+
 func TestExecute_MetadataStrip(t *testing.T) {
 	dir := t.TempDir()
 
@@ -1,14 +1,27 @@
 package pipeline
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"slices"
 	"sync"
+	"time"
 )
 
 // Pool manages a pool of workers that execute conversion jobs.
 type Pool struct {
 	pipeline *Pipeline
 	workers  int
+
+	// MaxMemory caps the estimated memory of the jobs running at once, in
+	// bytes (0 = no limit). Jobs are then started largest first, and a job
+	// waits for a free worker and for room in the budget; a job larger
+	// than the whole budget runs alone. See EstimateMemory. A job abandoned
+	// after the pipeline's JobTimeout counts against the budget until it
+	// returns, while its worker moves on, but for at most another
+	// JobTimeout, so a job that never returns cannot stall the pool.
+	MaxMemory int64
 }
 
 // NewPool creates a worker pool with the given pipeline and worker count.
@@ -20,87 +33,156 @@ func NewPool(p *Pipeline, workers int) *Pool {
 }
 
 // Run processes all jobs using the worker pool and returns results.
-// It respects the context for cancellation.
+// Cancelling the context aborts running jobs and skips the rest.
 func (pool *Pool) Run(ctx context.Context, jobs []Job) []Result {
 	results := make([]Result, len(jobs))
-	work := make(chan int, len(jobs))
+	pool.run(ctx, jobs, func(idx int, r Result) {
+		results[idx] = r
+	})
+	return results
+}
+
+// RunWithCallback processes jobs and calls the callback after each job completes.
+func (pool *Pool) RunWithCallback(ctx context.Context, jobs []Job, cb func(Result, int, int)) {
+	total := len(jobs)
+	var mu sync.Mutex
+	completed := 0
+
+	pool.run(ctx, jobs, func(_ int, r Result) {
+		mu.Lock()
+		completed++
+		c := completed
+		mu.Unlock()
+		cb(r, c, total)
+	})
+}
 
-	for i := range jobs {
-		work <- i
+// run executes the jobs on the pool's workers, calling done with each
+// job's index and result. Once ctx is done, each worker reports its next
+// job as cancelled and stops.
+func (pool *Pool) run(ctx context.Context, jobs []Job, done func(int, Result)) {
+	var costs []int64
+	if pool.MaxMemory > 0 {
+		costs = make([]int64, len(jobs))
+		for i, job := range jobs {
+			costs[i] = EstimateMemory(job)
+		}
 	}
-	close(work)
+	s := newScheduler(len(jobs), costs, pool.MaxMemory)
+	stop := context.AfterFunc(ctx, s.wake)
+	defer stop()
 
-	var wg sync.WaitGroup
 	workers := pool.workers
 	if workers > len(jobs) {
 		workers = len(jobs)
 	}
 
+	var wg sync.WaitGroup
 	for w := 0; w < workers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for idx := range work {
+			for {
+				idx, cost, ok := s.next(ctx)
+				if !ok {
+					return
+				}
 				select {
 				case <-ctx.Done():
-					results[idx] = Result{Job: jobs[idx], Error: ctx.Err()}
+					s.release(cost)
+					done(idx, Result{Job: jobs[idx], Error: ctx.Err()})
 					return
 				default:
 				}
-				inSize, outSize, err := pool.pipeline.Execute(jobs[idx])
-				results[idx] = Result{Job: jobs[idx], Error: err, InputSize: inSize, OutputSize: outSize}
+				// A job abandoned after its timeout still holds its
+				// memory, so it is released once the job returns, or
+				// after a grace period if it never does
+				var once sync.Once
+				release := func() { once.Do(func() { s.release(cost) }) }
+				r := pool.pipeline.executeJobContext(ctx, jobs[idx], release)
+				var te *TimeoutError
+				if errors.As(r.Error, &te) {
+					time.AfterFunc(te.Timeout, release)
+				}
+				done(idx, r)
 			}
 		}()
 	}
 
 	wg.Wait()
-	return results
 }
 
-// RunWithCallback processes jobs and calls the callback after each job completes.
-func (pool *Pool) RunWithCallback(ctx context.Context, jobs []Job, cb func(Result, int, int)) {
-	work := make(chan int, len(jobs))
-	for i := range jobs {
-		work <- i
-	}
-	close(work)
+// scheduler hands out jobs to workers. Without a memory budget it hands
+// them out in order; with one it hands out the largest job that fits in
+// the budget left, so large jobs start early instead of finishing last,
+// and small jobs fill the room beside them.
+type scheduler struct {
+	budget int64
 
-	total := len(jobs)
-	var mu sync.Mutex
-	completed := 0
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []int   // job indices, largest first with a budget
+	costs   []int64 // estimated memory per job index
+	used    int64
+	running int
+}
 
-	workers := pool.workers
-	if workers > total {
-		workers = total
+// newScheduler returns a scheduler of n jobs. With a budget, costs holds
+// each job's estimated memory.
+func newScheduler(n int, costs []int64, budget int64) *scheduler {
+	s := &scheduler{budget: budget, pending: make([]int, n), costs: costs}
+	s.cond = sync.NewCond(&s.mu)
+	for i := range s.pending {
+		s.pending[i] = i
+	}
+	if budget > 0 {
+		slices.SortStableFunc(s.pending, func(a, b int) int {
+			return cmp.Compare(costs[b], costs[a])
+		})
 	}
+	return s
+}
 
-	var wg sync.WaitGroup
-	for w := 0; w < workers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for idx := range work {
-				select {
-				case <-ctx.Done():
-					r := Result{Job: jobs[idx], Error: ctx.Err()}
-					mu.Lock()
-					completed++
-					c := completed
-					mu.Unlock()
-					cb(r, c, total)
-					return
-				default:
+// next removes and returns the next job to run and its reserved memory,
+// waiting until one fits in the budget. It returns false once no jobs are
+// left. Once ctx is done it returns the next job at once, for the worker
+// to report as cancelled.
+func (s *scheduler) next(ctx context.Context) (idx int, cost int64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if len(s.pending) == 0 {
+			return 0, 0, false
+		}
+		for i, idx := range s.pending {
+			cost = 0
+			if s.budget > 0 && ctx.Err() == nil {
+				cost = s.costs[idx]
+				if s.running > 0 && s.used+cost > s.budget {
+					continue
 				}
-				inSize, outSize, err := pool.pipeline.Execute(jobs[idx])
-				r := Result{Job: jobs[idx], Error: err, InputSize: inSize, OutputSize: outSize}
-				mu.Lock()
-				completed++
-				c := completed
-				mu.Unlock()
-				cb(r, c, total)
 			}
-		}()
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			s.used += cost
+			s.running++
+			return idx, cost, true
+		}
+		s.cond.Wait()
 	}
+}
 
-	wg.Wait()
+// release returns a finished job's memory to the budget.
+func (s *scheduler) release(cost int64) {
+	s.mu.Lock()
+	s.used -= cost
+	s.running--
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// wake wakes waiting workers, e.g. when the context is done.
+func (s *scheduler) wake() {
+	s.mu.Lock()
+	s.cond.Broadcast()
+	s.mu.Unlock()
 }
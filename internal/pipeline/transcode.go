@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/jpegtran"
+	"github.com/DanielTso/pixshift/internal/metadata"
+	"github.com/DanielTso/pixshift/internal/transform"
+)
+
+// transcodeLossless converts images at the bitstream level when the job
+// requests no lossy pixel changes: JPEG input is recompressed into a JXL
+// that keeps the original DCT coefficients, such a JXL is turned back into
+// the original JPEG, JPEG-to-JPEG orientation fixes and crops are done
+// on the DCT coefficients, and a JPEG with no orientation to fix is copied.
+// It returns the output image; ok is false when the pixel path must be
+// used.
+func (p *Pipeline) transcodeLossless(r io.Reader, inputFormat codec.Format, job Job) (output []byte, ok bool, err error) {
+	var buf bytes.Buffer
+	switch {
+	case canCopyJPEG(inputFormat, job):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, false, stageError(ErrIO, "read "+job.InputPath, err)
+		}
+		if !job.keepsMetadata() {
+			data = metadata.StripJPEGMetadata(data)
+		}
+		// The geometry is unchanged, so kept metadata needs no rewrite
+		// unless the job filters or adds to it
+		if !job.MetadataPolicy.IsZero() || !job.XMP.IsZero() || !job.IPTC.IsZero() {
+			if data, err = applyJPEGMetadata(data, job); err != nil {
+				return nil, false, metadataError("", err)
+			}
+		}
+		buf.Write(data)
+	case canTransformJPEGLossless(inputFormat, job):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, false, stageError(ErrIO, "read "+job.InputPath, err)
+		}
+		var crop transform.CropOptions
+		for _, op := range job.Operations() {
+			if op.Kind == OpCrop {
+				crop = op.cropOptions()
+			}
+		}
+		out, err := jpegtran.Transform(data, jpegtran.Options{
+			Orientation:  autoRotateOrientation(job),
+			Crop:         crop,
+			CopyMetadata: job.keepsMetadata(),
+		})
+		if err != nil {
+			// Progressive JPEGs and flips of partial MCUs cannot be
+			// done losslessly; decode them to pixels instead
+			return nil, false, nil
+		}
+		if out, err = applyJPEGMetadata(out, job); err != nil {
+			return nil, false, metadataError("", err)
+		}
+		buf.Write(out)
+	case !canTranscodeLossless(inputFormat, job):
+		return nil, false, nil
+	case inputFormat == codec.JPEG:
+		enc, err := p.Registry.Encoder(codec.JXL)
+		if err != nil {
+			return nil, false, nil
+		}
+		rc, isRecompressor := enc.(codec.JPEGRecompressor)
+		if !isRecompressor {
+			return nil, false, nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, false, stageError(ErrIO, "read "+job.InputPath, err)
+		}
+		// The reconstruction data would carry the metadata segments over
+		if !job.keepsMetadata() {
+			data = metadata.StripJPEGMetadata(data)
+		}
+		if err := rc.RecompressJPEG(&buf, data, job.EncodeOpts); err != nil {
+			// Some JPEGs (e.g. CMYK or arithmetic-coded) cannot be
+			// recompressed; decode them to pixels instead
+			return nil, false, nil
+		}
+	case inputFormat == codec.JXL:
+		dec, err := p.Registry.Decoder(codec.JXL)
+		if err != nil {
+			return nil, false, nil
+		}
+		rc, isReconstructor := dec.(codec.JPEGReconstructor)
+		if !isReconstructor {
+			return nil, false, nil
+		}
+		if err := rc.ReconstructJPEG(&buf, r); err != nil {
+			if errors.Is(err, codec.ErrNoJPEGReconstruction) {
+				return nil, false, nil
+			}
+			return nil, false, stageError(ErrDecode, "reconstruct jpeg", err)
+		}
+		if !job.keepsMetadata() {
+			return metadata.StripJPEGMetadata(buf.Bytes()), true, nil
+		}
+	}
+
+	return buf.Bytes(), true, nil
+}
+
+// applyJPEGMetadata rewrites the EXIF, XMP and IPTC segments of a JPEG
+// produced by the lossless path according to the job's policy and XMP and
+// IPTC fields. Kept EXIF gets the new dimensions; the thumbnail is
+// dropped, since the pixels are not decoded to regenerate it.
+func applyJPEGMetadata(data []byte, job Job) ([]byte, error) {
+	if job.MetadataPolicy.IsZero() && job.XMP.IsZero() && job.IPTC.IsZero() && !job.keepsMetadata() {
+		return data, nil
+	}
+	meta, err := metadata.Extract(bytes.NewReader(data), codec.JPEG)
+	if err != nil {
+		meta = nil // nothing to filter
+	}
+	if meta, err = meta.ApplyPolicy(job.MetadataPolicy); err != nil {
+		return nil, fmt.Errorf("metadata policy: %w", err)
+	}
+	if meta.HasEXIF() {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+		// jpegtran has already reset the orientation
+		if meta, err = meta.WithGeometry(metadata.Geometry{Width: cfg.Width, Height: cfg.Height}); err != nil {
+			return nil, fmt.Errorf("exif geometry: %w", err)
+		}
+	}
+	if meta, err = meta.WithXMPFields(job.XMP); err != nil {
+		return nil, fmt.Errorf("xmp: %w", err)
+	}
+	if meta, err = meta.WithIPTCFields(job.IPTC); err != nil {
+		return nil, fmt.Errorf("iptc: %w", err)
+	}
+	if meta.IsEmpty() {
+		return data, nil
+	}
+	return metadata.ReplaceJPEGMetadata(data, meta)
+}
+
+// canTranscodeLossless reports whether a job converts JPEG<->JXL without
+// touching pixels or rewriting metadata, so the bitstream can be carried
+// over. Unless the job keeps metadata, e.g. with StripMetadata,
+// transcodeLossless drops the EXIF, XMP and IPTC segments.
+func canTranscodeLossless(inputFormat codec.Format, job Job) bool {
+	if job.ReencodeJPEG || job.FromSidecar || !job.MetadataPolicy.IsZero() || !job.XMP.IsZero() || !job.IPTC.IsZero() || hasPixelTransforms(job) {
+		return false
+	}
+	return (inputFormat == codec.JPEG && job.OutputFormat == codec.JXL) ||
+		(inputFormat == codec.JXL && job.OutputFormat == codec.JPEG)
+}
+
+// canTransformJPEGLossless reports whether a JPEG-to-JPEG job only
+// reorients and/or crops, in that order, so it can be done in the DCT
+// domain. Metadata from a sidecar replaces the file's, so those jobs take
+// the pixel path.
+func canTransformJPEGLossless(inputFormat codec.Format, job Job) bool {
+	if inputFormat != codec.JPEG || job.OutputFormat != codec.JPEG || job.ReencodeJPEG || job.FromSidecar ||
+		!hasGeometricTransforms(job) || hasLossyTransforms(job) {
+		return false
+	}
+	// jpegtran corrects the orientation first and crops once
+	crops := 0
+	for _, op := range job.Operations() {
+		switch {
+		case op.Kind == OpCrop:
+			crops++
+		case op.Kind == OpAutoRotate && crops > 0 && job.EXIFOrientation > 1:
+			return false
+		}
+	}
+	return crops <= 1
+}
+
+// canCopyJPEG reports whether a JPEG-to-JPEG job auto-rotates an image
+// that is already upright and does nothing else, so the input bitstream
+// can be copied. Without auto-rotate, a same-format job re-encodes at the
+// job's quality.
+func canCopyJPEG(inputFormat codec.Format, job Job) bool {
+	return inputFormat == codec.JPEG && job.OutputFormat == codec.JPEG && !job.ReencodeJPEG && !job.FromSidecar &&
+		job.hasOp(OpAutoRotate) && !hasPixelTransforms(job)
+}
+
+// autoRotateOrientation returns the EXIF orientation transformImage would
+// correct, or 0 if it leaves the orientation alone.
+func autoRotateOrientation(job Job) int {
+	if job.EXIFOrientation > 1 && job.hasOp(OpAutoRotate) {
+		return job.EXIFOrientation
+	}
+	return 0
+}
+
+// hasPixelTransforms reports whether transformImage would modify the image.
+func hasPixelTransforms(job Job) bool {
+	return hasGeometricTransforms(job) || hasLossyTransforms(job)
+}
+
+// hasGeometricTransforms reports whether the job reorients or crops the image.
+func hasGeometricTransforms(job Job) bool {
+	return autoRotateOrientation(job) > 1 || job.hasOp(OpCrop)
+}
+
+// changesGeometry reports whether transformImage would reorient, crop or
+// resize the image.
+func changesGeometry(job Job) bool {
+	return hasGeometricTransforms(job) || job.hasOp(OpSmartCrop) || job.hasOp(OpResize)
+}
+
+// hasLossyTransforms reports whether the job changes pixel values, which
+// requires decoding and re-encoding.
+func hasLossyTransforms(job Job) bool {
+	for _, op := range job.Operations() {
+		if op.Kind != OpAutoRotate && op.Kind != OpCrop {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,300 @@
+package pipeline
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+)
+
+// fakeJXLCodec stands in for libjxl: "recompression" prefixes the JPEG bytes
+// with a marker and "reconstruction" strips it again.
+type fakeJXLCodec struct {
+	pixelEncodes int
+}
+
+var fakeJXLMarker = []byte("FAKEJXL")
+
+func (c *fakeJXLCodec) Format() codec.Format { return codec.JXL }
+
+func (c *fakeJXLCodec) Encode(w io.Writer, img image.Image, quality int) error {
+	c.pixelEncodes++
+	_, err := w.Write([]byte("pixels"))
+	return err
+}
+
+func (c *fakeJXLCodec) RecompressJPEG(w io.Writer, jpegData []byte, opts codec.EncodeOptions) error {
+	if _, err := w.Write(fakeJXLMarker); err != nil {
+		return err
+	}
+	_, err := w.Write(jpegData)
+	return err
+}
+
+func (c *fakeJXLCodec) Decode(r io.ReadSeeker) (image.Image, error) {
+	return image.NewRGBA(image.Rect(0, 0, 4, 4)), nil
+}
+
+func (c *fakeJXLCodec) ReconstructJPEG(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, fakeJXLMarker) {
+		return codec.ErrNoJPEGReconstruction
+	}
+	_, err = w.Write(data[len(fakeJXLMarker):])
+	return err
+}
+
+func newTranscodeTestPipeline(jxl *fakeJXLCodec) *Pipeline {
+	reg := codec.DefaultRegistry()
+	reg.RegisterEncoder(jxl)
+	reg.RegisterDecoder(jxl)
+	return NewPipeline(reg)
+}
+
+func TestExecuteJob_JPEGtoJXLRoundTripIsBitExact(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+	jxlPath := filepath.Join(dir, "test.jxl")
+	jpegPath := filepath.Join(dir, "restored.jpg")
+
+	jxl := &fakeJXLCodec{}
+	p := newTranscodeTestPipeline(jxl)
+
+	res := p.ExecuteJob(Job{
+		InputPath:    inputPath,
+		OutputPath:   jxlPath,
+		InputFormat:  codec.JPEG,
+		OutputFormat: codec.JXL,
+		Quality:      90,
+	})
+	if res.Error != nil {
+		t.Fatalf("JPEG->JXL: %v", res.Error)
+	}
+	if !res.Transcoded {
+		t.Error("JPEG->JXL without transforms should be transcoded")
+	}
+	if jxl.pixelEncodes != 0 {
+		t.Errorf("pixel encoder called %d times, want 0", jxl.pixelEncodes)
+	}
+
+	res = p.ExecuteJob(Job{
+		InputPath:    jxlPath,
+		OutputPath:   jpegPath,
+		InputFormat:  codec.JXL,
+		OutputFormat: codec.JPEG,
+		Quality:      90,
+	})
+	if res.Error != nil {
+		t.Fatalf("JXL->JPEG: %v", res.Error)
+	}
+	if !res.Transcoded {
+		t.Error("JXL->JPEG with reconstruction data should be transcoded")
+	}
+
+	original, _ := os.ReadFile(inputPath)
+	restored, _ := os.ReadFile(jpegPath)
+	if !bytes.Equal(original, restored) {
+		t.Error("reconstructed JPEG differs from the original")
+	}
+}
+
+func TestExecuteJob_JPEGtoJXLDropsMetadataUnlessKept(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEGWithExif(t, dir, "exif.jpg", buildTestEXIF(1))
+
+	for name, job := range map[string]Job{
+		"default": {},
+		"strip":   {StripMetadata: true},
+		"keep":    {PreserveMetadata: true},
+	} {
+		p := newTranscodeTestPipeline(&fakeJXLCodec{})
+		jxlPath := filepath.Join(dir, "out.jxl")
+		job.InputPath = inputPath
+		job.OutputPath = jxlPath
+		job.InputFormat = codec.JPEG
+		job.OutputFormat = codec.JXL
+		job.Quality = 90
+		res := p.ExecuteJob(job)
+		if res.Error != nil {
+			t.Fatalf("%s: %v", name, res.Error)
+		}
+		if !res.Transcoded {
+			t.Errorf("%s: should be transcoded", name)
+		}
+		data, _ := os.ReadFile(jxlPath)
+		if got, keep := bytes.Contains(data, []byte("Exif\x00\x00")), job.PreserveMetadata; got != keep {
+			t.Errorf("%s: reconstruction data has EXIF = %v", name, got)
+		}
+	}
+}
+
+func TestExecuteJob_JPEGtoJXLWithTransformsUsesPixels(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+
+	for name, job := range map[string]Job{
+		"resize":   {Width: 50},
+		"filter":   {Grayscale: true},
+		"reencode": {ReencodeJPEG: true},
+	} {
+		jxl := &fakeJXLCodec{}
+		p := newTranscodeTestPipeline(jxl)
+
+		job.InputPath = inputPath
+		job.OutputPath = filepath.Join(dir, name+".jxl")
+		job.OutputFormat = codec.JXL
+		job.Quality = 90
+		res := p.ExecuteJob(job)
+		if res.Error != nil {
+			t.Fatalf("%s: %v", name, res.Error)
+		}
+		if res.Transcoded {
+			t.Errorf("%s: should not be transcoded", name)
+		}
+		if jxl.pixelEncodes != 1 {
+			t.Errorf("%s: pixel encoder called %d times, want 1", name, jxl.pixelEncodes)
+		}
+	}
+}
+
+func TestExecuteJob_JXLWithoutReconstructionDecodesPixels(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "pixels.jxl")
+	if err := os.WriteFile(inputPath, []byte("not a transcoded jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTranscodeTestPipeline(&fakeJXLCodec{})
+	res := p.ExecuteJob(Job{
+		InputPath:    inputPath,
+		OutputPath:   filepath.Join(dir, "out.jpg"),
+		InputFormat:  codec.JXL,
+		OutputFormat: codec.JPEG,
+		Quality:      90,
+	})
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if res.Transcoded {
+		t.Error("JXL without reconstruction data should fall back to pixels")
+	}
+}
+
+func TestExecuteJob_JPEGAutoRotateIsLossless(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEGWithExif(t, dir, "rotate.jpg", buildTestEXIF(6))
+	outputPath := filepath.Join(dir, "rotated.jpg")
+
+	p := NewPipeline(codec.DefaultRegistry())
+	res := p.ExecuteJob(Job{
+		InputPath:        inputPath,
+		OutputPath:       outputPath,
+		OutputFormat:     codec.JPEG,
+		Quality:          90,
+		AutoRotate:       true,
+		PreserveMetadata: true,
+	})
+	if res.Error != nil {
+		t.Fatalf("ExecuteJob: %v", res.Error)
+	}
+	if !res.Transcoded {
+		t.Error("JPEG auto-rotate should be done in the DCT domain")
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if cfg.Width != 80 || cfg.Height != 100 {
+		t.Errorf("rotated size %dx%d, want 80x100", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metadata.Extract(f, codec.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := meta.Orientation(); o != 1 {
+		t.Errorf("EXIF orientation = %d, want 1", o)
+	}
+}
+
+func TestExecuteJob_JPEGAutoRotateUprightIsCopied(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEGWithExif(t, dir, "upright.jpg", buildTestEXIF(1))
+	input, err := os.ReadFile(inputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, keep := range map[string]bool{"kept": true, "dropped": false} {
+		outputPath := filepath.Join(dir, name+".jpg")
+		p := NewPipeline(codec.DefaultRegistry())
+		res := p.ExecuteJob(Job{
+			InputPath:        inputPath,
+			OutputPath:       outputPath,
+			OutputFormat:     codec.JPEG,
+			Quality:          50,
+			AutoRotate:       true,
+			PreserveMetadata: keep,
+		})
+		if res.Error != nil {
+			t.Fatalf("%s: %v", name, res.Error)
+		}
+		if !res.Transcoded {
+			t.Errorf("%s: an upright JPEG should be copied, not re-encoded", name)
+		}
+		output, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := input
+		if !keep {
+			want = metadata.StripJPEGMetadata(input)
+		}
+		if !bytes.Equal(output, want) {
+			t.Errorf("%s: output differs from the input bitstream", name)
+		}
+	}
+}
+
+func TestExecuteJob_JPEGLosslessFallsBackToPixels(t *testing.T) {
+	dir := t.TempDir()
+	// 100px is not a whole number of 16px MCUs, so a 180° rotation of the
+	// 4:2:0 test image cannot be done losslessly.
+	unaligned := createTestJPEGWithExif(t, dir, "rotate180.jpg", buildTestEXIF(3))
+	cropped := createTestJPEG(t, dir)
+
+	for name, job := range map[string]Job{
+		"unaligned": {InputPath: unaligned, AutoRotate: true},
+		"resize":    {InputPath: cropped, CropWidth: 32, CropHeight: 32, Width: 16},
+		"reencode":  {InputPath: cropped, CropWidth: 32, CropHeight: 32, ReencodeJPEG: true},
+	} {
+		p := NewPipeline(codec.DefaultRegistry())
+		job.OutputPath = filepath.Join(dir, name+".jpg")
+		job.OutputFormat = codec.JPEG
+		job.Quality = 90
+		res := p.ExecuteJob(job)
+		if res.Error != nil {
+			t.Fatalf("%s: %v", name, res.Error)
+		}
+		if res.Transcoded {
+			t.Errorf("%s: should be processed through pixels", name)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+	"github.com/DanielTso/pixshift/internal/naming"
+)
+
+// Variant is one of several outputs of a job. The variants share the
+// job's decode and the operations of its transform fields and Ops; each
+// then applies its own Ops followed by the resize of Width, Height and
+// MaxDim, and is encoded in its own format and quality.
+type Variant struct {
+	Name    string       `json:"name,omitempty" yaml:"name,omitempty"`       // label in the default file name
+	Format  codec.Format `json:"format,omitempty" yaml:"format,omitempty"`   // "" = the job's output format
+	Quality int          `json:"quality,omitempty" yaml:"quality,omitempty"` // 0 = the job's quality
+	Width   int          `json:"width,omitempty" yaml:"width,omitempty"`
+	Height  int          `json:"height,omitempty" yaml:"height,omitempty"`
+	MaxDim  int          `json:"max_dim,omitempty" yaml:"max_dim,omitempty"`
+	Ops     []Op         `json:"ops,omitempty" yaml:"ops,omitempty"`
+
+	// Template names the output file; see naming.Parse. The default is
+	// "{name}-LABEL", with the label from Label.
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+
+	// OutputPath is where the variant is written, resolved by the caller
+	// from its NameTemplate.
+	OutputPath string `json:"-" yaml:"-"`
+}
+
+// VariantResult is the outcome of one variant of a job.
+type VariantResult struct {
+	Variant    Variant
+	OutputPath string       // final path; its extension changes with Auto output
+	Format     codec.Format // the format written, chosen by Auto if requested
+	OutputSize int64
+	Auto       *AutoDecision
+	Error      error
+}
+
+// Label returns the variant's name, or one derived from its size: "640"
+// for MaxDim or Width 640, "640x480" for both dimensions, or its position
+// i+1 in the job.
+func (v Variant) Label(i int) string {
+	switch {
+	case v.Name != "":
+		return v.Name
+	case v.MaxDim > 0:
+		return strconv.Itoa(v.MaxDim)
+	case v.Width > 0 && v.Height > 0:
+		return fmt.Sprintf("%dx%d", v.Width, v.Height)
+	case v.Width > 0:
+		return strconv.Itoa(v.Width)
+	case v.Height > 0:
+		return strconv.Itoa(v.Height)
+	}
+	return strconv.Itoa(i + 1)
+}
+
+// OutputFormat returns the variant's format, or def when it has none.
+func (v Variant) OutputFormat(def codec.Format) codec.Format {
+	if v.Format == "" {
+		return def
+	}
+	f, _ := codec.ParseFormat(string(v.Format)) // validated by ValidateVariants
+	return f
+}
+
+// NameTemplate parses the variant's output name template; i is the
+// variant's position in the job.
+func (v Variant) NameTemplate(i int) (*naming.Template, error) {
+	if v.Template != "" {
+		return naming.Parse(v.Template)
+	}
+	return naming.Parse("{name}-" + v.Label(i))
+}
+
+// operations returns the variant's own operations: Ops, then the resize.
+func (v Variant) operations() []Op {
+	ops := slices.Clone(v.Ops)
+	if v.Width > 0 || v.Height > 0 || v.MaxDim > 0 {
+		ops = append(ops, Op{Kind: OpResize, Width: v.Width, Height: v.Height, MaxDim: v.MaxDim})
+	}
+	return ops
+}
+
+// Validate checks the variant's format, quality, size, operations and
+// template.
+func (v Variant) Validate() error {
+	if v.Format != "" {
+		if _, err := codec.ParseFormat(string(v.Format)); err != nil {
+			return err
+		}
+	}
+	if v.Quality < 0 || v.Quality > 100 {
+		return fmt.Errorf("quality %d out of range (1-100)", v.Quality)
+	}
+	if v.Width < 0 || v.Height < 0 || v.MaxDim < 0 {
+		return fmt.Errorf("negative size")
+	}
+	if strings.ContainsAny(v.Name, `/\{}`) {
+		return fmt.Errorf("name %q contains a path separator or brace", v.Name)
+	}
+	if err := ValidateOps(v.Ops); err != nil {
+		return err
+	}
+	if v.Template != "" {
+		if _, err := naming.Parse(v.Template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateVariants validates each variant, naming the first invalid one,
+// and rejects variants that would be written to the same default name.
+// def is the format of variants without one, as in OutputFormat.
+func ValidateVariants(variants []Variant, def codec.Format) error {
+	seen := make(map[string]int)
+	for i, v := range variants {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("variant %d (%s): %w", i+1, v.Label(i), err)
+		}
+		if v.Template != "" {
+			continue
+		}
+		key := v.Label(i) + "." + string(v.OutputFormat(def))
+		if j, dup := seen[key]; dup {
+			return fmt.Errorf("variants %d and %d are both named %q in the same format; set a name or template", j+1, i+1, v.Label(i))
+		}
+		seen[key] = i
+	}
+	return nil
+}
+
+// ParseVariant parses the CLI form of a variant: a format, optionally
+// followed by ":" and comma-separated parameters. A bare number is the
+// maximum dimension and WxH the width and height:
+//
+//	webp:640  avif:max=1280,quality=60  jpeg:400x400,ratio=1:1,name=square
+//
+// Keys are name, width, height, max, quality (or q), template, and ratio
+// and gravity for a crop before the resize.
+func ParseVariant(s string) (Variant, error) {
+	format, params, _ := strings.Cut(strings.TrimSpace(s), ":")
+	v := Variant{Format: codec.Format(format)}
+	var crop Op
+	if params != "" {
+		for _, p := range strings.Split(params, ",") {
+			key, value, hasValue := strings.Cut(p, "=")
+			if !hasValue {
+				key, value = "", p
+			}
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			var err error
+			switch key {
+			case "":
+				if w, h, ok := strings.Cut(value, "x"); ok {
+					if v.Width, err = atoiOrZero(w); err == nil {
+						v.Height, err = atoiOrZero(h)
+					}
+				} else {
+					v.MaxDim, err = strconv.Atoi(value)
+				}
+			case "name":
+				v.Name = value
+			case "width", "w":
+				v.Width, err = strconv.Atoi(value)
+			case "height", "h":
+				v.Height, err = strconv.Atoi(value)
+			case "max", "max_dim":
+				v.MaxDim, err = strconv.Atoi(value)
+			case "quality", "q":
+				v.Quality, err = strconv.Atoi(value)
+			case "template":
+				v.Template = value
+			case "ratio":
+				crop.Kind, crop.Ratio = OpCrop, value
+			case "gravity":
+				crop.Kind, crop.Gravity = OpCrop, value
+			default:
+				return Variant{}, fmt.Errorf("variant %q: unknown parameter %q", s, key)
+			}
+			if err != nil {
+				if key == "" {
+					key = "size"
+				}
+				return Variant{}, fmt.Errorf("variant %q: invalid %s %q", s, key, value)
+			}
+		}
+	}
+	if crop.Kind != "" {
+		v.Ops = []Op{crop}
+	}
+	if err := v.Validate(); err != nil {
+		return Variant{}, fmt.Errorf("variant %q: %w", s, err)
+	}
+	if v.Format == "" {
+		return Variant{}, fmt.Errorf("variant %q: missing format", s)
+	}
+	v.Format = v.OutputFormat("")
+	return v, nil
+}
+
+// variantJob returns the job that writes variant v: its output, format
+// and quality, and the job's operations followed by the variant's.
+func (j Job) variantJob(v Variant) Job {
+	j.OutputPath = v.OutputPath
+	j.OutputFormat = v.OutputFormat(j.OutputFormat)
+	if v.Quality > 0 {
+		j.Quality, j.EncodeOpts.Quality = v.Quality, v.Quality
+	}
+	j.Ops = append(slices.Clone(j.Ops), v.operations()...)
+	j.Variants = nil
+	return j
+}
+
+// executeVariants decodes the input once, applies the job's operations
+// and then the operations of each variant to the shared image, and writes
+// every variant. A failed variant does not stop the others; the first
+// failure is returned.
+func (p *Pipeline) executeVariants(ctx context.Context, r io.ReadSeeker, inputFormat codec.Format, job Job, res *Result, meta *metadata.Metadata, injectMeta bool) (int64, error) {
+	dec, err := p.Registry.Decoder(inputFormat)
+	if err != nil {
+		return 0, stageError(ErrUnsupportedFormat, "", err)
+	}
+	img, err := dec.Decode(contextReader{ctx, r})
+	if err != nil {
+		return 0, stageError(ErrDecode, "decode "+string(inputFormat), err)
+	}
+	if img, err = p.postDecode(ctx, job, 0, img); err != nil {
+		return 0, err
+	}
+	if img, err = p.transform(ctx, job, 0, img); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	var firstErr error
+	for i, v := range job.Variants {
+		vjob := job.variantJob(v)
+		vres := Result{Job: vjob}
+		vr := VariantResult{Variant: v, OutputPath: vjob.OutputPath, Format: vjob.OutputFormat}
+
+		var vimg image.Image
+		if vimg, err = applyOps(ctx, img, v.operations(), job); err == nil {
+			vr.OutputSize, err = p.encodeOutput(ctx, vimg, vjob, &vres, meta, injectMeta)
+		}
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		vr.OutputPath, vr.Format, vr.Auto, vr.Error = vres.Job.OutputPath, vres.Job.OutputFormat, vres.Auto, err
+		res.Variants = append(res.Variants, vr)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("variant %d (%s): %w", i+1, v.Label(i), err)
+			}
+			continue
+		}
+		total += vr.OutputSize
+	}
+	return total, firstErr
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DanielTso/pixshift/internal/codec"
+)
+
+func TestParseVariant(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Variant
+	}{
+		{"webp:640", Variant{Format: codec.WebP, MaxDim: 640}},
+		{"jpg:400x300,q=70", Variant{Format: codec.JPEG, Width: 400, Height: 300, Quality: 70}},
+		{"avif:max=1280,name=hero,template={name}_hero", Variant{Format: codec.AVIF, MaxDim: 1280, Name: "hero", Template: "{name}_hero"}},
+		{"png", Variant{Format: codec.PNG}},
+	}
+	for _, tt := range tests {
+		got, err := ParseVariant(tt.in)
+		if err != nil {
+			t.Errorf("ParseVariant(%q): %v", tt.in, err)
+			continue
+		}
+		if got.Format != tt.want.Format || got.MaxDim != tt.want.MaxDim || got.Width != tt.want.Width ||
+			got.Height != tt.want.Height || got.Quality != tt.want.Quality || got.Name != tt.want.Name || got.Template != tt.want.Template {
+			t.Errorf("ParseVariant(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	v, err := ParseVariant("webp:320x320,ratio=1:1,gravity=north")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Ops) != 1 || v.Ops[0] != (Op{Kind: OpCrop, Ratio: "1:1", Gravity: "north"}) {
+		t.Errorf("crop ops = %+v", v.Ops)
+	}
+
+	for in, msg := range map[string]string{
+		"":                 "missing format",
+		"bmpx:100":         "unsupported format",
+		"webp:big":         "invalid size",
+		"webp:q=101":       "out of range",
+		"webp:speed=1":     "unknown parameter",
+		"webp:name=a/b":    "path separator",
+		"webp:ratio=wide":  "invalid ratio",
+		"webp:template={x": "unclosed",
+	} {
+		if _, err := ParseVariant(in); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ParseVariant(%q) error = %v, want %q", in, err, msg)
+		}
+	}
+}
+
+func TestValidateVariants_DuplicateNames(t *testing.T) {
+	err := ValidateVariants([]Variant{{Format: codec.WebP, MaxDim: 640}, {Format: codec.AVIF, MaxDim: 640}, {Format: codec.WebP, Width: 640}}, "")
+	if err == nil || !strings.Contains(err.Error(), "variants 1 and 3") {
+		t.Errorf("error = %v", err)
+	}
+	if err := ValidateVariants([]Variant{{Format: codec.WebP, MaxDim: 640}, {Format: codec.WebP, Width: 640, Template: "{name}-wide"}}, ""); err != nil {
+		t.Errorf("templated variant rejected: %v", err)
+	}
+	// A variant without a format takes the job's
+	err = ValidateVariants([]Variant{{MaxDim: 640}, {Format: codec.WebP, MaxDim: 640}}, codec.WebP)
+	if err == nil || !strings.Contains(err.Error(), "variants 1 and 2") {
+		t.Errorf("default format: error = %v", err)
+	}
+	if err := ValidateVariants([]Variant{{MaxDim: 640}, {Format: codec.WebP, MaxDim: 640}}, codec.AVIF); err != nil {
+		t.Errorf("distinct formats rejected: %v", err)
+	}
+}
+
+// countingDecoder counts the decodes of the wrapped decoder.
+type countingDecoder struct {
+	codec.Decoder
+	n *int
+}
+
+func (d countingDecoder) Decode(r io.ReadSeeker) (image.Image, error) {
+	*d.n++
+	return d.Decoder.Decode(r)
+}
+
+func TestExecute_Variants(t *testing.T) {
+	dir := t.TempDir()
+	reg := codec.DefaultRegistry()
+	jpegDec, _ := reg.Decoder(codec.JPEG)
+	decodes := 0
+	reg.RegisterDecoder(countingDecoder{jpegDec, &decodes})
+
+	variants := []Variant{
+		{MaxDim: 50},
+		{Format: codec.JPEG, Width: 20, Quality: 50},
+		{Name: "square", Ops: []Op{{Kind: OpCrop, Ratio: "1:1"}}},
+	}
+	for i := range variants {
+		tmpl, err := variants[i].NameTemplate(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		variants[i].OutputPath = filepath.Join(dir, "out", tmpl.OutputName("photo.jpg", variants[i].OutputFormat(codec.PNG)))
+	}
+
+	p := NewPipeline(reg)
+	res := p.ExecuteJob(Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputFormat: codec.PNG,
+		Grayscale:    true,
+		Variants:     variants,
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if decodes != 1 {
+		t.Errorf("input decoded %d times", decodes)
+	}
+
+	want := []struct {
+		name   string
+		format codec.Format
+		w, h   int
+	}{
+		{"photo-50.png", codec.PNG, 50, 40},
+		{"photo-20.jpg", codec.JPEG, 20, 16},
+		{"photo-square.png", codec.PNG, 80, 80},
+	}
+	var total int64
+	for i, w := range want {
+		vr := res.Variants[i]
+		if filepath.Base(vr.OutputPath) != w.name || vr.Format != w.format || vr.Error != nil {
+			t.Errorf("variant %d = %s %s %v", i+1, vr.OutputPath, vr.Format, vr.Error)
+			continue
+		}
+		f, err := os.Open(vr.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != w.w || cfg.Height != w.h {
+			t.Errorf("%s: %dx%d (%v), want %dx%d", w.name, cfg.Width, cfg.Height, err, w.w, w.h)
+		}
+		total += vr.OutputSize
+	}
+	if res.OutputSize != total {
+		t.Errorf("output size = %d, want the variants' total %d", res.OutputSize, total)
+	}
+}
+
+func TestExecute_VariantFailureKeepsOthers(t *testing.T) {
+	dir := t.TempDir()
+	// CR2 can be decoded but not encoded
+	p := NewPipeline(codec.DefaultRegistry())
+	res := p.ExecuteJob(Job{
+		InputPath:    createTestJPEG(t, dir),
+		OutputFormat: codec.PNG,
+		Variants: []Variant{
+			{Format: codec.CR2, OutputPath: filepath.Join(dir, "a.cr2")},
+			{MaxDim: 10, OutputPath: filepath.Join(dir, "b.png")},
+		},
+	})
+	if res.Error == nil || !strings.HasPrefix(res.Error.Error(), "variant 1 (1):") {
+		t.Errorf("error = %v", res.Error)
+	}
+	if len(res.Variants) != 2 || res.Variants[0].Error == nil || res.Variants[1].Error != nil {
+		t.Fatalf("variant results = %+v", res.Variants)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.png")); err != nil {
+		t.Error("second variant not written")
+	}
+}
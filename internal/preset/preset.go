@@ -15,6 +15,10 @@ type Preset struct {
 	Height           int    `yaml:"height,omitempty"`
 	StripMetadata    bool   `yaml:"strip_metadata,omitempty"`
 	PreserveMetadata bool   `yaml:"preserve_metadata,omitempty"`
+	// KeepEXIF and StripEXIF form a metadata.Policy; either implies
+	// PreserveMetadata.
+	KeepEXIF  []string `yaml:"keep_exif,omitempty"`
+	StripEXIF []string `yaml:"strip_exif,omitempty"`
 	// v0.4.0
 	Grayscale  bool `yaml:"grayscale,omitempty"`
 	Sharpen    bool `yaml:"sharpen,omitempty"`
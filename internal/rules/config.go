@@ -5,6 +5,9 @@ import (
 	"os"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+	"github.com/DanielTso/pixshift/internal/naming"
+	"github.com/DanielTso/pixshift/internal/pipeline"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,16 +19,18 @@ type Config struct {
 
 // PresetConfig defines a custom preset in the config file.
 type PresetConfig struct {
-	Format           string `yaml:"format,omitempty"`
-	Quality          int    `yaml:"quality,omitempty"`
-	MaxDim           int    `yaml:"max_dim,omitempty"`
-	Width            int    `yaml:"width,omitempty"`
-	Height           int    `yaml:"height,omitempty"`
-	StripMetadata    bool   `yaml:"strip_metadata,omitempty"`
-	PreserveMetadata bool   `yaml:"preserve_metadata,omitempty"`
-	Grayscale        bool   `yaml:"grayscale,omitempty"`
-	Sharpen          bool   `yaml:"sharpen,omitempty"`
-	AutoRotate       bool   `yaml:"auto_rotate,omitempty"`
+	Format           string   `yaml:"format,omitempty"`
+	Quality          int      `yaml:"quality,omitempty"`
+	MaxDim           int      `yaml:"max_dim,omitempty"`
+	Width            int      `yaml:"width,omitempty"`
+	Height           int      `yaml:"height,omitempty"`
+	StripMetadata    bool     `yaml:"strip_metadata,omitempty"`
+	PreserveMetadata bool     `yaml:"preserve_metadata,omitempty"`
+	KeepEXIF         []string `yaml:"keep_exif,omitempty"`
+	StripEXIF        []string `yaml:"strip_exif,omitempty"`
+	Grayscale        bool     `yaml:"grayscale,omitempty"`
+	Sharpen          bool     `yaml:"sharpen,omitempty"`
+	AutoRotate       bool     `yaml:"auto_rotate,omitempty"`
 }
 
 // Rule defines a single conversion rule.
@@ -40,7 +45,11 @@ type Rule struct {
 	// Output settings.
 	Output  string `yaml:"output"`            // output format (e.g., "webp", "jpg")
 	Quality int    `yaml:"quality,omitempty"` // quality 1-100 (0 = default)
-	Dir     string `yaml:"dir,omitempty"`     // output directory override
+	Dir     string `yaml:"dir,omitempty"`     // output directory override (may use placeholders)
+
+	// Template names output files using placeholders such as {name},
+	// {date:2006/01/02} or {camera}; see the naming package.
+	Template string `yaml:"template,omitempty"`
 
 	// Transform fields
 	Width            int     `yaml:"width,omitempty"`
@@ -67,21 +76,87 @@ type Rule struct {
 	Contrast   float64 `yaml:"contrast,omitempty"`
 	Blur       float64 `yaml:"blur,omitempty"`
 
+	// Ops is an ordered operation chain, applied after the transform and
+	// filter fields above, e.g. [{op: resize, max_dim: 1200}, {op: crop, ratio: "1:1"}]
+	Ops []pipeline.Op `yaml:"ops,omitempty"`
+
+	// Outputs writes several variants of each matched file from a single
+	// decode, e.g. [{format: webp, max_dim: 640}, {format: avif, max_dim: 1280}].
+	// Variants without a format use Output, which is then optional.
+	Outputs []pipeline.Variant `yaml:"outputs,omitempty"`
+
 	// Encoding fields
 	Interpolation    string `yaml:"interpolation,omitempty"`
 	PngCompression   int    `yaml:"png_compression,omitempty"`
+	PngOptimize      bool   `yaml:"png_optimize,omitempty"`
 	WebpMethod       int    `yaml:"webp_method,omitempty"`
 	Lossless         bool   `yaml:"lossless,omitempty"`
 	Progressive      bool   `yaml:"progressive,omitempty"`
 	StripMetadata    bool   `yaml:"strip_metadata,omitempty"`
 	PreserveMetadata bool   `yaml:"preserve_metadata,omitempty"`
+
+	// Metadata policy: EXIF groups/tags to keep or strip (either implies
+	// preserve_metadata)
+	KeepEXIF  []string `yaml:"keep_exif,omitempty"`
+	StripEXIF []string `yaml:"strip_exif,omitempty"`
+
+	// XMP fields to set (written even with strip_metadata)
+	XMPTitle    string   `yaml:"xmp_title,omitempty"`
+	XMPCreator  string   `yaml:"xmp_creator,omitempty"`
+	XMPRights   string   `yaml:"xmp_rights,omitempty"`
+	XMPKeywords []string `yaml:"xmp_keywords,omitempty"`
+
+	// IPTC-IIM fields to set (written even with strip_metadata)
+	IPTCCaption   string   `yaml:"iptc_caption,omitempty"`
+	IPTCByline    string   `yaml:"iptc_byline,omitempty"`
+	IPTCCopyright string   `yaml:"iptc_copyright,omitempty"`
+	IPTCKeywords  []string `yaml:"iptc_keywords,omitempty"`
+
+	// Metadata sidecars: write "json" or "xmp" next to the output, or
+	// read the metadata from the input's sidecar
+	Sidecar     string `yaml:"sidecar,omitempty"`
+	FromSidecar bool   `yaml:"from_sidecar,omitempty"`
+
+	// Advanced encoder fields
+	Chroma           string  `yaml:"chroma,omitempty"`             // AVIF/HEIC: "420" only
+	JXLEffort        int     `yaml:"jxl_effort,omitempty"`         // 1-9
+	JXLDistance      float64 `yaml:"jxl_distance,omitempty"`       // 0.01-25
+	AVIFSpeed        int     `yaml:"avif_speed,omitempty"`         // 0-10
+	AVIFAlphaQuality int     `yaml:"avif_alpha_quality,omitempty"` // 1-100
+	AVIFBitDepth     int     `yaml:"avif_depth,omitempty"`         // 8 only
+}
+
+// MetadataPolicy returns the rule's EXIF keep/strip policy.
+func (r Rule) MetadataPolicy() metadata.Policy {
+	return metadata.Policy{Keep: r.KeepEXIF, Strip: r.StripEXIF}
+}
+
+// XMPFields returns the XMP properties the rule sets.
+func (r Rule) XMPFields() metadata.XMPFields {
+	return metadata.XMPFields{Title: r.XMPTitle, Creator: r.XMPCreator, Rights: r.XMPRights, Keywords: r.XMPKeywords}
+}
+
+// IPTCFields returns the IPTC datasets the rule sets.
+func (r Rule) IPTCFields() metadata.IPTCFields {
+	return metadata.IPTCFields{Caption: r.IPTCCaption, Byline: r.IPTCByline, Copyright: r.IPTCCopyright, Keywords: r.IPTCKeywords}
+}
+
+// SidecarFormat returns the rule's sidecar format, or "" for none.
+func (r Rule) SidecarFormat() metadata.SidecarFormat {
+	f, _ := metadata.ParseSidecarFormat(r.Sidecar) // validated by ParseRules
+	return f
 }
 
 // ParsedRule is a Rule with parsed format fields.
 type ParsedRule struct {
 	Rule         Rule
 	InputFormat  codec.Format // parsed from Rule.Format (empty = match any)
-	OutputFormat codec.Format // parsed from Rule.Output
+	OutputFormat codec.Format // parsed from Rule.Output (empty if every output has a format)
+
+	DirTemplate  *naming.Template // parsed from Rule.Dir if it has placeholders
+	NameTemplate *naming.Template // parsed from Rule.Template
+
+	OutputTemplates []*naming.Template // name templates of Rule.Outputs
 }
 
 // LoadConfig reads and parses a YAML config file.
@@ -110,15 +185,59 @@ func ParseRules(cfg *Config) ([]ParsedRule, error) {
 	for i, rule := range cfg.Rules {
 		pr := ParsedRule{Rule: rule}
 
-		if rule.Output == "" {
+		if rule.Output == "" && len(rule.Outputs) == 0 {
 			return nil, fmt.Errorf("rule %d: missing output format", i+1)
 		}
 
-		outFmt, err := codec.ParseFormat(rule.Output)
-		if err != nil {
+		var err error
+		if rule.Output != "" {
+			if pr.OutputFormat, err = codec.ParseFormat(rule.Output); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+		}
+		if err := pipeline.ValidateVariants(rule.Outputs, pr.OutputFormat); err != nil {
+			return nil, fmt.Errorf("rule %d: outputs: %w", i+1, err)
+		}
+		for j, v := range rule.Outputs {
+			if v.Format == "" && rule.Output == "" {
+				return nil, fmt.Errorf("rule %d: output %d has no format and the rule no output format", i+1, j+1)
+			}
+			tmpl, err := v.NameTemplate(j)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: output %d: %w", i+1, j+1, err)
+			}
+			pr.OutputTemplates = append(pr.OutputTemplates, tmpl)
+		}
+
+		if err := rule.MetadataPolicy().Validate(); err != nil {
 			return nil, fmt.Errorf("rule %d: %w", i+1, err)
 		}
-		pr.OutputFormat = outFmt
+		if err := pipeline.ValidateOps(rule.Ops); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if err := (codec.EncodeOptions{Subsample: rule.Chroma, AVIFBitDepth: rule.AVIFBitDepth}).Validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if rule.Sidecar != "" {
+			if _, err := metadata.ParseSidecarFormat(rule.Sidecar); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+		}
+
+		if rule.Dir != "" {
+			tmpl, err := naming.Parse(rule.Dir)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: dir: %w", i+1, err)
+			}
+			if tmpl.HasPlaceholders() {
+				pr.DirTemplate = tmpl
+			}
+		}
+		if rule.Template != "" {
+			if pr.NameTemplate, err = naming.Parse(rule.Template); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+		}
 
 		if rule.Format != "" {
 			inFmt, err := codec.ParseFormat(rule.Format)
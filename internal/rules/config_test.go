@@ -1,9 +1,14 @@
 package rules
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/pipeline"
 )
 
 func TestParseRules_Valid(t *testing.T) {
@@ -53,6 +58,55 @@ func TestParseRules_InvalidOutputFormat(t *testing.T) {
 	}
 }
 
+func TestParseRules_InvalidMetadataPolicy(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Output: "jpg", KeepEXIF: []string{"copyright"}, StripEXIF: []string{"location"}},
+		},
+	}
+
+	_, err := ParseRules(cfg)
+	if err == nil {
+		t.Error("expected error for unknown strip_exif entry, got nil")
+	}
+}
+
+func TestParseRules_UnsupportedEncodeOptions(t *testing.T) {
+	for _, rule := range []Rule{
+		{Output: "avif", Chroma: "444"},
+		{Output: "heic", Chroma: "422"},
+		{Output: "avif", AVIFBitDepth: 10},
+	} {
+		if _, err := ParseRules(&Config{Rules: []Rule{rule}}); err == nil {
+			t.Errorf("%+v: expected error for unsupported encoder option, got nil", rule)
+		}
+	}
+}
+
+func TestParseRules_InvalidSidecar(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Output: "jpg", Sidecar: "yaml"},
+		},
+	}
+
+	_, err := ParseRules(cfg)
+	if err == nil {
+		t.Error("expected error for unknown sidecar format, got nil")
+	}
+}
+
+func TestParseRules_InvalidTemplate(t *testing.T) {
+	for _, rule := range []Rule{
+		{Output: "jpg", Dir: "out/{year}"},
+		{Output: "jpg", Template: "{name"},
+	} {
+		if _, err := ParseRules(&Config{Rules: []Rule{rule}}); err == nil {
+			t.Errorf("expected error for dir %q, template %q", rule.Dir, rule.Template)
+		}
+	}
+}
+
 func TestParseRules_MissingOutput(t *testing.T) {
 	cfg := &Config{
 		Rules: []Rule{
@@ -95,3 +149,92 @@ func TestParseRules_NoFormat(t *testing.T) {
 		t.Errorf("expected empty InputFormat, got %q", parsed[0].InputFormat)
 	}
 }
+
+func TestLoadConfig_Ops(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pixshift.yaml")
+	yaml := `rules:
+  - output: webp
+    ops:
+      - {op: resize, max_dim: 1200}
+      - {op: crop, ratio: "1:1", gravity: north}
+      - op: watermark
+        text: PROOF
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	want := []pipeline.Op{
+		{Kind: pipeline.OpResize, MaxDim: 1200},
+		{Kind: pipeline.OpCrop, Ratio: "1:1", Gravity: "north"},
+		{Kind: pipeline.OpWatermark, Text: "PROOF"},
+	}
+	job := NewEngine(parsed).Match("a.png", codec.PNG)
+	if job == nil || !reflect.DeepEqual(job.Ops, want) {
+		t.Errorf("job ops = %+v, want %+v", job, want)
+	}
+
+	cfg.Rules[0].Ops[1].Ratio = "square"
+	if _, err := ParseRules(cfg); err == nil || !strings.Contains(err.Error(), "rule 1: op 2 (crop)") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestLoadConfig_Outputs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pixshift.yaml")
+	yaml := `rules:
+  - format: jpeg
+    dir: out
+    outputs:
+      - {format: webp, max_dim: 640}
+      - {format: avif, max_dim: 640, quality: 50}
+      - {format: jpg, width: 200, height: 200, name: thumb, ops: [{op: crop, ratio: "1:1"}]}
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	job := NewEngine(parsed).Match("photo.jpg", codec.JPEG)
+	if job == nil || len(job.Variants) != 3 {
+		t.Fatalf("job = %+v", job)
+	}
+	var paths []string
+	for _, v := range job.Variants {
+		paths = append(paths, v.OutputPath)
+	}
+	want := []string{
+		filepath.Join("out", "photo-640.webp"),
+		filepath.Join("out", "photo-640.avif"),
+		filepath.Join("out", "photo-thumb.jpg"),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("variant paths = %v, want %v", paths, want)
+	}
+	if job.Variants[1].Quality != 50 || len(job.Variants[2].Ops) != 1 {
+		t.Errorf("variants = %+v", job.Variants)
+	}
+
+	cfg.Rules[0].Outputs[0].Format = ""
+	if _, err := ParseRules(cfg); err == nil || !strings.Contains(err.Error(), "output 1 has no format") {
+		t.Errorf("error = %v", err)
+	}
+	cfg.Rules[0].Outputs[0].Format = "avif"
+	if _, err := ParseRules(cfg); err == nil || !strings.Contains(err.Error(), "both named") {
+		t.Errorf("error = %v", err)
+	}
+}
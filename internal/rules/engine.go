@@ -4,6 +4,7 @@ import (
 	"path/filepath"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/naming"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 )
 
@@ -16,6 +17,7 @@ type Engine struct {
 	OutputDir string // global output directory override
 	Quality   int    // global quality default
 	Metadata  bool   // preserve metadata globally
+	Template  *naming.Template // output name template for rules without one
 }
 
 // NewEngine creates a rules engine from parsed rules.
@@ -40,11 +42,33 @@ func (e *Engine) Match(filePath string, inputFormat codec.Format) *pipeline.Job
 		}
 
 		outDir := rule.Rule.Dir
+		if rule.DirTemplate != nil {
+			outDir = rule.DirTemplate.Expand(filePath, rule.OutputFormat)
+		}
 		if outDir == "" {
 			outDir = e.OutputDir
 		}
 
-		outputPath := buildOutputPath(filePath, outDir, rule.OutputFormat)
+		tmpl := rule.NameTemplate
+		if tmpl == nil {
+			tmpl = e.Template
+		}
+		var outputPath string
+		var variants []pipeline.Variant
+		if len(rule.Rule.Outputs) > 0 {
+			variants = make([]pipeline.Variant, len(rule.Rule.Outputs))
+			for i, v := range rule.Rule.Outputs {
+				format := v.OutputFormat(rule.OutputFormat)
+				dir := outDir
+				if rule.DirTemplate != nil {
+					dir = rule.DirTemplate.Expand(filePath, format)
+				}
+				v.OutputPath = buildOutputPath(filePath, dir, format, rule.OutputTemplates[i])
+				variants[i] = v
+			}
+		} else {
+			outputPath = buildOutputPath(filePath, outDir, rule.OutputFormat, tmpl)
+		}
 
 		return &pipeline.Job{
 			InputPath:        filePath,
@@ -54,6 +78,11 @@ func (e *Engine) Match(filePath string, inputFormat codec.Format) *pipeline.Job
 			Quality:          quality,
 			PreserveMetadata: e.Metadata || rule.Rule.PreserveMetadata,
 			StripMetadata:    rule.Rule.StripMetadata,
+			MetadataPolicy:   rule.Rule.MetadataPolicy(),
+			XMP:              rule.Rule.XMPFields(),
+			IPTC:             rule.Rule.IPTCFields(),
+			Sidecar:          rule.Rule.SidecarFormat(),
+			FromSidecar:      rule.Rule.FromSidecar,
 
 			// Transforms
 			Width:            rule.Rule.Width,
@@ -79,21 +108,42 @@ func (e *Engine) Match(filePath string, inputFormat codec.Format) *pipeline.Job
 			Brightness: rule.Rule.Brightness,
 			Contrast:   rule.Rule.Contrast,
 			Blur:       rule.Rule.Blur,
+			Ops:        rule.Rule.Ops,
+			Variants:   variants,
 
 			// Encoding
 			Interpolation: rule.Rule.Interpolation,
 			EncodeOpts: codec.EncodeOptions{
-				Quality:     quality,
-				Progressive: rule.Rule.Progressive,
-				Compression: rule.Rule.PngCompression,
-				WebPMethod:  rule.Rule.WebpMethod,
-				Lossless:    rule.Rule.Lossless,
+				Quality:          quality,
+				Progressive:      rule.Rule.Progressive,
+				Subsample:        rule.Rule.Chroma,
+				Compression:      rule.Rule.PngCompression,
+				WebPMethod:       rule.Rule.WebpMethod,
+				Lossless:         rule.Rule.Lossless,
+				JXLEffort:        rule.Rule.JXLEffort,
+				JXLDistance:      rule.Rule.JXLDistance,
+				AVIFSpeed:        rule.Rule.AVIFSpeed,
+				AVIFAlphaQuality: rule.Rule.AVIFAlphaQuality,
+				AVIFBitDepth:     rule.Rule.AVIFBitDepth,
+				PNGOptimize:      rule.Rule.PngOptimize,
 			},
 		}
 	}
 	return nil
 }
 
+// Templates returns the output directory and name templates of the engine
+// and its rules, e.g. to give back the {counter} numbers of a file that is
+// skipped after Match; see naming.Template.Mark.
+func (e *Engine) Templates() []*naming.Template {
+	tmpls := []*naming.Template{e.Template}
+	for _, rule := range e.Rules {
+		tmpls = append(tmpls, rule.DirTemplate, rule.NameTemplate)
+		tmpls = append(tmpls, rule.OutputTemplates...)
+	}
+	return tmpls
+}
+
 func (e *Engine) ruleMatches(rule ParsedRule, filePath string, inputFormat codec.Format) bool {
 	// Check format match
 	if rule.InputFormat != "" && rule.InputFormat != inputFormat {
@@ -111,10 +161,14 @@ func (e *Engine) ruleMatches(rule ParsedRule, filePath string, inputFormat codec
 	return true
 }
 
-func buildOutputPath(inputPath, outputDir string, format codec.Format) string {
-	base := filepath.Base(inputPath)
-	ext := filepath.Ext(base)
-	name := base[:len(base)-len(ext)] + codec.DefaultExtension(format)
+func buildOutputPath(inputPath, outputDir string, format codec.Format, tmpl *naming.Template) string {
+	var name string
+	if tmpl != nil {
+		name = tmpl.OutputName(inputPath, format)
+	} else {
+		base := filepath.Base(inputPath)
+		name = base[:len(base)-len(filepath.Ext(base))] + codec.DefaultExtension(format)
+	}
 
 	if outputDir != "" {
 		return filepath.Join(outputDir, name)
@@ -1,9 +1,12 @@
 package rules
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/metadata"
+	"github.com/DanielTso/pixshift/internal/naming"
 )
 
 func TestEngine_FormatMatch(t *testing.T) {
@@ -258,6 +261,56 @@ func TestMatch_WithEncodingOptions(t *testing.T) {
 	}
 }
 
+func TestMatch_WithAdvancedEncoderOptions(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Format: "png", Output: "avif", Chroma: "420", AVIFSpeed: 6, AVIFAlphaQuality: 95, AVIFBitDepth: 8},
+			{Format: "jpeg", Output: "jxl", JXLEffort: 9, JXLDistance: 1.5},
+		},
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	engine := NewEngine(parsed)
+
+	job := engine.Match("logo.png", codec.PNG)
+	if job == nil {
+		t.Fatal("expected avif match, got nil")
+	}
+	opts := job.EncodeOpts
+	if opts.Subsample != "420" || opts.AVIFSpeed != 6 || opts.AVIFAlphaQuality != 95 || opts.AVIFBitDepth != 8 {
+		t.Errorf("AVIF options not applied: %+v", opts)
+	}
+
+	job = engine.Match("photo.jpg", codec.JPEG)
+	if job == nil {
+		t.Fatal("expected jxl match, got nil")
+	}
+	if job.EncodeOpts.JXLEffort != 9 || job.EncodeOpts.JXLDistance != 1.5 {
+		t.Errorf("JXL options not applied: %+v", job.EncodeOpts)
+	}
+}
+
+func TestMatch_WithPNGOptimize(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Format: "avif", Output: "png", PngOptimize: true},
+		},
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	job := NewEngine(parsed).Match("photo.avif", codec.AVIF)
+	if job == nil {
+		t.Fatal("expected png match, got nil")
+	}
+	if !job.EncodeOpts.PNGOptimize {
+		t.Error("png_optimize not applied")
+	}
+}
+
 func TestMatch_WithWatermark(t *testing.T) {
 	cfg := &Config{
 		Rules: []Rule{
@@ -327,3 +380,100 @@ func TestMatch_PreserveMetadataFromRule(t *testing.T) {
 		t.Error("PreserveMetadata should be true from rule")
 	}
 }
+
+func TestMatch_WithXMPFields(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{
+				Format:      "jpeg",
+				Output:      "webp",
+				XMPTitle:    "Harbor",
+				XMPRights:   "CC BY 4.0",
+				XMPKeywords: []string{"sea", "boat"},
+			},
+		},
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	job := NewEngine(parsed).Match("photo.jpg", codec.JPEG)
+	if job == nil {
+		t.Fatal("expected match, got nil")
+	}
+	if job.XMP.Title != "Harbor" || job.XMP.Rights != "CC BY 4.0" || len(job.XMP.Keywords) != 2 {
+		t.Errorf("XMP = %+v", job.XMP)
+	}
+}
+
+func TestMatch_WithIPTCFields(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{
+				Format:        "jpeg",
+				Output:        "jpeg",
+				IPTCCaption:   "Boats at dawn",
+				IPTCCopyright: "(c) Ann",
+				IPTCKeywords:  []string{"sea"},
+			},
+		},
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	job := NewEngine(parsed).Match("photo.jpg", codec.JPEG)
+	if job == nil {
+		t.Fatal("expected match, got nil")
+	}
+	if job.IPTC.Caption != "Boats at dawn" || job.IPTC.Copyright != "(c) Ann" || len(job.IPTC.Keywords) != 1 {
+		t.Errorf("IPTC = %+v", job.IPTC)
+	}
+}
+
+func TestMatch_WithSidecar(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Format: "jpeg", Output: "webp", StripMetadata: true, Sidecar: "XMP"},
+		},
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	job := NewEngine(parsed).Match("photo.jpg", codec.JPEG)
+	if job == nil {
+		t.Fatal("expected match, got nil")
+	}
+	if job.Sidecar != metadata.SidecarXMP || !job.StripMetadata {
+		t.Errorf("sidecar = %q, strip = %v", job.Sidecar, job.StripMetadata)
+	}
+}
+
+func TestMatch_WithTemplate(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Format: "png", Output: "webp", Dir: "out/{parent}", Template: "{name}_{counter:2}"},
+			{Output: "jpg", Dir: "plain"},
+		},
+	}
+	parsed, err := ParseRules(cfg)
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	engine := NewEngine(parsed)
+	job := engine.Match(filepath.Join("shots", "a.png"), codec.PNG)
+	if want := filepath.Join("out", "shots", "a_01.webp"); job == nil || job.OutputPath != want {
+		t.Fatalf("job = %+v, want output %s", job, want)
+	}
+	if parsed[1].DirTemplate != nil {
+		t.Error("literal dir parsed as a template")
+	}
+
+	// The global template applies to rules without one
+	engine.Template, _ = naming.Parse("{format}/{name}")
+	job = engine.Match("b.gif", codec.GIF)
+	if want := filepath.Join("plain", "jpeg", "b.jpg"); job == nil || job.OutputPath != want {
+		t.Errorf("job = %+v, want output %s", job, want)
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"archive/zip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"net"
@@ -15,6 +17,7 @@ import (
 
 	"github.com/DanielTso/pixshift/internal/codec"
 	pixcolor "github.com/DanielTso/pixshift/internal/color"
+	"github.com/DanielTso/pixshift/internal/metadata"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 )
 
@@ -50,6 +53,27 @@ func writeError(w http.ResponseWriter, status int, code, msg string) {
 	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: msg, Code: code})
 }
 
+// conversionError maps the kind of a failed job's error to a status and
+// code: images the server cannot read are the client's fault, failures
+// to encode or write them the server's.
+func conversionError(err error) (int, string) {
+	switch pipeline.ErrorKind(err) {
+	case pipeline.ErrUnsupportedFormat:
+		return http.StatusUnsupportedMediaType, "UNSUPPORTED_FORMAT"
+	case pipeline.ErrDecode:
+		return http.StatusUnprocessableEntity, "DECODE_FAILED"
+	case pipeline.ErrMetadata:
+		return http.StatusUnprocessableEntity, "METADATA_FAILED"
+	case pipeline.ErrLimit:
+		return http.StatusUnprocessableEntity, "LIMIT_EXCEEDED"
+	case pipeline.ErrEncode:
+		return http.StatusInternalServerError, "ENCODE_FAILED"
+	case pipeline.ErrIO:
+		return http.StatusInternalServerError, "IO_ERROR"
+	}
+	return http.StatusInternalServerError, "CONVERSION_FAILED"
+}
+
 // statusRecorder wraps http.ResponseWriter to capture the status code for logging.
 type statusRecorder struct {
 	http.ResponseWriter
@@ -159,11 +183,7 @@ func rateLimitMiddleware(limiter *RateLimiter) func(http.Handler) http.Handler {
 // Start starts the HTTP server and blocks until ctx is cancelled.
 func (s *Server) Start(ctx context.Context) error {
 	handler := s.buildSimpleModeHandler(ctx)
-
-	timeout := s.Timeout
-	if timeout == 0 {
-		timeout = 60 * time.Second
-	}
+	timeout := s.timeout()
 
 	// Wrap with security headers
 	handler = securityHeadersMiddleware(handler)
@@ -192,6 +212,14 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 }
 
+// timeout returns the request timeout, 60 seconds unless set.
+func (s *Server) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return 60 * time.Second
+	}
+	return s.Timeout
+}
+
 // buildSimpleModeHandler creates the handler for simple mode (backward compatible).
 func (s *Server) buildSimpleModeHandler(ctx context.Context) http.Handler {
 	mux := http.NewServeMux()
@@ -269,17 +297,64 @@ func (s *Server) handleConvert(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	outputFmt := r.FormValue("format")
-	if outputFmt == "" {
+	var outFormat codec.Format
+	if outputFmt != "" {
+		if outFormat, err = codec.ParseFormat(outputFmt); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_FORMAT", err.Error())
+			return
+		}
+	}
+
+	// outputs lists variants written from a single decode; format is then
+	// only the default for variants without one
+	var variants []pipeline.Variant
+	if v := r.FormValue("outputs"); v != "" {
+		dec := json.NewDecoder(strings.NewReader(v))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&variants); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_OUTPUTS", "outputs must be a JSON array of variants: "+err.Error())
+			return
+		}
+		if len(variants) == 0 {
+			writeError(w, http.StatusBadRequest, "INVALID_OUTPUTS", "outputs is empty")
+			return
+		}
+		if err := pipeline.ValidateVariants(variants, outFormat); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_OUTPUTS", err.Error())
+			return
+		}
+	}
+
+	for _, v := range variants {
+		if outFormat == "" && v.Format == "" {
+			writeError(w, http.StatusBadRequest, "MISSING_FIELD", "missing required field: format (an output has no format)")
+			return
+		}
+	}
+	if outFormat == "" && len(variants) == 0 {
 		writeError(w, http.StatusBadRequest, "MISSING_FIELD", "missing required field: format")
 		return
 	}
-
-	outFormat, err := codec.ParseFormat(outputFmt)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "INVALID_FORMAT", err.Error())
+	manifest := r.FormValue("manifest")
+	if manifest != "" && manifest != "zip" && manifest != "json" {
+		writeError(w, http.StatusBadRequest, "INVALID_MANIFEST", "manifest must be zip or json")
 		return
 	}
 
+	var ops []pipeline.Op
+	if v := r.FormValue("ops"); v != "" {
+		dec := json.NewDecoder(strings.NewReader(v))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&ops); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_OPS", "ops must be a JSON array of operations: "+err.Error())
+			return
+		}
+		if err := pipeline.ValidateOps(ops); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_OPS", err.Error())
+			return
+		}
+	}
+
 	quality := 92
 	if q := r.FormValue("quality"); q != "" {
 		if v, err := strconv.Atoi(q); err == nil && v > 0 && v <= 100 {
@@ -322,18 +397,35 @@ func (s *Server) handleConvert(w http.ResponseWriter, r *http.Request) {
 
 	// Build output filename
 	baseName := strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
-	outExt := codec.DefaultExtension(outFormat)
-	outputPath := filepath.Join(tmpDir, baseName+outExt)
+	outputPath := filepath.Join(tmpDir, baseName+codec.DefaultExtension(outFormat))
+	if len(variants) > 0 {
+		outputPath = ""
+		outDir := filepath.Join(tmpDir, "out")
+		for i := range variants {
+			tmpl, _ := variants[i].NameTemplate(i) // validated above
+			name := tmpl.OutputName(inputPath, variants[i].OutputFormat(outFormat))
+			variants[i].OutputPath = filepath.Join(outDir, name)
+			if !filepath.IsLocal(name) {
+				writeError(w, http.StatusBadRequest, "INVALID_OUTPUTS", fmt.Sprintf("output %d: template leaves the output directory", i+1))
+				return
+			}
+		}
+	}
 
 	pipe := pipeline.NewPipeline(s.Registry)
+	// A decoder stuck in C code ignores the request context; the job
+	// timeout still ends the request
+	pipe.JobTimeout = s.timeout()
 	job := pipeline.Job{
 		InputPath:    inputPath,
 		OutputPath:   outputPath,
+		Variants:     variants,
 		OutputFormat: outFormat,
 		Quality:      quality,
 		Width:        width,
 		Height:       height,
 		MaxDim:       maxDim,
+		Ops:          ops,
 	}
 
 	// Boolean form fields
@@ -386,23 +478,72 @@ func (s *Server) handleConvert(w http.ResponseWriter, r *http.Request) {
 	job.EncodeOpts.Quality = quality
 	job.EncodeOpts.Progressive = r.FormValue("progressive") == "true"
 	job.EncodeOpts.Lossless = r.FormValue("lossless") == "true"
+	job.EncodeOpts.PNGOptimize = r.FormValue("png_optimize") == "true"
 	if v := r.FormValue("png_compression"); v != "" {
 		job.EncodeOpts.Compression, _ = strconv.Atoi(v)
 	}
 	if v := r.FormValue("webp_method"); v != "" {
 		job.EncodeOpts.WebPMethod, _ = strconv.Atoi(v)
 	}
+	job.EncodeOpts.Subsample = r.FormValue("chroma")
+	if v := r.FormValue("jxl_effort"); v != "" {
+		job.EncodeOpts.JXLEffort, _ = strconv.Atoi(v)
+	}
+	if v := r.FormValue("jxl_distance"); v != "" {
+		job.EncodeOpts.JXLDistance, _ = strconv.ParseFloat(v, 64)
+	}
+	if v := r.FormValue("avif_speed"); v != "" {
+		job.EncodeOpts.AVIFSpeed, _ = strconv.Atoi(v)
+	}
+	if v := r.FormValue("avif_alpha_quality"); v != "" {
+		job.EncodeOpts.AVIFAlphaQuality, _ = strconv.Atoi(v)
+	}
+	if v := r.FormValue("avif_depth"); v != "" {
+		job.EncodeOpts.AVIFBitDepth, _ = strconv.Atoi(v)
+	}
+	if err := job.EncodeOpts.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_OPTION", err.Error())
+		return
+	}
 
-	if _, _, err := pipe.Execute(job); err != nil {
-		writeError(w, http.StatusInternalServerError, "CONVERSION_FAILED", fmt.Sprintf("conversion failed: %v", err))
+	// Abort the conversion when the client goes away or the request
+	// outlives the server's timeout
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout())
+	defer cancel()
+	res := pipe.ExecuteJobContext(ctx, job)
+	if err := res.Error; err != nil {
+		var pe *pipeline.PanicError
+		if errors.As(err, &pe) {
+			data, _ := json.Marshal(map[string]interface{}{
+				"path":  r.URL.Path,
+				"file":  header.Filename,
+				"panic": fmt.Sprint(pe.Value),
+				"stack": string(pe.Stack),
+			})
+			fmt.Fprintf(os.Stderr, "%s\n", data)
+			writeError(w, http.StatusInternalServerError, "CONVERSION_PANIC", "conversion failed: the decoder or encoder crashed on this image")
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeError(w, http.StatusServiceUnavailable, "TIMEOUT", "conversion timed out")
+			return
+		}
+		status, code := conversionError(err)
+		writeError(w, status, code, fmt.Sprintf("conversion failed: %v", err))
 		return
 	}
 
-	// Set response headers
-	w.Header().Set("Content-Type", contentType(outFormat))
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, baseName+outExt))
+	if len(variants) > 0 {
+		writeVariants(w, baseName, filepath.Join(tmpDir, "out"), res.Variants, manifest == "json")
+		return
+	}
 
-	http.ServeFile(w, r, outputPath)
+	// Auto resolves the format (and with it the extension) per job, so
+	// serve what the pipeline actually wrote
+	w.Header().Set("Content-Type", contentType(res.Job.OutputFormat))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, baseName+codec.DefaultExtension(res.Job.OutputFormat)))
+
+	http.ServeFile(w, r, res.Job.OutputPath)
 }
 
 // handleSimplePalette handles POST /palette (no auth).
@@ -450,14 +591,41 @@ func (s *Server) handleSimpleAnalyze(w http.ResponseWriter, r *http.Request) {
 	width := bounds.Dx()
 	height := bounds.Dy()
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+	resp := map[string]interface{}{
 		"width":        width,
 		"height":       height,
 		"format":       string(info.format),
 		"size":         info.size,
 		"aspect_ratio": aspectRatio(width, height),
-	})
+	}
+	if meta := uploadMetadata(r, "file", info.format); meta != nil {
+		if exif, err := meta.EXIF(); err == nil {
+			resp["exif"] = exif.Summary()
+			resp["exif_tags"] = exif.Fields()
+		}
+		if iptc, err := meta.IPTCFields(); err == nil && !iptc.IsZero() {
+			resp["iptc"] = iptc
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// uploadMetadata extracts the metadata of an already-parsed multipart
+// upload. It returns nil when the file carries none.
+func uploadMetadata(r *http.Request, field string, format codec.Format) *metadata.Metadata {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	meta, err := metadata.Extract(file, format)
+	if err != nil {
+		return nil
+	}
+	return meta
 }
 
 // uploadInfo holds metadata about an uploaded file.
@@ -551,3 +719,48 @@ func contentType(f codec.Format) string {
 		return "application/octet-stream"
 	}
 }
+
+// VariantOutput is one output of a conversion with variants in the JSON
+// manifest response.
+type VariantOutput struct {
+	Name   string `json:"name"` // file name, relative to the manifest
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+	Data   []byte `json:"data"` // base64-encoded
+}
+
+// writeVariants responds with the variants written to dir: a zip archive
+// named after the upload, or a JSON manifest with the data inline.
+func writeVariants(w http.ResponseWriter, baseName, dir string, results []pipeline.VariantResult, asJSON bool) {
+	outputs := make([]VariantOutput, len(results))
+	for i, vr := range results {
+		data, err := os.ReadFile(vr.OutputPath)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "internal error")
+			return
+		}
+		rel, _ := filepath.Rel(dir, vr.OutputPath)
+		outputs[i] = VariantOutput{Name: filepath.ToSlash(rel), Format: string(vr.Format), Size: vr.OutputSize, Data: data}
+	}
+
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"outputs": outputs})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, baseName+".zip"))
+	zw := zip.NewWriter(w)
+	for _, out := range outputs {
+		// Images are already compressed; storing them keeps the response fast
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: out.Name, Method: zip.Store})
+		if err != nil {
+			return
+		}
+		if _, err := f.Write(out.Data); err != nil {
+			return
+		}
+	}
+	zw.Close()
+}
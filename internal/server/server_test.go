@@ -1,15 +1,18 @@
 package server
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"image"
 	"image/color"
 	"image/jpeg"
+	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/DanielTso/pixshift/internal/codec"
@@ -336,3 +339,228 @@ func TestServer_Integration_HTTPTest(t *testing.T) {
 		t.Errorf("health status = %q, want ok", health["status"])
 	}
 }
+
+func TestHandleConvert_Ops(t *testing.T) {
+	srv := newTestServer()
+	jpegData, err := createTestJPEG()
+	if err != nil {
+		t.Fatalf("create test jpeg: %v", err)
+	}
+
+	convert := func(ops string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("file", "test.jpg")
+		_, _ = part.Write(jpegData)
+		_ = writer.WriteField("format", "png")
+		_ = writer.WriteField("ops", ops)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/convert", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		srv.handleConvert(w, req)
+		return w
+	}
+
+	// Resizing first gives 4x4 and then 4x2; cropping first would give 8x4
+	w := convert(`[{"op":"resize","height":4},{"op":"crop","ratio":"2:1"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+	}
+	cfg, err := png.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if cfg.Width != 4 || cfg.Height != 2 {
+		t.Errorf("output = %dx%d, want 4x2", cfg.Width, cfg.Height)
+	}
+
+	for _, ops := range []string{`[{"op":"resize"}]`, `[{"op":"spin"}]`, `[{"op":"blur","radius":2}]`, `{}`} {
+		w := convert(ops)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_OPS") {
+			t.Errorf("ops %s: status = %d, body: %s", ops, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleConvert_UnsupportedEncodeOptions(t *testing.T) {
+	srv := newTestServer()
+	jpegData, err := createTestJPEG()
+	if err != nil {
+		t.Fatalf("create test jpeg: %v", err)
+	}
+
+	for field, value := range map[string]string{"chroma": "444", "avif_depth": "12"} {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("file", "test.jpg")
+		_, _ = part.Write(jpegData)
+		_ = writer.WriteField("format", "avif")
+		_ = writer.WriteField(field, value)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/convert", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		srv.handleConvert(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_OPTION") {
+			t.Errorf("%s=%s: status = %d, body: %s", field, value, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleConvert_AutoServesResolvedFormat(t *testing.T) {
+	srv := newTestServer()
+	jpegData, err := createTestJPEG()
+	if err != nil {
+		t.Fatalf("create test jpeg: %v", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "test.jpg")
+	_, _ = part.Write(jpegData)
+	_ = writer.WriteField("format", "auto")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/convert", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	srv.handleConvert(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200, body: %s", w.Code, w.Body.String())
+	}
+	disp := w.Header().Get("Content-Disposition")
+	if strings.Contains(disp, ".auto") || !strings.Contains(disp, `filename="test.`) {
+		t.Errorf("Content-Disposition = %q, want the resolved extension", disp)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "image/") {
+		t.Errorf("Content-Type = %q, want an image type", ct)
+	}
+	if w.Body.Len() == 0 {
+		t.Error("response body should not be empty")
+	}
+}
+
+func TestHandleConvert_Outputs(t *testing.T) {
+	srv := newTestServer()
+	jpegData, err := createTestJPEG()
+	if err != nil {
+		t.Fatalf("create test jpeg: %v", err)
+	}
+
+	convert := func(outputs, manifest string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("file", "test.jpg")
+		_, _ = part.Write(jpegData)
+		_ = writer.WriteField("format", "png")
+		_ = writer.WriteField("outputs", outputs)
+		if manifest != "" {
+			_ = writer.WriteField("manifest", manifest)
+		}
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/convert", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		srv.handleConvert(w, req)
+		return w
+	}
+	outputs := `[{"max_dim":4},{"format":"jpeg","width":2,"name":"small"}]`
+
+	w := convert(outputs, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "test-4.png,test-small.jpg" {
+		t.Errorf("zip entries = %v", names)
+	}
+
+	w = convert(outputs, "json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+	}
+	var resp struct{ Outputs []VariantOutput }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if len(resp.Outputs) != 2 {
+		t.Fatalf("outputs = %+v", resp.Outputs)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(resp.Outputs[0].Data))
+	if err != nil || cfg.Width != 4 || resp.Outputs[0].Size != int64(len(resp.Outputs[0].Data)) {
+		t.Errorf("output 1 = %s %dx%d, %v", resp.Outputs[0].Name, cfg.Width, cfg.Height, err)
+	}
+	if resp.Outputs[1].Format != "jpeg" {
+		t.Errorf("output 2 format = %q", resp.Outputs[1].Format)
+	}
+
+	for _, bad := range []string{`[]`, `[{"format":"bmp2"}]`, `[{"size":3}]`, `[{"template":"../{name}"}]`} {
+		w := convert(bad, "")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_OUTPUTS") {
+			t.Errorf("outputs %s: status = %d, body: %s", bad, w.Code, w.Body.String())
+		}
+	}
+}
+
+// panickingDecoder panics like a decoder given a malformed image.
+type panickingDecoder struct{ codec.Decoder }
+
+func (panickingDecoder) Decode(io.ReadSeeker) (image.Image, error) {
+	panic("corrupt tile")
+}
+
+func TestHandleConvert_DecoderPanic(t *testing.T) {
+	srv := newTestServer()
+	jpegDec, _ := srv.Registry.Decoder(codec.JPEG)
+	srv.Registry.RegisterDecoder(panickingDecoder{jpegDec})
+
+	jpegData, err := createTestJPEG()
+	if err != nil {
+		t.Fatalf("create test jpeg: %v", err)
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "test.jpg")
+	_, _ = part.Write(jpegData)
+	_ = writer.WriteField("format", "png")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/convert", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	srv.handleConvert(w, req)
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "CONVERSION_PANIC") {
+		t.Errorf("status = %d, body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleConvert_DecodeFailed(t *testing.T) {
+	srv := newTestServer()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "test.jpg")
+	_, _ = part.Write([]byte("not a jpeg"))
+	_ = writer.WriteField("format", "png")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/convert", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	srv.handleConvert(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "DECODE_FAILED") {
+		t.Errorf("status = %d, body: %s", w.Code, w.Body.String())
+	}
+}
@@ -1,6 +1,7 @@
 package ssim
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -21,6 +22,13 @@ const (
 // Returns a value between 0.0 (completely different) and 1.0 (identical).
 // If the images differ in size, img2 is resized to match img1.
 func Compare(img1, img2 image.Image) float64 {
+	score, _ := CompareContext(context.Background(), img1, img2)
+	return score
+}
+
+// CompareContext is Compare that stops between rows of windows once ctx is
+// done, returning the context's error.
+func CompareContext(ctx context.Context, img1, img2 image.Image) (float64, error) {
 	px1, w, h := toGrayFloat(img1)
 
 	b2 := img2.Bounds()
@@ -35,6 +43,9 @@ func Compare(img1, img2 image.Image) float64 {
 	var count int
 
 	for y := 0; y <= h-windowSize; y++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
 		for x := 0; x <= w-windowSize; x++ {
 			var sumX, sumY, sumXX, sumYY, sumXY float64
 
@@ -67,9 +78,9 @@ func Compare(img1, img2 image.Image) float64 {
 	}
 
 	if count == 0 {
-		return 1.0
+		return 1.0, nil
 	}
-	return math.Max(0, math.Min(1, sum/float64(count)))
+	return math.Max(0, math.Min(1, sum/float64(count))), nil
 }
 
 // CompareFiles decodes two image files and computes their SSIM.
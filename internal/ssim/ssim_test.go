@@ -1,6 +1,7 @@
 package ssim
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"math"
@@ -175,3 +176,12 @@ func TestCompare_Symmetric(t *testing.T) {
 		t.Errorf("SSIM should be roughly symmetric: %f vs %f", s1, s2)
 	}
 }
+
+func TestCompareContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	img := gradientImage(64, 64)
+	if _, err := CompareContext(ctx, img, img); err != context.Canceled {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+}
@@ -21,7 +21,28 @@ type CropOptions struct {
 // the largest crop that fits the source image with the requested ratio.
 // If the requested crop is larger than the image, the original is returned.
 func Crop(img image.Image, opts CropOptions) image.Image {
-	b := img.Bounds()
+	rect, ok := CropRect(img.Bounds(), opts)
+	if !ok {
+		return img
+	}
+	cropW, cropH := rect.Dx(), rect.Dy()
+
+	// Try zero-copy SubImage first.
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	if si, ok := img.(subImager); ok {
+		return si.SubImage(rect)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// CropRect returns the region of b that Crop would extract for opts. It
+// returns false if opts leave the image unchanged.
+func CropRect(b image.Rectangle, opts CropOptions) (image.Rectangle, bool) {
 	srcW := b.Dx()
 	srcH := b.Dy()
 
@@ -35,10 +56,10 @@ func Crop(img image.Image, opts CropOptions) image.Image {
 	}
 
 	if cropW <= 0 || cropH <= 0 {
-		return img
+		return b, false
 	}
 	if cropW >= srcW && cropH >= srcH {
-		return img
+		return b, false
 	}
 	if cropW > srcW {
 		cropW = srcW
@@ -48,19 +69,7 @@ func Crop(img image.Image, opts CropOptions) image.Image {
 	}
 
 	x, y := gravityOffset(srcW, srcH, cropW, cropH, opts.Gravity)
-	rect := image.Rect(b.Min.X+x, b.Min.Y+y, b.Min.X+x+cropW, b.Min.Y+y+cropH)
-
-	// Try zero-copy SubImage first.
-	type subImager interface {
-		SubImage(r image.Rectangle) image.Image
-	}
-	if si, ok := img.(subImager); ok {
-		return si.SubImage(rect)
-	}
-
-	dst := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
-	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
-	return dst
+	return image.Rect(b.Min.X+x, b.Min.Y+y, b.Min.X+x+cropW, b.Min.Y+y+cropH), true
 }
 
 // parseAspectRatio parses a "W:H" string into two integers.
@@ -1,6 +1,7 @@
 package transform
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"image/draw"
@@ -136,8 +137,15 @@ func Sharpen(img image.Image) image.Image {
 // Uses a two-pass separable approach with prefix sums for O(w*h) performance
 // regardless of radius, instead of the naive O(w*h*r²).
 func Blur(img image.Image, radius float64) image.Image {
+	img, _ = BlurContext(context.Background(), img, radius)
+	return img
+}
+
+// BlurContext is Blur that stops between rows once ctx is done, returning
+// the context's error.
+func BlurContext(ctx context.Context, img image.Image, radius float64) (image.Image, error) {
 	if radius <= 0 {
-		return img
+		return img, nil
 	}
 	b := img.Bounds()
 	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
@@ -153,6 +161,9 @@ func Blur(img image.Image, radius float64) image.Image {
 	pB := make([]int, w+1)
 	pA := make([]int, w+1)
 	for y := 0; y < h; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		rowOff := y * src.Stride
 		pR[0], pG[0], pB[0], pA[0] = 0, 0, 0, 0
 		for x := 0; x < w; x++ {
@@ -188,6 +199,9 @@ func Blur(img image.Image, radius float64) image.Image {
 	pB2 := make([]int, h+1)
 	pA2 := make([]int, h+1)
 	for x := 0; x < w; x++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		pR2[0], pG2[0], pB2[0], pA2[0] = 0, 0, 0, 0
 		for y := 0; y < h; y++ {
 			i := y*tmp.Stride + x*4
@@ -214,7 +228,7 @@ func Blur(img image.Image, radius float64) image.Image {
 		}
 	}
 
-	return dst
+	return dst, nil
 }
 
 // Invert negates all color channels.
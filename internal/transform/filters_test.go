@@ -1,6 +1,7 @@
 package transform
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"testing"
@@ -226,3 +227,12 @@ func TestBrightness_ClampMin(t *testing.T) {
 		t.Errorf("min brightness clamp: got R=%d G=%d B=%d", c.R, c.G, c.B)
 	}
 }
+
+func TestBlurContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	img := colorImage(20, 20, color.RGBA{R: 128, A: 255})
+	if _, err := BlurContext(ctx, img, 2); err != context.Canceled {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+}
@@ -1,6 +1,7 @@
 package transform
 
 import (
+	"context"
 	"image"
 	"image/draw"
 	"math"
@@ -13,13 +14,20 @@ const smartCropBlockSize = 16
 // variance analysis rather than face detection, making it suitable for
 // landscapes, products, and general photography.
 func SmartCrop(img image.Image, targetW, targetH int) image.Image {
+	img, _ = SmartCropContext(context.Background(), img, targetW, targetH)
+	return img
+}
+
+// SmartCropContext is SmartCrop that stops building the importance map
+// once ctx is done, returning the context's error.
+func SmartCropContext(ctx context.Context, img image.Image, targetW, targetH int) (image.Image, error) {
 	b := img.Bounds()
 	srcW := b.Dx()
 	srcH := b.Dy()
 
 	// If target >= source in both dimensions, return source unchanged.
 	if targetW >= srcW && targetH >= srcH {
-		return img
+		return img, nil
 	}
 
 	// Clamp target to source dimensions.
@@ -32,18 +40,21 @@ func SmartCrop(img image.Image, targetW, targetH int) image.Image {
 
 	// For very small images (smaller than block size), fall back to center crop.
 	if srcW <= smartCropBlockSize || srcH <= smartCropBlockSize {
-		return centerCrop(img, targetW, targetH)
+		return centerCrop(img, targetW, targetH), nil
 	}
 
 	// If target matches source after clamping, return unchanged.
 	if targetW == srcW && targetH == srcH {
-		return img
+		return img, nil
 	}
 
 	// Build importance map using color variance in blocks.
 	blocksX := srcW / smartCropBlockSize
 	blocksY := srcH / smartCropBlockSize
-	importance := buildImportanceMap(img, blocksX, blocksY)
+	importance, err := buildImportanceMap(ctx, img, blocksX, blocksY)
+	if err != nil {
+		return nil, err
+	}
 
 	// Sliding window search: find the position with highest total importance.
 	bestX, bestY := findBestWindow(importance, blocksX, blocksY, srcW, srcH, targetW, targetH)
@@ -58,21 +69,24 @@ func SmartCrop(img image.Image, targetW, targetH int) image.Image {
 		SubImage(r image.Rectangle) image.Image
 	}
 	if si, ok := img.(subImager); ok {
-		return si.SubImage(rect)
+		return si.SubImage(rect), nil
 	}
 
 	dst := image.NewRGBA(image.Rect(0, 0, targetW, targetH))
 	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
-	return dst
+	return dst, nil
 }
 
 // buildImportanceMap computes the color variance for each block in the image.
 // Higher variance means more visual detail/interest.
-func buildImportanceMap(img image.Image, blocksX, blocksY int) []float64 {
+func buildImportanceMap(ctx context.Context, img image.Image, blocksX, blocksY int) ([]float64, error) {
 	b := img.Bounds()
 	importance := make([]float64, blocksX*blocksY)
 
 	for by := 0; by < blocksY; by++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		for bx := 0; bx < blocksX; bx++ {
 			x0 := b.Min.X + bx*smartCropBlockSize
 			y0 := b.Min.Y + by*smartCropBlockSize
@@ -80,7 +94,7 @@ func buildImportanceMap(img image.Image, blocksX, blocksY int) []float64 {
 		}
 	}
 
-	return importance
+	return importance, nil
 }
 
 // blockVariance computes the standard deviation of RGB values across all
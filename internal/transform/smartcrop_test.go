@@ -1,6 +1,7 @@
 package transform
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"math/rand"
@@ -169,3 +170,12 @@ func TestSmartCrop_PartiallyLargerTarget(t *testing.T) {
 		t.Errorf("expected 64x64, got %dx%d", b.Dx(), b.Dy())
 	}
 }
+
+func TestSmartCropContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	if _, err := SmartCropContext(ctx, img, 100, 100); err != context.Canceled {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+}
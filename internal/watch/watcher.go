@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/naming"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 	"github.com/fsnotify/fsnotify"
 )
@@ -29,6 +30,12 @@ type Watcher struct {
 	IgnorePatterns []string      // glob patterns to skip (e.g. "*.tmp", ".git")
 	MaxRetries     int           // retry on error (default 0)
 	JobTemplate    pipeline.Job  // template with all transforms
+
+	// NameTemplate names output files (nil keeps the input name)
+	NameTemplate *naming.Template
+
+	mu      sync.Mutex
+	outputs map[string]bool // same-format outputs written, not to be reprocessed
 }
 
 // Watch starts watching the given directories for new/modified image files.
@@ -114,7 +121,7 @@ func (w *Watcher) Watch(ctx context.Context, dirs []string) error {
 				mu.Lock()
 				delete(pending, path)
 				mu.Unlock()
-				w.processFile(path)
+				w.processFile(ctx, path)
 			})
 			mu.Unlock()
 
@@ -138,7 +145,7 @@ func (w *Watcher) shouldIgnore(path string) bool {
 	return false
 }
 
-func (w *Watcher) processFile(path string) {
+func (w *Watcher) processFile(ctx context.Context, path string) {
 	maxAttempts := w.MaxRetries + 1
 	if maxAttempts < 1 {
 		maxAttempts = 1
@@ -151,14 +158,18 @@ func (w *Watcher) processFile(path string) {
 			if backoff > 16*time.Second {
 				backoff = 16 * time.Second
 			}
-			time.Sleep(backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
 			if w.Verbose {
 				fmt.Fprintf(os.Stderr, "retry %d/%d for %s\n", attempt+1, maxAttempts, path)
 			}
 		}
 
-		err := w.processFileOnce(path)
-		if err == nil {
+		err := w.processFileOnce(ctx, path)
+		if err == nil || ctx.Err() != nil {
 			return
 		}
 		lastErr = err
@@ -175,7 +186,7 @@ func (w *Watcher) processFile(path string) {
 	}
 }
 
-func (w *Watcher) processFileOnce(path string) error {
+func (w *Watcher) processFileOnce(ctx context.Context, path string) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
@@ -187,12 +198,23 @@ func (w *Watcher) processFileOnce(path string) error {
 		return err
 	}
 
-	// Don't convert if input matches output format
-	if inputFormat == w.OutputFormat {
+	// Don't convert if input matches output format, unless the job
+	// transforms or optimizes the image
+	sameFormat := inputFormat == w.OutputFormat
+	if sameFormat && !w.JobTemplate.ChangesImage() {
 		return nil
 	}
 
-	outputPath := buildOutputPath(path, w.OutputDir, w.OutputFormat)
+	// Same-format outputs are new images in a watched directory too; never
+	// process them again (before the template takes a {counter} number) or
+	// rewrite an input in place
+	if sameFormat && w.isOutput(path) {
+		return nil
+	}
+	outputPath := buildOutputPath(path, w.OutputDir, w.OutputFormat, w.NameTemplate)
+	if sameFormat && outputPath == path {
+		return nil
+	}
 
 	// Start from JobTemplate to inherit all transforms
 	job := w.JobTemplate
@@ -205,10 +227,13 @@ func (w *Watcher) processFileOnce(path string) error {
 	}
 	job.PreserveMetadata = w.Metadata
 
-	inSize, outSize, err := w.Pipeline.Execute(job)
+	inSize, outSize, err := w.Pipeline.ExecuteContext(ctx, job)
 	if err != nil {
 		return err
 	}
+	if sameFormat {
+		w.addOutput(outputPath)
+	}
 
 	if w.OnConvert != nil {
 		w.OnConvert(pipeline.Result{Job: job, InputSize: inSize, OutputSize: outSize})
@@ -216,10 +241,31 @@ func (w *Watcher) processFileOnce(path string) error {
 	return nil
 }
 
-func buildOutputPath(inputPath, outputDir string, format codec.Format) string {
-	base := filepath.Base(inputPath)
-	ext := filepath.Ext(base)
-	name := base[:len(base)-len(ext)] + codec.DefaultExtension(format)
+// isOutput reports whether path is a same-format output the watcher wrote.
+func (w *Watcher) isOutput(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.outputs[filepath.Clean(path)]
+}
+
+// addOutput records a same-format output, so its write events are ignored.
+func (w *Watcher) addOutput(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.outputs == nil {
+		w.outputs = make(map[string]bool)
+	}
+	w.outputs[filepath.Clean(path)] = true
+}
+
+func buildOutputPath(inputPath, outputDir string, format codec.Format, tmpl *naming.Template) string {
+	var name string
+	if tmpl != nil {
+		name = tmpl.OutputName(inputPath, format)
+	} else {
+		base := filepath.Base(inputPath)
+		name = base[:len(base)-len(filepath.Ext(base))] + codec.DefaultExtension(format)
+	}
 
 	if outputDir != "" {
 		return filepath.Join(outputDir, name)
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/DanielTso/pixshift/internal/codec"
+	"github.com/DanielTso/pixshift/internal/naming"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 )
 
@@ -237,12 +238,56 @@ func TestWatcher_SameFormatSkipped(t *testing.T) {
 	}
 }
 
+func TestWatcher_SameFormatWithTransforms(t *testing.T) {
+	dir := t.TempDir()
+	tmpl, err := naming.Parse("{name}_crop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var convertCount atomic.Int32
+
+	// Outputs land in the watched directory, so they must not be
+	// cropped again
+	w := &Watcher{
+		Pipeline:     pipeline.NewPipeline(codec.DefaultRegistry()),
+		OutputFormat: codec.JPEG,
+		Quality:      90,
+		JobTemplate:  pipeline.Job{CropWidth: 8, CropHeight: 8},
+		NameTemplate: tmpl,
+		OnConvert: func(r pipeline.Result) {
+			convertCount.Add(1)
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go w.Watch(ctx, []string{dir})
+	time.Sleep(200 * time.Millisecond)
+
+	createTestJPEGFile(t, filepath.Join(dir, "photo.jpg"))
+
+	time.Sleep(2 * time.Second)
+
+	if n := convertCount.Load(); n != 1 {
+		t.Errorf("convert count = %d, want 1", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo_crop.jpg")); err != nil {
+		t.Errorf("cropped output: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo_crop_crop.jpg")); err == nil {
+		t.Error("the cropped output was cropped again")
+	}
+}
+
 func TestBuildOutputPath(t *testing.T) {
 	tests := []struct {
 		name      string
 		inputPath string
 		outputDir string
 		format    codec.Format
+		tmpl      string
 		want      string
 	}{
 		{
@@ -266,11 +311,26 @@ func TestBuildOutputPath(t *testing.T) {
 			format:    codec.GIF,
 			want:      "/data/converted/image.gif",
 		},
+		{
+			name:      "name template",
+			inputPath: "/home/user/photos/vacation.jpg",
+			outputDir: "/tmp/output",
+			format:    codec.PNG,
+			tmpl:      "{parent}/{name}_{counter:3}",
+			want:      "/tmp/output/photos/vacation_001.png",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := buildOutputPath(tt.inputPath, tt.outputDir, tt.format)
+			var tmpl *naming.Template
+			if tt.tmpl != "" {
+				var err error
+				if tmpl, err = naming.Parse(tt.tmpl); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := buildOutputPath(tt.inputPath, tt.outputDir, tt.format, tmpl)
 			if got != tt.want {
 				t.Errorf("buildOutputPath() = %q, want %q", got, tt.want)
 			}
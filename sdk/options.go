@@ -16,6 +16,19 @@ type config struct {
 	watermarkOpacity float64
 	smartCropW       int
 	smartCropH       int
+	lossless         bool
+	chroma           string
+	jxlEffort        int
+	jxlDistance      float64
+	avifSpeed        int
+	avifAlphaQuality int
+	avifBitDepth     int
+	pngOptimize      bool
+	ops              []Op
+	preserveAttrs    bool
+	hooks            []Hooks
+	autoFormats      []Format
+	minSSIM          float64
 }
 
 func defaultConfig() config {
@@ -71,3 +84,52 @@ func WithWatermark(text, pos string, opacity float64) Option {
 		c.watermarkOpacity = opacity
 	}
 }
+
+// WithLossless enables lossless encoding for WebP, JXL and HEIC output.
+func WithLossless() Option { return func(c *config) { c.lossless = true } }
+
+// WithChroma sets the AVIF/HEIC chroma subsampling. The bundled encoders
+// only support "420"; other values fail the conversion before decoding.
+func WithChroma(subsample string) Option { return func(c *config) { c.chroma = subsample } }
+
+// WithJXLEffort sets the JXL encoder effort (1-9).
+func WithJXLEffort(effort int) Option { return func(c *config) { c.jxlEffort = effort } }
+
+// WithJXLDistance sets the JXL Butteraugli distance (0.01-25), overriding quality.
+func WithJXLDistance(d float64) Option { return func(c *config) { c.jxlDistance = d } }
+
+// WithAVIFSpeed sets the AVIF encoder speed (0-10, higher is faster).
+func WithAVIFSpeed(speed int) Option { return func(c *config) { c.avifSpeed = speed } }
+
+// WithAVIFAlphaQuality sets the AVIF alpha channel quality (1-100) separately from color quality.
+func WithAVIFAlphaQuality(q int) Option { return func(c *config) { c.avifAlphaQuality = q } }
+
+// WithAVIFBitDepth sets the AVIF bits per channel. The bundled encoder
+// only supports 8; other depths fail the conversion before decoding.
+func WithAVIFBitDepth(depth int) Option { return func(c *config) { c.avifBitDepth = depth } }
+
+// WithPNGOptimize searches PNG color types, bit depths and row filters for
+// the smallest lossless encoding.
+func WithPNGOptimize() Option { return func(c *config) { c.pngOptimize = true } }
+
+// WithOps appends operations to the transform chain. They run in order,
+// after the transforms set by the other options.
+func WithOps(ops ...Op) Option { return func(c *config) { c.ops = append(c.ops, ops...) } }
+
+// WithPreserveAttrs gives the output the input file's permissions and
+// modification time.
+func WithPreserveAttrs() Option { return func(c *config) { c.preserveAttrs = true } }
+
+// WithHooks adds hooks called at the stages of the conversion. Hooks added
+// by several options run in the order given.
+func WithHooks(hooks ...Hooks) Option { return func(c *config) { c.hooks = append(c.hooks, hooks...) } }
+
+// WithAutoFormats sets the candidate formats tried for Auto output. The
+// default is AVIF, WebP, JXL and JPEG.
+func WithAutoFormats(formats ...Format) Option {
+	return func(c *config) { c.autoFormats = append(c.autoFormats, formats...) }
+}
+
+// WithMinSSIM sets the minimum SSIM (0-1) a candidate of Auto output must
+// reach against the source; the default is 0.95.
+func WithMinSSIM(ssim float64) Option { return func(c *config) { c.minSSIM = ssim } }
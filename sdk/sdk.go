@@ -1,13 +1,16 @@
 package sdk
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/DanielTso/pixshift/internal/codec"
 	pixcolor "github.com/DanielTso/pixshift/internal/color"
+	"github.com/DanielTso/pixshift/internal/metadata"
 	"github.com/DanielTso/pixshift/internal/pipeline"
 	"github.com/DanielTso/pixshift/internal/ssim"
 )
@@ -25,8 +28,73 @@ const (
 	TIFF Format = codec.TIFF
 	GIF  Format = codec.GIF
 	JXL  Format = codec.JXL
+
+	// Auto picks, per image, the smallest of the candidate formats that
+	// keeps the quality set by WithMinSSIM; see WithAutoFormats.
+	Auto Format = codec.Auto
+)
+
+// Op is one operation of an ordered transform chain; see WithOps.
+type Op = pipeline.Op
+
+// ParseOp parses an operation in the CLI --op form, e.g. "resize:max=1200"
+// or "crop:ratio=16:9,gravity=north".
+func ParseOp(s string) (Op, error) { return pipeline.ParseOp(s) }
+
+// Variant is one output of ConvertVariants: its size, operations, format,
+// quality and file name template.
+type Variant = pipeline.Variant
+
+// VariantResult is the outcome of one variant of ConvertVariants.
+type VariantResult = pipeline.VariantResult
+
+// ParseVariant parses a variant in the CLI --variant form, e.g. "webp:640"
+// or "avif:max=1280,quality=60".
+func ParseVariant(s string) (Variant, error) { return pipeline.ParseVariant(s) }
+
+// Errors that classify a failed conversion, for use with errors.Is:
+// the input or output format is unsupported, the input could not be
+// decoded, the output could not be encoded, the metadata could not be
+// read or written, a limit such as the 64 KB JPEG EXIF segment was
+// exceeded, or a file could not be read or written.
+var (
+	ErrUnsupportedFormat = pipeline.ErrUnsupportedFormat
+	ErrDecode            = pipeline.ErrDecode
+	ErrEncode            = pipeline.ErrEncode
+	ErrMetadata          = pipeline.ErrMetadata
+	ErrLimit             = pipeline.ErrLimit
+	ErrIO                = pipeline.ErrIO
 )
 
+// Hooks are called at the stages of a conversion: before the input is
+// decoded, after decoding, after the transforms, before encoding and after
+// encoding. They can replace the image or the encoded output, collect
+// metrics, or veto an output by returning an error; see WithHooks.
+// Image hooks rule out lossless JPEG transcoding.
+type Hooks = pipeline.Hooks
+
+// ImageHook is a hook that is given, and may replace, the image.
+type ImageHook = pipeline.ImageHook
+
+// Job describes a conversion as it is passed to hooks: its input and
+// output paths, format and settings.
+type Job = pipeline.Job
+
+// AutoDecision records how an Auto output format was chosen: the format,
+// its quality, and the trial of each candidate.
+type AutoDecision = pipeline.AutoDecision
+
+// AutoTrial is the outcome of trial-encoding one Auto candidate.
+type AutoTrial = pipeline.AutoTrial
+
+// Output describes the file a conversion wrote. With Auto output its
+// format, and the extension of its path, are chosen per image.
+type Output struct {
+	Path   string
+	Format Format
+	Auto   *AutoDecision // set for Auto output
+}
+
 // Color represents a dominant color.
 type Color = pixcolor.Color
 
@@ -38,13 +106,52 @@ type ImageInfo struct {
 	Size   int64  `json:"size"`
 }
 
+// EXIFSummary holds the commonly used EXIF fields (camera, lens, exposure,
+// capture time, GPS) in typed form.
+type EXIFSummary = metadata.Summary
+
+// EXIFField is a single decoded EXIF tag.
+type EXIFField = metadata.Field
+
+// IPTCFields holds the common editorial IPTC-IIM fields (caption,
+// by-line, copyright, keywords and more).
+type IPTCFields = metadata.IPTCFields
+
+// ImageMetadata holds the EXIF and IPTC metadata of an image file.
+type ImageMetadata struct {
+	Format  Format       `json:"format"`
+	HasEXIF bool         `json:"has_exif"`
+	EXIF    *EXIFSummary `json:"exif,omitempty"`
+	Tags    []EXIFField  `json:"exif_tags,omitempty"`
+	IPTC    *IPTCFields  `json:"iptc,omitempty"`
+}
+
 // Convert converts an image file to the specified format and writes the result.
 func Convert(input, output string, opts ...Option) error {
+	return ConvertContext(context.Background(), input, output, opts...)
+}
+
+// ConvertContext is Convert with cancellation. Once ctx is done the
+// conversion stops, no partial output is left behind and the context's
+// error is returned.
+func ConvertContext(ctx context.Context, input, output string, opts ...Option) error {
+	_, err := ConvertOutput(ctx, input, output, opts...)
+	return err
+}
+
+// ConvertOutput is ConvertContext that also reports the file written,
+// which for Auto output is not output but output with the extension of
+// the chosen format.
+func ConvertOutput(ctx context.Context, input, output string, opts ...Option) (Output, error) {
 	cfg := defaultConfig()
 	for _, opt := range opts {
 		opt(&cfg)
 	}
 
+	if err := pipeline.ValidateOps(cfg.ops); err != nil {
+		return Output{}, err
+	}
+
 	reg := codec.DefaultRegistry()
 
 	// Detect output format from extension if not set.
@@ -53,39 +160,80 @@ func Convert(input, output string, opts ...Option) error {
 		ext := strings.TrimPrefix(filepath.Ext(output), ".")
 		f, err := codec.ParseFormat(ext)
 		if err != nil {
-			return fmt.Errorf("cannot determine output format from extension %q: %w", ext, err)
+			return Output{}, fmt.Errorf("cannot determine output format from extension %q: %w", ext, err)
 		}
 		outputFormat = f
 	}
 
-	job := pipeline.Job{
-		InputPath:        input,
-		OutputPath:       output,
-		OutputFormat:     outputFormat,
-		Quality:          cfg.quality,
-		Width:            cfg.width,
-		Height:           cfg.height,
-		MaxDim:           cfg.maxDim,
-		Grayscale:        cfg.grayscale,
-		Sharpen:          cfg.sharpen,
-		Blur:             cfg.blur,
-		Invert:           cfg.invert,
-		StripMetadata:    cfg.stripMetadata,
-		PreserveMetadata: cfg.preserveMetadata,
-		WatermarkText:    cfg.watermarkText,
-		WatermarkPos:     cfg.watermarkPos,
-		WatermarkOpacity: cfg.watermarkOpacity,
-		SmartCropWidth:   cfg.smartCropW,
-		SmartCropHeight:  cfg.smartCropH,
+	job := cfg.job(input, output, outputFormat)
+	pipe := pipeline.NewPipeline(reg)
+	pipe.Hooks = cfg.hooks
+	res := pipe.ExecuteJobContext(ctx, job)
+	if res.Error != nil {
+		return Output{}, res.Error
 	}
+	return Output{Path: res.Job.OutputPath, Format: res.Job.OutputFormat, Auto: res.Auto}, nil
+}
 
-	pipe := pipeline.NewPipeline(reg)
-	_, _, err := pipe.Execute(job)
-	return err
+// ConvertVariants writes several variants of an image from a single
+// decode, e.g. a responsive set of sizes and formats. The options apply
+// to every variant before its own size and operations; WithFormat gives
+// the format of variants without one. A variant without an OutputPath is
+// written to outputDir (or next to the input when it is "") under the
+// name from its template. A failed variant does not stop the others: the
+// results report each one and the error is the first failure.
+func ConvertVariants(input, outputDir string, variants []Variant, opts ...Option) ([]VariantResult, error) {
+	return ConvertVariantsContext(context.Background(), input, outputDir, variants, opts...)
+}
+
+// ConvertVariantsContext is ConvertVariants with cancellation.
+func ConvertVariantsContext(ctx context.Context, input, outputDir string, variants []Variant, opts ...Option) ([]VariantResult, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := pipeline.ValidateOps(cfg.ops); err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no variants")
+	}
+	if err := pipeline.ValidateVariants(variants, cfg.format); err != nil {
+		return nil, err
+	}
+
+	if outputDir == "" {
+		outputDir = filepath.Dir(input)
+	}
+	variants = slices.Clone(variants)
+	for i, v := range variants {
+		format := v.OutputFormat(cfg.format)
+		if format == "" {
+			return nil, fmt.Errorf("variant %d (%s): no format; set one or use WithFormat", i+1, v.Label(i))
+		}
+		if v.OutputPath == "" {
+			tmpl, _ := v.NameTemplate(i) // validated above
+			variants[i].OutputPath = filepath.Join(outputDir, tmpl.OutputName(input, format))
+		}
+	}
+
+	job := cfg.job(input, "", cfg.format)
+	job.Variants = variants
+
+	pipe := pipeline.NewPipeline(codec.DefaultRegistry())
+	pipe.Hooks = cfg.hooks
+	res := pipe.ExecuteJobContext(ctx, job)
+	return res.Variants, res.Error
 }
 
 // ConvertBytes converts image bytes to the specified format.
 func ConvertBytes(data []byte, outputFormat Format, opts ...Option) ([]byte, error) {
+	return ConvertBytesContext(context.Background(), data, outputFormat, opts...)
+}
+
+// ConvertBytesContext is ConvertBytes with cancellation.
+func ConvertBytesContext(ctx context.Context, data []byte, outputFormat Format, opts ...Option) ([]byte, error) {
 	tmpDir, err := os.MkdirTemp("", "pixshift-sdk-*")
 	if err != nil {
 		return nil, fmt.Errorf("create temp dir: %w", err)
@@ -101,11 +249,51 @@ func ConvertBytes(data []byte, outputFormat Format, opts ...Option) ([]byte, err
 	outputPath := filepath.Join(tmpDir, "output"+outExt)
 
 	opts = append([]Option{WithFormat(outputFormat)}, opts...)
-	if err := Convert(inputPath, outputPath, opts...); err != nil {
+	out, err := ConvertOutput(ctx, inputPath, outputPath, opts...)
+	if err != nil {
 		return nil, err
 	}
 
-	return os.ReadFile(outputPath)
+	return os.ReadFile(out.Path)
+}
+
+// job returns the pipeline job of a conversion with this configuration.
+func (cfg config) job(input, output string, outputFormat Format) pipeline.Job {
+	return pipeline.Job{
+		InputPath:        input,
+		OutputPath:       output,
+		OutputFormat:     outputFormat,
+		Quality:          cfg.quality,
+		Width:            cfg.width,
+		Height:           cfg.height,
+		MaxDim:           cfg.maxDim,
+		Grayscale:        cfg.grayscale,
+		Sharpen:          cfg.sharpen,
+		Blur:             cfg.blur,
+		Invert:           cfg.invert,
+		StripMetadata:    cfg.stripMetadata,
+		PreserveMetadata: cfg.preserveMetadata,
+		WatermarkText:    cfg.watermarkText,
+		WatermarkPos:     cfg.watermarkPos,
+		WatermarkOpacity: cfg.watermarkOpacity,
+		SmartCropWidth:   cfg.smartCropW,
+		SmartCropHeight:  cfg.smartCropH,
+		Ops:              cfg.ops,
+		PreserveAttrs:    cfg.preserveAttrs,
+		AutoFormats:      cfg.autoFormats,
+		AutoMinSSIM:      cfg.minSSIM,
+		EncodeOpts: codec.EncodeOptions{
+			Quality:          cfg.quality,
+			Subsample:        cfg.chroma,
+			Lossless:         cfg.lossless,
+			JXLEffort:        cfg.jxlEffort,
+			JXLDistance:      cfg.jxlDistance,
+			AVIFSpeed:        cfg.avifSpeed,
+			AVIFAlphaQuality: cfg.avifAlphaQuality,
+			AVIFBitDepth:     cfg.avifBitDepth,
+			PNGOptimize:      cfg.pngOptimize,
+		},
+	}
 }
 
 // Analyze returns metadata about an image file.
@@ -151,6 +339,46 @@ func Analyze(path string) (*ImageInfo, error) {
 	}, nil
 }
 
+// Metadata parses the EXIF and IPTC metadata of an image file. Files without
+// EXIF, or in formats EXIF cannot be read from, return HasEXIF false and no error.
+func Metadata(path string) (*ImageMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	format, err := codec.DetectFormat(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("detect format: %w", err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+
+	result := &ImageMetadata{Format: format}
+	meta, err := metadata.Extract(f, format)
+	if err != nil {
+		return result, nil
+	}
+	if iptc, err := meta.IPTCFields(); err == nil && !iptc.IsZero() {
+		result.IPTC = &iptc
+	}
+	if !meta.HasEXIF() {
+		return result, nil
+	}
+	result.HasEXIF = true
+
+	exif, err := meta.EXIF()
+	if err != nil {
+		return nil, fmt.Errorf("parse exif: %w", err)
+	}
+	result.EXIF = exif.Summary()
+	result.Tags = exif.Fields()
+	return result, nil
+}
+
 // Palette extracts the N most dominant colors from an image.
 func Palette(path string, count int) ([]Color, error) {
 	reg := codec.DefaultRegistry()
@@ -1,6 +1,8 @@
 package sdk
 
 import (
+	"context"
+	"errors"
 	"image"
 	"image/color"
 	"image/jpeg"
@@ -59,6 +61,20 @@ func TestConvert(t *testing.T) {
 	}
 }
 
+func TestConvert_ErrorKinds(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.jpg")
+	if err := os.WriteFile(corrupt, []byte("\xff\xd8\xff\xe0garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Convert(corrupt, filepath.Join(dir, "out.png")); !errors.Is(err, ErrDecode) {
+		t.Errorf("corrupt input: error = %v, want ErrDecode", err)
+	}
+	if err := Convert(filepath.Join(dir, "missing.jpg"), filepath.Join(dir, "out.png")); !errors.Is(err, ErrIO) {
+		t.Errorf("missing input: error = %v, want ErrIO", err)
+	}
+}
+
 func TestConvertBytes(t *testing.T) {
 	dir := t.TempDir()
 	inputPath := createTestJPEG(t, dir)
@@ -80,6 +96,35 @@ func TestConvertBytes(t *testing.T) {
 	}
 }
 
+func TestConvertAuto(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+
+	out, err := ConvertOutput(context.Background(), inputPath, filepath.Join(dir, "out.auto"),
+		WithFormat(Auto), WithAutoFormats(JPEG), WithMinSSIM(0.9))
+	if err != nil {
+		t.Fatalf("ConvertOutput: %v", err)
+	}
+	if out.Format != JPEG || out.Path != filepath.Join(dir, "out.jpg") || out.Auto == nil {
+		t.Errorf("output = %+v, want out.jpg as JPEG with a decision", out)
+	}
+	if _, err := os.Stat(out.Path); err != nil {
+		t.Errorf("output not written: %v", err)
+	}
+
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	output, err := ConvertBytes(data, Auto, WithAutoFormats(JPEG))
+	if err != nil {
+		t.Fatalf("ConvertBytes: %v", err)
+	}
+	if len(output) < 2 || output[0] != 0xFF || output[1] != 0xD8 {
+		t.Error("ConvertBytes output is not a JPEG")
+	}
+}
+
 func TestConvertWithOptions(t *testing.T) {
 	dir := t.TempDir()
 	inputPath := createTestJPEG(t, dir)
@@ -98,6 +143,73 @@ func TestConvertWithOptions(t *testing.T) {
 	}
 }
 
+func TestConvertWithOps(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+	outputPath := filepath.Join(dir, "output.png")
+
+	// Resizing first leaves the 60x60 crop larger than the image
+	crop, err := ParseOp("crop:60x60")
+	if err != nil {
+		t.Fatalf("ParseOp: %v", err)
+	}
+	if err := Convert(inputPath, outputPath, WithOps(Op{Kind: "resize", Width: 30}, crop)); err != nil {
+		t.Fatalf("Convert with ops: %v", err)
+	}
+	info, err := Analyze(outputPath)
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if info.Width != 30 || info.Height != 24 {
+		t.Errorf("output = %dx%d, want 30x24", info.Width, info.Height)
+	}
+
+	if err := Convert(inputPath, outputPath, WithOps(Op{Kind: "resize"})); err == nil {
+		t.Error("expected error for resize without dimensions")
+	}
+}
+
+func TestConvertVariants(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := createTestJPEG(t, dir)
+	outDir := filepath.Join(dir, "out")
+
+	small, err := ParseVariant("png:50")
+	if err != nil {
+		t.Fatalf("ParseVariant: %v", err)
+	}
+	results, err := ConvertVariants(inputPath, outDir, []Variant{small, {Width: 20, Name: "thumb"}}, WithFormat(JPEG))
+	if err != nil {
+		t.Fatalf("ConvertVariants: %v", err)
+	}
+	want := []struct {
+		path          string
+		width, height int
+	}{
+		{filepath.Join(outDir, "test-50.png"), 50, 40},
+		{filepath.Join(outDir, "test-thumb.jpg"), 20, 16},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v", results)
+	}
+	for i, w := range want {
+		if results[i].OutputPath != w.path {
+			t.Errorf("variant %d path = %s, want %s", i+1, results[i].OutputPath, w.path)
+		}
+		info, err := Analyze(w.path)
+		if err != nil {
+			t.Fatalf("Analyze: %v", err)
+		}
+		if info.Width != w.width || info.Height != w.height {
+			t.Errorf("variant %d = %dx%d, want %dx%d", i+1, info.Width, info.Height, w.width, w.height)
+		}
+	}
+
+	if _, err := ConvertVariants(inputPath, outDir, []Variant{{MaxDim: 10}}); err == nil {
+		t.Error("expected error for a variant without a format")
+	}
+}
+
 func TestAnalyze(t *testing.T) {
 	dir := t.TempDir()
 	inputPath := createTestJPEG(t, dir)
@@ -121,6 +233,45 @@ func TestAnalyze(t *testing.T) {
 	}
 }
 
+func TestMetadata(t *testing.T) {
+	dir := t.TempDir()
+	plain := createTestJPEG(t, dir)
+
+	m, err := Metadata(plain)
+	if err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+	if m.HasEXIF || m.EXIF != nil {
+		t.Errorf("plain JPEG reported EXIF: %+v", m)
+	}
+
+	// Splice an APP1 segment holding IFD0 Make=Canon after SOI.
+	data, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00" +
+		"\x01\x00\x0f\x01\x02\x00\x06\x00\x00\x00\x1a\x00\x00\x00" +
+		"\x00\x00\x00\x00Canon\x00")
+	app1 := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	tagged := append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+	path := filepath.Join(dir, "tagged.jpg")
+	if err := os.WriteFile(path, tagged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = Metadata(path)
+	if err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+	if !m.HasEXIF || m.EXIF == nil || m.EXIF.Make != "Canon" {
+		t.Fatalf("EXIF = %+v, want Make Canon", m.EXIF)
+	}
+	if len(m.Tags) != 1 || m.Tags[0].Name != "Make" {
+		t.Errorf("tags = %+v", m.Tags)
+	}
+}
+
 func TestPalette(t *testing.T) {
 	dir := t.TempDir()
 	inputPath := createTestJPEG(t, dir)
@@ -152,3 +303,35 @@ func TestCompare(t *testing.T) {
 		t.Errorf("SSIM of identical image = %f, want ~1.0", score)
 	}
 }
+
+func TestConvertWithHooks(t *testing.T) {
+	dir := t.TempDir()
+	var decoded image.Rectangle
+	var written int
+	err := Convert(createTestJPEG(t, dir), filepath.Join(dir, "out.png"),
+		WithMaxDim(50),
+		WithHooks(Hooks{
+			PostDecode: func(ctx context.Context, job Job, frame int, img image.Image) (image.Image, error) {
+				decoded = img.Bounds()
+				return img, nil
+			},
+			PostEncode: func(ctx context.Context, job Job, data []byte) ([]byte, error) {
+				written = len(data)
+				return data, nil
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if decoded.Dx() != 100 || written == 0 {
+		t.Errorf("decoded %v, encoded %d bytes", decoded, written)
+	}
+
+	veto := errors.New("vetoed")
+	err = Convert(createTestJPEG(t, dir), filepath.Join(dir, "vetoed.png"),
+		WithHooks(Hooks{PreDecode: func(ctx context.Context, job Job, format Format) error { return veto }}))
+	if !errors.Is(err, veto) {
+		t.Errorf("error = %v, want the hook's error", err)
+	}
+}